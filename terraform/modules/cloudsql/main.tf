@@ -89,12 +89,14 @@ resource "google_sql_database" "databases" {
 
 # Users
 resource "google_sql_user" "users" {
-  for_each = { for user in var.users : user.name => user }
+  # var.users is sensitive for the passwords, and for_each refuses sensitive
+  # values, so users are keyed by their names alone
+  for_each = nonsensitive(toset([for user in var.users : user.name]))
 
-  name     = each.value.name
+  name     = each.key
   project  = var.project_id
   instance = google_sql_database_instance.instance.name
-  password = each.value.password
+  password = one([for user in var.users : user.password if user.name == each.key])
   type     = local.is_sqlserver ? "BUILT_IN" : null
 
   dynamic "password_policy" {
@@ -131,7 +131,7 @@ variable "retained_backups" {
 
 # Maintenance
 variable "maintenance_window_day" {
-  description = "Maintenance window day (1-7, Sunday=1)"
+  description = "Maintenance window day (1-7, Monday=1 to Sunday=7 as Cloud SQL numbers them)"
   type        = number
   default     = 7
 }
@@ -33,6 +33,28 @@ locals {
   ])
 }
 
+# Project-level IAM bindings for other members
+resource "google_project_iam_member" "role_bindings" {
+  for_each = {
+    for binding in local.member_role_bindings : "${binding.role}-${binding.member}" => binding
+  }
+
+  project = var.project_id
+  role    = each.value.role
+  member  = each.value.member
+}
+
+locals {
+  member_role_bindings = flatten([
+    for binding in var.role_bindings : [
+      for member in binding.members : {
+        role   = binding.role
+        member = member
+      }
+    ]
+  ])
+}
+
 # Custom IAM Role (optional)
 resource "google_project_iam_custom_role" "custom_roles" {
   for_each = { for role in var.custom_roles : role.role_id => role }
@@ -16,6 +16,15 @@ variable "service_accounts" {
   default = []
 }
 
+variable "role_bindings" {
+  description = "Project-level roles to grant to any members, e.g. groups or service accounts managed elsewhere"
+  type = list(object({
+    role    = string
+    members = list(string)
+  }))
+  default = []
+}
+
 variable "custom_roles" {
   description = "List of custom roles to create"
   type = list(object({
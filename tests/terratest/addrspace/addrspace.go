@@ -0,0 +1,348 @@
+// Package addrspace collects the IP ranges a configuration allocates, from a
+// plan or a tfvars file, and checks them for overlaps and free space. Subnet
+// primary and secondary ranges, private services reservations and on-prem
+// ranges share one private address space, but neither Terraform nor the plan
+// stops two of them from colliding: the API rejects some overlaps at apply,
+// and overlaps with on-prem only show once routes are exchanged.
+package addrspace
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/gruntwork-io/terratest/modules/testing"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// Range is a named CIDR range
+type Range struct {
+	Name   string
+	Prefix netip.Prefix
+}
+
+func (r Range) String() string {
+	return fmt.Sprintf("%s (%s)", r.Name, r.Prefix)
+}
+
+// Reservation is a range whose size is planned but whose address GCP picks at
+// apply, such as an automatically allocated private services range
+type Reservation struct {
+	Name string
+	Bits int
+}
+
+// Space is the set of ranges a configuration uses
+type Space struct {
+	Ranges       []Range
+	Reservations []Reservation
+}
+
+// Add adds a range, e.g. an on-prem range the configuration must avoid.
+// GCP only accepts network addresses, so cidr must have no host bits set.
+func (s *Space) Add(name, cidr string) error {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if prefix != prefix.Masked() {
+		return fmt.Errorf("%s: %s has host bits set, want %s", name, cidr, prefix.Masked())
+	}
+	s.Ranges = append(s.Ranges, Range{Name: name, Prefix: prefix})
+	return nil
+}
+
+// FromPlan collects subnet primary and secondary ranges and VPC_PEERING global
+// addresses. Global addresses without a planned address become reservations.
+func FromPlan(plan *terraform.PlanStruct) (*Space, error) {
+	space := &Space{}
+	for _, resource := range planassert.ResourcesOfType(plan, "google_compute_subnetwork") {
+		values := resource.AttributeValues
+		if cidr, ok := values["ip_cidr_range"].(string); ok {
+			if err := space.Add(resource.Address, cidr); err != nil {
+				return nil, err
+			}
+		}
+		secondary, _ := values["secondary_ip_range"].([]interface{})
+		for _, r := range secondary {
+			block, _ := r.(map[string]interface{})
+			name, _ := block["range_name"].(string)
+			if cidr, ok := block["ip_cidr_range"].(string); ok {
+				if err := space.Add(resource.Address+" "+name, cidr); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	for _, resource := range planassert.ResourcesOfType(plan, "google_compute_global_address") {
+		values := resource.AttributeValues
+		if purpose, _ := values["purpose"].(string); purpose != "VPC_PEERING" {
+			continue
+		}
+		bits, _ := values["prefix_length"].(float64)
+		if address, _ := values["address"].(string); address != "" {
+			if err := space.Add(resource.Address, fmt.Sprintf("%s/%d", address, int(bits))); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		space.Reservations = append(space.Reservations, Reservation{Name: resource.Address, Bits: int(bits)})
+	}
+	return space, nil
+}
+
+// FromTFVars collects every CIDR string in the given variables of a tfvars
+// file, or in all of them when none are given, named by their path such as
+// subnets[0].ip_cidr_range. Firewall source ranges are not address space, so
+// files that set them should name the variables to read.
+func FromTFVars(path string, variables ...string) (*Space, error) {
+	file, diags := hclparse.NewParser().ParseHCLFile(path)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	attrs, diags := file.Body.JustAttributes()
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	names := variables
+	if len(names) == 0 {
+		for name := range attrs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	space := &Space{}
+	for _, name := range names {
+		attr, ok := attrs[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: variable %s is not set", path, name)
+		}
+		value, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		if err := space.collect(name, value); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return space, nil
+}
+
+// collect adds the CIDR strings in value, walking lists, maps and objects
+func (s *Space) collect(path string, value cty.Value) error {
+	if value.IsNull() || !value.IsKnown() {
+		return nil
+	}
+	switch ty := value.Type(); {
+	case ty == cty.String:
+		if _, err := netip.ParsePrefix(value.AsString()); err == nil {
+			return s.Add(path, value.AsString())
+		}
+	case ty.IsListType() || ty.IsTupleType() || ty.IsSetType():
+		i := 0
+		for it := value.ElementIterator(); it.Next(); i++ {
+			_, element := it.Element()
+			if err := s.collect(fmt.Sprintf("%s[%d]", path, i), element); err != nil {
+				return err
+			}
+		}
+	case ty.IsMapType() || ty.IsObjectType():
+		for it := value.ElementIterator(); it.Next(); {
+			key, element := it.Element()
+			if err := s.collect(path+"."+key.AsString(), element); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Conflict is two overlapping ranges. CIDR ranges that overlap are either the
+// same range or one contains the other; Outer is the larger.
+type Conflict struct {
+	Outer, Inner Range
+}
+
+func (c Conflict) String() string {
+	if c.Outer.Prefix == c.Inner.Prefix {
+		return fmt.Sprintf("%s and %s are the same range", c.Outer, c.Inner)
+	}
+	return fmt.Sprintf("%s contains %s", c.Outer, c.Inner)
+}
+
+// Conflicts returns every pair of overlapping ranges, ordered by address
+func (s *Space) Conflicts() []Conflict {
+	ranges := s.sorted()
+	var conflicts []Conflict
+	for i, a := range ranges {
+		for _, b := range ranges[i+1:] {
+			if a.Prefix.Overlaps(b.Prefix) {
+				conflicts = append(conflicts, Conflict{Outer: a, Inner: b})
+			}
+		}
+	}
+	return conflicts
+}
+
+// sorted returns the ranges by address, larger ranges first
+func (s *Space) sorted() []Range {
+	ranges := append([]Range{}, s.Ranges...)
+	sort.SliceStable(ranges, func(i, j int) bool {
+		a, b := ranges[i].Prefix, ranges[j].Prefix
+		if a.Addr() != b.Addr() {
+			return a.Addr().Less(b.Addr())
+		}
+		return a.Bits() < b.Bits()
+	})
+	return ranges
+}
+
+// Free returns the blocks of within that no range overlaps, as the fewest
+// CIDR ranges, ordered by address
+func (s *Space) Free(within netip.Prefix) []netip.Prefix {
+	within = within.Masked()
+	used := false
+	for _, r := range s.Ranges {
+		if !r.Prefix.Overlaps(within) {
+			continue
+		}
+		if r.Prefix.Bits() <= within.Bits() {
+			return nil
+		}
+		used = true
+	}
+	if !used {
+		return []netip.Prefix{within}
+	}
+	low, high := halves(within)
+	return append(s.Free(low), s.Free(high)...)
+}
+
+// Allocate returns the lowest free block of the given prefix length within
+// the supernet, as GCP would for an automatic allocation if it chose the same
+// way. It reports false when no block that size is free.
+func (s *Space) Allocate(within netip.Prefix, bits int) (netip.Prefix, bool) {
+	for _, free := range s.Free(within) {
+		if free.Bits() <= bits {
+			return netip.PrefixFrom(free.Addr(), bits), true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// Place allocates every reservation within the supernet, largest first, and
+// returns the ranges they could take. It fails when one does not fit.
+func (s *Space) Place(within netip.Prefix) ([]Range, error) {
+	reservations := append([]Reservation{}, s.Reservations...)
+	sort.SliceStable(reservations, func(i, j int) bool { return reservations[i].Bits < reservations[j].Bits })
+
+	placed := &Space{Ranges: append([]Range{}, s.Ranges...)}
+	var ranges []Range
+	for _, reservation := range reservations {
+		prefix, ok := placed.Allocate(within, reservation.Bits)
+		if !ok {
+			return nil, fmt.Errorf("%s: no free /%d left in %s", reservation.Name, reservation.Bits, within)
+		}
+		r := Range{Name: reservation.Name, Prefix: prefix}
+		placed.Ranges = append(placed.Ranges, r)
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// halves splits a prefix into its two sub-prefixes one bit longer
+func halves(p netip.Prefix) (netip.Prefix, netip.Prefix) {
+	low := netip.PrefixFrom(p.Addr(), p.Bits()+1)
+	return low, netip.PrefixFrom(last(low).Next(), p.Bits()+1)
+}
+
+// last returns the highest address in p
+func last(p netip.Prefix) netip.Addr {
+	bytes := p.Masked().Addr().AsSlice()
+	for i := p.Bits(); i < len(bytes)*8; i++ {
+		bytes[i/8] |= 0x80 >> (i % 8)
+	}
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+// Size returns the number of addresses in p, saturating at 1<<62 for large
+// IPv6 ranges
+func Size(p netip.Prefix) uint64 {
+	hostBits := p.Addr().BitLen() - p.Bits()
+	if hostBits >= 62 {
+		return 1 << 62
+	}
+	return 1 << hostBits
+}
+
+// WriteReport prints the ranges, their conflicts, the reservations placed
+// within the supernet and the free blocks left in it
+func WriteReport(w io.Writer, space *Space, within netip.Prefix) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CIDR\tADDRESSES\tNAME")
+	for _, r := range space.sorted() {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", r.Prefix, Size(r.Prefix), r.Name)
+	}
+	placed, err := space.Place(within)
+	for _, r := range placed {
+		fmt.Fprintf(tw, "%s\t%d\t%s (if allocated lowest first)\n", r.Prefix, Size(r.Prefix), r.Name)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	conflicts := space.Conflicts()
+	fmt.Fprintf(w, "\n%d conflicts\n", len(conflicts))
+	for _, conflict := range conflicts {
+		fmt.Fprintf(w, "  %s\n", conflict)
+	}
+	if err != nil {
+		fmt.Fprintf(w, "\n%v\n", err)
+		return nil
+	}
+
+	free := (&Space{Ranges: append(append([]Range{}, space.Ranges...), placed...)}).Free(within)
+	var total uint64
+	largest := within.Bits() + 1
+	for _, block := range free {
+		total += Size(block)
+		if block.Bits() < largest {
+			largest = block.Bits()
+		}
+	}
+	fmt.Fprintf(w, "\n%d of %d addresses free in %s", total, Size(within), within)
+	if len(free) > 0 {
+		fmt.Fprintf(w, ", largest block /%d", largest)
+	}
+	fmt.Fprintln(w)
+	return nil
+}
+
+// NoConflictsE returns an error listing overlapping ranges
+func NoConflictsE(space *Space) error {
+	conflicts := space.Conflicts()
+	if len(conflicts) == 0 {
+		return nil
+	}
+	lines := make([]string, len(conflicts))
+	for i, conflict := range conflicts {
+		lines[i] = conflict.String()
+	}
+	return fmt.Errorf("%d overlapping ranges:\n%s", len(conflicts), strings.Join(lines, "\n"))
+}
+
+// NoConflicts asserts no two ranges overlap
+func NoConflicts(t testing.TestingT, space *Space) bool {
+	return assert.NoError(t, NoConflictsE(space))
+}
@@ -0,0 +1,118 @@
+package addrspace
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// TestFromPlan tests subnet, secondary and private services ranges are collected
+func TestFromPlan(t *testing.T) {
+	t.Parallel()
+
+	space, err := FromPlan(planassert.LoadPlanFile(t, "testdata/plan.json"))
+	require.NoError(t, err)
+
+	var cidrs []string
+	for _, r := range space.sorted() {
+		cidrs = append(cidrs, r.Prefix.String())
+	}
+	assert.Equal(t, []string{
+		"10.0.0.0/20", "10.0.8.0/24", "10.10.0.0/16", "10.11.0.0/20",
+		"10.20.0.0/16", "10.21.0.0/20", "10.30.0.0/20",
+	}, cidrs)
+	assert.Equal(t, []Reservation{{Name: "module.network.google_compute_global_address.private_ip_range", Bits: 16}}, space.Reservations)
+
+	conflicts := space.Conflicts()
+	require.Len(t, conflicts, 1)
+	assert.Equal(t, `module.network.google_compute_subnetwork.subnets["dev-public"] (10.0.0.0/20) contains module.network.google_compute_subnetwork.subnets["dev-private"] (10.0.8.0/24)`, conflicts[0].String())
+	assert.ErrorContains(t, NoConflictsE(space), "1 overlapping ranges")
+}
+
+// TestFromTFVars tests CIDRs are collected from nested tfvars values
+func TestFromTFVars(t *testing.T) {
+	t.Parallel()
+
+	space, err := FromTFVars("testdata/network.tfvars", "subnets")
+	require.NoError(t, err)
+	require.Len(t, space.Ranges, 6)
+	assert.Equal(t, Range{Name: "subnets[1].secondary_ip_range_pods", Prefix: netip.MustParsePrefix("10.1.128.0/17")}, space.Ranges[4])
+
+	conflicts := space.Conflicts()
+	require.Len(t, conflicts, 1)
+	assert.Equal(t, "subnets[0].secondary_ip_range_pods", conflicts[0].Outer.Name)
+	assert.Equal(t, "subnets[1].secondary_ip_range_pods", conflicts[0].Inner.Name)
+
+	// Without names the firewall source ranges are read too, and collide
+	all, err := FromTFVars("testdata/network.tfvars")
+	require.NoError(t, err)
+	assert.Len(t, all.Conflicts(), 2)
+	assert.Equal(t, "rdp_source_ranges[0] (35.235.240.0/20) and ssh_source_ranges[0] (35.235.240.0/20) are the same range", all.Conflicts()[1].String())
+
+	_, err = FromTFVars("testdata/network.tfvars", "vpc_name")
+	assert.ErrorContains(t, err, "variable vpc_name is not set")
+}
+
+// TestAdd tests on-prem ranges are checked alongside planned ones
+func TestAdd(t *testing.T) {
+	t.Parallel()
+
+	space := &Space{}
+	require.NoError(t, space.Add("subnet", "10.0.0.0/24"))
+	require.NoError(t, space.Add("on-prem", "10.0.0.0/16"))
+	assert.Equal(t, "on-prem (10.0.0.0/16) contains subnet (10.0.0.0/24)", space.Conflicts()[0].String())
+
+	assert.ErrorContains(t, space.Add("typo", "10.0.0.1/24"), "typo: 10.0.0.1/24 has host bits set, want 10.0.0.0/24")
+	assert.Error(t, space.Add("typo", "10.0.0.0/33"))
+}
+
+// TestFree tests free blocks, allocation and reservation placement
+func TestFree(t *testing.T) {
+	t.Parallel()
+
+	space := &Space{}
+	require.NoError(t, space.Add("a", "10.0.0.0/24"))
+	require.NoError(t, space.Add("b", "10.0.2.0/23"))
+	require.NoError(t, space.Add("elsewhere", "192.168.0.0/16"))
+	within := netip.MustParsePrefix("10.0.0.0/22")
+
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.0.1.0/24")}, space.Free(within))
+	assert.Equal(t, []netip.Prefix{within}, (&Space{}).Free(within))
+	assert.Empty(t, space.Free(netip.MustParsePrefix("10.0.2.0/24")))
+
+	prefix, ok := space.Allocate(netip.MustParsePrefix("10.0.0.0/16"), 20)
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.16.0/20", prefix.String())
+	_, ok = space.Allocate(within, 23)
+	assert.False(t, ok)
+
+	space.Reservations = []Reservation{{Name: "psa", Bits: 16}, {Name: "small", Bits: 24}}
+	placed, err := space.Place(netip.MustParsePrefix("10.0.0.0/15"))
+	require.NoError(t, err)
+	assert.Equal(t, []Range{
+		{Name: "psa", Prefix: netip.MustParsePrefix("10.1.0.0/16")},
+		{Name: "small", Prefix: netip.MustParsePrefix("10.0.1.0/24")},
+	}, placed)
+	_, err = space.Place(netip.MustParsePrefix("10.0.0.0/16"))
+	assert.ErrorContains(t, err, "psa: no free /16 left in 10.0.0.0/16")
+}
+
+// TestWriteReport tests the report lists ranges, conflicts and free space
+func TestWriteReport(t *testing.T) {
+	t.Parallel()
+
+	space, err := FromPlan(planassert.LoadPlanFile(t, "testdata/plan.json"))
+	require.NoError(t, err)
+	var report strings.Builder
+	require.NoError(t, WriteReport(&report, space, netip.MustParsePrefix("10.0.0.0/8")))
+
+	assert.Contains(t, report.String(), "10.0.0.0/20   4096       module.network.google_compute_subnetwork.subnets[\"dev-public\"]")
+	assert.Contains(t, report.String(), "10.1.0.0/16   65536      module.network.google_compute_global_address.private_ip_range (if allocated lowest first)")
+	assert.Contains(t, report.String(), "1 conflicts")
+	assert.Contains(t, report.String(), "16564224 of 16777216 addresses free in 10.0.0.0/8, largest block /9")
+}
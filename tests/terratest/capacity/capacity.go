@@ -0,0 +1,315 @@
+// Package capacity checks that planned subnets have enough addresses for the
+// VMs and internal load balancers that land in them, with every managed
+// instance group at full autoscale and surging through a rolling update.
+package capacity
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/gruntwork-io/terratest/modules/testing"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// reservedAddresses are the addresses GCP keeps in every primary range: the
+// network, default gateway, second-to-last and broadcast addresses
+const reservedAddresses = 4
+
+// Subnet is a primary subnet range VMs take addresses from
+type Subnet struct {
+	// Address is empty for subnets passed in rather than planned
+	Address string
+	Name    string
+	Region  string
+	Prefix  netip.Prefix
+}
+
+// Usable returns the number of addresses VMs and load balancers can use
+func (s Subnet) Usable() int {
+	hostBits := s.Prefix.Addr().BitLen() - s.Prefix.Bits()
+	if hostBits > 30 {
+		return math.MaxInt32
+	}
+	return 1<<hostBits - reservedAddresses
+}
+
+// Group is a planned managed instance group
+type Group struct {
+	Address     string
+	Region      string
+	TargetSize  int
+	MaxReplicas int
+	// MaxSurge is how many instances an update adds above the group's size
+	MaxSurge int
+	// Subnetwork is the template's subnetwork, empty when unknown until apply
+	Subnetwork string
+}
+
+// Peak returns the most instances the group runs at once: its autoscaling
+// maximum, or target size without an autoscaler, plus the update surge
+func (g Group) Peak() int {
+	return max(g.TargetSize, g.MaxReplicas) + g.MaxSurge
+}
+
+// Endpoint is a planned resource that takes one address in a subnet: a
+// standalone instance, an internal address or an internal forwarding rule
+type Endpoint struct {
+	Address    string
+	Region     string
+	Subnetwork string
+}
+
+// Usage is a subnet and what lands in it
+type Usage struct {
+	Subnet    Subnet
+	Groups    []Group
+	Endpoints []Endpoint
+}
+
+// Demand returns the addresses needed with every group at its peak
+func (u Usage) Demand() int {
+	demand := len(u.Endpoints)
+	for _, group := range u.Groups {
+		demand += group.Peak()
+	}
+	return demand
+}
+
+// Headroom returns the usable addresses left at peak, negative when the
+// subnet would run out
+func (u Usage) Headroom() int {
+	return u.Subnet.Usable() - u.Demand()
+}
+
+func (u Usage) String() string {
+	parts := make([]string, 0, len(u.Groups)+1)
+	for _, group := range u.Groups {
+		parts = append(parts, fmt.Sprintf("%s: %d", group.Address, group.Peak()))
+	}
+	if len(u.Endpoints) > 0 {
+		parts = append(parts, fmt.Sprintf("%d other addresses", len(u.Endpoints)))
+	}
+	return fmt.Sprintf("%s (%s) needs %d of %d usable addresses at peak (%s)",
+		u.Subnet.Name, u.Subnet.Prefix, u.Demand(), u.Subnet.Usable(), strings.Join(parts, ", "))
+}
+
+var (
+	groupTypes      = []string{"google_compute_region_instance_group_manager", "google_compute_instance_group_manager"}
+	autoscalerTypes = []string{"google_compute_region_autoscaler", "google_compute_autoscaler"}
+)
+
+// vmPurposes are the subnet purposes that hand out addresses to VMs; proxy
+// only and Private Service Connect subnets are left out
+var vmPurposes = map[string]bool{"": true, "PRIVATE": true}
+
+// FromPlan computes the usage of every planned subnet, plus the given
+// subnets that exist outside the plan. Groups find their instance template
+// and autoscaler in the same module. A subnetwork unknown until apply is
+// taken to be the only VM subnet in the resource's region.
+func FromPlan(plan *terraform.PlanStruct, existing ...Subnet) ([]Usage, error) {
+	var usages []Usage
+	for _, subnet := range existing {
+		usages = append(usages, Usage{Subnet: subnet})
+	}
+	for _, resource := range planassert.ResourcesOfType(plan, "google_compute_subnetwork") {
+		values := resource.AttributeValues
+		if purpose, _ := values["purpose"].(string); !vmPurposes[purpose] {
+			continue
+		}
+		subnet := Subnet{Address: resource.Address}
+		subnet.Name, _ = values["name"].(string)
+		subnet.Region, _ = values["region"].(string)
+		cidr, _ := values["ip_cidr_range"].(string)
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", resource.Address, err)
+		}
+		subnet.Prefix = prefix
+		usages = append(usages, Usage{Subnet: subnet})
+	}
+	sort.SliceStable(usages, func(i, j int) bool { return usages[i].Subnet.Name < usages[j].Subnet.Name })
+
+	groups, err := groupsOf(plan)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		i, err := resolve(usages, group.Address, group.Subnetwork, group.Region)
+		if err != nil {
+			return nil, err
+		}
+		usages[i].Groups = append(usages[i].Groups, group)
+	}
+	for _, endpoint := range endpointsOf(plan) {
+		i, err := resolve(usages, endpoint.Address, endpoint.Subnetwork, endpoint.Region)
+		if err != nil {
+			return nil, err
+		}
+		usages[i].Endpoints = append(usages[i].Endpoints, endpoint)
+	}
+	return usages, nil
+}
+
+// groupsOf reads the planned groups with their templates and autoscalers
+func groupsOf(plan *terraform.PlanStruct) ([]Group, error) {
+	templates := byModule(plan, "google_compute_instance_template", "google_compute_region_instance_template")
+	autoscalers := byModule(plan, autoscalerTypes...)
+	resources := byModule(plan, groupTypes...)
+
+	var groups []Group
+	for _, resource := range planResources(plan, groupTypes...) {
+		module := planassert.ModulePath(resource)
+		values := resource.AttributeValues
+		group := Group{
+			Address:    resource.Address,
+			Region:     location(values),
+			TargetSize: intValue(values, "target_size"),
+		}
+
+		switch t := templates[module]; len(t) {
+		case 0:
+		case 1:
+			group.Subnetwork = planassert.String(t[0].AttributeValues, "network_interface.0.subnetwork")
+		default:
+			return nil, fmt.Errorf("%s: %d instance templates in %s, cannot tell which one it uses", resource.Address, len(t), moduleName(module))
+		}
+
+		for _, autoscaler := range autoscalers[module] {
+			target := planassert.String(autoscaler.AttributeValues, "target")
+			name, _ := values["name"].(string)
+			if target == "" && len(resources[module]) > 1 {
+				return nil, fmt.Errorf("%s: target unknown until apply and %s has several groups", autoscaler.Address, moduleName(module))
+			}
+			if target == "" || planassert.LastSegment(target) == name {
+				group.MaxReplicas = intValue(autoscaler.AttributeValues, "autoscaling_policy.0.max_replicas")
+			}
+		}
+
+		group.MaxSurge = intValue(values, "update_policy.0.max_surge_fixed")
+		if percent := intValue(values, "update_policy.0.max_surge_percent"); percent > 0 {
+			size := max(group.TargetSize, group.MaxReplicas)
+			group.MaxSurge = (size*percent + 99) / 100
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// endpointsOf reads the planned resources that take a single address
+func endpointsOf(plan *terraform.PlanStruct) []Endpoint {
+	var endpoints []Endpoint
+	for _, resource := range planassert.ResourcesOfType(plan, "google_compute_instance") {
+		values := resource.AttributeValues
+		endpoints = append(endpoints, Endpoint{resource.Address, location(values), planassert.String(values, "network_interface.0.subnetwork")})
+	}
+	for _, resource := range planassert.ResourcesOfType(plan, "google_compute_address") {
+		values := resource.AttributeValues
+		if planassert.String(values, "address_type") == "INTERNAL" {
+			endpoints = append(endpoints, Endpoint{resource.Address, location(values), planassert.String(values, "subnetwork")})
+		}
+	}
+	// Rules that use a reserved internal address count it a second time,
+	// which errs towards running out
+	for _, resource := range planassert.ResourcesOfType(plan, "google_compute_forwarding_rule") {
+		values := resource.AttributeValues
+		if scheme := planassert.String(values, "load_balancing_scheme"); scheme == "INTERNAL" || scheme == "INTERNAL_MANAGED" {
+			endpoints = append(endpoints, Endpoint{resource.Address, location(values), planassert.String(values, "subnetwork")})
+		}
+	}
+	return endpoints
+}
+
+// resolve finds the usage of the subnet a resource lands in
+func resolve(usages []Usage, address, subnetwork, region string) (int, error) {
+	if subnetwork != "" {
+		name := planassert.LastSegment(subnetwork)
+		for i, usage := range usages {
+			if usage.Subnet.Name == name && (region == "" || usage.Subnet.Region == region) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("%s: subnetwork %s is not planned; pass it to FromPlan", address, subnetwork)
+	}
+
+	var candidates []int
+	for i, usage := range usages {
+		if usage.Subnet.Region == region {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) != 1 {
+		return 0, fmt.Errorf("%s: subnetwork unknown until apply and %d subnets in %s", address, len(candidates), region)
+	}
+	return candidates[0], nil
+}
+
+// FitsE returns an error listing the subnets that would run out of addresses
+func FitsE(usages []Usage) error {
+	var exhausted []string
+	for _, usage := range usages {
+		if usage.Headroom() < 0 {
+			exhausted = append(exhausted, usage.String())
+		}
+	}
+	if len(exhausted) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d subnets would run out of addresses:\n%s", len(exhausted), strings.Join(exhausted, "\n"))
+}
+
+// Fits asserts no subnet would run out of addresses
+func Fits(t testing.TestingT, usages []Usage) bool {
+	return assert.NoError(t, FitsE(usages))
+}
+
+// planResources returns the planned resources of the given types by address
+func planResources(plan *terraform.PlanStruct, resourceTypes ...string) []*tfjson.StateResource {
+	var resources []*tfjson.StateResource
+	for _, resourceType := range resourceTypes {
+		resources = append(resources, planassert.ResourcesOfType(plan, resourceType)...)
+	}
+	sort.Slice(resources, func(i, j int) bool { return resources[i].Address < resources[j].Address })
+	return resources
+}
+
+// byModule groups the planned resources of the given types by module path
+func byModule(plan *terraform.PlanStruct, resourceTypes ...string) map[string][]*tfjson.StateResource {
+	modules := map[string][]*tfjson.StateResource{}
+	for _, resource := range planResources(plan, resourceTypes...) {
+		module := planassert.ModulePath(resource)
+		modules[module] = append(modules[module], resource)
+	}
+	return modules
+}
+
+func moduleName(module string) string {
+	if module == "" {
+		return "the root module"
+	}
+	return module
+}
+
+// location returns a resource's region, derived from its zone if it is zonal
+func location(values map[string]interface{}) string {
+	if region := planassert.String(values, "region"); region != "" {
+		return planassert.LastSegment(region)
+	}
+	zone := planassert.LastSegment(planassert.String(values, "zone"))
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		return zone[:i]
+	}
+	return zone
+}
+
+func intValue(values map[string]interface{}, path string) int {
+	value, _ := planassert.Lookup(values, path)
+	n, _ := value.(float64)
+	return int(n)
+}
@@ -0,0 +1,75 @@
+package capacity
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// TestFromPlan tests groups and endpoints land in their subnets at peak size
+func TestFromPlan(t *testing.T) {
+	t.Parallel()
+
+	usages, err := FromPlan(planassert.LoadPlanFile(t, "testdata/plan.json"))
+	require.NoError(t, err)
+	require.Len(t, usages, 2)
+
+	dev := usages[0]
+	assert.Equal(t, "dev-subnet-01", dev.Subnet.Name)
+	assert.Equal(t, 252, dev.Subnet.Usable())
+	require.Len(t, dev.Groups, 2)
+	// Autoscaled to 10 plus a surge of 3
+	assert.Equal(t, 13, dev.Groups[0].Peak())
+	// Target size 2 plus a 50% surge, rounded up
+	assert.Equal(t, 3, dev.Groups[1].Peak())
+	assert.Equal(t, []string{"google_compute_address.ilb_ip", "google_compute_forwarding_rule.ilb"}, endpointAddresses(dev))
+	assert.Equal(t, 18, dev.Demand())
+
+	dr := usages[1]
+	assert.Equal(t, "dr-subnet", dr.Subnet.Name)
+	assert.Equal(t, "europe-west4", dr.Groups[0].Region)
+	assert.Equal(t, -1, dr.Headroom())
+
+	err = FitsE(usages)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "dr-subnet (10.4.0.0/28) needs 13 of 12 usable addresses at peak (module.compute_dr.google_compute_instance_group_manager.mig: 12, 1 other addresses)")
+	assert.NotContains(t, err.Error(), "dev-subnet-01")
+}
+
+// TestFromPlanExisting tests subnets outside the plan and unresolvable ones
+func TestFromPlanExisting(t *testing.T) {
+	t.Parallel()
+	plan := planassert.LoadPlanFile(t, "testdata/plan.json")
+
+	// A second VM subnet in europe-west1 makes the unknown subnetworks ambiguous
+	_, err := FromPlan(plan, Subnet{Name: "default", Region: "europe-west1", Prefix: netip.MustParsePrefix("10.132.0.0/20")})
+	assert.ErrorContains(t, err, "module.compute_app_a.google_compute_region_instance_group_manager.mig: subnetwork unknown until apply and 2 subnets in europe-west1")
+
+	delete(plan.ResourcePlannedValuesMap, `module.network.google_compute_subnetwork.subnets["dr-subnet"]`)
+	_, err = FromPlan(plan)
+	assert.ErrorContains(t, err, "module.compute_dr.google_compute_instance_group_manager.mig: subnetwork dr-subnet is not planned; pass it to FromPlan")
+
+	usages, err := FromPlan(plan, Subnet{Name: "dr-subnet", Region: "europe-west4", Prefix: netip.MustParsePrefix("10.4.0.0/27")})
+	require.NoError(t, err)
+	assert.NoError(t, FitsE(usages))
+}
+
+// TestUsable tests GCP's four reserved addresses are subtracted
+func TestUsable(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, 4, Subnet{Prefix: netip.MustParsePrefix("10.0.0.0/29")}.Usable())
+	assert.Equal(t, 4092, Subnet{Prefix: netip.MustParsePrefix("10.0.0.0/20")}.Usable())
+}
+
+func endpointAddresses(usage Usage) []string {
+	var addresses []string
+	for _, endpoint := range usage.Endpoints {
+		addresses = append(addresses, endpoint.Address)
+	}
+	return addresses
+}
@@ -5,176 +5,652 @@ import (
 
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+	"github.com/unicredit/gcp-migration/tests/terratest/policy"
+	"github.com/unicredit/gcp-migration/tests/terratest/privateaccess"
+	"github.com/unicredit/gcp-migration/tests/terratest/recovery"
+	"github.com/unicredit/gcp-migration/tests/terratest/replica"
+	"github.com/unicredit/gcp-migration/tests/terratest/schedule"
+	"github.com/unicredit/gcp-migration/tests/terratest/secrets"
+	"github.com/unicredit/gcp-migration/tests/terratest/sqltier"
+	"github.com/unicredit/gcp-migration/tests/terratest/sqlupgrade"
+	"github.com/unicredit/gcp-migration/tests/terratest/tier"
+)
+
+const (
+	cloudSQLInstance = "module.cloudsql.google_sql_database_instance.instance"
+	cloudSQLReplica  = "module.cloudsql.google_sql_database_instance.read_replica[0]"
 )
 
 // TestCloudSQLModuleValidation validates the Cloud SQL module configuration
 func TestCloudSQLModuleValidation(t *testing.T) {
+	tier.Require(t, tier.Validate)
 	t.Parallel()
 
 	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "../../terraform/modules/cloudsql",
+		TerraformDir: moduleDir(t, "cloudsql"),
 		Vars: map[string]interface{}{
-			"project_id":     "test-project",
-			"region":         "europe-west1",
-			"environment":    "test",
-			"instance_name":  "test-db",
-			"database_type":  "postgresql",
+			"project_id":    "test-project",
+			"region":        "europe-west1",
+			"environment":   "test",
+			"instance_name": "test-db",
+			"database_type": "postgresql",
 		},
 		NoColor: true,
 	})
 
-	terraform.Validate(t, terraformOptions)
+	validate(t, terraformOptions)
 }
 
 // TestCloudSQLPostgreSQL tests PostgreSQL instance configuration
 func TestCloudSQLPostgreSQL(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/cloudsql",
-		Vars: map[string]interface{}{
-			"project_id":       "test-project",
-			"region":           "europe-west1",
-			"environment":      "test",
-			"instance_name":    "postgres-test",
-			"database_type":    "postgresql",
-			"database_version": "POSTGRES_15",
-			"tier":             "db-custom-2-4096",
-		},
-		NoColor: true,
+	terraformOptions := fixtureOptions(t, "cloudsql", map[string]interface{}{
+		"project_id":       "test-project",
+		"region":           "europe-west1",
+		"environment":      "test",
+		"instance_name":    "postgres-test",
+		"database_type":    "postgresql",
+		"database_version": "POSTGRES_15",
+		"tier":             "db-custom-2-4096",
 	})
 
-	terraform.Init(t, terraformOptions)
-	planOutput := terraform.Plan(t, terraformOptions)
+	plan := planWithStruct(t, terraformOptions)
 
 	// Verify PostgreSQL configuration
-	assert.Contains(t, planOutput, "google_sql_database_instance")
-	assert.Contains(t, planOutput, "POSTGRES_15")
+	planassert.AttributeEquals(t, plan, cloudSQLInstance, "database_version", "POSTGRES_15")
+	sqltier.Size(t, plan, cloudSQLInstance, 2, 4)
 }
 
 // TestCloudSQLSQLServer tests SQL Server instance configuration
 func TestCloudSQLSQLServer(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/cloudsql",
-		Vars: map[string]interface{}{
-			"project_id":       "test-project",
-			"region":           "europe-west1",
-			"environment":      "test",
-			"instance_name":    "sqlserver-test",
-			"database_type":    "sqlserver",
-			"database_version": "SQLSERVER_2019_STANDARD",
-			"tier":             "db-custom-2-4096",
-		},
-		NoColor: true,
+	terraformOptions := fixtureOptions(t, "cloudsql", map[string]interface{}{
+		"project_id":       "test-project",
+		"region":           "europe-west1",
+		"environment":      "test",
+		"instance_name":    "sqlserver-test",
+		"database_type":    "sqlserver",
+		"database_version": "SQLSERVER_2019_STANDARD",
+		"tier":             "db-custom-2-4096",
 	})
 
-	terraform.Init(t, terraformOptions)
-	planOutput := terraform.Plan(t, terraformOptions)
+	plan := planWithStruct(t, terraformOptions)
 
 	// Verify SQL Server configuration
-	assert.Contains(t, planOutput, "google_sql_database_instance")
-	assert.Contains(t, planOutput, "SQLSERVER_2019")
+	planassert.AttributeEquals(t, plan, cloudSQLInstance, "database_version", "SQLSERVER_2019_STANDARD")
+	sqltier.AtLeast(t, plan, cloudSQLInstance, 2, 3.75)
+	planassert.AttributeEquals(t, plan, cloudSQLInstance, "settings.0.backup_configuration.0.point_in_time_recovery_enabled", false)
+}
+
+// TestCloudSQLTierValidation tests tiers are checked against GCP's sizing
+// constraints for the engine
+func TestCloudSQLTierValidation(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		tier            string
+		databaseType    string
+		databaseVersion string
+		problem         string
+	}{
+		{"custom_postgres", "db-custom-4-16384", "postgresql", "POSTGRES_15", ""},
+		{"shared_core_postgres", "db-g1-small", "postgresql", "POSTGRES_15", ""},
+		{"odd_vcpus", "db-custom-3-12288", "postgresql", "POSTGRES_15", "3 vCPUs"},
+		{"too_little_memory_per_vcpu", "db-custom-8-4096", "postgresql", "POSTGRES_15", "512 MB per vCPU"},
+		{"shared_core_sqlserver", "db-g1-small", "sqlserver", "SQLSERVER_2019_STANDARD", "SQL Server needs a custom tier"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			terraformOptions := fixtureOptions(t, "cloudsql", map[string]interface{}{
+				"project_id":       "test-project",
+				"region":           "europe-west1",
+				"environment":      "test",
+				"instance_name":    "tier-test",
+				"database_type":    tc.databaseType,
+				"database_version": tc.databaseVersion,
+				"tier":             tc.tier,
+			})
+
+			plan := planWithStruct(t, terraformOptions)
+
+			err := policy.NoFindingsE(plan, "cloudsql-valid-tier")
+			if tc.problem != "" {
+				assert.ErrorContains(t, err, tc.problem)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
 }
 
 // TestCloudSQLHighAvailability tests HA configuration
 func TestCloudSQLHighAvailability(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/cloudsql",
-		Vars: map[string]interface{}{
-			"project_id":        "test-project",
-			"region":            "europe-west1",
-			"environment":       "test",
-			"instance_name":     "ha-test",
-			"database_type":     "postgresql",
-			"high_availability": true,
-			"availability_type": "REGIONAL",
-		},
-		NoColor: true,
+	terraformOptions := fixtureOptions(t, "cloudsql", map[string]interface{}{
+		"project_id":        "test-project",
+		"region":            "europe-west1",
+		"environment":       "test",
+		"instance_name":     "ha-test",
+		"database_type":     "postgresql",
+		"high_availability": true,
+		"availability_type": "REGIONAL",
 	})
 
-	terraform.Init(t, terraformOptions)
-	planOutput := terraform.Plan(t, terraformOptions)
+	plan := planWithStruct(t, terraformOptions)
 
 	// Verify HA configuration
-	assert.Contains(t, planOutput, "REGIONAL")
+	planassert.AttributeEquals(t, plan, cloudSQLInstance, "settings.0.availability_type", "REGIONAL")
 }
 
 // TestCloudSQLPrivateIP tests private IP configuration
 func TestCloudSQLPrivateIP(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/cloudsql",
-		Vars: map[string]interface{}{
-			"project_id":      "test-project",
-			"region":          "europe-west1",
-			"environment":     "test",
-			"instance_name":   "private-ip-test",
-			"database_type":   "postgresql",
-			"ipv4_enabled":    false,
-			"private_network": "projects/test-project/global/networks/test-vpc",
-		},
-		NoColor: true,
+	terraformOptions := fixtureOptions(t, "cloudsql", map[string]interface{}{
+		"project_id":      "test-project",
+		"region":          "europe-west1",
+		"environment":     "test",
+		"instance_name":   "private-ip-test",
+		"database_type":   "postgresql",
+		"ipv4_enabled":    false,
+		"private_network": "projects/test-project/global/networks/test-vpc",
 	})
 
-	terraform.Init(t, terraformOptions)
-	planStruct := terraform.InitAndPlanAndShowWithStruct(t, terraformOptions)
+	plan := planWithStruct(t, terraformOptions)
 
 	// Verify private IP configuration
-	assert.NotNil(t, planStruct.ResourcePlannedValuesMap)
+	planassert.AttributeEquals(t, plan, cloudSQLInstance, "settings.0.ip_configuration.0.ipv4_enabled", false)
+	planassert.AttributeEquals(t, plan, cloudSQLInstance, "settings.0.ip_configuration.0.private_network", "projects/test-project/global/networks/test-vpc")
+}
+
+// TestCloudSQLPrivateServicesAccess tests a private IP instance is only
+// accepted with a service networking connection passed in for its VPC
+func TestCloudSQLPrivateServicesAccess(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	testCases := []struct {
+		name                 string
+		privateNetwork       string
+		privateVPCConnection string
+		problem              string
+	}{
+		{"public_only", "", "", ""},
+		{"connection_input", "projects/test-project/global/networks/test-vpc", "projects/test-project/global/networks/test-vpc:servicenetworking.googleapis.com", ""},
+		{"no_connection", "projects/test-project/global/networks/test-vpc", "", "no service networking connection"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			vars := map[string]interface{}{
+				"project_id":      "test-project",
+				"region":          "europe-west1",
+				"environment":     "test",
+				"instance_name":   "private-access-test",
+				"database_type":   "postgresql",
+				"ipv4_enabled":    tc.privateNetwork == "",
+				"private_network": tc.privateNetwork,
+			}
+			if tc.privateVPCConnection != "" {
+				vars["private_vpc_connection"] = tc.privateVPCConnection
+			}
+			plan := planWithStruct(t, fixtureOptions(t, "cloudsql", vars))
+
+			err := privateaccess.ConnectedE(plan)
+			if tc.problem != "" {
+				assert.ErrorContains(t, err, tc.problem)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
 }
 
 // TestCloudSQLBackupConfiguration tests backup configuration
 func TestCloudSQLBackupConfiguration(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/cloudsql",
-		Vars: map[string]interface{}{
-			"project_id":         "test-project",
-			"region":             "europe-west1",
-			"environment":        "test",
-			"instance_name":      "backup-test",
-			"database_type":      "postgresql",
-			"backup_enabled":     true,
-			"backup_start_time":  "03:00",
-			"retained_backups":   14,
-			"transaction_log_retention_days": 7,
-		},
-		NoColor: true,
+	terraformOptions := fixtureOptions(t, "cloudsql", map[string]interface{}{
+		"project_id":                     "test-project",
+		"region":                         "europe-west1",
+		"environment":                    "test",
+		"instance_name":                  "backup-test",
+		"database_type":                  "postgresql",
+		"backup_enabled":                 true,
+		"backup_start_time":              "03:00",
+		"retained_backups":               14,
+		"transaction_log_retention_days": 7,
 	})
 
-	terraform.Init(t, terraformOptions)
-	planOutput := terraform.Plan(t, terraformOptions)
+	plan := planWithStruct(t, terraformOptions)
+
+	// Verify backup configuration
+	backup := "settings.0.backup_configuration.0."
+	planassert.AttributeEquals(t, plan, cloudSQLInstance, backup+"enabled", true)
+	planassert.AttributeEquals(t, plan, cloudSQLInstance, backup+"start_time", "03:00")
+	planassert.AttributeEquals(t, plan, cloudSQLInstance, backup+"transaction_log_retention_days", 7)
+	planassert.AttributeEquals(t, plan, cloudSQLInstance, backup+"backup_retention_settings.0.retained_backups", 14)
+}
+
+// TestCloudSQLRecoveryObjectives tests the RPO, RTO and restore window
+// derived from the planned backup and availability settings against the
+// targets for the environment
+func TestCloudSQLRecoveryObjectives(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	testCases := []struct {
+		name             string
+		environment      string
+		databaseType     string
+		databaseVersion  string
+		highAvailability bool
+		pitr             bool
+		retainedBackups  int
+		problem          string
+	}{
+		{"prod_postgres", "prod", "postgresql", "POSTGRES_15", true, true, 7, ""},
+		{"prod_zonal", "prod", "postgresql", "POSTGRES_15", false, true, 7, "RTO 1h0m0s exceeds 5m0s"},
+		{"prod_without_pitr", "prod", "postgresql", "POSTGRES_15", true, false, 7, "point-in-time recovery is off"},
+		{"prod_short_retention", "prod", "postgresql", "POSTGRES_15", true, true, 3, "restore window of 3 days"},
+		// The module forces point-in-time recovery off for SQL Server
+		{"prod_sqlserver", "prod", "sqlserver", "SQLSERVER_2019_STANDARD", true, true, 7, "RPO 24h0m0s exceeds 15m0s"},
+		{"test_zonal", "test", "postgresql", "POSTGRES_15", false, false, 1, ""},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			terraformOptions := fixtureOptions(t, "cloudsql", map[string]interface{}{
+				"project_id":             "test-project",
+				"region":                 "europe-west1",
+				"environment":            tc.environment,
+				"instance_name":          "recovery-test",
+				"database_type":          tc.databaseType,
+				"database_version":       tc.databaseVersion,
+				"high_availability":      tc.highAvailability,
+				"point_in_time_recovery": tc.pitr,
+				"retained_backups":       tc.retainedBackups,
+			})
+
+			plan := planWithStruct(t, terraformOptions)
+
+			err := recovery.MeetsE(plan, recovery.DefaultTargets)
+			if tc.problem != "" {
+				assert.ErrorContains(t, err, tc.problem)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestCloudSQLMaintenanceSchedule tests backup and maintenance windows are
+// checked against each other and the application's business hours in UTC
+func TestCloudSQLMaintenanceSchedule(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		backupStartTime string
+		maintenanceDay  int
+		maintenanceHour int
+		problem         string
+	}{
+		{"overnight", "22:00", 7, 3, ""},
+		// Backups may start up to 4 hours after the start time
+		{"backup_into_maintenance", "01:00", 7, 3, "overlaps maintenance window Sun 03:00-04:00 UTC"},
+		// 08:00 in Rome is 06:00 UTC in summer
+		{"backup_into_business_hours", "03:00", 7, 1, "overlaps app-a business hours Mon 06:00-16:00 UTC"},
+		{"maintenance_in_business_hours", "22:00", 2, 10, "maintenance window Tue 10:00-11:00 UTC overlaps app-a business hours"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			terraformOptions := fixtureOptions(t, "cloudsql", map[string]interface{}{
+				"project_id":              "test-project",
+				"region":                  "europe-west1",
+				"environment":             "test",
+				"application":             "app-a",
+				"instance_name":           "schedule-test",
+				"database_type":           "postgresql",
+				"backup_start_time":       tc.backupStartTime,
+				"maintenance_window_day":  tc.maintenanceDay,
+				"maintenance_window_hour": tc.maintenanceHour,
+			})
+
+			plan := planWithStruct(t, terraformOptions)
 
-	// Verify backup configuration is present
-	assert.Contains(t, planOutput, "backup_configuration")
+			err := schedule.NoConflictsE(plan, schedule.DefaultBusinessHours)
+			if tc.problem != "" {
+				assert.ErrorContains(t, err, tc.problem)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
 }
 
-// TestCloudSQLDeletionProtection tests deletion protection
+// TestCloudSQLReadReplica tests replicas are compared with their primary and
+// checked for reachability from their region
+func TestCloudSQLReadReplica(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	const testVPC = "projects/test-project/global/networks/test-vpc"
+	testCases := []struct {
+		name           string
+		replicaRegion  string
+		privateNetwork string
+		flags          []map[string]string
+		divergences    []string
+		unreachable    []string
+	}{
+		// The module copies neither maintenance nor insights settings
+		{"same_region", "europe-west1", "", nil, []string{"settings.0.maintenance_window", "settings.0.insights_config"}, []string{"no private network"}},
+		{"flags_not_copied", "europe-west1", "", []map[string]string{{"name": "max_connections", "value": "500"}}, []string{"settings.0.database_flags"}, []string{"no private network"}},
+		{"cross_region", "europe-west4", "", nil, []string{"settings.0.maintenance_window"}, []string{"no private network"}},
+		// The fixture plans no subnet or private services access for the VPC
+		{"cross_region_private", "europe-west4", testVPC, nil, []string{"settings.0.maintenance_window"}, []string{
+			"no subnet of test-vpc in europe-west4",
+			"no private services access connection for test-vpc",
+		}},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			vars := map[string]interface{}{
+				"project_id":          "test-project",
+				"region":              "europe-west1",
+				"environment":         "test",
+				"instance_name":       "replica-test",
+				"database_type":       "postgresql",
+				"create_read_replica": true,
+				"replica_region":      tc.replicaRegion,
+			}
+			if tc.flags != nil {
+				vars["database_flags"] = tc.flags
+			}
+			if tc.privateNetwork != "" {
+				vars["private_network"] = tc.privateNetwork
+			}
+			plan := planWithStruct(t, fixtureOptions(t, "cloudsql", vars))
+
+			planassert.AttributeEquals(t, plan, cloudSQLReplica, "region", tc.replicaRegion)
+			err := replica.ConsistentE(plan)
+			for _, setting := range tc.divergences {
+				assert.ErrorContains(t, err, setting)
+			}
+			err = replica.ReachableE(plan)
+			for _, problem := range tc.unreachable {
+				assert.ErrorContains(t, err, problem)
+			}
+		})
+	}
+}
+
+// TestCloudSQLDeletionProtection tests deletion protection is planned as set
+// and required of production instances only
 func TestCloudSQLDeletionProtection(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/cloudsql",
-		Vars: map[string]interface{}{
-			"project_id":          "test-project",
-			"region":              "europe-west1",
-			"environment":         "prod",
-			"instance_name":       "deletion-protection-test",
-			"database_type":       "postgresql",
-			"deletion_protection": true,
-		},
-		NoColor: true,
-	})
+	testCases := []struct {
+		name               string
+		environment        string
+		deletionProtection bool
+		violation          bool
+	}{
+		{"prod_protected", "prod", true, false},
+		{"prod_unprotected", "prod", false, true},
+		{"test_unprotected", "test", false, false},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			terraformOptions := fixtureOptions(t, "cloudsql", map[string]interface{}{
+				"project_id":          "test-project",
+				"region":              "europe-west1",
+				"environment":         tc.environment,
+				"instance_name":       "deletion-protection-test",
+				"database_type":       "postgresql",
+				"deletion_protection": tc.deletionProtection,
+			})
+
+			plan := planWithStruct(t, terraformOptions)
+
+			planassert.AttributeEquals(t, plan, cloudSQLInstance, "deletion_protection", tc.deletionProtection)
+			err := policy.NoFindingsE(plan, "cloudsql-deletion-protection")
+			if tc.violation {
+				assert.ErrorContains(t, err, "deletion_protection is off")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestCloudSQLProductionPolicy tests the Cloud SQL policy pack against
+// production configurations of the module
+func TestCloudSQLProductionPolicy(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	var ids []string
+	for _, rule := range policy.CloudSQLRules(policy.DefaultCloudSQLConfig) {
+		ids = append(ids, rule.ID)
+	}
+
+	testCases := []struct {
+		name       string
+		vars       map[string]interface{}
+		violations []string
+	}{
+		{"compliant", nil, nil},
+		{"zonal", map[string]interface{}{"high_availability": false}, []string{"cloudsql-regional-availability"}},
+		{"public_ip", map[string]interface{}{"ipv4_enabled": true}, []string{"cloudsql-no-public-ip"}},
+		{"no_backups", map[string]interface{}{"backup_enabled": false}, []string{"cloudsql-backups"}},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			vars := map[string]interface{}{
+				"project_id":          "test-project",
+				"region":              "europe-west1",
+				"environment":         "prod",
+				"instance_name":       "policy-test",
+				"database_type":       "postgresql",
+				"high_availability":   true,
+				"deletion_protection": true,
+			}
+			for name, value := range tc.vars {
+				vars[name] = value
+			}
+			plan := planWithStruct(t, fixtureOptions(t, "cloudsql", vars))
+
+			selected, err := policy.Default.Select(ids...)
+			require.NoError(t, err)
+			var violations []string
+			for _, finding := range selected.Evaluate(plan) {
+				violations = append(violations, finding.Rule)
+			}
+			assert.Equal(t, tc.violations, violations)
+		})
+	}
+}
+
+// TestCloudSQLDatabaseFlags tests planned database flags against the catalog
+// for the instance's engine and version
+func TestCloudSQLDatabaseFlags(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		environment     string
+		databaseType    string
+		databaseVersion string
+		flags           []map[string]string
+		problem         string
+	}{
+		{"valid_postgres", "prod", "postgresql", "POSTGRES_15", []map[string]string{{"name": "log_connections", "value": "on"}}, ""},
+		{"unknown_flag", "prod", "postgresql", "POSTGRES_15", []map[string]string{{"name": "log_conections", "value": "on"}}, `unknown flag "log_conections"`},
+		{"postgres_flag_on_sqlserver", "prod", "sqlserver", "SQLSERVER_2019_STANDARD", []map[string]string{{"name": "work_mem", "value": "4096"}}, `flag "work_mem" is PostgreSQL-only`},
+		{"restart_in_prod", "prod", "postgresql", "POSTGRES_15", []map[string]string{{"name": "max_connections", "value": "500"}}, "restarts the instance"},
+		{"restart_in_test", "test", "postgresql", "POSTGRES_15", []map[string]string{{"name": "max_connections", "value": "500"}}, ""},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			terraformOptions := fixtureOptions(t, "cloudsql", map[string]interface{}{
+				"project_id":       "test-project",
+				"region":           "europe-west1",
+				"environment":      tc.environment,
+				"instance_name":    "flags-test",
+				"database_type":    tc.databaseType,
+				"database_version": tc.databaseVersion,
+				"database_flags":   tc.flags,
+			})
+
+			plan := planWithStruct(t, terraformOptions)
+
+			planassert.AttributeEquals(t, plan, cloudSQLInstance, "settings.0.database_flags.0.name", tc.flags[0]["name"])
+			err := policy.NoFindingsE(plan, "cloudsql-database-flags")
+			if tc.problem != "" {
+				assert.ErrorContains(t, err, tc.problem)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestCloudSQLUpgradePath tests Cloud SQL can take the legacy database
+// versions to the planned ones with the planned flags
+func TestCloudSQLUpgradePath(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		legacy          string
+		databaseType    string
+		databaseVersion string
+		flags           []map[string]string
+		problem         string
+	}{
+		{"app_a_9_6", "POSTGRES_9_6", "postgresql", "POSTGRES_15", []map[string]string{{"name": "log_connections", "value": "on"}}, ""},
+		{"app_a_10", "POSTGRES_10", "postgresql", "POSTGRES_16", []map[string]string{{"name": "log_connections", "value": "on"}}, ""},
+		{"app_b_2014", "SQLSERVER_2014", "sqlserver", "SQLSERVER_2019_STANDARD", []map[string]string{{"name": "max degree of parallelism", "value": "8"}}, ""},
+		{"app_b_2016", "SQLSERVER_2016", "sqlserver", "SQLSERVER_2022_STANDARD", []map[string]string{{"name": "max degree of parallelism", "value": "8"}}, ""},
+		{"removed_flag", "POSTGRES_10", "postgresql", "POSTGRES_15", []map[string]string{{"name": "wal_keep_segments", "value": "64"}}, `use "wal_keep_size"`},
+		{"changed_flag", "POSTGRES_9_6", "postgresql", "POSTGRES_15", []map[string]string{{"name": "password_encryption", "value": "md5"}}, "changes meaning"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			terraformOptions := fixtureOptions(t, "cloudsql", map[string]interface{}{
+				"project_id":       "test-project",
+				"region":           "europe-west1",
+				"environment":      "test",
+				"instance_name":    "upgrade-test",
+				"database_type":    tc.databaseType,
+				"database_version": tc.databaseVersion,
+				"database_flags":   tc.flags,
+			})
+
+			plan := planWithStruct(t, terraformOptions)
+
+			err := sqlupgrade.FromLegacyE(plan, cloudSQLInstance, tc.legacy)
+			if tc.problem != "" {
+				assert.ErrorContains(t, err, tc.problem)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestCloudSQLUserSecrets tests user passwords stay sensitive through the
+// module and PostgreSQL users get a password policy when it is enabled
+func TestCloudSQLUserSecrets(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	testCases := []struct {
+		name           string
+		databaseType   string
+		passwordPolicy bool
+		policyBlocks   int
+	}{
+		{"postgres_policy", "postgresql", true, 1},
+		{"postgres_no_policy", "postgresql", false, 0},
+		// The module only sets password policies on PostgreSQL users
+		{"sqlserver", "sqlserver", true, 0},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			terraformOptions := fixtureOptions(t, "cloudsql", map[string]interface{}{
+				"project_id":             "test-project",
+				"region":                 "europe-west1",
+				"environment":            "test",
+				"instance_name":          "secrets-test",
+				"database_type":          tc.databaseType,
+				"enable_password_policy": tc.passwordPolicy,
+				"users":                  []map[string]string{{"name": "app_user", "password": "plan-only-password"}},
+			})
 
-	terraform.Init(t, terraformOptions)
-	planOutput := terraform.Plan(t, terraformOptions)
+			plan := planWithStruct(t, terraformOptions)
 
-	// Verify deletion protection is enabled
-	assert.Contains(t, planOutput, "deletion_protection")
+			policies, err := planassert.Attribute(plan, `module.cloudsql.google_sql_user.users["app_user"]`, "password_policy")
+			require.NoError(t, err)
+			assert.Len(t, policies, tc.policyBlocks)
+			secrets.NoFindings(t, plan)
+		})
+	}
 }
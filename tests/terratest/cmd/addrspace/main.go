@@ -0,0 +1,75 @@
+// Command addrspace reports the IP ranges a plan or tfvars file allocates,
+// their overlaps and the free space left, and exits non-zero on overlaps or
+// when a reservation such as the private services range cannot fit.
+//
+//	terraform show -json plan.out > plan.json
+//	go run ./cmd/addrspace -on-prem 10.200.0.0/16,192.168.0.0/16 plan.json
+//	go run ./cmd/addrspace -vars subnets terraform.tfvars
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/addrspace"
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+func main() {
+	within := flag.String("within", "10.0.0.0/8", "supernet to report free space in and place reservations within")
+	onPrem := flag.String("on-prem", "", "comma separated on-prem ranges that must not overlap")
+	vars := flag.String("vars", "", "comma separated tfvars variables to read (default all)")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "addrspace: want one plan JSON or .tfvars file")
+		flag.Usage()
+		os.Exit(2)
+	}
+	supernet, err := netip.ParsePrefix(*within)
+	if err != nil {
+		fail(2, err)
+	}
+
+	space, err := load(flag.Arg(0), *vars)
+	if err != nil {
+		fail(2, err)
+	}
+	if *onPrem != "" {
+		for i, cidr := range strings.Split(*onPrem, ",") {
+			if err := space.Add(fmt.Sprintf("on-prem[%d]", i), strings.TrimSpace(cidr)); err != nil {
+				fail(2, err)
+			}
+		}
+	}
+
+	if err := addrspace.WriteReport(os.Stdout, space, supernet); err != nil {
+		fail(2, err)
+	}
+	if _, err := space.Place(supernet); err != nil || len(space.Conflicts()) > 0 {
+		os.Exit(1)
+	}
+}
+
+func load(path, vars string) (*addrspace.Space, error) {
+	if strings.HasSuffix(path, ".tfvars") {
+		var names []string
+		if vars != "" {
+			names = strings.Split(vars, ",")
+		}
+		return addrspace.FromTFVars(path, names...)
+	}
+	plan, err := planassert.LoadPlanFileE(path)
+	if err != nil {
+		return nil, err
+	}
+	return addrspace.FromPlan(plan)
+}
+
+func fail(code int, err error) {
+	fmt.Fprintf(os.Stderr, "addrspace: %v\n", err)
+	os.Exit(code)
+}
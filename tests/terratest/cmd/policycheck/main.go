@@ -0,0 +1,110 @@
+// Command policycheck evaluates the policy package's guardrails against plans
+// written by `terraform show -json` and exits non-zero when any finding
+// reaches the severity threshold. "-" reads a plan from stdin.
+//
+//	terraform show -json plan.out > plan.json
+//	go run ./cmd/policycheck -severity high plan.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+	"github.com/unicredit/gcp-migration/tests/terratest/policy"
+)
+
+func main() {
+	severity := flag.String("severity", "high", "fail on findings of this severity or higher (low, medium, high, critical)")
+	rules := flag.String("rules", "", "comma separated rule IDs to evaluate (default all)")
+	list := flag.Bool("list", false, "list the rules and exit")
+	asJSON := flag.Bool("json", false, "print findings as JSON")
+	flag.Parse()
+
+	registry := policy.Default
+	if *rules != "" {
+		selected, err := policy.Default.Select(strings.Split(*rules, ",")...)
+		if err != nil {
+			fail(2, err)
+		}
+		registry = selected
+	}
+	if *list {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, rule := range registry.Rules() {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", rule.ID, rule.Severity, rule.Description)
+		}
+		tw.Flush()
+		return
+	}
+
+	threshold, err := policy.ParseSeverity(*severity)
+	if err != nil {
+		fail(2, err)
+	}
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "policycheck: no plan files given")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := 0
+	var all []finding
+	for _, path := range flag.Args() {
+		plan, err := load(path)
+		if err != nil {
+			fail(2, err)
+		}
+		for _, f := range registry.Evaluate(plan) {
+			all = append(all, finding{Plan: path, Finding: f})
+			if f.Severity >= threshold {
+				failed++
+			}
+		}
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(all); err != nil {
+			fail(2, err)
+		}
+	} else {
+		for _, f := range all {
+			fmt.Printf("%s: %s\n", f.Plan, f.Finding)
+		}
+		fmt.Printf("%d findings, %d at or above %s\n", len(all), failed, threshold)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// finding is a policy finding with the plan it came from, as printed by -json
+type finding struct {
+	Plan string `json:"plan"`
+	policy.Finding
+}
+
+func load(path string) (*terraform.PlanStruct, error) {
+	if path != "-" {
+		return planassert.LoadPlanFileE(path)
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	return terraform.ParsePlanJSON(string(data))
+}
+
+func fail(code int, err error) {
+	fmt.Fprintf(os.Stderr, "policycheck: %v\n", err)
+	os.Exit(code)
+}
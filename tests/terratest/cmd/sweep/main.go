@@ -0,0 +1,85 @@
+// Command sweep deletes resources that interrupted test runs left in a test
+// project. It prints a dry-run report by default and only deletes with
+// -delete. Like terraform, it honours the google provider's
+// GOOGLE_*_CUSTOM_ENDPOINT and GOOGLE_OAUTH_ACCESS_TOKEN variables.
+// Resources that cannot carry the test_run_id label, such as networks,
+// firewalls and service accounts, are only swept for an explicit -run-id.
+//
+//	go run ./cmd/sweep -project my-test-project -older-than 6h
+//	go run ./cmd/sweep -project my-test-project -run-id abc123 -delete
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/naming"
+	"github.com/unicredit/gcp-migration/tests/terratest/sweep"
+)
+
+// labelFlag collects repeated -label key=value flags
+type labelFlag map[string]string
+
+func (l labelFlag) String() string {
+	var pairs []string
+	for key, value := range l {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (l labelFlag) Set(value string) error {
+	key, v, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("want key=value, got %q", value)
+	}
+	l[key] = v
+	return nil
+}
+
+func main() {
+	labels := labelFlag{}
+	project := flag.String("project", os.Getenv("GOOGLE_PROJECT"), "project to sweep (default $GOOGLE_PROJECT)")
+	runID := flag.String("run-id", "", "only sweep resources of this run, as set through "+naming.RunIDEnv+"; required to sweep unlabelled resources")
+	olderThan := flag.Duration("older-than", 2*time.Hour, "only sweep resources at least this old, so running tests keep theirs")
+	deleteResources := flag.Bool("delete", false, "delete the selected resources instead of only reporting them")
+	timeout := flag.Duration("timeout", 30*time.Minute, "give up after this long")
+	flag.Var(labels, "label", "only sweep resources with this key=value label (repeatable)")
+	flag.Parse()
+
+	if *project == "" {
+		fmt.Fprintln(os.Stderr, "sweep: -project is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*project, sweep.Filter{RunID: *runID, OlderThan: *olderThan, Labels: labels}, *deleteResources, *timeout); err != nil {
+		fmt.Fprintf(os.Stderr, "sweep: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(project string, filter sweep.Filter, deleteResources bool, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	sweeper, err := sweep.New(ctx, project, sweep.EndpointsFromEnv(os.Getenv))
+	if err != nil {
+		return err
+	}
+	candidates, err := sweeper.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if err := sweep.WriteReport(os.Stdout, candidates, sweeper.Now()); err != nil {
+		return err
+	}
+	if !deleteResources {
+		fmt.Println("dry run: pass -delete to delete the selected resources")
+		return nil
+	}
+	return sweeper.Delete(ctx, os.Stdout, candidates)
+}
@@ -1,18 +1,26 @@
 package test
 
 import (
+	"net/netip"
 	"testing"
 
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/capacity"
+	"github.com/unicredit/gcp-migration/tests/terratest/naming"
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+	"github.com/unicredit/gcp-migration/tests/terratest/tier"
 )
 
 // TestComputeModuleValidation validates the compute module configuration
 func TestComputeModuleValidation(t *testing.T) {
+	tier.Require(t, tier.Validate)
 	t.Parallel()
 
 	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "../../terraform/modules/compute",
+		TerraformDir: moduleDir(t, "compute"),
 		Vars: map[string]interface{}{
 			"project_id":    "test-project",
 			"region":        "europe-west1",
@@ -24,91 +32,83 @@ func TestComputeModuleValidation(t *testing.T) {
 		NoColor: true,
 	})
 
-	terraform.Validate(t, terraformOptions)
+	validate(t, terraformOptions)
 }
 
 // TestComputeModulePlan tests the compute module plan output
 func TestComputeModulePlan(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/compute",
-		Vars: map[string]interface{}{
-			"project_id":    "test-project",
-			"region":        "europe-west1",
-			"environment":   "test",
-			"instance_name": "test-instance",
-			"machine_type":  "e2-medium",
-			"instance_type": "linux",
-			"network":       "default",
-			"subnetwork":    "default",
-		},
-		NoColor: true,
+	terraformOptions := fixtureOptions(t, "compute", map[string]interface{}{
+		"project_id":    "test-project",
+		"region":        "europe-west1",
+		"environment":   "test",
+		"instance_name": "test-instance",
+		"machine_type":  "e2-medium",
+		"instance_type": "linux",
+		"network":       "default",
+		"subnetwork":    "default",
 	})
 
-	defer terraform.Destroy(t, terraformOptions)
-
-	terraform.Init(t, terraformOptions)
-	planOutput := terraform.Plan(t, terraformOptions)
+	plan := planWithStruct(t, terraformOptions)
+	instanceName := terraformOptions.Vars["instance_name"].(string)
 
 	// Verify expected resources
-	assert.Contains(t, planOutput, "google_compute_instance_template")
-	assert.Contains(t, planOutput, "google_compute_region_instance_group_manager")
-	assert.Contains(t, planOutput, "google_compute_health_check")
+	planassert.AttributeEquals(t, plan, "module.compute.google_compute_instance_template.template", "machine_type", "e2-medium")
+	planassert.AttributeEquals(t, plan, "module.compute.google_compute_region_instance_group_manager.mig", "base_instance_name", instanceName)
+	planassert.AttributeEquals(t, plan, "module.compute.google_compute_health_check.health_check", "http_health_check.0.request_path", "/health")
 }
 
 // TestComputeLinuxInstance tests Linux instance configuration
 func TestComputeLinuxInstance(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/compute",
-		Vars: map[string]interface{}{
-			"project_id":    "test-project",
-			"region":        "europe-west1",
-			"environment":   "test",
-			"instance_name": "linux-test",
-			"machine_type":  "e2-medium",
-			"instance_type": "linux",
-			"source_image":  "projects/rocky-linux-cloud/global/images/family/rocky-linux-9",
-		},
-		NoColor: true,
+	terraformOptions := fixtureOptions(t, "compute", map[string]interface{}{
+		"project_id":    "test-project",
+		"region":        "europe-west1",
+		"environment":   "test",
+		"instance_name": "linux-test",
+		"machine_type":  "e2-medium",
+		"instance_type": "linux",
+		"source_image":  "projects/rocky-linux-cloud/global/images/family/rocky-linux-9",
 	})
 
-	terraform.Init(t, terraformOptions)
-	planOutput := terraform.Plan(t, terraformOptions)
+	plan := planWithStruct(t, terraformOptions)
 
 	// Verify Linux-specific configuration
-	assert.Contains(t, planOutput, "rocky-linux")
+	planassert.AttributeContains(t, plan, "module.compute.google_compute_instance_template.template", "disk.0.source_image", "rocky-linux")
+	planassert.AttributeEquals(t, plan, "module.compute.google_compute_instance_template.template", "labels.os", "linux")
+	planassert.AttributeEquals(t, plan, "module.compute.google_compute_instance_template.template", "labels."+naming.LabelKey, naming.RunID())
 }
 
 // TestComputeWindowsInstance tests Windows instance configuration
 func TestComputeWindowsInstance(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/compute",
-		Vars: map[string]interface{}{
-			"project_id":    "test-project",
-			"region":        "europe-west1",
-			"environment":   "test",
-			"instance_name": "windows-test",
-			"machine_type":  "e2-standard-4",
-			"instance_type": "windows",
-			"source_image":  "projects/windows-cloud/global/images/family/windows-2022",
-		},
-		NoColor: true,
+	terraformOptions := fixtureOptions(t, "compute", map[string]interface{}{
+		"project_id":    "test-project",
+		"region":        "europe-west1",
+		"environment":   "test",
+		"instance_name": "windows-test",
+		"machine_type":  "e2-standard-4",
+		"instance_type": "windows",
+		"source_image":  "projects/windows-cloud/global/images/family/windows-2022",
 	})
 
-	terraform.Init(t, terraformOptions)
-	planOutput := terraform.Plan(t, terraformOptions)
+	plan := planWithStruct(t, terraformOptions)
 
 	// Verify Windows-specific configuration
-	assert.Contains(t, planOutput, "windows")
+	planassert.AttributeContains(t, plan, "module.compute.google_compute_instance_template.template", "disk.0.source_image", "windows-2022")
+	planassert.AttributeEquals(t, plan, "module.compute.google_compute_instance_template.template", "machine_type", "e2-standard-4")
+	planassert.AttributeEquals(t, plan, "module.compute.google_compute_instance_template.template", "labels.os", "windows")
 }
 
 // TestComputeAutoscaling tests autoscaling configuration
 func TestComputeAutoscaling(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
 	testCases := []struct {
@@ -136,55 +136,130 @@ func TestComputeAutoscaling(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-				TerraformDir: "./fixtures/compute",
-				Vars: map[string]interface{}{
-					"project_id":    "test-project",
-					"region":        "europe-west1",
-					"environment":   "test",
-					"instance_name": "autoscale-test",
-					"min_replicas":  tc.minReplicas,
-					"max_replicas":  tc.maxReplicas,
-				},
-				NoColor: true,
+			terraformOptions := fixtureOptions(t, "compute", map[string]interface{}{
+				"project_id":    "test-project",
+				"region":        "europe-west1",
+				"environment":   "test",
+				"instance_name": "autoscale-test",
+				"min_replicas":  tc.minReplicas,
+				"max_replicas":  tc.maxReplicas,
+			})
+
+			if tc.shouldFail {
+				assert.Error(t, planE(t, terraformOptions))
+				return
+			}
+
+			plan := planWithStruct(t, terraformOptions)
+			autoscaler := "module.compute.google_compute_region_autoscaler.autoscaler[0]"
+			planassert.AttributeEquals(t, plan, autoscaler, "autoscaling_policy.0.min_replicas", tc.minReplicas)
+			planassert.AttributeEquals(t, plan, autoscaler, "autoscaling_policy.0.max_replicas", tc.maxReplicas)
+		})
+	}
+}
+
+// TestComputeSubnetCapacity tests the group fits its subnet at full autoscale
+// plus the update surge. The fixture lands in an existing subnet, so its range
+// is given to the planner.
+func TestComputeSubnetCapacity(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		subnetCIDR  string
+		maxReplicas int
+		shouldFail  bool
+	}{
+		{
+			name:        "default_subnet",
+			subnetCIDR:  "10.132.0.0/20",
+			maxReplicas: 10,
+			shouldFail:  false,
+		},
+		{
+			// 12 usable addresses: 12 replicas fit, but not with the surge instance
+			name:        "exhausted_by_surge",
+			subnetCIDR:  "10.132.0.0/28",
+			maxReplicas: 12,
+			shouldFail:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			terraformOptions := fixtureOptions(t, "compute", map[string]interface{}{
+				"project_id":    "test-project",
+				"region":        "europe-west1",
+				"environment":   "test",
+				"instance_name": "capacity-test",
+				"subnetwork":    "default",
+				"max_replicas":  tc.maxReplicas,
 			})
 
-			terraform.Init(t, terraformOptions)
+			plan := planWithStruct(t, terraformOptions)
+			usages, err := capacity.FromPlan(plan, capacity.Subnet{
+				Name:   "default",
+				Region: "europe-west1",
+				Prefix: netip.MustParsePrefix(tc.subnetCIDR),
+			})
+			require.NoError(t, err)
 
 			if tc.shouldFail {
-				_, err := terraform.PlanE(t, terraformOptions)
-				assert.Error(t, err)
-			} else {
-				terraform.Plan(t, terraformOptions)
+				assert.Error(t, capacity.FitsE(usages))
+				return
 			}
+			capacity.Fits(t, usages)
 		})
 	}
 }
 
 // TestComputeNoPublicIP verifies instances don't have public IPs
 func TestComputeNoPublicIP(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/compute",
-		Vars: map[string]interface{}{
-			"project_id":       "test-project",
-			"region":           "europe-west1",
-			"environment":      "test",
-			"instance_name":    "no-public-ip-test",
-			"assign_public_ip": false,
+	testCases := []struct {
+		name           string
+		assignPublicIP bool
+		shouldFail     bool
+	}{
+		{
+			name:           "private_only",
+			assignPublicIP: false,
+			shouldFail:     false,
 		},
-		NoColor: true,
-	})
+		{
+			name:           "public_ip_requested",
+			assignPublicIP: true,
+			shouldFail:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			terraformOptions := fixtureOptions(t, "compute", map[string]interface{}{
+				"project_id":       "test-project",
+				"region":           "europe-west1",
+				"environment":      "test",
+				"instance_name":    "no-public-ip-test",
+				"assign_public_ip": tc.assignPublicIP,
+			})
 
-	terraform.Init(t, terraformOptions)
-	planStruct := terraform.InitAndPlanAndShowWithStruct(t, terraformOptions)
+			plan := planWithStruct(t, terraformOptions)
 
-	// Verify no access_config in network_interface
-	for _, resource := range planStruct.ResourcePlannedValuesMap {
-		if resource.Type == "google_compute_instance_template" {
-			// Check that access_config is not present or empty
-			// This validates our security requirement
-		}
+			// Verify no access_config in any network_interface
+			if tc.shouldFail {
+				assert.Error(t, planassert.NoExternalIPE(plan))
+			} else {
+				planassert.NoExternalIP(t, plan)
+			}
+		})
 	}
 }
@@ -0,0 +1,462 @@
+// Package contracts statically cross-checks Terraform module calls against the
+// variable blocks declared by the called module. It only parses HCL, so it
+// runs without a terraform binary, provider plugins or credentials.
+package contracts
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/function/stdlib"
+)
+
+// Kind classifies a contract violation
+type Kind string
+
+const (
+	// UnknownArgument is an argument the called module does not declare
+	UnknownArgument Kind = "unknown-argument"
+	// MissingRequired is a module variable without default that the caller omits
+	MissingRequired Kind = "missing-required"
+	// TypeMismatch is an argument whose value cannot convert to the variable type
+	TypeMismatch Kind = "type-mismatch"
+	// InvalidExpression is an argument that only uses variables and locals
+	// but fails to evaluate, e.g. because it calls an unknown function
+	InvalidExpression Kind = "invalid-expression"
+)
+
+// metaArguments are module block arguments handled by Terraform itself
+var metaArguments = map[string]bool{
+	"source":     true,
+	"version":    true,
+	"count":      true,
+	"for_each":   true,
+	"providers":  true,
+	"depends_on": true,
+}
+
+// Variable is a single variable block of a module
+type Variable struct {
+	Name     string
+	Type     cty.Type
+	Required bool
+	Range    hcl.Range
+}
+
+// Module is the set of variables and locals declared in a module directory
+type Module struct {
+	Dir       string
+	Variables map[string]*Variable
+	Calls     []*Call
+
+	locals map[string]hcl.Expression
+}
+
+// Call is a module block together with its literal arguments
+type Call struct {
+	Name      string
+	Source    string
+	Range     hcl.Range
+	Arguments map[string]*hcl.Attribute
+}
+
+// Violation describes one broken contract between a caller and a module
+type Violation struct {
+	Caller   string
+	Call     string
+	Argument string
+	Kind     Kind
+	Message  string
+	Range    hcl.Range
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: module.%s: %s %q: %s", v.Range, v.Call, v.Kind, v.Argument, v.Message)
+}
+
+var fileSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{
+		{Type: "variable", LabelNames: []string{"name"}},
+		{Type: "module", LabelNames: []string{"name"}},
+		{Type: "locals"},
+	},
+}
+
+var variableSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "type"},
+		{Name: "default"},
+	},
+}
+
+// LoadModule parses every .tf file in dir
+func LoadModule(dir string) (*Module, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no .tf files in %s", dir)
+	}
+	sort.Strings(files)
+
+	module := &Module{
+		Dir:       dir,
+		Variables: map[string]*Variable{},
+		locals:    map[string]hcl.Expression{},
+	}
+	parser := hclparse.NewParser()
+	for _, path := range files {
+		file, diags := parser.ParseHCLFile(path)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		content, _, diags := file.Body.PartialContent(fileSchema)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		for _, block := range content.Blocks {
+			switch block.Type {
+			case "variable":
+				variable, err := decodeVariable(block)
+				if err != nil {
+					return nil, err
+				}
+				module.Variables[variable.Name] = variable
+			case "module":
+				call, err := decodeCall(block)
+				if err != nil {
+					return nil, err
+				}
+				module.Calls = append(module.Calls, call)
+			case "locals":
+				attrs, diags := block.Body.JustAttributes()
+				if diags.HasErrors() {
+					return nil, diags
+				}
+				for name, attr := range attrs {
+					module.locals[name] = attr.Expr
+				}
+			}
+		}
+	}
+	return module, nil
+}
+
+func decodeVariable(block *hcl.Block) (*Variable, error) {
+	content, _, diags := block.Body.PartialContent(variableSchema)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	variable := &Variable{
+		Name:  block.Labels[0],
+		Type:  cty.DynamicPseudoType,
+		Range: block.DefRange,
+	}
+	if attr, ok := content.Attributes["type"]; ok {
+		ty, diags := typeexpr.TypeConstraint(attr.Expr)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		variable.Type = ty
+	}
+	_, hasDefault := content.Attributes["default"]
+	variable.Required = !hasDefault
+	return variable, nil
+}
+
+func decodeCall(block *hcl.Block) (*Call, error) {
+	attrs, diags := block.Body.JustAttributes()
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	call := &Call{
+		Name:      block.Labels[0],
+		Range:     block.DefRange,
+		Arguments: map[string]*hcl.Attribute{},
+	}
+	for name, attr := range attrs {
+		if name == "source" {
+			value, diags := attr.Expr.Value(nil)
+			if diags.HasErrors() || value.Type() != cty.String {
+				return nil, fmt.Errorf("%s: module %q source must be a literal string", attr.Range, call.Name)
+			}
+			call.Source = value.AsString()
+		}
+		if metaArguments[name] {
+			continue
+		}
+		call.Arguments[name] = attr
+	}
+	return call, nil
+}
+
+// IsLocal reports whether the call source is a relative path on disk
+func (c *Call) IsLocal() bool {
+	return strings.HasPrefix(c.Source, "./") || strings.HasPrefix(c.Source, "../")
+}
+
+// Check loads the module in dir and verifies every local module call in it
+func Check(dir string) ([]Violation, error) {
+	caller, err := LoadModule(dir)
+	if err != nil {
+		return nil, err
+	}
+	var violations []Violation
+	for _, call := range caller.Calls {
+		if !call.IsLocal() {
+			continue
+		}
+		callee, err := LoadModule(filepath.Join(dir, call.Source))
+		if err != nil {
+			return nil, fmt.Errorf("module.%s: %w", call.Name, err)
+		}
+		violations = append(violations, CheckCall(caller, call, callee)...)
+	}
+	return violations, nil
+}
+
+// CheckCall verifies a single module call against the callee variables
+func CheckCall(caller *Module, call *Call, callee *Module) []Violation {
+	var violations []Violation
+	ctx, localDiags := caller.evalContext()
+
+	for _, name := range sortedKeys(call.Arguments) {
+		attr := call.Arguments[name]
+		variable, ok := callee.Variables[name]
+		if !ok {
+			violations = append(violations, Violation{
+				Caller:   caller.Dir,
+				Call:     call.Name,
+				Argument: name,
+				Kind:     UnknownArgument,
+				Message:  fmt.Sprintf("%s declares no variable %q", call.Source, name),
+				Range:    attr.Range,
+			})
+			continue
+		}
+
+		if hasReferences(attr.Expr) {
+			// Resource, data source and module values are only known at plan time
+			continue
+		}
+		diags := caller.localDiagnostics(attr.Expr, localDiags, map[string]bool{})
+		value, valueDiags := attr.Expr.Value(ctx)
+		diags = append(diags, valueDiags...)
+		if diags.HasErrors() {
+			violations = append(violations, Violation{
+				Caller:   caller.Dir,
+				Call:     call.Name,
+				Argument: name,
+				Kind:     InvalidExpression,
+				Message:  errorMessages(diags),
+				Range:    attr.Range,
+			})
+			continue
+		}
+		if value.Type() == cty.DynamicPseudoType {
+			// Type unknown, e.g. built from a local that has references
+			continue
+		}
+		if !convertible(value.Type(), variable.Type) {
+			violations = append(violations, Violation{
+				Caller:   caller.Dir,
+				Call:     call.Name,
+				Argument: name,
+				Kind:     TypeMismatch,
+				Message: fmt.Sprintf("cannot convert %s to %s",
+					typeexpr.TypeString(value.Type()), typeexpr.TypeString(variable.Type)),
+				Range: attr.Range,
+			})
+		}
+	}
+
+	for _, name := range sortedKeys(callee.Variables) {
+		variable := callee.Variables[name]
+		if _, ok := call.Arguments[name]; ok || !variable.Required {
+			continue
+		}
+		violations = append(violations, Violation{
+			Caller:   caller.Dir,
+			Call:     call.Name,
+			Argument: name,
+			Kind:     MissingRequired,
+			Message:  fmt.Sprintf("required by %s but not set", call.Source),
+			Range:    call.Range,
+		})
+	}
+	return violations
+}
+
+func convertible(from, to cty.Type) bool {
+	return from.Equals(to) || convert.GetConversionUnsafe(from, to) != nil
+}
+
+// evalContext builds a context where every var.* is an unknown value of its
+// declared type, so expressions evaluate to their type shape only. Locals
+// that cannot be evaluated are unknown of any type; the errors of those that
+// have no references are returned by name.
+func (m *Module) evalContext() (*hcl.EvalContext, map[string]hcl.Diagnostics) {
+	vars := map[string]cty.Value{}
+	for name, variable := range m.Variables {
+		vars[name] = cty.UnknownVal(variable.Type)
+	}
+	ctx := &hcl.EvalContext{
+		Variables: map[string]cty.Value{
+			"var": cty.ObjectVal(vars),
+		},
+		Functions: functions,
+	}
+
+	// Locals may refer to each other, so resolve them until no progress is made
+	locals := map[string]cty.Value{}
+	for progress := true; progress; {
+		progress = false
+		ctx.Variables["local"] = cty.ObjectVal(locals)
+		for name, expr := range m.locals {
+			if _, done := locals[name]; done {
+				continue
+			}
+			value, diags := expr.Value(ctx)
+			if diags.HasErrors() {
+				continue
+			}
+			locals[name] = value
+			progress = true
+		}
+	}
+	var failed []string
+	for name := range m.locals {
+		if _, done := locals[name]; !done {
+			locals[name] = cty.DynamicVal
+			failed = append(failed, name)
+		}
+	}
+	ctx.Variables["local"] = cty.ObjectVal(locals)
+
+	// Failed locals without references are evaluated again: those that only
+	// failed on other unknown locals succeed now and the rest are errors
+	localDiags := map[string]hcl.Diagnostics{}
+	for _, name := range failed {
+		expr := m.locals[name]
+		if hasReferences(expr) {
+			continue
+		}
+		if _, diags := expr.Value(ctx); diags.HasErrors() {
+			localDiags[name] = diags
+		}
+	}
+	return ctx, localDiags
+}
+
+// hasReferences reports whether expr refers to anything but variables and
+// locals
+func hasReferences(expr hcl.Expression) bool {
+	for _, traversal := range expr.Variables() {
+		if root := traversal.RootName(); root != "var" && root != "local" {
+			return true
+		}
+	}
+	return false
+}
+
+// localDiagnostics returns the errors of the locals expr uses, directly or
+// through other locals
+func (m *Module) localDiagnostics(expr hcl.Expression, localDiags map[string]hcl.Diagnostics, seen map[string]bool) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	for _, traversal := range expr.Variables() {
+		if traversal.RootName() != "local" || len(traversal) < 2 {
+			continue
+		}
+		attr, ok := traversal[1].(hcl.TraverseAttr)
+		if !ok || seen[attr.Name] {
+			continue
+		}
+		seen[attr.Name] = true
+		diags = append(diags, localDiags[attr.Name]...)
+		if local, ok := m.locals[attr.Name]; ok {
+			diags = append(diags, m.localDiagnostics(local, localDiags, seen)...)
+		}
+	}
+	return diags
+}
+
+// errorMessages joins the summary and detail of every error in diags
+func errorMessages(diags hcl.Diagnostics) string {
+	var messages []string
+	for _, diag := range diags.Errs() {
+		message := diag.Error()
+		if d, ok := diag.(*hcl.Diagnostic); ok {
+			message = d.Summary
+			if d.Detail != "" {
+				message += ": " + d.Detail
+			}
+		}
+		messages = append(messages, message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// functions is the subset of Terraform built-ins used by the fixtures. Calls
+// to any other function are reported as invalid expressions.
+var functions = map[string]function.Function{
+	"coalesce":     stdlib.CoalesceFunc,
+	"coalescelist": stdlib.CoalesceListFunc,
+	"compact":      stdlib.CompactFunc,
+	"concat":       stdlib.ConcatFunc,
+	"contains":     stdlib.ContainsFunc,
+	"distinct":     stdlib.DistinctFunc,
+	"flatten":      stdlib.FlattenFunc,
+	"format":       stdlib.FormatFunc,
+	"join":         stdlib.JoinFunc,
+	"keys":         stdlib.KeysFunc,
+	"length":       stdlib.LengthFunc,
+	"lookup":       stdlib.LookupFunc,
+	"lower":        stdlib.LowerFunc,
+	"merge":        stdlib.MergeFunc,
+	"upper":        stdlib.UpperFunc,
+	"values":       stdlib.ValuesFunc,
+	"tolist":       stdlib.MakeToFunc(cty.List(cty.DynamicPseudoType)),
+	"toset":        stdlib.MakeToFunc(cty.Set(cty.DynamicPseudoType)),
+	"tomap":        stdlib.MakeToFunc(cty.Map(cty.DynamicPseudoType)),
+	"tostring":     stdlib.MakeToFunc(cty.String),
+	"tonumber":     stdlib.MakeToFunc(cty.Number),
+	"tobool":       stdlib.MakeToFunc(cty.Bool),
+}
+
+// FixtureDirs returns every sub-directory of root that contains .tf files
+func FixtureDirs(root string) ([]string, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, entry.Name())
+		if files, _ := filepath.Glob(filepath.Join(dir, "*.tf")); len(files) > 0 {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
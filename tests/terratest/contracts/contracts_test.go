@@ -0,0 +1,80 @@
+package contracts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCheckValidCaller verifies a caller matching the module reports nothing
+func TestCheckValidCaller(t *testing.T) {
+	t.Parallel()
+
+	violations, err := Check("testdata/callers/valid")
+	require.NoError(t, err)
+	assert.Empty(t, violations)
+}
+
+// TestCheckDriftedCaller verifies each kind of drift is reported
+func TestCheckDriftedCaller(t *testing.T) {
+	t.Parallel()
+
+	violations, err := Check("testdata/callers/drift")
+	require.NoError(t, err)
+
+	found := map[string]Kind{}
+	for _, v := range violations {
+		assert.Equal(t, "bucket", v.Call)
+		found[v.Argument] = v.Kind
+	}
+
+	assert.Equal(t, map[string]Kind{
+		"bucket_name":     UnknownArgument,
+		"versioning":      TypeMismatch,
+		"lifecycle_rules": TypeMismatch,
+		"project_id":      MissingRequired,
+		"name":            MissingRequired,
+	}, found)
+}
+
+// TestCheckInvalidCaller verifies arguments that only use variables and
+// locals but fail to evaluate are reported, and references are skipped
+func TestCheckInvalidCaller(t *testing.T) {
+	t.Parallel()
+
+	violations, err := Check("testdata/callers/invalid")
+	require.NoError(t, err)
+
+	found := map[string]string{}
+	for _, v := range violations {
+		assert.Equal(t, InvalidExpression, v.Kind, v.Argument)
+		found[v.Argument] = v.Message
+	}
+
+	assert.Equal(t, map[string]string{
+		"name":       `Call to unknown function: There is no function named "title".`,
+		"versioning": `Call to unknown function: There is no function named "alltrue".`,
+	}, found)
+}
+
+// TestLoadModuleVariables verifies required-ness and types are decoded
+func TestLoadModuleVariables(t *testing.T) {
+	t.Parallel()
+
+	module, err := LoadModule("testdata/modules/bucket")
+	require.NoError(t, err)
+	require.Len(t, module.Variables, 4)
+
+	assert.True(t, module.Variables["project_id"].Required)
+	assert.False(t, module.Variables["versioning"].Required)
+	assert.True(t, module.Variables["lifecycle_rules"].Type.IsListType())
+}
+
+// TestLoadModuleMissingDir verifies a missing module is an error
+func TestLoadModuleMissingDir(t *testing.T) {
+	t.Parallel()
+
+	_, err := LoadModule("testdata/modules/missing")
+	assert.Error(t, err)
+}
@@ -0,0 +1,21 @@
+module "bucket" {
+  source = "../../modules/bucket"
+
+  bucket_name     = var.name
+  versioning      = var.versioning
+  lifecycle_rules = var.lifecycle_rules
+}
+
+variable "name" {
+  type = string
+}
+
+variable "versioning" {
+  type = list(string)
+}
+
+variable "lifecycle_rules" {
+  type = list(object({
+    age = number
+  }))
+}
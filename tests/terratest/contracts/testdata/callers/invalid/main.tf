@@ -0,0 +1,28 @@
+locals {
+  prefix = title(var.name)
+  name   = "${local.prefix}-bucket"
+}
+
+module "bucket" {
+  source = "../../modules/bucket"
+
+  project_id      = google_project.project.project_id
+  name            = local.name
+  versioning      = alltrue([var.versioning])
+  lifecycle_rules = coalescelist(var.lifecycle_rules, [])
+}
+
+variable "name" {
+  type = string
+}
+
+variable "versioning" {
+  type = bool
+}
+
+variable "lifecycle_rules" {
+  type = list(object({
+    age    = number
+    action = string
+  }))
+}
@@ -0,0 +1,27 @@
+locals {
+  prefix = "test"
+}
+
+module "bucket" {
+  source = "../../modules/bucket"
+
+  project_id = var.project_id
+  name       = "${local.prefix}-${var.name}"
+  versioning = true
+
+  lifecycle_rules = [
+    {
+      age    = 30
+      action = "Delete"
+    }
+  ]
+}
+
+variable "project_id" {
+  type = string
+}
+
+variable "name" {
+  type    = string
+  default = "bucket"
+}
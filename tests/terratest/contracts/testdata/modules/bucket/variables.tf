@@ -0,0 +1,20 @@
+variable "project_id" {
+  type = string
+}
+
+variable "name" {
+  type = string
+}
+
+variable "versioning" {
+  type    = bool
+  default = false
+}
+
+variable "lifecycle_rules" {
+  type = list(object({
+    age    = number
+    action = string
+  }))
+  default = []
+}
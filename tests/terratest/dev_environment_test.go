@@ -0,0 +1,195 @@
+package test
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/addrspace"
+	"github.com/unicredit/gcp-migration/tests/terratest/capacity"
+	"github.com/unicredit/gcp-migration/tests/terratest/firewall"
+	"github.com/unicredit/gcp-migration/tests/terratest/nat"
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+	"github.com/unicredit/gcp-migration/tests/terratest/policy"
+	"github.com/unicredit/gcp-migration/tests/terratest/privateaccess"
+	"github.com/unicredit/gcp-migration/tests/terratest/schedule"
+	"github.com/unicredit/gcp-migration/tests/terratest/secrets"
+	"github.com/unicredit/gcp-migration/tests/terratest/tier"
+)
+
+// localBackendOverride replaces the GCS backend so the environment can be
+// planned without access to the state bucket
+const localBackendOverride = `terraform {
+  backend "local" {}
+}
+`
+
+// devEnvironmentOptions copies terraform/environments/dev to a temp dir with a
+// local backend override and returns options with placeholder inputs
+func devEnvironmentOptions(t *testing.T) *terraform.Options {
+	dir := workingCopy(t, "terraform/environments/dev")
+	err := os.WriteFile(filepath.Join(dir, "backend_override.tf"), []byte(localBackendOverride), 0o644)
+	require.NoError(t, err)
+
+	return terraform.WithDefaultRetryableErrors(t, &terraform.Options{
+		TerraformDir: dir,
+		Vars: map[string]interface{}{
+			"project_id":         "test-project",
+			"region":             "europe-west1",
+			"zones":              []string{"europe-west1-b", "europe-west1-c"},
+			"app_a_image":        "projects/rocky-linux-cloud/global/images/family/rocky-linux-9",
+			"app_b_image":        "projects/windows-cloud/global/images/family/windows-2022",
+			"postgres_password":  "plan-only-password",
+			"sqlserver_password": "plan-only-password",
+		},
+		NoColor: true,
+	})
+}
+
+// TestDevEnvironmentNoPublicIP verifies no VM in the dev environment gets an external IP
+func TestDevEnvironmentNoPublicIP(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	plan := planWithStruct(t, devEnvironmentOptions(t))
+
+	planassert.ResourceCount(t, plan, "google_compute_instance_template", 2)
+	planassert.NoExternalIP(t, plan)
+	// Without external IPs the VMs reach the internet through Cloud NAT only
+	nat.Covered(t, plan)
+}
+
+// TestDevEnvironmentGuardrails verifies the dev environment has no high or
+// critical policy findings
+func TestDevEnvironmentGuardrails(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	plan := planWithStruct(t, devEnvironmentOptions(t))
+
+	policy.Passes(t, plan, policy.High)
+}
+
+// TestDevEnvironmentReachability verifies who can reach the dev VMs: admin
+// ports only through IAP, HTTP(S) from anywhere and app ports only internally
+func TestDevEnvironmentReachability(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	plan := planWithStruct(t, devEnvironmentOptions(t))
+	network, err := firewall.FromPlan(plan)
+	require.NoError(t, err)
+
+	internet := firewall.MustFromRange("0.0.0.0/0")
+	iap := firewall.MustFromRange("35.235.240.0/20")
+
+	// Health check probes may reach the VMs from the internet, so the admin
+	// and app ports are checked from public ranges outside IAP and the probes
+	for _, cidr := range []string{"1.0.0.0/8", "35.234.0.0/16", "203.0.113.0/24"} {
+		public := firewall.MustFromRange(cidr)
+		firewall.Unreachable(t, network, public, "dev-app-a", "tcp", 22)
+		firewall.Unreachable(t, network, public, "dev-app-b", "tcp", 3389)
+		firewall.Unreachable(t, network, public, "dev-app-a", "tcp", 8080)
+	}
+	firewall.Reachable(t, network, iap, "dev-app-a", "tcp", 22)
+	firewall.Reachable(t, network, iap, "dev-app-b", "tcp", 3389)
+	firewall.Unreachable(t, network, iap, "dev-app-a", "tcp", 3389)
+
+	firewall.Reachable(t, network, internet, "dev-app-a", "tcp", 443)
+	firewall.Reachable(t, network, internet, "dev-app-b", "tcp", 443)
+	firewall.Reachable(t, network, firewall.MustFromRange("130.211.0.0/22"), "dev-app-a", "tcp", 8080)
+}
+
+// TestDevEnvironmentAddressSpace verifies the dev subnets do not overlap and
+// leave room in 10.0.0.0/8 for the private services range
+func TestDevEnvironmentAddressSpace(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	plan := planWithStruct(t, devEnvironmentOptions(t))
+	space, err := addrspace.FromPlan(plan)
+	require.NoError(t, err)
+
+	addrspace.NoConflicts(t, space)
+	require.Len(t, space.Reservations, 1)
+	_, err = space.Place(netip.MustParsePrefix("10.0.0.0/8"))
+	require.NoError(t, err)
+}
+
+// TestDevEnvironmentSubnetCapacity verifies both application groups fit the
+// dev subnet at full size and through a rolling update
+func TestDevEnvironmentSubnetCapacity(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	plan := planWithStruct(t, devEnvironmentOptions(t))
+	usages, err := capacity.FromPlan(plan)
+	require.NoError(t, err)
+
+	capacity.Fits(t, usages)
+}
+
+// devScheduleTracking is where rescheduling the dev databases is tracked
+const devScheduleTracking = "backlog user-022: move dev Cloud SQL backups and maintenance off the module defaults"
+
+// devKnownConflicts are the scheduling conflicts the dev databases have from
+// keeping the cloudsql module's default backup and maintenance windows.
+// Rescheduling them is an infrastructure change of its own; remove entries as
+// it lands.
+var devKnownConflicts = func() []schedule.Known {
+	var known []schedule.Known
+	for _, address := range []string{
+		"module.cloudsql_postgres.google_sql_database_instance.instance",
+		"module.cloudsql_sqlserver.google_sql_database_instance.instance",
+	} {
+		for _, kind := range []schedule.Kind{schedule.SharedSlot, schedule.BackupInMaintenance, schedule.BackupInBusinessHours} {
+			known = append(known, schedule.Known{Kind: kind, Address: address, Tracking: devScheduleTracking})
+		}
+	}
+	return known
+}()
+
+// TestDevEnvironmentDatabaseSchedule tests the dev databases have no
+// scheduling conflicts beyond the known ones
+func TestDevEnvironmentDatabaseSchedule(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	plan := planWithStruct(t, devEnvironmentOptions(t))
+
+	schedule.NoConflicts(t, plan, schedule.DefaultBusinessHours, devKnownConflicts...)
+}
+
+// TestDevEnvironmentDatabaseSecrets tests the database passwords fed to the
+// dev environment stay sensitive from its variables to the Cloud SQL users
+func TestDevEnvironmentDatabaseSecrets(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	plan := planWithStruct(t, devEnvironmentOptions(t))
+
+	secrets.NoFindings(t, plan)
+}
+
+// TestDevEnvironmentDatabasePrivateAccess tests both Cloud SQL instances wait
+// for the network module's private services access and fit its range
+func TestDevEnvironmentDatabasePrivateAccess(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	plan := planWithStruct(t, devEnvironmentOptions(t))
+
+	instances, err := privateaccess.FromPlan(plan)
+	require.NoError(t, err)
+	require.Len(t, instances, 2)
+	for _, instance := range instances {
+		assert.Equal(t, "module.network.google_service_networking_connection.private_vpc_connection", instance.Connection, instance.Address)
+	}
+	privateaccess.Connected(t, plan)
+	privateaccess.PeeringRanges(t, plan, privateaccess.DefaultSizing)
+}
@@ -0,0 +1,296 @@
+// Package firewall models planned VPC firewall rules and the instances they
+// protect, and simulates which traffic they let in. It answers questions like
+// "can 0.0.0.0/0 reach dev-app-b on tcp/3389?" from a plan alone, following
+// GCP's evaluation order: the lowest priority number wins, deny beats allow at
+// equal priority and ingress is denied when no rule matches.
+package firewall
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	tfjson "github.com/hashicorp/terraform-json"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// defaultPriority is the priority of rules that do not set one
+const defaultPriority = 1000
+
+// Rule is a planned google_compute_firewall
+type Rule struct {
+	Address  string
+	Name     string
+	Priority int
+	// Ingress is false for egress rules
+	Ingress bool
+	// Deny is set on deny rules and Protocols then lists what they deny
+	Deny      bool
+	Disabled  bool
+	Protocols []Protocol
+
+	SourceRanges          []*net.IPNet
+	SourceTags            []string
+	SourceServiceAccounts []string
+	TargetTags            []string
+	TargetServiceAccounts []string
+}
+
+// Protocol is one allow or deny block: a protocol and its port ranges, where
+// no ranges means every port
+type Protocol struct {
+	Name  string
+	Ports []PortRange
+}
+
+// PortRange is an inclusive range of ports
+type PortRange struct {
+	From, To int
+}
+
+func (p Protocol) String() string {
+	if len(p.Ports) == 0 {
+		return p.Name + ":all"
+	}
+	var ports []string
+	for _, r := range p.Ports {
+		if r.From == r.To {
+			ports = append(ports, strconv.Itoa(r.From))
+		} else {
+			ports = append(ports, fmt.Sprintf("%d-%d", r.From, r.To))
+		}
+	}
+	return p.Name + ":" + strings.Join(ports, ",")
+}
+
+// Covers reports whether the block matches protocol on port. Port is ignored
+// for protocols without ports, such as icmp.
+func (p Protocol) Covers(protocol string, port int) bool {
+	if p.Name != strings.ToLower(protocol) && p.Name != "all" {
+		return false
+	}
+	if len(p.Ports) == 0 {
+		return true
+	}
+	for _, r := range p.Ports {
+		if port >= r.From && port <= r.To {
+			return true
+		}
+	}
+	return false
+}
+
+// Within reports whether every port the block matches is in allowed, a set
+// of "protocol:port" strings
+func (p Protocol) Within(allowed map[string]bool) bool {
+	if len(p.Ports) == 0 {
+		return false
+	}
+	for _, r := range p.Ports {
+		for port := r.From; port <= r.To; port++ {
+			if !allowed[p.Name+":"+strconv.Itoa(port)] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Covers reports whether any of the rule's blocks matches protocol on port
+func (r Rule) Covers(protocol string, port int) bool {
+	for _, p := range r.Protocols {
+		if p.Covers(protocol, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// Targeted reports whether the rule is limited by target tags or service
+// accounts rather than applying to every instance in the network
+func (r Rule) Targeted() bool {
+	return len(r.TargetTags) > 0 || len(r.TargetServiceAccounts) > 0
+}
+
+// Parse reads a planned google_compute_firewall. It also returns the values
+// it could not parse; those are left out of the rule. An allow or deny block
+// whose ports all fail to parse therefore matches every port, which errs
+// towards reporting exposure.
+func Parse(resource *tfjson.StateResource) (Rule, []string) {
+	values := resource.AttributeValues
+	rule := Rule{
+		Address:  resource.Address,
+		Priority: defaultPriority,
+		Ingress:  true,
+	}
+	rule.Name, _ = values["name"].(string)
+	if priority, ok := values["priority"].(float64); ok {
+		rule.Priority = int(priority)
+	}
+	if direction, _ := values["direction"].(string); direction == "EGRESS" {
+		rule.Ingress = false
+	}
+	rule.Disabled, _ = values["disabled"].(bool)
+
+	var problems []string
+	for _, source := range stringList(values["source_ranges"]) {
+		network, err := ParseRange(source)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("unparsable source range %q", source))
+			continue
+		}
+		rule.SourceRanges = append(rule.SourceRanges, network)
+	}
+
+	blocks, _ := values["allow"].([]interface{})
+	if denies, _ := values["deny"].([]interface{}); len(denies) > 0 {
+		rule.Deny = true
+		blocks = denies
+	}
+	for _, b := range blocks {
+		block, _ := b.(map[string]interface{})
+		name, _ := block["protocol"].(string)
+		protocol := Protocol{Name: strings.ToLower(name)}
+		for _, port := range stringList(block["ports"]) {
+			r, err := parsePortRange(port)
+			if err != nil {
+				problems = append(problems, err.Error())
+				continue
+			}
+			protocol.Ports = append(protocol.Ports, r)
+		}
+		rule.Protocols = append(rule.Protocols, protocol)
+	}
+
+	rule.SourceTags = stringList(values["source_tags"])
+	rule.SourceServiceAccounts = stringList(values["source_service_accounts"])
+	rule.TargetTags = stringList(values["target_tags"])
+	rule.TargetServiceAccounts = stringList(values["target_service_accounts"])
+	return rule, problems
+}
+
+// ParseRange reads a CIDR range or a single address
+func ParseRange(value string) (*net.IPNet, error) {
+	if _, network, err := net.ParseCIDR(value); err == nil {
+		return network, nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid range %q", value)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}, nil
+}
+
+// parsePortRange reads "22" or "1000-2000"
+func parsePortRange(port string) (PortRange, error) {
+	low, high, isRange := strings.Cut(port, "-")
+	from, err := strconv.Atoi(low)
+	if err != nil {
+		return PortRange{}, fmt.Errorf("unparsable port %q", port)
+	}
+	to := from
+	if isRange {
+		if to, err = strconv.Atoi(high); err != nil || to < from {
+			return PortRange{}, fmt.Errorf("unparsable port range %q", port)
+		}
+	}
+	return PortRange{from, to}, nil
+}
+
+// Instance is a planned instance or instance template that firewall rules
+// can target
+type Instance struct {
+	Address string
+	// Name is the instance name, or the template's name_prefix without its
+	// trailing hyphen
+	Name            string
+	Tags            []string
+	ServiceAccounts []string
+}
+
+// instanceTypes are the resource types parsed as instances
+var instanceTypes = []string{"google_compute_instance", "google_compute_instance_template"}
+
+func parseInstance(resource *tfjson.StateResource) Instance {
+	values := resource.AttributeValues
+	instance := Instance{Address: resource.Address, Tags: stringList(values["tags"])}
+	instance.Name, _ = values["name"].(string)
+	if prefix, ok := values["name_prefix"].(string); ok && instance.Name == "" {
+		instance.Name = strings.TrimSuffix(prefix, "-")
+	}
+	accounts, _ := values["service_account"].([]interface{})
+	for _, a := range accounts {
+		account, _ := a.(map[string]interface{})
+		if email, ok := account["email"].(string); ok && email != "" {
+			instance.ServiceAccounts = append(instance.ServiceAccounts, email)
+		}
+	}
+	return instance
+}
+
+// Network is the firewall rules and instances of a plan. Rules and instances
+// are not matched by VPC, since network self links are unknown until apply;
+// plans with several VPCs should be queried with that in mind.
+type Network struct {
+	Rules     []Rule
+	Instances []Instance
+}
+
+// FromPlan reads the planned firewall rules, instances and instance templates.
+// It fails if a rule has values it cannot parse.
+func FromPlan(plan *terraform.PlanStruct) (*Network, error) {
+	network := &Network{}
+	for _, resource := range planassert.ResourcesOfType(plan, "google_compute_firewall") {
+		rule, problems := Parse(resource)
+		if len(problems) > 0 {
+			return nil, fmt.Errorf("%s: %s", resource.Address, strings.Join(problems, "; "))
+		}
+		network.Rules = append(network.Rules, rule)
+	}
+	for _, resourceType := range instanceTypes {
+		for _, resource := range planassert.ResourcesOfType(plan, resourceType) {
+			network.Instances = append(network.Instances, parseInstance(resource))
+		}
+	}
+
+	// Evaluation order: priority, then deny before allow
+	sort.SliceStable(network.Rules, func(i, j int) bool {
+		a, b := network.Rules[i], network.Rules[j]
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		return a.Deny && !b.Deny
+	})
+	return network, nil
+}
+
+// Instance finds an instance by address or name
+func (n *Network) Instance(name string) (Instance, error) {
+	var names []string
+	for _, instance := range n.Instances {
+		if instance.Address == name || instance.Name == name {
+			return instance, nil
+		}
+		names = append(names, instance.Name)
+	}
+	return Instance{}, fmt.Errorf("no instance or instance template %q (planned: %s)", name, strings.Join(names, ", "))
+}
+
+// stringList reads a planned list or set of strings
+func stringList(value interface{}) []string {
+	list, _ := value.([]interface{})
+	var result []string
+	for _, element := range list {
+		if s, ok := element.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
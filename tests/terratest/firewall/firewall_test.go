@@ -0,0 +1,182 @@
+package firewall
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+const (
+	appA = "dev-app-a"
+	appB = "dev-app-b"
+)
+
+func loadNetwork(t *testing.T) *Network {
+	plan := planassert.LoadPlanFile(t, "testdata/plan.json")
+	network, err := FromPlan(plan)
+	require.NoError(t, err)
+	return network
+}
+
+// TestCanReach tests range sources against tags, priorities and deny rules
+func TestCanReach(t *testing.T) {
+	t.Parallel()
+	network := loadNetwork(t)
+
+	testCases := []struct {
+		source  string
+		target  string
+		port    int
+		allowed bool
+		rule    string
+	}{
+		// Part of 0.0.0.0/0 is quarantined, so not all of it gets through
+		{"0.0.0.0/0", appA, 443, false, "google_compute_firewall.deny_quarantine"},
+		{"203.0.113.0/24", appB, 3389, false, ""},
+		{"35.235.240.0/20", appB, 3389, true, "module.network.google_compute_firewall.allow_rdp"},
+		{"35.235.240.5", appA, 22, true, "module.network.google_compute_firewall.allow_ssh"},
+		{"35.235.240.5", appA, 3389, false, ""},
+		{"203.0.113.0/24", appA, 443, true, "module.network.google_compute_firewall.allow_http_https"},
+		{"10.1.0.0/16", appA, 8080, true, "module.network.google_compute_firewall.allow_internal"},
+		{"10.99.1.0/24", appA, 8080, false, "google_compute_firewall.deny_quarantine"},
+		{"10.0.0.0/8", appA, 8080, false, "google_compute_firewall.deny_quarantine"},
+		{"130.211.0.0/22", "module.compute_app_b.google_compute_instance_template.template", 8080, true, "module.network.google_compute_firewall.allow_health_check"},
+	}
+	for _, tc := range testCases {
+		verdict, err := network.CanReach(MustFromRange(tc.source), tc.target, "tcp", tc.port)
+		require.NoError(t, err)
+		assert.Equal(t, Verdict{Allowed: tc.allowed, Rule: tc.rule}, verdict, "%s to %s on %d", tc.source, tc.target, tc.port)
+	}
+
+	_, err := network.CanReach(MustFromRange("0.0.0.0/0"), "dev-app-c", "tcp", 80)
+	assert.ErrorContains(t, err, "dev-app-a, dev-app-b")
+}
+
+// TestMayReach tests a range source reaches the target when any part of it
+// gets past the deny rules to an allow rule
+func TestMayReach(t *testing.T) {
+	t.Parallel()
+	network := loadNetwork(t)
+
+	testCases := []struct {
+		source  string
+		target  string
+		port    int
+		allowed bool
+		rule    string
+	}{
+		// Health check probes come from part of the internet on every port
+		{"0.0.0.0/0", appA, 22, true, "module.network.google_compute_firewall.allow_health_check"},
+		// and IAP's part of it is allowed SSH
+		{"35.235.0.0/16", appA, 22, true, "module.network.google_compute_firewall.allow_ssh"},
+		{"203.0.113.0/24", appB, 3389, false, ""},
+		{"203.0.113.0/24", appA, 22, false, ""},
+		// The quarantined /16 is taken out, the rest of 10/8 gets through
+		{"10.0.0.0/8", appA, 8080, true, "module.network.google_compute_firewall.allow_internal"},
+		{"10.99.1.0/24", appA, 8080, false, "google_compute_firewall.deny_quarantine"},
+	}
+	for _, tc := range testCases {
+		verdict, err := network.MayReach(MustFromRange(tc.source), tc.target, "tcp", tc.port)
+		require.NoError(t, err)
+		assert.Equal(t, Verdict{Allowed: tc.allowed, Rule: tc.rule}, verdict, "%s to %s on %d", tc.source, tc.target, tc.port)
+	}
+
+	err := UnreachableE(network, MustFromRange("35.235.0.0/16"), appA, "tcp", 22)
+	assert.ErrorContains(t, err, "35.235.0.0/16 can reach dev-app-a on tcp/22: allowed by module.network.google_compute_firewall.allow_ssh")
+}
+
+// TestSubtract tests a deny range is cut out of a source range
+func TestSubtract(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		pieces []string
+		cut    string
+		left   []string
+	}{
+		{[]string{"10.0.0.0/8"}, "10.0.0.0/8", nil},
+		{[]string{"10.0.0.0/8"}, "0.0.0.0/0", nil},
+		{[]string{"10.0.0.0/8"}, "192.168.0.0/16", []string{"10.0.0.0/8"}},
+		{[]string{"10.0.0.0/8"}, "10.0.0.0/9", []string{"10.128.0.0/9"}},
+		{[]string{"10.0.0.0/8"}, "10.192.0.0/10", []string{"10.0.0.0/9", "10.128.0.0/10"}},
+		{[]string{"10.0.0.0/30", "10.0.0.8/30"}, "10.0.0.1/32", []string{"10.0.0.0/32", "10.0.0.2/31", "10.0.0.8/30"}},
+	}
+	for _, tc := range testCases {
+		var pieces []*net.IPNet
+		for _, cidr := range tc.pieces {
+			pieces = append(pieces, MustFromRange(cidr).Range)
+		}
+		var left []string
+		for _, piece := range subtract(pieces, MustFromRange(tc.cut).Range) {
+			left = append(left, piece.String())
+		}
+		assert.Equal(t, tc.left, left, "%v - %s", tc.pieces, tc.cut)
+	}
+}
+
+// TestCanReachFromInstance tests service account and tag sources
+func TestCanReachFromInstance(t *testing.T) {
+	t.Parallel()
+	network := loadNetwork(t)
+	instanceA, err := network.Instance(appA)
+	require.NoError(t, err)
+	instanceB, err := network.Instance(appB)
+	require.NoError(t, err)
+
+	Reachable(t, network, FromInstance(instanceA), appB, "tcp", 1433)
+	Unreachable(t, network, FromInstance(instanceB), appA, "tcp", 1433)
+	// Without a range only rules naming source tags or accounts match
+	Unreachable(t, network, FromInstance(instanceA), appB, "tcp", 5432)
+
+	err = UnreachableE(network, MustFromRange("203.0.113.7"), appA, "tcp", 80)
+	assert.ErrorContains(t, err, "203.0.113.7/32 can reach dev-app-a on tcp/80: allowed by module.network.google_compute_firewall.allow_http_https")
+	err = ReachableE(network, MustFromRange("203.0.113.7"), appB, "tcp", 3389)
+	assert.ErrorContains(t, err, "denied by the implied deny ingress rule")
+}
+
+// TestSources tests which allow rules admit traffic to a target
+func TestSources(t *testing.T) {
+	t.Parallel()
+	network := loadNetwork(t)
+
+	rules, err := network.Sources(appA, "tcp", 8080)
+	require.NoError(t, err)
+	var addresses []string
+	for _, rule := range rules {
+		addresses = append(addresses, rule.Address)
+	}
+	assert.Equal(t, []string{
+		"module.network.google_compute_firewall.allow_health_check",
+		"module.network.google_compute_firewall.allow_internal",
+	}, addresses)
+	assert.Equal(t, "130.211.0.0/22", rules[0].SourceRanges[0].String())
+}
+
+// TestParse tests rule fields, defaults and unparsable values
+func TestParse(t *testing.T) {
+	t.Parallel()
+	plan := planassert.LoadPlanFile(t, "testdata/plan.json")
+
+	resource, err := planassert.Resource(plan, "google_compute_firewall.deny_quarantine")
+	require.NoError(t, err)
+	rule, problems := Parse(resource)
+	assert.Empty(t, problems)
+	assert.True(t, rule.Deny)
+	assert.Equal(t, 900, rule.Priority)
+	assert.True(t, rule.Covers("udp", 53))
+	assert.False(t, rule.Targeted())
+
+	resource.AttributeValues["source_ranges"] = []interface{}{"10.0.0.0/33", "10.1.2.3"}
+	resource.AttributeValues["deny"] = []interface{}{map[string]interface{}{"protocol": "tcp", "ports": []interface{}{"22-x"}}}
+	rule, problems = Parse(resource)
+	assert.Equal(t, []string{`unparsable source range "10.0.0.0/33"`, `unparsable port range "22-x"`}, problems)
+	assert.Equal(t, "10.1.2.3/32", rule.SourceRanges[0].String())
+	assert.Equal(t, "tcp:all", rule.Protocols[0].String())
+
+	_, err = FromPlan(plan)
+	assert.ErrorContains(t, err, "google_compute_firewall.deny_quarantine: unparsable source range")
+}
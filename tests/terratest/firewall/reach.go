@@ -0,0 +1,294 @@
+package firewall
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/gruntwork-io/terratest/modules/testing"
+	"github.com/stretchr/testify/assert"
+)
+
+// worldRanges are the implied sources of ingress rules that set none
+var worldRanges = []string{"0.0.0.0/0", "::/0"}
+
+// Source is where traffic comes from: an address range, an instance's tags
+// and service accounts, or both
+type Source struct {
+	Range           *net.IPNet
+	Tags            []string
+	ServiceAccounts []string
+}
+
+// FromRange returns a source for every address in cidr, e.g. "0.0.0.0/0"
+func FromRange(cidr string) (Source, error) {
+	network, err := ParseRange(cidr)
+	if err != nil {
+		return Source{}, err
+	}
+	return Source{Range: network}, nil
+}
+
+// MustFromRange is FromRange for ranges known to be valid, as in tests
+func MustFromRange(cidr string) Source {
+	source, err := FromRange(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return source
+}
+
+// FromInstance returns a source for traffic sent by instance, which firewall
+// rules match by source tags and service accounts
+func FromInstance(instance Instance) Source {
+	return Source{Tags: instance.Tags, ServiceAccounts: instance.ServiceAccounts}
+}
+
+func (s Source) String() string {
+	if s.Range != nil {
+		return s.Range.String()
+	}
+	return fmt.Sprintf("tags %v", s.Tags)
+}
+
+// Verdict is the outcome of a reachability query
+type Verdict struct {
+	Allowed bool
+	// Rule is the address of the deciding rule, empty for the implied deny
+	Rule string
+}
+
+func (v Verdict) String() string {
+	action := "denied"
+	if v.Allowed {
+		action = "allowed"
+	}
+	if v.Rule == "" {
+		return action + " by the implied deny ingress rule"
+	}
+	return action + " by " + v.Rule
+}
+
+// CanReach decides whether traffic from source reaches the target instance,
+// named by address or name, on protocol and port. A range source reaches the
+// target only when every address in it does; this is decided per rule, so a
+// range split across several allow rules counts as unreachable.
+func (n *Network) CanReach(source Source, target, protocol string, port int) (Verdict, error) {
+	instance, err := n.Instance(target)
+	if err != nil {
+		return Verdict{}, err
+	}
+	for _, rule := range n.Rules {
+		if !rule.Ingress || rule.Disabled || !rule.targets(instance) || !rule.Covers(protocol, port) {
+			continue
+		}
+		if rule.Deny && rule.mayMatch(source) || !rule.Deny && rule.matchesAll(source) {
+			return Verdict{Allowed: !rule.Deny, Rule: rule.Address}, nil
+		}
+	}
+	return Verdict{}, nil
+}
+
+// MayReach decides whether any of source's traffic reaches the target
+// instance on protocol and port. Deny rules take the addresses they match out
+// of the source range, and the first allow rule matching part of what is left
+// lets that part through.
+func (n *Network) MayReach(source Source, target, protocol string, port int) (Verdict, error) {
+	instance, err := n.Instance(target)
+	if err != nil {
+		return Verdict{}, err
+	}
+	var remaining []*net.IPNet
+	if source.Range != nil {
+		remaining = []*net.IPNet{source.Range}
+	}
+	for _, rule := range n.Rules {
+		if !rule.Ingress || rule.Disabled || !rule.targets(instance) || !rule.Covers(protocol, port) {
+			continue
+		}
+		if rule.matchesIdentity(source) {
+			return Verdict{Allowed: !rule.Deny, Rule: rule.Address}, nil
+		}
+		if rule.Deny {
+			for _, network := range rule.sourceRanges() {
+				remaining = subtract(remaining, network)
+			}
+			if source.Range != nil && len(remaining) == 0 {
+				return Verdict{Rule: rule.Address}, nil
+			}
+			continue
+		}
+		for _, piece := range remaining {
+			for _, network := range rule.sourceRanges() {
+				if contains(network, piece) || contains(piece, network) {
+					return Verdict{Allowed: true, Rule: rule.Address}, nil
+				}
+			}
+		}
+	}
+	return Verdict{}, nil
+}
+
+// Sources returns the ingress allow rules that let some traffic reach the
+// target on protocol and port, in evaluation order. A deny rule earlier in
+// the order can still block part of what they allow; CanReach accounts for it.
+func (n *Network) Sources(target, protocol string, port int) ([]Rule, error) {
+	instance, err := n.Instance(target)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	for _, rule := range n.Rules {
+		if rule.Ingress && !rule.Disabled && !rule.Deny && rule.targets(instance) && rule.Covers(protocol, port) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// targets reports whether the rule applies to instance
+func (r Rule) targets(instance Instance) bool {
+	if !r.Targeted() {
+		return true
+	}
+	return overlaps(r.TargetTags, instance.Tags) || overlaps(r.TargetServiceAccounts, instance.ServiceAccounts)
+}
+
+// sourceRanges returns the rule's source ranges, which default to every
+// address when the rule names no sources at all
+func (r Rule) sourceRanges() []*net.IPNet {
+	if len(r.SourceRanges) > 0 || len(r.SourceTags) > 0 || len(r.SourceServiceAccounts) > 0 {
+		return r.SourceRanges
+	}
+	var ranges []*net.IPNet
+	for _, cidr := range worldRanges {
+		_, network, _ := net.ParseCIDR(cidr)
+		ranges = append(ranges, network)
+	}
+	return ranges
+}
+
+// matchesIdentity reports whether the rule matches source by tag or service
+// account, which covers all of its traffic
+func (r Rule) matchesIdentity(source Source) bool {
+	return overlaps(r.SourceTags, source.Tags) || overlaps(r.SourceServiceAccounts, source.ServiceAccounts)
+}
+
+// matchesAll reports whether the rule matches all of source's traffic
+func (r Rule) matchesAll(source Source) bool {
+	if r.matchesIdentity(source) {
+		return true
+	}
+	if source.Range == nil {
+		return false
+	}
+	for _, network := range r.sourceRanges() {
+		if contains(network, source.Range) {
+			return true
+		}
+	}
+	return false
+}
+
+// mayMatch reports whether the rule matches any of source's traffic
+func (r Rule) mayMatch(source Source) bool {
+	if r.matchesIdentity(source) {
+		return true
+	}
+	if source.Range == nil {
+		return false
+	}
+	for _, network := range r.sourceRanges() {
+		if contains(network, source.Range) || contains(source.Range, network) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether outer includes every address of inner
+func contains(outer, inner *net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	return outerBits == innerBits && outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
+
+// subtract returns the parts of pieces outside cut
+func subtract(pieces []*net.IPNet, cut *net.IPNet) []*net.IPNet {
+	var left []*net.IPNet
+	for _, piece := range pieces {
+		switch {
+		case contains(cut, piece):
+		case contains(piece, cut):
+			left = append(left, outside(piece, cut)...)
+		default:
+			left = append(left, piece)
+		}
+	}
+	return left
+}
+
+// outside returns the parts of network outside cut, which it contains, as
+// the halves that do not hold cut at each step down to it
+func outside(network, cut *net.IPNet) []*net.IPNet {
+	ones, bits := network.Mask.Size()
+	if ones == bits || contains(cut, network) {
+		return nil
+	}
+	mask := net.CIDRMask(ones+1, bits)
+	lower := &net.IPNet{IP: network.IP.Mask(mask), Mask: mask}
+	upperIP := make(net.IP, len(lower.IP))
+	copy(upperIP, lower.IP)
+	upperIP[ones/8] |= 0x80 >> (ones % 8)
+	upper := &net.IPNet{IP: upperIP, Mask: mask}
+
+	if contains(lower, cut) {
+		return append(outside(lower, cut), upper)
+	}
+	return append([]*net.IPNet{lower}, outside(upper, cut)...)
+}
+
+func overlaps(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReachableE returns an error unless source reaches target on protocol and port
+func ReachableE(network *Network, source Source, target, protocol string, port int) error {
+	verdict, err := network.CanReach(source, target, protocol, port)
+	if err != nil {
+		return err
+	}
+	if !verdict.Allowed {
+		return fmt.Errorf("%s cannot reach %s on %s/%d: %s", source, target, protocol, port, verdict)
+	}
+	return nil
+}
+
+// Reachable asserts source reaches target on protocol and port
+func Reachable(t testing.TestingT, network *Network, source Source, target, protocol string, port int) bool {
+	return assert.NoError(t, ReachableE(network, source, target, protocol, port))
+}
+
+// UnreachableE returns an error if any of source's traffic reaches target on
+// protocol and port
+func UnreachableE(network *Network, source Source, target, protocol string, port int) error {
+	verdict, err := network.MayReach(source, target, protocol, port)
+	if err != nil {
+		return err
+	}
+	if verdict.Allowed {
+		return fmt.Errorf("%s can reach %s on %s/%d: %s", source, target, protocol, port, verdict)
+	}
+	return nil
+}
+
+// Unreachable asserts none of source's traffic reaches target on protocol and port
+func Unreachable(t testing.TestingT, network *Network, source Source, target, protocol string, port int) bool {
+	return assert.NoError(t, UnreachableE(network, source, target, protocol, port))
+}
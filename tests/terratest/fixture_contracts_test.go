@@ -0,0 +1,37 @@
+package test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/contracts"
+	"github.com/unicredit/gcp-migration/tests/terratest/tier"
+)
+
+// TestFixtureContracts checks every fixture and environment module call
+// against the variables the called module declares, without terraform init
+func TestFixtureContracts(t *testing.T) {
+	tier.Require(t, tier.Static)
+	t.Parallel()
+
+	dirs, err := contracts.FixtureDirs("./fixtures")
+	require.NoError(t, err)
+	require.NotEmpty(t, dirs)
+	dirs = append(dirs, "../../terraform/environments/dev")
+
+	for _, dir := range dirs {
+		dir := dir
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			t.Parallel()
+
+			violations, err := contracts.Check(dir)
+			require.NoError(t, err)
+			for _, v := range violations {
+				assert.Fail(t, "module contract violated", v.String())
+			}
+		})
+	}
+}
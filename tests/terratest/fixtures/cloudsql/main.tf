@@ -1,24 +1,46 @@
 # Terratest Fixture for Cloud SQL Module
 
+locals {
+  # Tests use lower-case engine names, the module expects the API enum
+  database_types = {
+    postgresql = "POSTGRES"
+    sqlserver  = "SQLSERVER"
+  }
+}
+
 module "cloudsql" {
   source = "../../../../terraform/modules/cloudsql"
 
   project_id                     = var.project_id
   region                         = var.region
-  environment                    = var.environment
   instance_name                  = var.instance_name
-  database_type                  = var.database_type
+  database_type                  = lookup(local.database_types, lower(var.database_type), upper(var.database_type))
   database_version               = var.database_version
   tier                           = var.tier
-  high_availability              = var.high_availability
-  availability_type              = var.availability_type
-  ipv4_enabled                   = var.ipv4_enabled
+  availability_type              = var.high_availability ? "REGIONAL" : var.availability_type
+  enable_public_ip               = var.ipv4_enabled
   private_network                = var.private_network
+  private_vpc_connection         = var.private_vpc_connection
   backup_enabled                 = var.backup_enabled
   backup_start_time              = var.backup_start_time
   retained_backups               = var.retained_backups
   transaction_log_retention_days = var.transaction_log_retention_days
+  point_in_time_recovery         = var.point_in_time_recovery
+  maintenance_window_day         = var.maintenance_window_day
+  maintenance_window_hour        = var.maintenance_window_hour
   deletion_protection            = var.deletion_protection
+  database_flags                 = var.database_flags
+  create_read_replica            = var.create_read_replica
+  replica_region                 = var.replica_region
+  users                          = var.users
+  enable_password_policy         = var.enable_password_policy
+
+  labels = merge(
+    {
+      environment = var.environment
+    },
+    { for key, value in { test_run_id = var.test_run_id, application = var.application } : key => value if value != "" },
+  )
 }
 
 variable "project_id" {
@@ -35,6 +57,18 @@ variable "environment" {
   default = "test"
 }
 
+# Set by the test helpers so resources from one run can be found and swept
+variable "test_run_id" {
+  type    = string
+  default = ""
+}
+
+# Application label the business hours of schedule tests are looked up by
+variable "application" {
+  type    = string
+  default = ""
+}
+
 variable "instance_name" {
   type    = string
   default = "test-db"
@@ -75,6 +109,12 @@ variable "private_network" {
   default = ""
 }
 
+# Stands in for the network module's connection when private_network is set
+variable "private_vpc_connection" {
+  type    = string
+  default = null
+}
+
 variable "backup_enabled" {
   type    = bool
   default = true
@@ -95,7 +135,66 @@ variable "transaction_log_retention_days" {
   default = 7
 }
 
+variable "point_in_time_recovery" {
+  type    = bool
+  default = true
+}
+
+variable "maintenance_window_day" {
+  type    = number
+  default = 7
+}
+
+variable "maintenance_window_hour" {
+  type    = number
+  default = 3
+}
+
 variable "deletion_protection" {
   type    = bool
   default = false
 }
+
+variable "database_flags" {
+  type = list(object({
+    name  = string
+    value = string
+  }))
+  default = []
+}
+
+variable "create_read_replica" {
+  type    = bool
+  default = false
+}
+
+variable "replica_region" {
+  type    = string
+  default = null
+}
+
+variable "users" {
+  type = list(object({
+    name     = string
+    password = string
+  }))
+  default   = []
+  sensitive = true
+}
+
+variable "enable_password_policy" {
+  type    = bool
+  default = true
+}
+
+output "instance_name" {
+  value = module.cloudsql.instance_name
+}
+
+output "instance_connection_name" {
+  value = module.cloudsql.instance_connection_name
+}
+
+output "private_ip_address" {
+  value = module.cloudsql.private_ip_address
+}
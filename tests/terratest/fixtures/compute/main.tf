@@ -3,18 +3,27 @@
 module "compute" {
   source = "../../../../terraform/modules/compute"
 
-  project_id       = var.project_id
-  region           = var.region
-  environment      = var.environment
-  instance_name    = var.instance_name
-  machine_type     = var.machine_type
-  instance_type    = var.instance_type
-  source_image     = var.source_image
-  network          = var.network
-  subnetwork       = var.subnetwork
-  min_replicas     = var.min_replicas
-  max_replicas     = var.max_replicas
-  assign_public_ip = var.assign_public_ip
+  project_id            = var.project_id
+  name                  = var.instance_name
+  region                = var.region
+  zones                 = var.zones
+  machine_type          = var.machine_type
+  source_image          = var.source_image
+  network               = var.network
+  subnetwork            = var.subnetwork
+  service_account_email = var.service_account_email
+  enable_autoscaling    = true
+  min_replicas          = var.min_replicas
+  max_replicas          = var.max_replicas
+  enable_public_ip      = var.assign_public_ip
+
+  labels = merge(
+    {
+      environment = var.environment
+      os          = var.instance_type
+    },
+    { for key, value in { test_run_id = var.test_run_id } : key => value if value != "" },
+  )
 }
 
 variable "project_id" {
@@ -31,11 +40,22 @@ variable "environment" {
   default = "test"
 }
 
+# Set by the test helpers so resources from one run can be found and swept
+variable "test_run_id" {
+  type    = string
+  default = ""
+}
+
 variable "instance_name" {
   type    = string
   default = "test-instance"
 }
 
+variable "zones" {
+  type    = list(string)
+  default = ["europe-west1-b", "europe-west1-c"]
+}
+
 variable "machine_type" {
   type    = string
   default = "e2-medium"
@@ -61,6 +81,11 @@ variable "subnetwork" {
   default = "default"
 }
 
+variable "service_account_email" {
+  type    = string
+  default = "test-sa@test-project.iam.gserviceaccount.com"
+}
+
 variable "min_replicas" {
   type    = number
   default = 1
@@ -75,3 +100,15 @@ variable "assign_public_ip" {
   type    = bool
   default = false
 }
+
+output "instance_template_self_link" {
+  value = module.compute.instance_template_self_link
+}
+
+output "instance_group" {
+  value = module.compute.instance_group
+}
+
+output "health_check_self_link" {
+  value = module.compute.health_check_self_link
+}
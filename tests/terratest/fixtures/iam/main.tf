@@ -3,13 +3,19 @@
 module "iam" {
   source = "../../../../terraform/modules/iam"
 
-  project_id               = var.project_id
-  environment              = var.environment
-  service_accounts         = var.service_accounts
-  role_bindings            = var.role_bindings
-  enable_workload_identity = var.enable_workload_identity
-  workload_identity_config = var.workload_identity_config
-  account_type             = var.account_type
+  project_id = var.project_id
+
+  service_accounts = [
+    for sa in var.service_accounts : {
+      name         = sa.account_id
+      display_name = sa.display_name
+      description  = sa.description
+      roles        = []
+    }
+  ]
+  role_bindings = var.role_bindings
+
+  create_workload_identity_pool = var.enable_workload_identity
 }
 
 variable "project_id" {
@@ -27,7 +33,13 @@ variable "service_accounts" {
     display_name = string
     description  = string
   }))
-  default = []
+  default = [
+    {
+      account_id   = "test-sa"
+      display_name = "Test Service Account"
+      description  = "Service account created by the IAM fixture"
+    }
+  ]
 }
 
 variable "role_bindings" {
@@ -52,7 +64,6 @@ variable "workload_identity_config" {
   default = null
 }
 
-variable "account_type" {
-  type    = string
-  default = "application"
+output "service_account_emails" {
+  value = module.iam.service_account_emails
 }
@@ -1,20 +1,65 @@
 # Terratest Fixture for Load Balancer Module
 
+# The module takes self links for the health check and SSL policy, so the
+# fixture creates both from its own variables
+
+resource "google_compute_health_check" "fixture" {
+  name    = "${var.name}-health-check"
+  project = var.project_id
+
+  check_interval_sec  = var.health_check.check_interval_sec
+  timeout_sec         = var.health_check.timeout_sec
+  healthy_threshold   = var.health_check.healthy_threshold
+  unhealthy_threshold = var.health_check.unhealthy_threshold
+
+  http_health_check {
+    port         = var.health_check.port
+    request_path = var.health_check.request_path
+  }
+}
+
+resource "google_compute_ssl_policy" "fixture" {
+  name            = "${var.name}-ssl-policy"
+  project         = var.project_id
+  profile         = var.ssl_policy
+  min_tls_version = var.min_tls_version
+}
+
 module "load_balancer" {
   source = "../../../../terraform/modules/load-balancer"
 
-  project_id      = var.project_id
-  region          = var.region
-  environment     = var.environment
-  name            = var.name
-  enable_https    = var.enable_https
-  ssl_policy      = var.ssl_policy
-  min_tls_version = var.min_tls_version
-  backends        = var.backends
-  health_check    = var.health_check
-  url_map_rules   = var.url_map_rules
-  enable_cdn      = var.enable_cdn
-  cdn_policy      = var.cdn_policy
+  project_id = var.project_id
+  name       = var.name
+
+  backends = [
+    for backend in var.backends : {
+      instance_group        = backend.group
+      balancing_mode        = backend.balancing_mode
+      capacity_scaler       = backend.capacity_scaler
+      max_utilization       = backend.balancing_mode == "UTILIZATION" ? 0.8 : null
+      max_rate_per_instance = backend.balancing_mode == "RATE" ? 100 : null
+    }
+  ]
+
+  health_check         = google_compute_health_check.fixture.id
+  ssl_certificates     = var.ssl_certificates
+  ssl_policy           = google_compute_ssl_policy.fixture.id
+  enable_http_redirect = var.enable_https
+  enable_cdn           = var.enable_cdn
+
+  host_rules = [
+    for rule in var.url_map_rules : {
+      hosts        = rule.hosts
+      path_matcher = rule.path_matcher
+    }
+  ]
+
+  path_matchers = [
+    for rule in var.url_map_rules : {
+      name       = rule.path_matcher
+      path_rules = []
+    }
+  ]
 }
 
 variable "project_id" {
@@ -51,6 +96,11 @@ variable "min_tls_version" {
   default = "TLS_1_2"
 }
 
+variable "ssl_certificates" {
+  type    = list(string)
+  default = ["projects/test-project/global/sslCertificates/test-certificate"]
+}
+
 variable "backends" {
   type = list(object({
     group           = string
@@ -103,3 +153,11 @@ variable "cdn_policy" {
   })
   default = null
 }
+
+output "external_ip" {
+  value = module.load_balancer.external_ip
+}
+
+output "url_map_id" {
+  value = module.load_balancer.url_map_id
+}
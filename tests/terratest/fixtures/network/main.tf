@@ -3,12 +3,29 @@
 module "network" {
   source = "../../../../terraform/modules/network"
 
-  project_id          = var.project_id
-  region              = var.region
-  environment         = var.environment
-  vpc_name            = var.vpc_name
-  public_subnet_cidr  = var.public_subnet_cidr
-  private_subnet_cidr = var.private_subnet_cidr
+  project_id = var.project_id
+  vpc_name   = var.vpc_name
+
+  subnets = [
+    {
+      name                        = "${var.vpc_name}-public"
+      region                      = var.region
+      ip_cidr_range               = var.public_subnet_cidr
+      secondary_ip_range_pods     = var.public_pods_cidr
+      secondary_ip_range_services = var.public_services_cidr
+    },
+    {
+      name                        = "${var.vpc_name}-private"
+      region                      = var.region
+      ip_cidr_range               = var.private_subnet_cidr
+      secondary_ip_range_pods     = var.private_pods_cidr
+      secondary_ip_range_services = var.private_services_cidr
+    }
+  ]
+
+  nat_regions       = coalescelist(var.nat_regions, [var.region])
+  ssh_source_ranges = var.ssh_source_ranges
+  rdp_source_ranges = var.rdp_source_ranges
 }
 
 variable "project_id" {
@@ -20,11 +37,6 @@ variable "region" {
   default = "europe-west1"
 }
 
-variable "environment" {
-  type    = string
-  default = "test"
-}
-
 variable "vpc_name" {
   type    = string
   default = "test-vpc"
@@ -40,14 +52,40 @@ variable "private_subnet_cidr" {
   default = "10.0.2.0/24"
 }
 
-variable "enable_ssh_firewall" {
-  type    = bool
-  default = true
+variable "public_pods_cidr" {
+  type    = string
+  default = "10.10.0.0/16"
+}
+
+variable "public_services_cidr" {
+  type    = string
+  default = "10.11.0.0/20"
 }
 
-variable "enable_http_firewall" {
-  type    = bool
-  default = true
+variable "private_pods_cidr" {
+  type    = string
+  default = "10.20.0.0/16"
+}
+
+variable "private_services_cidr" {
+  type    = string
+  default = "10.21.0.0/20"
+}
+
+# Regions to create Cloud NAT in; empty means the fixture's region
+variable "nat_regions" {
+  type    = list(string)
+  default = []
+}
+
+variable "ssh_source_ranges" {
+  type    = list(string)
+  default = ["35.235.240.0/20"]
+}
+
+variable "rdp_source_ranges" {
+  type    = list(string)
+  default = ["35.235.240.0/20"]
 }
 
 output "vpc_id" {
@@ -57,3 +95,11 @@ output "vpc_id" {
 output "vpc_name" {
   value = module.network.vpc_name
 }
+
+output "vpc_self_link" {
+  value = module.network.vpc_self_link
+}
+
+output "subnets" {
+  value = module.network.subnets
+}
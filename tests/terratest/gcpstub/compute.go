@@ -0,0 +1,355 @@
+package gcpstub
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// handleCompute serves projects/{project}/{scope}/{collection}[/{name}[/{action}]]
+// where scope is "global", "regions/{region}" or "zones/{zone}"
+func (s *Server) handleCompute(w http.ResponseWriter, r *http.Request) {
+	rel := strings.Trim(strings.TrimPrefix(r.URL.Path, ComputePrefix), "/")
+	parts := strings.Split(rel, "/")
+	if len(parts) < 2 || parts[0] != "projects" {
+		writeError(w, http.StatusNotFound, "unknown compute path %s", rel)
+		return
+	}
+	project := parts[1]
+	if len(parts) == 2 {
+		s.computeProject(w, project)
+		return
+	}
+
+	var scope []string
+	switch parts[2] {
+	case "aggregated":
+		if len(parts) != 4 || r.Method != http.MethodGet {
+			writeError(w, http.StatusNotImplemented, "gcpstub does not serve %s %s", r.Method, rel)
+			return
+		}
+		s.aggregatedList(w, project, parts[3])
+		return
+	case "global":
+		scope, parts = parts[:3], parts[3:]
+	case "regions", "zones":
+		if len(parts) < 4 {
+			writeError(w, http.StatusNotFound, "unknown compute path %s", rel)
+			return
+		}
+		scope, parts = parts[:4], parts[4:]
+	default:
+		writeError(w, http.StatusNotFound, "unknown compute path %s", rel)
+		return
+	}
+	if len(parts) == 0 {
+		if scope[2] == "global" {
+			writeError(w, http.StatusNotFound, "unknown compute path %s", rel)
+			return
+		}
+		s.computeLocation(w, project, scope)
+		return
+	}
+
+	collection := "compute/v1/" + strings.Join(append(scope, parts[0]), "/")
+	if parts[0] == "images" && r.Method == http.MethodGet && len(parts) > 1 {
+		s.computeImage(w, collection, parts[1:])
+		return
+	}
+	if parts[0] == "operations" {
+		s.computeOperation(w, collection, parts[1:])
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		s.list(w, collection, "compute#"+parts[0]+"List")
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		s.computeInsert(w, r, project, scope, collection, parts[0])
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		s.get(w, collection+"/"+parts[1])
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		s.computeDelete(w, project, scope, collection+"/"+parts[1])
+	case len(parts) == 2 && (r.Method == http.MethodPatch || r.Method == http.MethodPut):
+		s.computeUpdate(w, r, project, scope, collection+"/"+parts[1])
+	case len(parts) == 3 && r.Method == http.MethodPost:
+		s.computeAction(w, r, project, scope, collection+"/"+parts[1], parts[2])
+	case len(parts) == 3 && r.Method == http.MethodGet:
+		// Sub-resource reads such as getIamPolicy or listManagedInstances
+		s.get(w, collection+"/"+parts[1]+"/"+parts[2])
+	default:
+		writeError(w, http.StatusNotImplemented, "gcpstub does not serve %s %s", r.Method, rel)
+	}
+}
+
+func (s *Server) computeProject(w http.ResponseWriter, project string) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"kind":     "compute#project",
+		"name":     project,
+		"id":       ProjectNumber,
+		"selfLink": s.URL + ComputePrefix + "projects/" + project,
+	})
+}
+
+// computeLocation describes a region with zones a to d, or a single zone
+func (s *Server) computeLocation(w http.ResponseWriter, project string, scope []string) {
+	name := scope[3]
+	resource := map[string]interface{}{
+		"name":     name,
+		"status":   "UP",
+		"selfLink": s.URL + ComputePrefix + strings.Join(scope, "/"),
+	}
+	if scope[2] == "regions" {
+		resource["kind"] = "compute#region"
+		var zones []interface{}
+		for _, zone := range []string{"a", "b", "c", "d"} {
+			zones = append(zones, s.URL+ComputePrefix+"projects/"+project+"/zones/"+name+"-"+zone)
+		}
+		resource["zones"] = zones
+	} else {
+		resource["kind"] = "compute#zone"
+		resource["region"] = s.URL + ComputePrefix + "projects/" + project + "/regions/" + name[:strings.LastIndex(name, "-")]
+	}
+	writeJSON(w, http.StatusOK, resource)
+}
+
+// computeImage serves seeded images and otherwise makes up a READY image, so
+// public images and families such as rocky-linux-cloud resolve offline
+func (s *Server) computeImage(w http.ResponseWriter, collection string, rest []string) {
+	path := collection + "/" + strings.Join(rest, "/")
+	if image, ok := s.Get(path); ok {
+		writeJSON(w, http.StatusOK, image)
+		return
+	}
+	name := rest[len(rest)-1]
+	image := map[string]interface{}{
+		"kind":             "compute#image",
+		"name":             name,
+		"status":           "READY",
+		"diskSizeGb":       "20",
+		"archiveSizeBytes": "0",
+		"selfLink":         s.URL + "/" + collection + "/" + name,
+	}
+	if rest[0] == "family" {
+		image["family"] = name
+		image["name"] = name + "-v20240101"
+		image["selfLink"] = s.URL + "/" + collection + "/" + name + "-v20240101"
+	}
+	writeJSON(w, http.StatusOK, image)
+}
+
+func (s *Server) computeInsert(w http.ResponseWriter, r *http.Request, project string, scope []string, collection, kind string) {
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return
+	}
+	name := stringField(body, "name")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	s.mu.Lock()
+	path := collection + "/" + name
+	if _, exists := s.resources[path]; exists {
+		s.mu.Unlock()
+		writeError(w, http.StatusConflict, "The resource '%s' already exists", path)
+		return
+	}
+	body["kind"] = "compute#" + strings.TrimSuffix(kind, "s")
+	body["id"] = s.newIDLocked()
+	body["selfLink"] = s.URL + "/" + path
+	body["creationTimestamp"] = s.timestamp()
+	s.decorateComputeLocked(path, kind, body)
+	s.resources[path] = body
+	op := s.computeOperationLocked(project, scope, "insert", body["selfLink"].(string))
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, op)
+}
+
+// decorateComputeLocked fills in output-only fields the provider reads back
+func (s *Server) decorateComputeLocked(path, kind string, body map[string]interface{}) {
+	switch kind {
+	case "networks":
+		body["subnetworks"] = []interface{}{}
+	case "subnetworks":
+		body["fingerprint"] = "gcpstub"
+		if _, network, err := net.ParseCIDR(stringField(body, "ipCidrRange")); err == nil {
+			gateway := network.IP.To4()
+			if gateway != nil {
+				gateway[3]++
+				body["gatewayAddress"] = gateway.String()
+			}
+		}
+	case "addresses", "globalAddresses":
+		if stringField(body, "address") == "" {
+			body["address"] = fmt.Sprintf("10.%d.0.0", len(s.resources)%200+50)
+		}
+		body["status"] = "RESERVED"
+	case "instanceGroupManagers":
+		body["fingerprint"] = "gcpstub"
+		body["instanceGroup"] = strings.Replace(body["selfLink"].(string), "/instanceGroupManagers/", "/instanceGroups/", 1)
+		body["status"] = map[string]interface{}{
+			"isStable":      true,
+			"versionTarget": map[string]interface{}{"isReached": true},
+		}
+		body["currentActions"] = map[string]interface{}{"none": body["targetSize"]}
+	case "instanceTemplates":
+		if _, ok := body["properties"]; !ok {
+			body["properties"] = map[string]interface{}{}
+		}
+	}
+	body["labelFingerprint"] = "gcpstub"
+}
+
+func (s *Server) computeDelete(w http.ResponseWriter, project string, scope []string, path string) {
+	s.mu.Lock()
+	resource, ok := s.resources[path]
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "The resource '%s' was not found", path)
+		return
+	}
+	delete(s.resources, path)
+	op := s.computeOperationLocked(project, scope, "delete", stringField(resource, "selfLink"))
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, op)
+}
+
+func (s *Server) computeUpdate(w http.ResponseWriter, r *http.Request, project string, scope []string, path string) {
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return
+	}
+	s.mu.Lock()
+	resource, ok := s.resources[path]
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "The resource '%s' was not found", path)
+		return
+	}
+	merge(resource, body)
+	op := s.computeOperationLocked(project, scope, "patch", stringField(resource, "selfLink"))
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, op)
+}
+
+// computeAction handles custom verbs like setLabels or addPeering. Setters
+// are merged into the resource; everything else just completes.
+func (s *Server) computeAction(w http.ResponseWriter, r *http.Request, project string, scope []string, path, action string) {
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return
+	}
+	s.mu.Lock()
+	resource, ok := s.resources[path]
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "The resource '%s' was not found", path)
+		return
+	}
+	if strings.HasPrefix(action, "set") {
+		merge(resource, body)
+	}
+	op := s.computeOperationLocked(project, scope, action, stringField(resource, "selfLink"))
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, op)
+}
+
+// computeOperationLocked records and returns an operation that is already DONE
+func (s *Server) computeOperationLocked(project string, scope []string, operationType, targetLink string) map[string]interface{} {
+	name := "operation-" + s.newIDLocked()
+	path := "compute/v1/" + strings.Join(scope, "/") + "/operations/" + name
+	op := map[string]interface{}{
+		"kind":          "compute#operation",
+		"id":            s.newIDLocked(),
+		"name":          name,
+		"operationType": operationType,
+		"targetLink":    targetLink,
+		"status":        "DONE",
+		"progress":      100,
+		"insertTime":    s.timestamp(),
+		"endTime":       s.timestamp(),
+		"selfLink":      s.URL + "/" + path,
+	}
+	switch scope[2] {
+	case "regions":
+		op["region"] = scope[3]
+	case "zones":
+		op["zone"] = scope[3]
+	}
+	s.resources[path] = op
+	return op
+}
+
+func (s *Server) computeOperation(w http.ResponseWriter, collection string, rest []string) {
+	if len(rest) == 0 {
+		s.list(w, collection, "compute#operationList")
+		return
+	}
+	// GET operations/{name} and POST operations/{name}/wait are equivalent here
+	s.get(w, collection+"/"+rest[0])
+}
+
+// get writes the stored resource at path or a 404
+func (s *Server) get(w http.ResponseWriter, path string) {
+	resource, ok := s.Get(path)
+	if !ok {
+		writeError(w, http.StatusNotFound, "The resource '%s' was not found", path)
+		return
+	}
+	writeJSON(w, http.StatusOK, resource)
+}
+
+// list writes the direct children of collection in the compute list format
+func (s *Server) list(w http.ResponseWriter, collection, kind string) {
+	s.mu.Lock()
+	items := []interface{}{}
+	for _, path := range s.childrenLocked(collection, true) {
+		items = append(items, clone(s.resources[path]))
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"kind":     kind,
+		"items":    items,
+		"selfLink": s.URL + "/" + collection,
+	})
+}
+
+// aggregatedList writes every regional and zonal resource of collection keyed
+// by scope, as in aggregated/{collection}
+func (s *Server) aggregatedList(w http.ResponseWriter, project, collection string) {
+	base := "compute/v1/projects/" + project
+	items := map[string]interface{}{}
+	s.mu.Lock()
+	for _, path := range s.childrenLocked(base, false) {
+		// {regions|zones}/{location}/{collection}/{name}
+		parts := strings.Split(strings.TrimPrefix(path, base+"/"), "/")
+		if len(parts) != 4 || parts[0] != "regions" && parts[0] != "zones" || parts[2] != collection {
+			continue
+		}
+		key := parts[0] + "/" + parts[1]
+		scoped, ok := items[key].(map[string]interface{})
+		if !ok {
+			scoped = map[string]interface{}{collection: []interface{}{}}
+			items[key] = scoped
+		}
+		scoped[collection] = append(scoped[collection].([]interface{}), clone(s.resources[path]))
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"kind":     "compute#" + strings.TrimSuffix(collection, "s") + "AggregatedList",
+		"items":    items,
+		"selfLink": s.URL + "/" + base + "/aggregated/" + collection,
+	})
+}
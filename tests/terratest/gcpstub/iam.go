@@ -0,0 +1,348 @@
+package gcpstub
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// handleIAM serves service accounts, their keys, custom roles and workload
+// identity pools under projects/{project}
+func (s *Server) handleIAM(w http.ResponseWriter, r *http.Request) {
+	rel := strings.Trim(strings.TrimPrefix(r.URL.Path, IAMPrefix), "/")
+	parts := strings.Split(rel, "/")
+	if len(parts) < 3 || parts[0] != "projects" {
+		writeError(w, http.StatusNotFound, "unknown iam path %s", rel)
+		return
+	}
+
+	switch parts[2] {
+	case "serviceAccounts":
+		s.iamServiceAccounts(w, r, parts[1], parts[3:])
+	case "roles":
+		s.iamGeneric(w, r, "iam/v1/projects/"+parts[1]+"/roles", parts[3:], "roleId", "role")
+	default:
+		if strings.HasPrefix(parts[2], "locations") || len(parts) > 3 && parts[3] == "workloadIdentityPools" {
+			s.iamWorkloadIdentity(w, r, rel)
+			return
+		}
+		writeError(w, http.StatusNotFound, "unknown iam path %s", rel)
+	}
+}
+
+// iamServiceAccounts handles projects/{project}/serviceAccounts[/{email}[/keys[/{key}]]].
+// The provider reads accounts back through projects/-/serviceAccounts/{email}
+// so accounts are stored by email alone.
+func (s *Server) iamServiceAccounts(w http.ResponseWriter, r *http.Request, project string, rest []string) {
+	collection := "iam/v1/serviceAccounts"
+
+	if len(rest) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			s.mu.Lock()
+			accounts := []interface{}{}
+			for _, path := range s.childrenLocked(collection, true) {
+				if account := s.resources[path]; project == "-" || stringField(account, "projectId") == project {
+					accounts = append(accounts, clone(account))
+				}
+			}
+			s.mu.Unlock()
+			writeJSON(w, http.StatusOK, map[string]interface{}{"accounts": accounts})
+		case http.MethodPost:
+			s.iamCreateServiceAccount(w, r, project)
+		default:
+			writeError(w, http.StatusNotImplemented, "gcpstub does not serve %s serviceAccounts", r.Method)
+		}
+		return
+	}
+
+	email, action := rest[0], ""
+	if i := strings.Index(email, ":"); i >= 0 {
+		email, action = email[:i], email[i+1:]
+	}
+	path := collection + "/" + email
+
+	switch {
+	case action == "getIamPolicy" || action == "setIamPolicy":
+		s.iamPolicy(w, r, path+"/policy", action)
+	case action != "":
+		// enable, disable and undelete
+		s.get(w, path)
+	case len(rest) >= 2 && rest[1] == "keys":
+		s.iamKeys(w, r, path, email, rest[2:])
+	case r.Method == http.MethodGet:
+		s.get(w, path)
+	case r.Method == http.MethodDelete:
+		s.mu.Lock()
+		for _, child := range s.childrenLocked(path, false) {
+			delete(s.resources, child)
+		}
+		delete(s.resources, path)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{})
+	case r.Method == http.MethodPatch || r.Method == http.MethodPut:
+		body, err := readBody(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+			return
+		}
+		// PATCH wraps the account in {"serviceAccount": ..., "updateMask": ...}
+		if account, ok := body["serviceAccount"].(map[string]interface{}); ok {
+			body = account
+		}
+		s.mu.Lock()
+		account, ok := s.resources[path]
+		if ok {
+			merge(account, body)
+			account = clone(account)
+		}
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "Service account %s does not exist.", email)
+			return
+		}
+		writeJSON(w, http.StatusOK, account)
+	default:
+		writeError(w, http.StatusNotImplemented, "gcpstub does not serve %s %s", r.Method, path)
+	}
+}
+
+func (s *Server) iamCreateServiceAccount(w http.ResponseWriter, r *http.Request, project string) {
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return
+	}
+	accountID := stringField(body, "accountId")
+	if accountID == "" {
+		writeError(w, http.StatusBadRequest, "accountId is required")
+		return
+	}
+	account, _ := body["serviceAccount"].(map[string]interface{})
+	if account == nil {
+		account = map[string]interface{}{}
+	}
+	email := fmt.Sprintf("%s@%s.iam.gserviceaccount.com", accountID, project)
+
+	s.mu.Lock()
+	path := "iam/v1/serviceAccounts/" + email
+	if _, exists := s.resources[path]; exists {
+		s.mu.Unlock()
+		writeError(w, http.StatusConflict, "Service account %s already exists within project projects/%s.", accountID, project)
+		return
+	}
+	account["name"] = "projects/" + project + "/serviceAccounts/" + email
+	account["projectId"] = project
+	account["email"] = email
+	account["uniqueId"] = s.newIDLocked()
+	account["oauth2ClientId"] = account["uniqueId"]
+	account["etag"] = "gcpstub"
+	s.resources[path] = account
+	account = clone(account)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, account)
+}
+
+func (s *Server) iamKeys(w http.ResponseWriter, r *http.Request, accountPath, email string, rest []string) {
+	if _, ok := s.Get(accountPath); !ok {
+		writeError(w, http.StatusNotFound, "Service account %s does not exist.", email)
+		return
+	}
+	collection := accountPath + "/keys"
+
+	switch {
+	case len(rest) == 0 && r.Method == http.MethodGet:
+		s.mu.Lock()
+		keys := []interface{}{}
+		for _, path := range s.childrenLocked(collection, true) {
+			keys = append(keys, clone(s.resources[path]))
+		}
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"keys": keys})
+	case len(rest) == 0 && r.Method == http.MethodPost:
+		s.mu.Lock()
+		id := s.newIDLocked()
+		key := map[string]interface{}{
+			"name":            strings.TrimPrefix(collection, "iam/v1/") + "/" + id,
+			"keyAlgorithm":    "KEY_ALG_RSA_2048",
+			"keyOrigin":       "GOOGLE_PROVIDED",
+			"keyType":         "USER_MANAGED",
+			"validAfterTime":  s.timestamp(),
+			"validBeforeTime": "9999-12-31T23:59:59Z",
+			// base64 of "{}" so the provider can decode the credentials
+			"privateKeyData": "e30=",
+			"privateKeyType": "TYPE_GOOGLE_CREDENTIALS_FILE",
+			"publicKeyData":  "",
+		}
+		s.resources[collection+"/"+id] = key
+		key = clone(key)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, key)
+	case len(rest) == 1 && r.Method == http.MethodGet:
+		s.get(w, collection+"/"+rest[0])
+	case len(rest) == 1 && r.Method == http.MethodDelete:
+		s.mu.Lock()
+		delete(s.resources, collection+"/"+rest[0])
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{})
+	default:
+		writeError(w, http.StatusNotImplemented, "gcpstub does not serve %s keys", r.Method)
+	}
+}
+
+// iamGeneric serves a flat collection whose create request carries the id in
+// idField and the resource in bodyField
+func (s *Server) iamGeneric(w http.ResponseWriter, r *http.Request, collection string, rest []string, idField, bodyField string) {
+	switch {
+	case len(rest) == 0 && r.Method == http.MethodGet:
+		s.mu.Lock()
+		items := []interface{}{}
+		for _, path := range s.childrenLocked(collection, true) {
+			items = append(items, clone(s.resources[path]))
+		}
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{bodyField + "s": items})
+	case len(rest) == 0 && r.Method == http.MethodPost:
+		body, err := readBody(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+			return
+		}
+		resource, _ := body[bodyField].(map[string]interface{})
+		if resource == nil {
+			resource = map[string]interface{}{}
+		}
+		resource["name"] = strings.TrimPrefix(collection, "iam/v1/") + "/" + stringField(body, idField)
+		resource["etag"] = "gcpstub"
+		s.Put(collection+"/"+stringField(body, idField), resource)
+		writeJSON(w, http.StatusOK, resource)
+	case len(rest) == 1 && r.Method == http.MethodGet:
+		s.get(w, collection+"/"+rest[0])
+	case len(rest) == 1 && r.Method == http.MethodDelete:
+		s.mu.Lock()
+		resource, ok := s.resources[collection+"/"+rest[0]]
+		if ok {
+			resource["deleted"] = true
+			resource = clone(resource)
+		}
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "The resource '%s' was not found", rest[0])
+			return
+		}
+		writeJSON(w, http.StatusOK, resource)
+	case len(rest) == 1 && r.Method == http.MethodPatch:
+		body, err := readBody(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+			return
+		}
+		s.mu.Lock()
+		resource, ok := s.resources[collection+"/"+rest[0]]
+		if ok {
+			merge(resource, body)
+			resource = clone(resource)
+		}
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "The resource '%s' was not found", rest[0])
+			return
+		}
+		writeJSON(w, http.StatusOK, resource)
+	default:
+		writeError(w, http.StatusNotImplemented, "gcpstub does not serve %s %s", r.Method, collection)
+	}
+}
+
+// iamWorkloadIdentity handles projects/{p}/locations/global/workloadIdentityPools
+// and their providers. Creates and deletes return a finished operation.
+func (s *Server) iamWorkloadIdentity(w http.ResponseWriter, r *http.Request, rel string) {
+	parts := strings.Split(rel, "/")
+	if n := len(parts); n >= 2 && parts[n-2] == "operations" {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"name": rel, "done": true})
+		return
+	}
+	path := "iam/v1/" + rel
+
+	switch r.Method {
+	case http.MethodGet:
+		if len(parts)%2 == 1 {
+			s.mu.Lock()
+			items := []interface{}{}
+			for _, child := range s.childrenLocked(path, true) {
+				items = append(items, clone(s.resources[child]))
+			}
+			s.mu.Unlock()
+			writeJSON(w, http.StatusOK, map[string]interface{}{parts[len(parts)-1]: items})
+			return
+		}
+		s.get(w, path)
+	case http.MethodPost:
+		body, err := readBody(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+			return
+		}
+		var id string
+		for key, values := range r.URL.Query() {
+			if strings.HasSuffix(key, "Id") && len(values) > 0 {
+				id = values[0]
+			}
+		}
+		body["name"] = rel + "/" + id
+		body["state"] = "ACTIVE"
+		s.Put(path+"/"+id, body)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"name":     rel + "/" + id + "/operations/create",
+			"done":     true,
+			"response": body,
+		})
+	case http.MethodPatch:
+		body, err := readBody(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+			return
+		}
+		s.mu.Lock()
+		if resource, ok := s.resources[path]; ok {
+			merge(resource, body)
+		}
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"name": rel + "/operations/update", "done": true})
+	case http.MethodDelete:
+		s.mu.Lock()
+		for _, child := range s.childrenLocked(path, false) {
+			delete(s.resources, child)
+		}
+		delete(s.resources, path)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"name": rel + "/operations/delete", "done": true})
+	default:
+		writeError(w, http.StatusNotImplemented, "gcpstub does not serve %s %s", r.Method, rel)
+	}
+}
+
+// iamPolicy serves getIamPolicy and setIamPolicy for the resource owning path
+func (s *Server) iamPolicy(w http.ResponseWriter, r *http.Request, path, action string) {
+	if action == "setIamPolicy" {
+		body, err := readBody(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+			return
+		}
+		policy, _ := body["policy"].(map[string]interface{})
+		if policy == nil {
+			policy = map[string]interface{}{}
+		}
+		policy["etag"] = "BwWKmjvelug="
+		s.Put(path, policy)
+		writeJSON(w, http.StatusOK, policy)
+		return
+	}
+	policy, ok := s.Get(path)
+	if !ok {
+		policy = map[string]interface{}{"version": 1, "etag": "ACAB", "bindings": []interface{}{}}
+	}
+	writeJSON(w, http.StatusOK, policy)
+}
@@ -0,0 +1,35 @@
+package gcpstub
+
+import (
+	"net/http"
+	"strings"
+)
+
+// handleResourceManager serves projects/{project} and its IAM policy verbs
+func (s *Server) handleResourceManager(w http.ResponseWriter, r *http.Request) {
+	rel := strings.Trim(strings.TrimPrefix(r.URL.Path, ResourceManagerPrefix), "/")
+	parts := strings.Split(rel, "/")
+	if len(parts) != 2 || parts[0] != "projects" {
+		writeError(w, http.StatusNotFound, "unknown resourcemanager path %s", rel)
+		return
+	}
+
+	project, action := parts[1], ""
+	if i := strings.Index(project, ":"); i >= 0 {
+		project, action = project[:i], project[i+1:]
+	}
+
+	switch action {
+	case "":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"projectId":      project,
+			"projectNumber":  ProjectNumber,
+			"name":           project,
+			"lifecycleState": "ACTIVE",
+		})
+	case "getIamPolicy", "setIamPolicy":
+		s.iamPolicy(w, r, "resourcemanager/v1/projects/"+project+"/policy", action)
+	default:
+		writeError(w, http.StatusNotImplemented, "gcpstub does not serve %s %s", r.Method, rel)
+	}
+}
@@ -0,0 +1,247 @@
+// Package gcpstub is an in-process stand-in for the Google Cloud REST APIs the
+// Terraform modules touch: Compute Engine, Cloud SQL Admin, IAM, Cloud Resource
+// Manager and Service Networking. It keeps resources in memory, completes every
+// long-running operation immediately and is wired into the google provider
+// through its custom endpoint environment variables.
+package gcpstub
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// API path prefixes served by the stand-in. Each becomes the provider's
+// custom endpoint for that API.
+const (
+	ComputePrefix           = "/compute/v1/"
+	SQLAdminPrefix          = "/sql/v1beta4/"
+	IAMPrefix               = "/iam/v1/"
+	ResourceManagerPrefix   = "/resourcemanager/v1/"
+	ServiceNetworkingPrefix = "/servicenetworking/v1/"
+)
+
+// ProjectNumber is returned for every project looked up through Resource Manager
+const ProjectNumber = "123456789012"
+
+// Server is a running stand-in
+type Server struct {
+	URL string
+
+	httpServer *httptest.Server
+	mu         sync.Mutex
+	resources  map[string]map[string]interface{}
+	nextID     uint64
+	requests   []string
+	clock      func() time.Time
+}
+
+// NewServer starts a stand-in on a random local port. Call Close when done.
+func NewServer() *Server {
+	s := &Server{
+		resources: map[string]map[string]interface{}{},
+		nextID:    1000,
+		clock:     time.Now,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(ComputePrefix, s.handleCompute)
+	mux.HandleFunc(SQLAdminPrefix, s.handleSQLAdmin)
+	mux.HandleFunc(IAMPrefix, s.handleIAM)
+	mux.HandleFunc(ResourceManagerPrefix, s.handleResourceManager)
+	mux.HandleFunc(ServiceNetworkingPrefix, s.handleServiceNetworking)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotImplemented, "gcpstub does not serve %s %s", r.Method, r.URL.Path)
+	})
+
+	s.httpServer = httptest.NewServer(s.logRequests(mux))
+	s.URL = s.httpServer.URL
+	return s
+}
+
+// Start starts a stand-in that is closed when the test finishes
+func Start(t testing.TB) *Server {
+	s := NewServer()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Close shuts the stand-in down
+func (s *Server) Close() {
+	s.httpServer.Close()
+}
+
+// EnvVars returns the provider environment that routes every API the modules
+// use to the stand-in and supplies a dummy access token
+func (s *Server) EnvVars() map[string]string {
+	return map[string]string{
+		"GOOGLE_OAUTH_ACCESS_TOKEN":                 "gcpstub-token",
+		"GOOGLE_COMPUTE_CUSTOM_ENDPOINT":            s.URL + ComputePrefix,
+		"GOOGLE_SQL_CUSTOM_ENDPOINT":                s.URL + SQLAdminPrefix,
+		"GOOGLE_IAM_CUSTOM_ENDPOINT":                s.URL + IAMPrefix,
+		"GOOGLE_IAM_BETA_CUSTOM_ENDPOINT":           s.URL + IAMPrefix,
+		"GOOGLE_RESOURCE_MANAGER_CUSTOM_ENDPOINT":   s.URL + ResourceManagerPrefix,
+		"GOOGLE_SERVICE_NETWORKING_CUSTOM_ENDPOINT": s.URL + ServiceNetworkingPrefix,
+	}
+}
+
+// Requests returns "METHOD path" for every request served so far
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// Put seeds a resource at the API-relative path, e.g.
+// compute/v1/projects/p/global/networks/vpc
+func (s *Server) Put(path string, resource map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resources[strings.Trim(path, "/")] = resource
+}
+
+// Get returns a copy of the resource at the API-relative path
+func (s *Server) Get(path string) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resource, ok := s.resources[strings.Trim(path, "/")]
+	if !ok {
+		return nil, false
+	}
+	return clone(resource), true
+}
+
+// Paths returns every stored resource path under prefix in sorted order
+func (s *Server) Paths(prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.childrenLocked(strings.Trim(prefix, "/"), false)
+}
+
+// Resources returns the paths of every stored resource except operations and
+// IAM policies, e.g. to check nothing is left after terraform destroy
+func (s *Server) Resources() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var paths []string
+	for _, path := range s.childrenLocked("", false) {
+		if strings.Contains(path, "/operations/") || strings.HasSuffix(path, "/policy") {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+		s.mu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// childrenLocked lists the stored paths below prefix. With direct set, only
+// immediate children of the collection are returned.
+func (s *Server) childrenLocked(prefix string, direct bool) []string {
+	if prefix != "" {
+		prefix += "/"
+	}
+	var paths []string
+	for path := range s.resources {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		if direct && strings.Contains(strings.TrimPrefix(path, prefix), "/") {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (s *Server) newIDLocked() string {
+	s.nextID++
+	return fmt.Sprint(s.nextID)
+}
+
+func (s *Server) timestamp() string {
+	return s.clock().UTC().Format(time.RFC3339)
+}
+
+func readBody(r *http.Request) (map[string]interface{}, error) {
+	body := map[string]interface{}{}
+	data, err := io.ReadAll(r.Body)
+	if err != nil || len(data) == 0 {
+		return body, err
+	}
+	err = json.Unmarshal(data, &body)
+	return body, err
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// writeError writes an error in the Google API JSON error format, which the
+// provider uses to tell "not found" from real failures
+func writeError(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	status := map[int]string{
+		http.StatusBadRequest:     "INVALID_ARGUMENT",
+		http.StatusNotFound:       "NOT_FOUND",
+		http.StatusConflict:       "ALREADY_EXISTS",
+		http.StatusNotImplemented: "UNIMPLEMENTED",
+	}[code]
+	reason := map[int]string{
+		http.StatusBadRequest:     "invalid",
+		http.StatusNotFound:       "notFound",
+		http.StatusConflict:       "alreadyExists",
+		http.StatusNotImplemented: "notImplemented",
+	}[code]
+	writeJSON(w, code, map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"status":  status,
+			"errors": []interface{}{
+				map[string]interface{}{"message": message, "reason": reason, "domain": "global"},
+			},
+		},
+	})
+}
+
+// merge copies patch fields over resource, recursing into nested objects
+func merge(resource, patch map[string]interface{}) {
+	for key, value := range patch {
+		if nested, ok := value.(map[string]interface{}); ok {
+			if existing, ok := resource[key].(map[string]interface{}); ok {
+				merge(existing, nested)
+				continue
+			}
+		}
+		resource[key] = value
+	}
+}
+
+func clone(resource map[string]interface{}) map[string]interface{} {
+	data, _ := json.Marshal(resource)
+	out := map[string]interface{}{}
+	_ = json.Unmarshal(data, &out)
+	return out
+}
+
+func stringField(resource map[string]interface{}, key string) string {
+	value, _ := resource[key].(string)
+	return value
+}
@@ -0,0 +1,196 @@
+package gcpstub
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iam/v1"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sqladmin/v1beta4"
+)
+
+const project = "test-project"
+
+// clientOptions points a Go API client at the stand-in. Like the provider, the
+// SQL Admin and IAM clients take the endpoint without its version segment.
+func clientOptions(s *Server, endpoint string) []option.ClientOption {
+	return []option.ClientOption{option.WithEndpoint(s.URL + endpoint), option.WithoutAuthentication()}
+}
+
+// TestComputeLifecycle tests insert, get, list and delete through the compute client
+func TestComputeLifecycle(t *testing.T) {
+	t.Parallel()
+	s := Start(t)
+	ctx := context.Background()
+	service, err := compute.NewService(ctx, clientOptions(s, ComputePrefix)...)
+	require.NoError(t, err)
+
+	op, err := service.Networks.Insert(project, &compute.Network{Name: "vpc", AutoCreateSubnetworks: false}).Do()
+	require.NoError(t, err)
+	assert.Equal(t, "DONE", op.Status)
+
+	op, err = service.Subnetworks.Insert(project, "europe-west1", &compute.Subnetwork{
+		Name: "vpc-private", IpCidrRange: "10.0.2.0/24", Network: op.TargetLink,
+	}).Do()
+	require.NoError(t, err)
+	assert.Equal(t, "europe-west1", op.Region)
+
+	subnet, err := service.Subnetworks.Get(project, "europe-west1", "vpc-private").Do()
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.2.1", subnet.GatewayAddress)
+
+	_, err = service.Networks.Insert(project, &compute.Network{Name: "vpc"}).Do()
+	assertCode(t, http.StatusConflict, err)
+
+	networks, err := service.Networks.List(project).Do()
+	require.NoError(t, err)
+	require.Len(t, networks.Items, 1)
+
+	_, err = service.Networks.Delete(project, "vpc").Do()
+	require.NoError(t, err)
+	_, err = service.Networks.Get(project, "vpc").Do()
+	assertCode(t, http.StatusNotFound, err)
+}
+
+// TestSQLAdminLifecycle tests instances with users and databases
+func TestSQLAdminLifecycle(t *testing.T) {
+	t.Parallel()
+	s := Start(t)
+	ctx := context.Background()
+	service, err := sqladmin.NewService(ctx, clientOptions(s, "/")...)
+	require.NoError(t, err)
+
+	op, err := service.Instances.Insert(project, &sqladmin.DatabaseInstance{
+		Name:            "test-postgres",
+		Region:          "europe-west1",
+		DatabaseVersion: "POSTGRES_15",
+		Settings:        &sqladmin.Settings{Tier: "db-custom-2-7680"},
+	}).Do()
+	require.NoError(t, err)
+	assert.Equal(t, "DONE", op.Status)
+
+	_, err = service.Operations.Get(project, op.Name).Do()
+	require.NoError(t, err)
+
+	instance, err := service.Instances.Get(project, "test-postgres").Do()
+	require.NoError(t, err)
+	assert.Equal(t, "test-project:europe-west1:test-postgres", instance.ConnectionName)
+	assert.Equal(t, "RUNNABLE", instance.State)
+
+	_, err = service.Users.Insert(project, "test-postgres", &sqladmin.User{Name: "app", Password: "secret"}).Do()
+	require.NoError(t, err)
+	_, err = service.Databases.Insert(project, "test-postgres", &sqladmin.Database{Name: "appdb"}).Do()
+	require.NoError(t, err)
+
+	users, err := service.Users.List(project, "test-postgres").Do()
+	require.NoError(t, err)
+	require.Len(t, users.Items, 1)
+	assert.Empty(t, users.Items[0].Password)
+
+	_, err = service.Instances.Delete(project, "test-postgres").Do()
+	require.NoError(t, err)
+	assert.Empty(t, s.Paths("sql/v1beta4/projects/"+project+"/instances"))
+}
+
+// TestIAMServiceAccounts tests accounts are addressable by email under projects/-
+func TestIAMServiceAccounts(t *testing.T) {
+	t.Parallel()
+	s := Start(t)
+	ctx := context.Background()
+	service, err := iam.NewService(ctx, clientOptions(s, "/iam/")...)
+	require.NoError(t, err)
+
+	account, err := service.Projects.ServiceAccounts.Create("projects/"+project, &iam.CreateServiceAccountRequest{
+		AccountId:      "app-a-sa",
+		ServiceAccount: &iam.ServiceAccount{DisplayName: "App A"},
+	}).Do()
+	require.NoError(t, err)
+	assert.Equal(t, "app-a-sa@test-project.iam.gserviceaccount.com", account.Email)
+
+	got, err := service.Projects.ServiceAccounts.Get("projects/-/serviceAccounts/" + account.Email).Do()
+	require.NoError(t, err)
+	assert.Equal(t, "App A", got.DisplayName)
+
+	key, err := service.Projects.ServiceAccounts.Keys.Create(account.Name, &iam.CreateServiceAccountKeyRequest{}).Do()
+	require.NoError(t, err)
+	assert.NotEmpty(t, key.PrivateKeyData)
+
+	_, err = service.Projects.ServiceAccounts.Delete(account.Name).Do()
+	require.NoError(t, err)
+	_, err = service.Projects.ServiceAccounts.Get(account.Name).Do()
+	assertCode(t, http.StatusNotFound, err)
+}
+
+// TestEnvVars tests every custom endpoint points at the stand-in
+func TestEnvVars(t *testing.T) {
+	t.Parallel()
+	s := Start(t)
+
+	for name, value := range s.EnvVars() {
+		if name == "GOOGLE_OAUTH_ACCESS_TOKEN" {
+			continue
+		}
+		assert.Contains(t, value, s.URL, name)
+	}
+	resp, err := http.Get(s.URL + ResourceManagerPrefix + "projects/" + project)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"GET /resourcemanager/v1/projects/test-project"}, s.Requests())
+}
+
+func assertCode(t *testing.T, code int, err error) {
+	t.Helper()
+	var apiErr *googleapi.Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, code, apiErr.Code)
+}
+
+// TestComputeLookups tests the regions, zones and public images the provider
+// resolves without creating them
+func TestComputeLookups(t *testing.T) {
+	t.Parallel()
+	s := Start(t)
+	ctx := context.Background()
+	service, err := compute.NewService(ctx, clientOptions(s, ComputePrefix)...)
+	require.NoError(t, err)
+
+	region, err := service.Regions.Get(project, "europe-west1").Do()
+	require.NoError(t, err)
+	assert.Len(t, region.Zones, 4)
+
+	image, err := service.Images.GetFromFamily("rocky-linux-cloud", "rocky-linux-9").Do()
+	require.NoError(t, err)
+	assert.Equal(t, "READY", image.Status)
+	assert.Equal(t, "rocky-linux-9", image.Family)
+
+	_, err = service.Networks.Insert(project, &compute.Network{Name: "vpc"}).Do()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"compute/v1/projects/test-project/global/networks/vpc"}, s.Resources())
+}
+
+// TestAggregatedList tests regional and zonal resources are grouped by scope
+func TestAggregatedList(t *testing.T) {
+	t.Parallel()
+	s := Start(t)
+	ctx := context.Background()
+	service, err := compute.NewService(ctx, clientOptions(s, ComputePrefix)...)
+	require.NoError(t, err)
+
+	_, err = service.RegionInstanceGroupManagers.Insert(project, "europe-west1", &compute.InstanceGroupManager{Name: "regional-mig"}).Do()
+	require.NoError(t, err)
+	_, err = service.InstanceGroupManagers.Insert(project, "europe-west1-b", &compute.InstanceGroupManager{Name: "zonal-mig"}).Do()
+	require.NoError(t, err)
+
+	list, err := service.InstanceGroupManagers.AggregatedList(project).Do()
+	require.NoError(t, err)
+	require.Contains(t, list.Items, "regions/europe-west1")
+	require.Contains(t, list.Items, "zones/europe-west1-b")
+	assert.Equal(t, "regional-mig", list.Items["regions/europe-west1"].InstanceGroupManagers[0].Name)
+	assert.Equal(t, "zonal-mig", list.Items["zones/europe-west1-b"].InstanceGroupManagers[0].Name)
+}
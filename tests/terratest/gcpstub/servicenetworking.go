@@ -0,0 +1,81 @@
+package gcpstub
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// handleServiceNetworking serves services/{service}/connections, which the
+// network module uses to peer the VPC with Cloud SQL, and operations/{name}
+func (s *Server) handleServiceNetworking(w http.ResponseWriter, r *http.Request) {
+	rel := strings.Trim(strings.TrimPrefix(r.URL.Path, ServiceNetworkingPrefix), "/")
+	parts := strings.Split(rel, "/")
+
+	switch {
+	case len(parts) == 2 && parts[0] == "operations":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"name": rel, "done": true})
+	case len(parts) >= 3 && parts[0] == "services" && strings.HasPrefix(parts[2], "connections"):
+		s.serviceNetworkingConnections(w, r, parts[1], parts[2:])
+	default:
+		writeError(w, http.StatusNotFound, "unknown servicenetworking path %s", rel)
+	}
+}
+
+// serviceNetworkingConnections stores one connection per network and service,
+// keyed by the network's project-relative path
+func (s *Server) serviceNetworkingConnections(w http.ResponseWriter, r *http.Request, service string, rest []string) {
+	collection := "servicenetworking/v1/services/" + service + "/connections"
+
+	// connections/{name}:deleteConnection and connections/-:updateConnection
+	// style verbs arrive as a single segment
+	if len(rest) == 1 && strings.Contains(rest[0], ":") || len(rest) == 2 {
+		body, err := readBody(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+			return
+		}
+		segment := rest[len(rest)-1]
+		action := segment[strings.Index(segment, ":")+1:]
+		network := stringField(body, "network")
+		if network == "" {
+			network = stringField(body, "consumerNetwork")
+		}
+		s.mu.Lock()
+		key := collection + "/" + url.PathEscape(network)
+		if action == "deleteConnection" {
+			delete(s.resources, key)
+		} else if connection, ok := s.resources[key]; ok {
+			merge(connection, body)
+		}
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"name": "operations/" + action, "done": true})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		network := r.URL.Query().Get("network")
+		s.mu.Lock()
+		connections := []interface{}{}
+		for _, path := range s.childrenLocked(collection, true) {
+			if connection := s.resources[path]; network == "" || stringField(connection, "network") == network {
+				connections = append(connections, clone(connection))
+			}
+		}
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"connections": connections})
+	case http.MethodPost:
+		body, err := readBody(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+			return
+		}
+		body["service"] = "services/" + service
+		body["peering"] = "servicenetworking-googleapis-com"
+		s.Put(collection+"/"+url.PathEscape(stringField(body, "network")), body)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"name": "operations/create-connection", "done": true})
+	default:
+		writeError(w, http.StatusNotImplemented, "gcpstub does not serve %s connections", r.Method)
+	}
+}
@@ -0,0 +1,273 @@
+package gcpstub
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// handleSQLAdmin serves projects/{project}/instances[/{name}[/{collection}[/{child}]]]
+// and projects/{project}/operations/{name}
+func (s *Server) handleSQLAdmin(w http.ResponseWriter, r *http.Request) {
+	rel := strings.Trim(strings.TrimPrefix(r.URL.Path, SQLAdminPrefix), "/")
+	parts := strings.Split(rel, "/")
+	if len(parts) < 3 || parts[0] != "projects" {
+		writeError(w, http.StatusNotFound, "unknown sqladmin path %s", rel)
+		return
+	}
+	project := parts[1]
+	base := "sql/v1beta4/projects/" + project
+
+	switch {
+	case parts[2] == "operations" && len(parts) == 4:
+		s.get(w, base+"/operations/"+parts[3])
+	case parts[2] == "tiers" && len(parts) == 3:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"kind": "sql#tiersList", "items": []interface{}{}})
+	case parts[2] != "instances":
+		writeError(w, http.StatusNotFound, "unknown sqladmin path %s", rel)
+	case len(parts) == 3 && r.Method == http.MethodGet:
+		s.sqlList(w, base+"/instances", "sql#instancesList")
+	case len(parts) == 3 && r.Method == http.MethodPost:
+		s.sqlInsertInstance(w, r, project)
+	case len(parts) == 4 && r.Method == http.MethodGet:
+		s.get(w, base+"/instances/"+parts[3])
+	case len(parts) == 4 && r.Method == http.MethodDelete:
+		s.sqlDelete(w, project, base+"/instances/"+parts[3])
+	case len(parts) == 4 && (r.Method == http.MethodPatch || r.Method == http.MethodPut):
+		s.sqlUpdate(w, r, project, base+"/instances/"+parts[3])
+	case len(parts) >= 5 && parts[4] == "users":
+		s.sqlUsers(w, r, project, base+"/instances/"+parts[3])
+	case len(parts) >= 5 && parts[4] == "databases":
+		s.sqlDatabases(w, r, project, base+"/instances/"+parts[3], parts[5:])
+	case len(parts) == 5 && r.Method == http.MethodPost:
+		// Instance verbs such as restart, failover or promoteReplica
+		s.sqlOperationResponse(w, project, parts[4], s.URL+"/"+base+"/instances/"+parts[3])
+	default:
+		writeError(w, http.StatusNotImplemented, "gcpstub does not serve %s %s", r.Method, rel)
+	}
+}
+
+func (s *Server) sqlInsertInstance(w http.ResponseWriter, r *http.Request, project string) {
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return
+	}
+	name := stringField(body, "name")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	region := stringField(body, "region")
+
+	s.mu.Lock()
+	path := "sql/v1beta4/projects/" + project + "/instances/" + name
+	if _, exists := s.resources[path]; exists {
+		s.mu.Unlock()
+		writeError(w, http.StatusConflict, "The Cloud SQL instance already exists.")
+		return
+	}
+	body["kind"] = "sql#instance"
+	body["project"] = project
+	body["selfLink"] = s.URL + "/" + path
+	body["connectionName"] = fmt.Sprintf("%s:%s:%s", project, region, name)
+	body["state"] = "RUNNABLE"
+	body["backendType"] = "SECOND_GEN"
+	body["gceZone"] = region + "-b"
+	body["createTime"] = s.timestamp()
+	body["serviceAccountEmailAddress"] = fmt.Sprintf("p%s-gcpstub@gcp-sa-cloud-sql.iam.gserviceaccount.com", ProjectNumber)
+	body["ipAddresses"] = []interface{}{
+		map[string]interface{}{"type": "PRIVATE", "ipAddress": fmt.Sprintf("10.200.%d.3", len(s.resources)%250)},
+	}
+	body["serverCaCert"] = map[string]interface{}{
+		"kind":            "sql#sslCert",
+		"cert":            "-----BEGIN CERTIFICATE-----\ngcpstub\n-----END CERTIFICATE-----\n",
+		"commonName":      "C=US,O=Google\\, Inc,CN=Google Cloud SQL Server CA",
+		"sha1Fingerprint": "gcpstub",
+		"instance":        name,
+		"createTime":      s.timestamp(),
+		"expirationTime":  "2036-01-01T00:00:00Z",
+	}
+	if settings, ok := body["settings"].(map[string]interface{}); ok {
+		settings["settingsVersion"] = "1"
+	}
+	s.resources[path] = body
+	op := s.sqlOperationLocked(project, "CREATE", body["selfLink"].(string))
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, op)
+}
+
+func (s *Server) sqlDelete(w http.ResponseWriter, project, path string) {
+	s.mu.Lock()
+	resource, ok := s.resources[path]
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "The Cloud SQL instance does not exist.")
+		return
+	}
+	for _, child := range s.childrenLocked(path, false) {
+		delete(s.resources, child)
+	}
+	delete(s.resources, path)
+	op := s.sqlOperationLocked(project, "DELETE", stringField(resource, "selfLink"))
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, op)
+}
+
+func (s *Server) sqlUpdate(w http.ResponseWriter, r *http.Request, project, path string) {
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return
+	}
+	s.mu.Lock()
+	resource, ok := s.resources[path]
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "The Cloud SQL instance does not exist.")
+		return
+	}
+	merge(resource, body)
+	op := s.sqlOperationLocked(project, "UPDATE", stringField(resource, "selfLink"))
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, op)
+}
+
+// sqlUsers handles the users collection, which is keyed by the name query
+// parameter rather than a path segment
+func (s *Server) sqlUsers(w http.ResponseWriter, r *http.Request, project, instancePath string) {
+	if _, ok := s.Get(instancePath); !ok {
+		writeError(w, http.StatusNotFound, "The Cloud SQL instance does not exist.")
+		return
+	}
+	collection := instancePath + "/users"
+	instance := instancePath[strings.LastIndex(instancePath, "/")+1:]
+
+	switch r.Method {
+	case http.MethodGet:
+		s.sqlList(w, collection, "sql#usersList")
+	case http.MethodPost:
+		body, err := readBody(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+			return
+		}
+		body["kind"] = "sql#user"
+		body["project"] = project
+		body["instance"] = instance
+		// Passwords are write-only in the real API
+		delete(body, "password")
+		s.Put(collection+"/"+stringField(body, "name"), body)
+		s.sqlOperationResponse(w, project, "CREATE_USER", s.URL+"/"+instancePath)
+	case http.MethodPut:
+		name := r.URL.Query().Get("name")
+		body, err := readBody(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+			return
+		}
+		delete(body, "password")
+		s.mu.Lock()
+		if user, ok := s.resources[collection+"/"+name]; ok {
+			merge(user, body)
+		}
+		s.mu.Unlock()
+		s.sqlOperationResponse(w, project, "UPDATE_USER", s.URL+"/"+instancePath)
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(s.resources, collection+"/"+r.URL.Query().Get("name"))
+		s.mu.Unlock()
+		s.sqlOperationResponse(w, project, "DELETE_USER", s.URL+"/"+instancePath)
+	default:
+		writeError(w, http.StatusNotImplemented, "gcpstub does not serve %s users", r.Method)
+	}
+}
+
+func (s *Server) sqlDatabases(w http.ResponseWriter, r *http.Request, project, instancePath string, rest []string) {
+	if _, ok := s.Get(instancePath); !ok {
+		writeError(w, http.StatusNotFound, "The Cloud SQL instance does not exist.")
+		return
+	}
+	collection := instancePath + "/databases"
+	instance := instancePath[strings.LastIndex(instancePath, "/")+1:]
+
+	switch {
+	case len(rest) == 0 && r.Method == http.MethodGet:
+		s.sqlList(w, collection, "sql#databasesList")
+	case len(rest) == 0 && r.Method == http.MethodPost:
+		body, err := readBody(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+			return
+		}
+		path := collection + "/" + stringField(body, "name")
+		body["kind"] = "sql#database"
+		body["project"] = project
+		body["instance"] = instance
+		body["selfLink"] = s.URL + "/" + path
+		s.Put(path, body)
+		s.sqlOperationResponse(w, project, "CREATE_DATABASE", s.URL+"/"+instancePath)
+	case len(rest) == 1 && r.Method == http.MethodGet:
+		s.get(w, collection+"/"+rest[0])
+	case len(rest) == 1 && r.Method == http.MethodDelete:
+		s.mu.Lock()
+		delete(s.resources, collection+"/"+rest[0])
+		s.mu.Unlock()
+		s.sqlOperationResponse(w, project, "DELETE_DATABASE", s.URL+"/"+instancePath)
+	case len(rest) == 1 && (r.Method == http.MethodPatch || r.Method == http.MethodPut):
+		body, err := readBody(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: %v", err)
+			return
+		}
+		s.mu.Lock()
+		if database, ok := s.resources[collection+"/"+rest[0]]; ok {
+			merge(database, body)
+		}
+		s.mu.Unlock()
+		s.sqlOperationResponse(w, project, "UPDATE_DATABASE", s.URL+"/"+instancePath)
+	default:
+		writeError(w, http.StatusNotImplemented, "gcpstub does not serve %s databases", r.Method)
+	}
+}
+
+func (s *Server) sqlOperationResponse(w http.ResponseWriter, project, operationType, targetLink string) {
+	s.mu.Lock()
+	op := s.sqlOperationLocked(project, operationType, targetLink)
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, op)
+}
+
+// sqlOperationLocked records and returns an operation that is already DONE
+func (s *Server) sqlOperationLocked(project, operationType, targetLink string) map[string]interface{} {
+	name := "operation-" + s.newIDLocked()
+	path := "sql/v1beta4/projects/" + project + "/operations/" + name
+	op := map[string]interface{}{
+		"kind":          "sql#operation",
+		"name":          name,
+		"operationType": operationType,
+		"status":        "DONE",
+		"targetLink":    targetLink,
+		"targetProject": project,
+		"insertTime":    s.timestamp(),
+		"endTime":       s.timestamp(),
+		"selfLink":      s.URL + "/" + path,
+	}
+	s.resources[path] = op
+	return op
+}
+
+// sqlList writes the direct children of collection in the sqladmin list format
+func (s *Server) sqlList(w http.ResponseWriter, collection, kind string) {
+	s.mu.Lock()
+	items := []interface{}{}
+	for _, path := range s.childrenLocked(collection, true) {
+		items = append(items, clone(s.resources[path]))
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"kind": kind, "items": items})
+}
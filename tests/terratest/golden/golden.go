@@ -0,0 +1,271 @@
+// Package golden snapshots planned values to testdata/golden/*.json and
+// compares later plans against them with a structural diff, so reviewers see
+// exactly which planned attributes a module change moves.
+package golden
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/gruntwork-io/terratest/modules/testing"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/require"
+)
+
+// Dir is where snapshots are stored, relative to the test's working directory
+const Dir = "testdata/golden"
+
+// VolatileAttributes are dropped from every resource because they change
+// between runs or provider versions without any change to the modules
+var VolatileAttributes = map[string]bool{
+	"creation_timestamp": true,
+	"effective_labels":   true,
+	"etag":               true,
+	"fingerprint":        true,
+	"id":                 true,
+	"label_fingerprint":  true,
+	"self_link":          true,
+	"terraform_labels":   true,
+	"timeouts":           true,
+	"unique_id":          true,
+}
+
+// Snapshot is the serialized form of a plan
+type Snapshot struct {
+	Resources map[string]Resource    `json:"resources"`
+	Outputs   map[string]interface{} `json:"outputs,omitempty"`
+}
+
+// Resource is one planned managed resource
+type Resource struct {
+	Type   string                 `json:"type"`
+	Values map[string]interface{} `json:"values"`
+}
+
+// FromPlan builds a snapshot of the planned managed resources and root outputs
+// with volatile attributes stripped. Values unknown until apply are absent
+// from planned values and so never appear in the snapshot.
+func FromPlan(plan *terraform.PlanStruct) (*Snapshot, error) {
+	snapshot := &Snapshot{Resources: map[string]Resource{}, Outputs: map[string]interface{}{}}
+	for address, resource := range plan.ResourcePlannedValuesMap {
+		if resource.Mode == tfjson.DataResourceMode {
+			continue
+		}
+		values := map[string]interface{}{}
+		for name, value := range resource.AttributeValues {
+			if !VolatileAttributes[name] {
+				values[name] = value
+			}
+		}
+		snapshot.Resources[address] = Resource{Type: resource.Type, Values: values}
+	}
+	if plan.RawPlan.PlannedValues != nil {
+		for name, output := range plan.RawPlan.PlannedValues.Outputs {
+			if output.Sensitive {
+				snapshot.Outputs[name] = "(sensitive)"
+				continue
+			}
+			snapshot.Outputs[name] = output.Value
+		}
+	}
+	return snapshot.normalize()
+}
+
+// normalize round-trips through JSON so in-memory snapshots compare equal to
+// ones read from disk
+func (s *Snapshot) normalize() (*Snapshot, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	out := &Snapshot{}
+	return out, json.Unmarshal(data, out)
+}
+
+// Marshal renders the snapshot as indented JSON with sorted keys
+func (s *Snapshot) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(s); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Path returns the snapshot file for name
+func Path(name string) string {
+	return filepath.Join(Dir, name+".json")
+}
+
+// Read loads the snapshot stored for name
+func Read(name string) (*Snapshot, error) {
+	data, err := os.ReadFile(Path(name))
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", Path(name), err)
+	}
+	return snapshot, nil
+}
+
+// Write stores the snapshot for name
+func Write(name string, snapshot *Snapshot) error {
+	data, err := snapshot.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(Dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(Path(name), data, 0o644)
+}
+
+// CompareE compares the plan with the snapshot stored for name, or rewrites
+// the snapshot when update is set. Callers pass their own -update test flag.
+func CompareE(name string, plan *terraform.PlanStruct, update bool) error {
+	got, err := FromPlan(plan)
+	if err != nil {
+		return err
+	}
+	if update {
+		return Write(name, got)
+	}
+
+	want, err := Read(name)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no golden snapshot at %s, run the test with -update to create it", Path(name))
+	}
+	if err != nil {
+		return err
+	}
+	if diff := Diff(want, got); len(diff) > 0 {
+		return fmt.Errorf("plan differs from %s (run with -update to accept):\n%s", Path(name), strings.Join(diff, "\n"))
+	}
+	return nil
+}
+
+// Compare fails the test if the plan differs from the snapshot stored for
+// name, or rewrites the snapshot when update is set
+func Compare(t testing.TestingT, name string, plan *terraform.PlanStruct, update bool) {
+	require.NoError(t, CompareE(name, plan, update))
+}
+
+// Diff lists the differences between two snapshots, one line per changed
+// value. Lines start with "+" for additions, "-" for removals and "~" for
+// changes, followed by the resource address and attribute path.
+func Diff(want, got *Snapshot) []string {
+	var lines []string
+	for _, address := range keys(want.Resources, got.Resources) {
+		before, inWant := want.Resources[address]
+		after, inGot := got.Resources[address]
+		switch {
+		case !inGot:
+			lines = append(lines, fmt.Sprintf("- %s (%s)", address, before.Type))
+		case !inWant:
+			lines = append(lines, fmt.Sprintf("+ %s (%s)", address, after.Type))
+		default:
+			lines = append(lines, diffValue(address, toInterface(before.Values), toInterface(after.Values))...)
+		}
+	}
+	for _, name := range keys(want.Outputs, got.Outputs) {
+		before, inWant := want.Outputs[name]
+		after, inGot := got.Outputs[name]
+		switch {
+		case !inGot:
+			lines = append(lines, fmt.Sprintf("- output.%s", name))
+		case !inWant:
+			lines = append(lines, fmt.Sprintf("+ output.%s = %s", name, render(after)))
+		default:
+			lines = append(lines, diffValue("output."+name, before, after)...)
+		}
+	}
+	return lines
+}
+
+func diffValue(path string, before, after interface{}) []string {
+	switch b := before.(type) {
+	case map[string]interface{}:
+		a, ok := after.(map[string]interface{})
+		if !ok {
+			break
+		}
+		var lines []string
+		for _, key := range keys(b, a) {
+			child := path + "." + key
+			bv, inBefore := b[key]
+			av, inAfter := a[key]
+			switch {
+			case !inAfter:
+				lines = append(lines, fmt.Sprintf("- %s = %s", child, render(bv)))
+			case !inBefore:
+				lines = append(lines, fmt.Sprintf("+ %s = %s", child, render(av)))
+			default:
+				lines = append(lines, diffValue(child, bv, av)...)
+			}
+		}
+		return lines
+	case []interface{}:
+		a, ok := after.([]interface{})
+		if !ok {
+			break
+		}
+		var lines []string
+		for i := 0; i < len(b) || i < len(a); i++ {
+			child := fmt.Sprintf("%s.%d", path, i)
+			switch {
+			case i >= len(a):
+				lines = append(lines, fmt.Sprintf("- %s = %s", child, render(b[i])))
+			case i >= len(b):
+				lines = append(lines, fmt.Sprintf("+ %s = %s", child, render(a[i])))
+			default:
+				lines = append(lines, diffValue(child, b[i], a[i])...)
+			}
+		}
+		return lines
+	}
+	if render(before) == render(after) {
+		return nil
+	}
+	return []string{fmt.Sprintf("~ %s: %s => %s", path, render(before), render(after))}
+}
+
+func render(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+func toInterface(values map[string]interface{}) interface{} {
+	if values == nil {
+		return map[string]interface{}{}
+	}
+	return values
+}
+
+// keys returns the union of the keys of both maps in sorted order
+func keys[V any](a, b map[string]V) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, m := range []map[string]V{a, b} {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				out = append(out, key)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,69 @@
+package golden
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+const firewallAddress = "module.network.google_compute_firewall.allow_ssh"
+
+var update = flag.Bool("update", false, "regenerate the sample snapshot instead of comparing")
+
+// TestCompare tests the sample plan matches its stored snapshot
+func TestCompare(t *testing.T) {
+	t.Parallel()
+
+	Compare(t, "sample", planassert.LoadPlanFile(t, "testdata/plan.json"), *update)
+}
+
+// TestFromPlanStripsVolatile tests volatile attributes never reach the snapshot
+func TestFromPlanStripsVolatile(t *testing.T) {
+	t.Parallel()
+
+	snapshot, err := FromPlan(planassert.LoadPlanFile(t, "testdata/plan.json"))
+	require.NoError(t, err)
+
+	subnet := snapshot.Resources[`module.network.google_compute_subnetwork.subnets["test-vpc-public"]`]
+	assert.Equal(t, "10.0.1.0/24", subnet.Values["ip_cidr_range"])
+	assert.NotContains(t, subnet.Values, "id")
+	assert.NotContains(t, subnet.Values, "creation_timestamp")
+	assert.Equal(t, "test-vpc", snapshot.Outputs["vpc_name"])
+}
+
+// TestDiff tests changed, added and removed values are reported by path
+func TestDiff(t *testing.T) {
+	t.Parallel()
+
+	want, err := FromPlan(planassert.LoadPlanFile(t, "testdata/plan.json"))
+	require.NoError(t, err)
+	got, err := FromPlan(planassert.LoadPlanFile(t, "testdata/plan.json"))
+	require.NoError(t, err)
+	assert.Empty(t, Diff(want, got))
+
+	got.Resources[firewallAddress].Values["source_ranges"] = []interface{}{"0.0.0.0/0", "10.0.0.0/8"}
+	got.Resources[firewallAddress].Values["description"] = "ssh"
+	delete(got.Resources, "module.cloudsql.google_sql_database_instance.instance")
+	got.Outputs["vpc_name"] = "prod-vpc"
+
+	assert.Equal(t, []string{
+		"- module.cloudsql.google_sql_database_instance.instance (google_sql_database_instance)",
+		`+ module.network.google_compute_firewall.allow_ssh.description = "ssh"`,
+		`~ module.network.google_compute_firewall.allow_ssh.source_ranges.0: "35.235.240.0/20" => "0.0.0.0/0"`,
+		`+ module.network.google_compute_firewall.allow_ssh.source_ranges.1 = "10.0.0.0/8"`,
+		`~ output.vpc_name: "test-vpc" => "prod-vpc"`,
+	}, Diff(want, got))
+}
+
+// TestCompareMissing tests a missing snapshot points at -update
+func TestCompareMissing(t *testing.T) {
+	t.Parallel()
+
+	err := CompareE("does-not-exist", planassert.LoadPlanFile(t, "testdata/plan.json"), false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "-update")
+}
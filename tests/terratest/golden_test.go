@@ -0,0 +1,113 @@
+package test
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/golden"
+	"github.com/unicredit/gcp-migration/tests/terratest/tier"
+)
+
+var updateGolden = flag.Bool("update", false, "regenerate golden plan snapshots instead of comparing")
+
+// TestGoldenPlans compares each fixture/var-set plan with its snapshot in
+// testdata/golden. Run with -update to regenerate after an intended change.
+func TestGoldenPlans(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		fixture string
+		vars    map[string]interface{}
+	}{
+		{
+			name:    "network-default",
+			fixture: "network",
+			vars:    map[string]interface{}{},
+		},
+		{
+			name:    "compute-linux",
+			fixture: "compute",
+			vars: map[string]interface{}{
+				"instance_name": "golden-linux",
+				"instance_type": "linux",
+			},
+		},
+		{
+			name:    "compute-windows",
+			fixture: "compute",
+			vars: map[string]interface{}{
+				"instance_name": "golden-windows",
+				"instance_type": "windows",
+			},
+		},
+		{
+			name:    "cloudsql-postgres",
+			fixture: "cloudsql",
+			vars: map[string]interface{}{
+				"instance_name":    "golden-postgres",
+				"database_type":    "postgresql",
+				"database_version": "POSTGRES_15",
+				"tier":             "db-custom-2-4096",
+			},
+		},
+		{
+			name:    "cloudsql-sqlserver-ha",
+			fixture: "cloudsql",
+			vars: map[string]interface{}{
+				"instance_name":     "golden-sqlserver",
+				"database_type":     "sqlserver",
+				"database_version":  "SQLSERVER_2019_STANDARD",
+				"tier":              "db-custom-2-4096",
+				"high_availability": true,
+			},
+		},
+		{
+			name:    "iam-default",
+			fixture: "iam",
+			vars:    map[string]interface{}{},
+		},
+		{
+			name:    "load-balancer-https",
+			fixture: "load-balancer",
+			vars: map[string]interface{}{
+				"name":         "golden-lb",
+				"enable_https": true,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			vars := map[string]interface{}{
+				"project_id": "test-project",
+			}
+			if tc.fixture != "iam" {
+				vars["region"] = "europe-west1"
+			}
+			// The network module has no environment input
+			if tc.fixture != "network" {
+				vars["environment"] = "test"
+			}
+			for name, value := range tc.vars {
+				vars[name] = value
+			}
+
+			// Options are built directly rather than with fixtureOptions so
+			// names stay stable between runs
+			terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
+				TerraformDir: fixtureDir(t, tc.fixture),
+				Vars:         vars,
+				NoColor:      true,
+			})
+
+			golden.Compare(t, tc.name, planWithStruct(t, terraformOptions), *updateGolden)
+		})
+	}
+}
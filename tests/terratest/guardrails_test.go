@@ -0,0 +1,36 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/policy"
+	"github.com/unicredit/gcp-migration/tests/terratest/tier"
+)
+
+// TestFixtureGuardrails plans every fixture with its defaults and checks the
+// plan has no high or critical policy findings
+func TestFixtureGuardrails(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	for _, fixture := range []string{"network", "compute", "cloudsql", "iam", "load-balancer"} {
+		fixture := fixture
+		t.Run(fixture, func(t *testing.T) {
+			t.Parallel()
+
+			vars := map[string]interface{}{
+				"project_id": testProject,
+			}
+			if fixture != "iam" {
+				vars["region"] = "europe-west1"
+			}
+			// The network module has no environment input
+			if fixture != "network" {
+				vars["environment"] = "test"
+			}
+			plan := planWithStruct(t, fixtureOptions(t, fixture, vars))
+
+			policy.Passes(t, plan, policy.High)
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/files"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/gcpstub"
+	"github.com/unicredit/gcp-migration/tests/terratest/naming"
+	"github.com/unicredit/gcp-migration/tests/terratest/tier"
+)
+
+// repoRoot is the repository root relative to this package. Working copies
+// are taken from here so relative module sources keep resolving.
+const repoRoot = "../.."
+
+// pluginCacheEnv is terraform's provider plugin cache setting
+const pluginCacheEnv = "TF_PLUGIN_CACHE_DIR"
+
+// initMu serializes terraform init, since concurrent writers to the shared
+// plugin cache are not safe
+var initMu sync.Mutex
+
+// workingCopy copies dir, a path relative to the repository root, and the
+// modules it sources into a temp dir laid out like the repository, and returns
+// the copy of dir. Each test gets its own .terraform, lock file and state. The
+// copy is removed unless the test fails.
+func workingCopy(t *testing.T, dir string) string {
+	// The temp root is created here rather than by test_structure, which hands
+	// back the original directory when a SKIP_* stage variable is set
+	root, err := os.MkdirTemp("", "terratest-")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("keeping working copy %s", root)
+			return
+		}
+		os.RemoveAll(root)
+	})
+
+	trees := []string{sharedModules}
+	if !strings.HasPrefix(dir, sharedModules+"/") {
+		trees = append(trees, dir)
+	}
+	for _, tree := range trees {
+		destination := filepath.Join(root, filepath.FromSlash(tree))
+		require.NoError(t, os.MkdirAll(destination, 0o755))
+		require.NoError(t, files.CopyFolderContentsWithFilter(filepath.Join(repoRoot, filepath.FromSlash(tree)), destination, terraformSource))
+	}
+	return filepath.Join(root, filepath.FromSlash(dir))
+}
+
+// sharedModules is the directory fixtures and environments source modules from
+const sharedModules = "terraform/modules"
+
+// terraformSource leaves hidden files, state and tfvars out of working copies,
+// keeping the lock and version files terraform reads
+func terraformSource(path string) bool {
+	if files.PathIsTerraformLockFile(path) || files.PathIsTerraformVersionFile(path) {
+		return true
+	}
+	return !files.PathContainsHiddenFileOrFolder(path) && !files.PathContainsTerraformStateOrVars(path)
+}
+
+// fixtureDir returns an isolated working copy of ./fixtures/<name>
+func fixtureDir(t *testing.T, name string) string {
+	return workingCopy(t, "tests/terratest/fixtures/"+name)
+}
+
+// moduleDir returns an isolated working copy of terraform/modules/<name>
+func moduleDir(t *testing.T, name string) string {
+	return workingCopy(t, "terraform/modules/"+name)
+}
+
+// fixtureNaming describes the variable each fixture derives its resource
+// names from, the fixture's default for it and the longest suffix the called
+// module appends
+var fixtureNaming = map[string]struct {
+	variable string
+	base     string
+	rule     naming.Rule
+	labelled bool
+}{
+	"network": {"vpc_name", "test-vpc", naming.GCE.Reserve(len("-router-northamerica-northeast2")), false},
+	// Instance templates use name_prefix, which the provider extends by 26 characters
+	"compute":       {"instance_name", "test-instance", naming.GCE.Reserve(len("-") + 26), true},
+	"cloudsql":      {"instance_name", "test-db", naming.CloudSQLInstance(testProject).Reserve(len("-replica")), true},
+	"load-balancer": {"name", "test-lb", naming.GCE.Reserve(len("-https-forwarding")), false},
+	"iam":           {"service_accounts", "test-sa", naming.ServiceAccount, false},
+}
+
+// testProject is the project every fixture plans against
+const testProject = "test-project"
+
+// fixtureName returns the unique name the test's fixture gets for base
+func fixtureName(t *testing.T, fixture, base string) string {
+	return naming.Name(t, base, fixtureNaming[fixture].rule)
+}
+
+// uniqueNames replaces the fixture's name variable with a name unique to this
+// test and run, and sets test_run_id on fixtures that label their resources.
+// Service account IDs are renamed individually, including in role_bindings.
+func uniqueNames(t *testing.T, fixture string, vars map[string]interface{}) map[string]interface{} {
+	spec, ok := fixtureNaming[fixture]
+	if !ok {
+		return vars
+	}
+	if spec.labelled {
+		vars["test_run_id"] = naming.RunID()
+	}
+	if fixture == "iam" {
+		renameServiceAccounts(t, vars)
+		return vars
+	}
+
+	base, _ := vars[spec.variable].(string)
+	if base == "" {
+		base = spec.base
+	}
+	vars[spec.variable] = fixtureName(t, fixture, base)
+	return vars
+}
+
+// renameServiceAccounts gives every service account a unique ID, falling back
+// to the fixture's default account
+func renameServiceAccounts(t *testing.T, vars map[string]interface{}) {
+	accounts, ok := vars["service_accounts"].([]map[string]interface{})
+	if !ok {
+		accounts = []map[string]interface{}{
+			{
+				"account_id":   "test-sa",
+				"display_name": "Test Service Account",
+				"description":  "Service account created by the IAM fixture",
+			},
+		}
+	}
+
+	renamed := map[string]string{}
+	for _, account := range accounts {
+		id := account["account_id"].(string)
+		renamed[id] = fixtureName(t, "iam", id)
+		account["account_id"] = renamed[id]
+	}
+	vars["service_accounts"] = accounts
+
+	bindings, _ := vars["role_bindings"].([]map[string]interface{})
+	for _, binding := range bindings {
+		members, _ := binding["members"].([]string)
+		for i, member := range members {
+			for old, id := range renamed {
+				members[i] = strings.Replace(member, "serviceAccount:"+old+"@", "serviceAccount:"+id+"@", 1)
+				member = members[i]
+			}
+		}
+	}
+}
+
+// fixtureOptions returns options for ./fixtures/<fixture> in an isolated
+// working copy, with the fixture's resource names made unique
+func fixtureOptions(t *testing.T, fixture string, vars map[string]interface{}) *terraform.Options {
+	return terraform.WithDefaultRetryableErrors(t, &terraform.Options{
+		TerraformDir: fixtureDir(t, fixture),
+		Vars:         uniqueNames(t, fixture, vars),
+		NoColor:      true,
+	})
+}
+
+// pluginCacheDir returns the provider cache shared by every working copy,
+// honouring TF_PLUGIN_CACHE_DIR when it is already set
+func pluginCacheDir(t *testing.T) string {
+	dir := os.Getenv(pluginCacheEnv)
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		require.NoError(t, err)
+		dir = filepath.Join(cache, "terratest", "plugin-cache")
+	}
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	return dir
+}
+
+// initE runs terraform init with the shared plugin cache
+func initE(t *testing.T, terraformOptions *terraform.Options) error {
+	if terraformOptions.EnvVars == nil {
+		terraformOptions.EnvVars = map[string]string{}
+	}
+	if terraformOptions.EnvVars[pluginCacheEnv] == "" {
+		terraformOptions.EnvVars[pluginCacheEnv] = pluginCacheDir(t)
+	}
+
+	initMu.Lock()
+	defer initMu.Unlock()
+	_, err := terraform.InitE(t, terraformOptions)
+	return err
+}
+
+// useStandIn points the google provider at a per-test gcpstub server when
+// TERRATEST_OFFLINE is set and returns it, or nil when running online.
+// Explicit EnvVars on the options win.
+func useStandIn(t *testing.T, terraformOptions *terraform.Options) *gcpstub.Server {
+	if !tier.Offline() {
+		return nil
+	}
+	stub := gcpstub.Start(t)
+	env := stub.EnvVars()
+	for name, value := range terraformOptions.EnvVars {
+		env[name] = value
+	}
+	terraformOptions.EnvVars = env
+	return stub
+}
+
+// validate runs init and validate
+func validate(t *testing.T, terraformOptions *terraform.Options) {
+	require.NoError(t, initE(t, terraformOptions))
+	terraform.Validate(t, terraformOptions)
+}
+
+// planWithStruct runs init, plan and show and returns the parsed plan. The
+// plan file goes to a per-test temp dir unless PlanFilePath is already set.
+func planWithStruct(t *testing.T, terraformOptions *terraform.Options) *terraform.PlanStruct {
+	useStandIn(t, terraformOptions)
+	if terraformOptions.PlanFilePath == "" {
+		terraformOptions.PlanFilePath = filepath.Join(t.TempDir(), "plan.out")
+	}
+	require.NoError(t, initE(t, terraformOptions))
+	terraform.Plan(t, terraformOptions)
+	return terraform.ShowWithStruct(t, terraformOptions)
+}
+
+// apply runs init and apply and destroys when the test finishes, including
+// after a failed apply. Against the stand-in it also checks that destroy left
+// nothing behind; the stand-in is returned for further checks.
+func apply(t *testing.T, terraformOptions *terraform.Options) *gcpstub.Server {
+	stub := useStandIn(t, terraformOptions)
+	require.NoError(t, initE(t, terraformOptions))
+	t.Cleanup(func() {
+		terraform.Destroy(t, terraformOptions)
+		if stub != nil {
+			assert.Empty(t, stub.Resources(), "resources left in the stand-in after destroy")
+		}
+	})
+	terraform.Apply(t, terraformOptions)
+	return stub
+}
+
+// planE runs init and plan and returns the error, for cases expected to fail
+func planE(t *testing.T, terraformOptions *terraform.Options) error {
+	useStandIn(t, terraformOptions)
+	if err := initE(t, terraformOptions); err != nil {
+		return err
+	}
+	_, err := terraform.PlanE(t, terraformOptions)
+	return err
+}
+
+// TestWorkingCopy tests a working copy holds the directory and the shared
+// modules in a temp dir of its own, so removing it cannot reach the repository
+func TestWorkingCopy(t *testing.T) {
+	tier.Require(t, tier.Static)
+	t.Parallel()
+
+	dir := workingCopy(t, "tests/terratest/fixtures/network")
+
+	root := filepath.Clean(filepath.Join(dir, "../../../.."))
+	assert.True(t, strings.HasPrefix(root, filepath.Clean(os.TempDir())+string(filepath.Separator)), root)
+	assert.FileExists(t, filepath.Join(dir, "main.tf"))
+	assert.FileExists(t, filepath.Join(root, "terraform/modules/network/main.tf"))
+	assert.NoDirExists(t, filepath.Join(root, "terraform/environments"))
+	assert.NoDirExists(t, filepath.Join(root, "tests/terratest/planassert"))
+}
@@ -1,18 +1,39 @@
 package test
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+	"github.com/unicredit/gcp-migration/tests/terratest/policy"
+	"github.com/unicredit/gcp-migration/tests/terratest/tier"
 )
 
+// roleBindingAddress returns the planned address of a role_bindings grant
+func roleBindingAddress(role, member string) string {
+	return fmt.Sprintf(`module.iam.google_project_iam_member.role_bindings["%s-%s"]`, role, member)
+}
+
+// serviceAccountMember returns the IAM member of a service account in the test project
+func serviceAccountMember(accountID string) string {
+	return "serviceAccount:" + accountID + "@test-project.iam.gserviceaccount.com"
+}
+
+// serviceAccountAddress returns the planned address of a service account
+func serviceAccountAddress(accountID string) string {
+	return fmt.Sprintf(`module.iam.google_service_account.service_accounts["%s"]`, accountID)
+}
+
 // TestIAMModuleValidation validates the IAM module configuration
 func TestIAMModuleValidation(t *testing.T) {
+	tier.Require(t, tier.Validate)
 	t.Parallel()
 
 	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "../../terraform/modules/iam",
+		TerraformDir: moduleDir(t, "iam"),
 		Vars: map[string]interface{}{
 			"project_id":  "test-project",
 			"environment": "test",
@@ -20,154 +41,176 @@ func TestIAMModuleValidation(t *testing.T) {
 		NoColor: true,
 	})
 
-	terraform.Validate(t, terraformOptions)
+	validate(t, terraformOptions)
 }
 
 // TestIAMServiceAccountCreation tests service account creation
 func TestIAMServiceAccountCreation(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/iam",
-		Vars: map[string]interface{}{
-			"project_id":  "test-project",
-			"environment": "test",
-			"service_accounts": []map[string]interface{}{
-				{
-					"account_id":   "app-a-sa",
-					"display_name": "App A Service Account",
-					"description":  "Service account for App A",
-				},
-				{
-					"account_id":   "app-b-sa",
-					"display_name": "App B Service Account",
-					"description":  "Service account for App B",
-				},
+	terraformOptions := fixtureOptions(t, "iam", map[string]interface{}{
+		"project_id":  "test-project",
+		"environment": "test",
+		"service_accounts": []map[string]interface{}{
+			{
+				"account_id":   "app-a-sa",
+				"display_name": "App A Service Account",
+				"description":  "Service account for App A",
+			},
+			{
+				"account_id":   "app-b-sa",
+				"display_name": "App B Service Account",
+				"description":  "Service account for App B",
 			},
 		},
-		NoColor: true,
 	})
 
-	terraform.Init(t, terraformOptions)
-	planOutput := terraform.Plan(t, terraformOptions)
+	plan := planWithStruct(t, terraformOptions)
+	appA := fixtureName(t, "iam", "app-a-sa")
+	appB := fixtureName(t, "iam", "app-b-sa")
 
 	// Verify service accounts are created
-	assert.Contains(t, planOutput, "google_service_account")
-	assert.Contains(t, planOutput, "app-a-sa")
-	assert.Contains(t, planOutput, "app-b-sa")
+	planassert.ResourceCount(t, plan, "google_service_account", 2)
+	planassert.AttributeEquals(t, plan, serviceAccountAddress(appA), "account_id", appA)
+	planassert.AttributeEquals(t, plan, serviceAccountAddress(appB), "display_name", "App B Service Account")
 }
 
 // TestIAMRoleBindings tests IAM role bindings
 func TestIAMRoleBindings(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/iam",
-		Vars: map[string]interface{}{
-			"project_id":  "test-project",
-			"environment": "test",
-			"role_bindings": []map[string]interface{}{
-				{
-					"role":    "roles/compute.instanceAdmin.v1",
-					"members": []string{"serviceAccount:app-a-sa@test-project.iam.gserviceaccount.com"},
-				},
-				{
-					"role":    "roles/cloudsql.client",
-					"members": []string{"serviceAccount:app-a-sa@test-project.iam.gserviceaccount.com"},
-				},
+	terraformOptions := fixtureOptions(t, "iam", map[string]interface{}{
+		"project_id":  "test-project",
+		"environment": "test",
+		"service_accounts": []map[string]interface{}{
+			{
+				"account_id":   "app-a-sa",
+				"display_name": "App A Service Account",
+				"description":  "Service account for App A",
+			},
+		},
+		"role_bindings": []map[string]interface{}{
+			{
+				"role":    "roles/compute.instanceAdmin.v1",
+				"members": []string{"serviceAccount:app-a-sa@test-project.iam.gserviceaccount.com"},
+			},
+			{
+				"role":    "roles/cloudsql.client",
+				"members": []string{"serviceAccount:app-a-sa@test-project.iam.gserviceaccount.com"},
 			},
 		},
-		NoColor: true,
 	})
 
-	terraform.Init(t, terraformOptions)
-	planOutput := terraform.Plan(t, terraformOptions)
+	plan := planWithStruct(t, terraformOptions)
+	member := serviceAccountMember(fixtureName(t, "iam", "app-a-sa"))
 
 	// Verify role bindings
-	assert.Contains(t, planOutput, "google_project_iam_member")
+	planassert.ResourceCount(t, plan, "google_project_iam_member", 2)
+	planassert.AttributeEquals(t, plan, roleBindingAddress("roles/compute.instanceAdmin.v1", member), "member", member)
+	planassert.AttributeEquals(t, plan, roleBindingAddress("roles/cloudsql.client", member), "role", "roles/cloudsql.client")
 }
 
-// TestIAMNoPublicAccess tests that no public access is granted
+// TestIAMNoPublicAccess tests that the public access guardrail passes named
+// principals and catches allUsers and allAuthenticatedUsers
 func TestIAMNoPublicAccess(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	// This test validates that allUsers and allAuthenticatedUsers are not used
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/iam",
-		Vars: map[string]interface{}{
-			"project_id":  "test-project",
-			"environment": "test",
-			"role_bindings": []map[string]interface{}{
-				{
-					"role":    "roles/viewer",
-					"members": []string{"user:admin@unicredit.example.com"},
+	testCases := []struct {
+		name    string
+		member  string
+		finding bool
+	}{
+		{"user", "user:admin@unicredit.example.com", false},
+		{"all_users", "allUsers", true},
+		{"all_authenticated_users", "allAuthenticatedUsers", true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			terraformOptions := fixtureOptions(t, "iam", map[string]interface{}{
+				"project_id":  "test-project",
+				"environment": "test",
+				"role_bindings": []map[string]interface{}{
+					{
+						"role":    "roles/viewer",
+						"members": []string{tc.member},
+					},
 				},
-			},
-		},
-		NoColor: true,
-	})
+			})
 
-	terraform.Init(t, terraformOptions)
-	planOutput := terraform.Plan(t, terraformOptions)
+			plan := planWithStruct(t, terraformOptions)
 
-	// Verify no public access principals
-	assert.NotContains(t, planOutput, "allUsers")
-	assert.NotContains(t, planOutput, "allAuthenticatedUsers")
+			// The binding reaches the plan unchanged
+			planassert.AttributeEquals(t, plan, roleBindingAddress("roles/viewer", tc.member), "member", tc.member)
+			err := policy.NoFindingsE(plan, "iam-no-public-principals")
+			if tc.finding {
+				assert.ErrorContains(t, err, "iam-no-public-principals: "+roleBindingAddress("roles/viewer", tc.member)+": roles/viewer granted to "+tc.member)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
 }
 
 // TestIAMWorkloadIdentity tests Workload Identity configuration
 func TestIAMWorkloadIdentity(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/iam",
-		Vars: map[string]interface{}{
-			"project_id":               "test-project",
-			"environment":              "test",
-			"enable_workload_identity": true,
-			"workload_identity_config": map[string]interface{}{
-				"namespace":           "default",
-				"service_account":     "app-sa",
-				"gcp_service_account": "app-sa@test-project.iam.gserviceaccount.com",
-			},
+	terraformOptions := fixtureOptions(t, "iam", map[string]interface{}{
+		"project_id":               "test-project",
+		"environment":              "test",
+		"enable_workload_identity": true,
+		"workload_identity_config": map[string]interface{}{
+			"namespace":           "default",
+			"service_account":     "app-sa",
+			"gcp_service_account": "app-sa@test-project.iam.gserviceaccount.com",
 		},
-		NoColor: true,
 	})
 
-	terraform.Init(t, terraformOptions)
-	planOutput := terraform.Plan(t, terraformOptions)
+	plan := planWithStruct(t, terraformOptions)
 
-	// Verify Workload Identity binding
-	assert.Contains(t, planOutput, "google_service_account_iam_member")
+	// Verify the Workload Identity pool
+	planassert.AttributeEquals(t, plan, "module.iam.google_iam_workload_identity_pool.pool[0]", "workload_identity_pool_id", "default-pool")
 }
 
-// TestIAMLeastPrivilege tests least privilege principle
+// TestIAMLeastPrivilege tests the module grants service accounts exactly the
+// roles bound to them and the guardrail rejects basic roles
 func TestIAMLeastPrivilege(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	// Define expected roles for each service account type
 	testCases := []struct {
-		name          string
-		accountType   string
-		expectedRoles []string
+		name      string
+		roles     []string
+		basicRole string
 	}{
 		{
-			name:        "app_service_account",
-			accountType: "application",
-			expectedRoles: []string{
+			name: "app_service_account",
+			roles: []string{
 				"roles/cloudsql.client",
 				"roles/logging.logWriter",
 				"roles/monitoring.metricWriter",
 			},
 		},
 		{
-			name:        "compute_service_account",
-			accountType: "compute",
-			expectedRoles: []string{
+			name: "compute_service_account",
+			roles: []string{
 				"roles/compute.instanceAdmin.v1",
 				"roles/iam.serviceAccountUser",
 			},
 		},
+		{
+			name:      "basic_role",
+			roles:     []string{"roles/logging.logWriter", "roles/editor"},
+			basicRole: "roles/editor",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -175,27 +218,35 @@ func TestIAMLeastPrivilege(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-				TerraformDir: "./fixtures/iam",
-				Vars: map[string]interface{}{
-					"project_id":   "test-project",
-					"environment":  "test",
-					"account_type": tc.accountType,
-				},
-				NoColor: true,
+			var bindings []map[string]interface{}
+			for _, role := range tc.roles {
+				bindings = append(bindings, map[string]interface{}{
+					"role":    role,
+					"members": []string{serviceAccountMember("test-sa")},
+				})
+			}
+			terraformOptions := fixtureOptions(t, "iam", map[string]interface{}{
+				"project_id":    "test-project",
+				"environment":   "test",
+				"role_bindings": bindings,
 			})
 
-			terraform.Init(t, terraformOptions)
-			planOutput := terraform.Plan(t, terraformOptions)
+			plan := planWithStruct(t, terraformOptions)
+			member := serviceAccountMember(fixtureName(t, "iam", "test-sa"))
 
-			// Verify expected roles are assigned
-			for _, role := range tc.expectedRoles {
-				assert.Contains(t, planOutput, role)
+			// Verify the account gets the bound roles and nothing more
+			planassert.ResourceCount(t, plan, "google_project_iam_member", len(tc.roles))
+			for _, role := range tc.roles {
+				planassert.AttributeEquals(t, plan, roleBindingAddress(role, member), "member", member)
 			}
 
-			// Verify overly permissive roles are not used
-			assert.NotContains(t, planOutput, "roles/owner")
-			assert.NotContains(t, planOutput, "roles/editor")
+			// Verify overly permissive roles are caught
+			err := policy.NoFindingsE(plan, "iam-no-basic-roles")
+			if tc.basicRole != "" {
+				assert.ErrorContains(t, err, "basic role "+tc.basicRole+" granted to "+member)
+			} else {
+				assert.NoError(t, err)
+			}
 		})
 	}
 }
@@ -0,0 +1,122 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/tier"
+)
+
+// TestNetworkLifecycle applies the network fixture, checks the VPC and subnet
+// outputs and destroys it
+func TestNetworkLifecycle(t *testing.T) {
+	tier.Require(t, tier.Apply)
+	t.Parallel()
+
+	terraformOptions := fixtureOptions(t, "network", map[string]interface{}{
+		"project_id": testProject,
+		"region":     "europe-west1",
+	})
+	stub := apply(t, terraformOptions)
+	vpcName := terraformOptions.Vars["vpc_name"].(string)
+
+	assert.Equal(t, vpcName, terraform.Output(t, terraformOptions, "vpc_name"))
+	assert.True(t, strings.HasSuffix(terraform.Output(t, terraformOptions, "vpc_self_link"), "/projects/test-project/global/networks/"+vpcName))
+
+	subnets := terraform.OutputMapOfObjects(t, terraformOptions, "subnets")
+	require.Contains(t, subnets, vpcName+"-private")
+	private := subnets[vpcName+"-private"].(map[string]interface{})
+	assert.Equal(t, "10.0.2.0/24", private["ip_cidr_range"])
+	assert.True(t, strings.HasSuffix(private["region"].(string), "europe-west1"))
+
+	if stub != nil {
+		_, ok := stub.Get("compute/v1/projects/test-project/global/networks/" + vpcName)
+		assert.True(t, ok, "network not created in the stand-in")
+	}
+}
+
+// TestComputeLifecycle applies the compute fixture and checks the instance
+// group and template outputs
+func TestComputeLifecycle(t *testing.T) {
+	tier.Require(t, tier.Apply)
+	t.Parallel()
+
+	terraformOptions := fixtureOptions(t, "compute", map[string]interface{}{
+		"project_id": testProject,
+		"region":     "europe-west1",
+	})
+	apply(t, terraformOptions)
+	name := terraformOptions.Vars["instance_name"].(string)
+
+	assert.Contains(t, terraform.Output(t, terraformOptions, "instance_group"), "/instanceGroups/"+name+"-mig")
+	assert.Contains(t, terraform.Output(t, terraformOptions, "instance_template_self_link"), "/global/instanceTemplates/"+name+"-")
+	assert.Contains(t, terraform.Output(t, terraformOptions, "health_check_self_link"), "/healthChecks/"+name+"-health-check")
+}
+
+// TestCloudSQLLifecycle applies a PostgreSQL instance and checks the
+// connection name the Cloud SQL proxy would use
+func TestCloudSQLLifecycle(t *testing.T) {
+	tier.Require(t, tier.Apply)
+	t.Parallel()
+
+	terraformOptions := fixtureOptions(t, "cloudsql", map[string]interface{}{
+		"project_id":    testProject,
+		"region":        "europe-west1",
+		"database_type": "postgresql",
+	})
+	apply(t, terraformOptions)
+	name := terraformOptions.Vars["instance_name"].(string)
+
+	assert.Equal(t, name, terraform.Output(t, terraformOptions, "instance_name"))
+	assert.Equal(t, "test-project:europe-west1:"+name, terraform.Output(t, terraformOptions, "instance_connection_name"))
+	assert.NotEmpty(t, terraform.Output(t, terraformOptions, "private_ip_address"))
+}
+
+// TestIAMLifecycle applies two service accounts and checks their emails
+func TestIAMLifecycle(t *testing.T) {
+	tier.Require(t, tier.Apply)
+	t.Parallel()
+
+	terraformOptions := fixtureOptions(t, "iam", map[string]interface{}{
+		"project_id": testProject,
+		"service_accounts": []map[string]interface{}{
+			{
+				"account_id":   "app-a-sa",
+				"display_name": "App A Service Account",
+				"description":  "Service account for App A",
+			},
+			{
+				"account_id":   "app-b-sa",
+				"display_name": "App B Service Account",
+				"description":  "Service account for App B",
+			},
+		},
+	})
+	apply(t, terraformOptions)
+
+	emails := terraform.OutputMap(t, terraformOptions, "service_account_emails")
+	for _, base := range []string{"app-a-sa", "app-b-sa"} {
+		id := fixtureName(t, "iam", base)
+		assert.Equal(t, id+"@test-project.iam.gserviceaccount.com", emails[id])
+	}
+}
+
+// TestLoadBalancerLifecycle applies the load balancer fixture and checks the
+// reserved address and URL map outputs
+func TestLoadBalancerLifecycle(t *testing.T) {
+	tier.Require(t, tier.Apply)
+	t.Parallel()
+
+	terraformOptions := fixtureOptions(t, "load-balancer", map[string]interface{}{
+		"project_id": testProject,
+	})
+	apply(t, terraformOptions)
+	name := terraformOptions.Vars["name"].(string)
+
+	assert.NotEmpty(t, terraform.Output(t, terraformOptions, "external_ip"))
+	assert.Contains(t, terraform.Output(t, terraformOptions, "url_map_id"), "/urlMaps/"+name+"-url-map")
+}
@@ -4,15 +4,18 @@ import (
 	"testing"
 
 	"github.com/gruntwork-io/terratest/modules/terraform"
-	"github.com/stretchr/testify/assert"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+	"github.com/unicredit/gcp-migration/tests/terratest/tier"
 )
 
 // TestLoadBalancerModuleValidation validates the load balancer module
 func TestLoadBalancerModuleValidation(t *testing.T) {
+	tier.Require(t, tier.Validate)
 	t.Parallel()
 
 	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "../../terraform/modules/load-balancer",
+		TerraformDir: moduleDir(t, "load-balancer"),
 		Vars: map[string]interface{}{
 			"project_id":  "test-project",
 			"region":      "europe-west1",
@@ -22,131 +25,122 @@ func TestLoadBalancerModuleValidation(t *testing.T) {
 		NoColor: true,
 	})
 
-	terraform.Validate(t, terraformOptions)
+	validate(t, terraformOptions)
 }
 
 // TestLoadBalancerHTTPS tests HTTPS load balancer configuration
 func TestLoadBalancerHTTPS(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/load-balancer",
-		Vars: map[string]interface{}{
-			"project_id":   "test-project",
-			"region":       "europe-west1",
-			"environment":  "test",
-			"name":         "https-lb-test",
-			"enable_https": true,
-			"ssl_policy":   "MODERN",
-		},
-		NoColor: true,
+	terraformOptions := fixtureOptions(t, "load-balancer", map[string]interface{}{
+		"project_id":   "test-project",
+		"region":       "europe-west1",
+		"environment":  "test",
+		"name":         "https-lb-test",
+		"enable_https": true,
+		"ssl_policy":   "MODERN",
 	})
 
-	terraform.Init(t, terraformOptions)
-	planOutput := terraform.Plan(t, terraformOptions)
+	plan := planWithStruct(t, terraformOptions)
 
 	// Verify HTTPS configuration
-	assert.Contains(t, planOutput, "google_compute_global_forwarding_rule")
-	assert.Contains(t, planOutput, "google_compute_target_https_proxy")
-	assert.Contains(t, planOutput, "google_compute_ssl_certificate")
+	planassert.AttributeEquals(t, plan, "module.load_balancer.google_compute_global_forwarding_rule.https", "port_range", "443")
+	planassert.AttributeContains(t, plan, "module.load_balancer.google_compute_target_https_proxy.https_proxy", "ssl_certificates",
+		"projects/test-project/global/sslCertificates/test-certificate")
+	planassert.ResourceExists(t, plan, "module.load_balancer.google_compute_global_forwarding_rule.http[0]")
 }
 
 // TestLoadBalancerBackendService tests backend service configuration
 func TestLoadBalancerBackendService(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/load-balancer",
-		Vars: map[string]interface{}{
-			"project_id":  "test-project",
-			"region":      "europe-west1",
-			"environment": "test",
-			"name":        "backend-test",
-			"backends": []map[string]interface{}{
-				{
-					"group":           "projects/test-project/regions/europe-west1/instanceGroups/app-a-mig",
-					"balancing_mode":  "UTILIZATION",
-					"capacity_scaler": 1.0,
-				},
+	terraformOptions := fixtureOptions(t, "load-balancer", map[string]interface{}{
+		"project_id":  "test-project",
+		"region":      "europe-west1",
+		"environment": "test",
+		"name":        "backend-test",
+		"backends": []map[string]interface{}{
+			{
+				"group":           "projects/test-project/regions/europe-west1/instanceGroups/app-a-mig",
+				"balancing_mode":  "UTILIZATION",
+				"capacity_scaler": 1.0,
 			},
 		},
-		NoColor: true,
 	})
 
-	terraform.Init(t, terraformOptions)
-	planOutput := terraform.Plan(t, terraformOptions)
+	plan := planWithStruct(t, terraformOptions)
 
 	// Verify backend service
-	assert.Contains(t, planOutput, "google_compute_backend_service")
+	backend := "module.load_balancer.google_compute_backend_service.backend"
+	planassert.AttributeEquals(t, plan, backend, "load_balancing_scheme", "EXTERNAL_MANAGED")
+	planassert.AttributeEquals(t, plan, backend, "backend.0.group", "projects/test-project/regions/europe-west1/instanceGroups/app-a-mig")
+	planassert.AttributeEquals(t, plan, backend, "backend.0.balancing_mode", "UTILIZATION")
 }
 
 // TestLoadBalancerHealthCheck tests health check configuration
 func TestLoadBalancerHealthCheck(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/load-balancer",
-		Vars: map[string]interface{}{
-			"project_id":  "test-project",
-			"region":      "europe-west1",
-			"environment": "test",
-			"name":        "health-check-test",
-			"health_check": map[string]interface{}{
-				"check_interval_sec":  10,
-				"timeout_sec":         5,
-				"healthy_threshold":   2,
-				"unhealthy_threshold": 3,
-				"request_path":        "/health",
-				"port":                8080,
-			},
+	terraformOptions := fixtureOptions(t, "load-balancer", map[string]interface{}{
+		"project_id":  "test-project",
+		"region":      "europe-west1",
+		"environment": "test",
+		"name":        "health-check-test",
+		"health_check": map[string]interface{}{
+			"check_interval_sec":  10,
+			"timeout_sec":         5,
+			"healthy_threshold":   2,
+			"unhealthy_threshold": 3,
+			"request_path":        "/health",
+			"port":                8080,
 		},
-		NoColor: true,
 	})
 
-	terraform.Init(t, terraformOptions)
-	planOutput := terraform.Plan(t, terraformOptions)
+	plan := planWithStruct(t, terraformOptions)
 
-	// Verify health check
-	assert.Contains(t, planOutput, "google_compute_health_check")
-	assert.Contains(t, planOutput, "/health")
+	// Verify the module's backend service uses the health check
+	planassert.AttributeReferences(t, plan, "module.load_balancer.google_compute_backend_service.backend", "health_checks", "google_compute_health_check.fixture")
 }
 
 // TestLoadBalancerURLMap tests URL map configuration
 func TestLoadBalancerURLMap(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/load-balancer",
-		Vars: map[string]interface{}{
-			"project_id":  "test-project",
-			"region":      "europe-west1",
-			"environment": "test",
-			"name":        "url-map-test",
-			"url_map_rules": []map[string]interface{}{
-				{
-					"hosts":        []string{"app-a.example.com"},
-					"path_matcher": "app-a-paths",
-					"backend":      "app-a-backend",
-				},
-				{
-					"hosts":        []string{"app-b.example.com"},
-					"path_matcher": "app-b-paths",
-					"backend":      "app-b-backend",
-				},
+	terraformOptions := fixtureOptions(t, "load-balancer", map[string]interface{}{
+		"project_id":  "test-project",
+		"region":      "europe-west1",
+		"environment": "test",
+		"name":        "url-map-test",
+		"url_map_rules": []map[string]interface{}{
+			{
+				"hosts":        []string{"app-a.example.com"},
+				"path_matcher": "app-a-paths",
+				"backend":      "app-a-backend",
+			},
+			{
+				"hosts":        []string{"app-b.example.com"},
+				"path_matcher": "app-b-paths",
+				"backend":      "app-b-backend",
 			},
 		},
-		NoColor: true,
 	})
 
-	terraform.Init(t, terraformOptions)
-	planOutput := terraform.Plan(t, terraformOptions)
+	plan := planWithStruct(t, terraformOptions)
 
 	// Verify URL map
-	assert.Contains(t, planOutput, "google_compute_url_map")
+	urlMap := "module.load_balancer.google_compute_url_map.url_map"
+	planassert.AttributeContains(t, plan, urlMap, "path_matcher.*.name", "app-a-paths")
+	planassert.AttributeContains(t, plan, urlMap, "path_matcher.*.name", "app-b-paths")
+	planassert.AttributeContains(t, plan, urlMap, "host_rule.*.path_matcher", "app-a-paths")
 }
 
 // TestLoadBalancerSSLPolicy tests SSL policy configuration
 func TestLoadBalancerSSLPolicy(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
 	testCases := []struct {
@@ -171,55 +165,45 @@ func TestLoadBalancerSSLPolicy(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-				TerraformDir: "./fixtures/load-balancer",
-				Vars: map[string]interface{}{
-					"project_id":      "test-project",
-					"region":          "europe-west1",
-					"environment":     "test",
-					"name":            "ssl-policy-test",
-					"ssl_policy":      tc.sslProfile,
-					"min_tls_version": tc.minVersion,
-				},
-				NoColor: true,
+			terraformOptions := fixtureOptions(t, "load-balancer", map[string]interface{}{
+				"project_id":      "test-project",
+				"region":          "europe-west1",
+				"environment":     "test",
+				"name":            "ssl-policy-test",
+				"ssl_policy":      tc.sslProfile,
+				"min_tls_version": tc.minVersion,
 			})
 
-			terraform.Init(t, terraformOptions)
-			planOutput := terraform.Plan(t, terraformOptions)
+			plan := planWithStruct(t, terraformOptions)
 
-			// Verify SSL policy
-			assert.Contains(t, planOutput, "google_compute_ssl_policy")
-			assert.Contains(t, planOutput, tc.sslProfile)
+			// Verify the module's HTTPS proxy uses the SSL policy
+			planassert.AttributeReferences(t, plan, "module.load_balancer.google_compute_target_https_proxy.https_proxy", "ssl_policy", "google_compute_ssl_policy.fixture")
 		})
 	}
 }
 
 // TestLoadBalancerCDN tests Cloud CDN configuration
 func TestLoadBalancerCDN(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/load-balancer",
-		Vars: map[string]interface{}{
-			"project_id":  "test-project",
-			"region":      "europe-west1",
-			"environment": "test",
-			"name":        "cdn-test",
-			"enable_cdn":  true,
-			"cdn_policy": map[string]interface{}{
-				"cache_mode":                   "CACHE_ALL_STATIC",
-				"default_ttl":                  3600,
-				"max_ttl":                      86400,
-				"negative_caching":             true,
-				"serve_while_stale":            86400,
-			},
+	terraformOptions := fixtureOptions(t, "load-balancer", map[string]interface{}{
+		"project_id":  "test-project",
+		"region":      "europe-west1",
+		"environment": "test",
+		"name":        "cdn-test",
+		"enable_cdn":  true,
+		"cdn_policy": map[string]interface{}{
+			"cache_mode":        "CACHE_ALL_STATIC",
+			"default_ttl":       3600,
+			"max_ttl":           86400,
+			"negative_caching":  true,
+			"serve_while_stale": 86400,
 		},
-		NoColor: true,
 	})
 
-	terraform.Init(t, terraformOptions)
-	planOutput := terraform.Plan(t, terraformOptions)
+	plan := planWithStruct(t, terraformOptions)
 
 	// Verify CDN is enabled
-	assert.Contains(t, planOutput, "enable_cdn")
+	planassert.AttributeEquals(t, plan, "module.load_balancer.google_compute_backend_service.backend", "enable_cdn", true)
 }
@@ -0,0 +1,170 @@
+// Package naming derives collision-free resource names for tests, so runs from
+// different pipelines can share a project. Names combine a readable base, the
+// run ID and a hash of the test name, and are trimmed to GCP's length rules.
+package naming
+
+import (
+	"crypto/rand"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// RunIDEnv overrides the run ID, e.g. with the CI pipeline ID, so resources
+// of one pipeline can be found and swept later
+const RunIDEnv = "TERRATEST_RUN_ID"
+
+// LabelKey is the label that carries the run ID on labelled resources
+const LabelKey = "test_run_id"
+
+// runIDLength is the length of generated run IDs and the cap on overrides
+const runIDLength = 6
+
+// rfc1035 is the name format for compute resources and service account IDs
+var rfc1035 = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+
+// Rule constrains a name
+type Rule struct {
+	// Kind names the resource in error messages
+	Kind   string
+	MinLen int
+	MaxLen int
+}
+
+var (
+	// GCE covers compute resources: networks, firewalls, templates and the like
+	GCE = Rule{Kind: "compute resource name", MinLen: 1, MaxLen: 63}
+	// ServiceAccount covers service account IDs
+	ServiceAccount = Rule{Kind: "service account ID", MinLen: 6, MaxLen: 30}
+)
+
+// CloudSQLInstance covers Cloud SQL instance IDs, where project-ID:instance-ID
+// must be at most 98 characters
+func CloudSQLInstance(project string) Rule {
+	return Rule{Kind: "Cloud SQL instance ID", MinLen: 1, MaxLen: 98 - len(project) - 1}
+}
+
+// Reserve shortens the rule by n characters for a suffix the module appends,
+// e.g. 13 for "-health-check"
+func (r Rule) Reserve(n int) Rule {
+	r.MaxLen -= n
+	return r
+}
+
+// Validate checks name against the rule
+func (r Rule) Validate(name string) error {
+	if len(name) < r.MinLen || len(name) > r.MaxLen {
+		return fmt.Errorf("%s %q must be %d to %d characters, got %d", r.Kind, name, r.MinLen, r.MaxLen, len(name))
+	}
+	if !rfc1035.MatchString(name) {
+		return fmt.Errorf("%s %q must start with a lowercase letter, end with a letter or digit and contain only lowercase letters, digits and hyphens", r.Kind, name)
+	}
+	return nil
+}
+
+var (
+	runIDOnce sync.Once
+	runID     string
+)
+
+// RunID identifies this test process. It comes from TERRATEST_RUN_ID when
+// set and is random otherwise; either way it is a valid label value.
+func RunID() string {
+	runIDOnce.Do(func() {
+		runID = sanitizeRunID(os.Getenv(RunIDEnv))
+		if runID == "" {
+			runID = randomID(runIDLength)
+		}
+	})
+	return runID
+}
+
+func sanitizeRunID(value string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(value) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	id := b.String()
+	// Keep the end, which is the part that changes between pipeline runs
+	if len(id) > runIDLength {
+		id = id[len(id)-runIDLength:]
+	}
+	return id
+}
+
+func randomID(n int) string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	id := make([]byte, n)
+	for i := range id {
+		index, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			panic(err)
+		}
+		id[i] = alphabet[index.Int64()]
+	}
+	return string(id)
+}
+
+// Name returns a unique name for base within the test. It is deterministic
+// for a given run, test and base, so assertions can call it again to learn
+// the name a fixture was given.
+func Name(t testing.TB, base string, rule Rule) string {
+	t.Helper()
+	name, err := NameE(RunID(), t.Name(), base, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// NameE builds "<base>-<run ID>-<test hash>", trimming base so the result fits
+// the rule
+func NameE(runID, testName, base string, rule Rule) (string, error) {
+	hash := fnv.New32a()
+	hash.Write([]byte(testName + "/" + base))
+	suffix := "-" + runID + "-" + fmt.Sprintf("%04s", strconv.FormatUint(uint64(hash.Sum32()%(36*36*36*36)), 36))
+
+	base = sanitizeBase(base)
+	if room := rule.MaxLen - len(suffix); len(base) > room {
+		if room < 1 {
+			return "", fmt.Errorf("%s allows %d characters, too few for a unique suffix", rule.Kind, rule.MaxLen)
+		}
+		base = strings.TrimRight(base[:room], "-")
+	}
+	name := base + suffix
+	for len(name) < rule.MinLen {
+		name = "x" + name
+	}
+	return name, rule.Validate(name)
+}
+
+// sanitizeBase lowercases base, replaces invalid characters with hyphens and
+// makes sure it starts with a letter
+func sanitizeBase(base string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(base) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('-')
+		}
+	}
+	name := strings.Trim(b.String(), "-")
+	if name == "" || name[0] < 'a' || name[0] > 'z' {
+		name = "t" + name
+	}
+	return name
+}
+
+// Labels returns the labels every labelled test resource carries
+func Labels() map[string]string {
+	return map[string]string{LabelKey: RunID()}
+}
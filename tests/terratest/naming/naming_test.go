@@ -0,0 +1,81 @@
+package naming
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestNameE tests names are unique per test and run and stay within rules
+func TestNameE(t *testing.T) {
+	t.Parallel()
+
+	a, err := NameE("abc123", "TestNetworkModulePlan", "test-vpc", GCE)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(a, "test-vpc-abc123-"), a)
+	assert.Len(t, a, len("test-vpc-abc123-")+4)
+
+	again, err := NameE("abc123", "TestNetworkModulePlan", "test-vpc", GCE)
+	require.NoError(t, err)
+	assert.Equal(t, a, again)
+
+	otherTest, err := NameE("abc123", "TestNetworkModuleOutputs", "test-vpc", GCE)
+	require.NoError(t, err)
+	assert.NotEqual(t, a, otherTest)
+
+	otherRun, err := NameE("zzz999", "TestNetworkModulePlan", "test-vpc", GCE)
+	require.NoError(t, err)
+	assert.NotEqual(t, a, otherRun)
+}
+
+// TestNameETrims tests long bases are cut to the rule without a trailing hyphen
+func TestNameETrims(t *testing.T) {
+	t.Parallel()
+
+	name, err := NameE("abc123", "TestIAM", "application-frontend-sa", ServiceAccount)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(name), 30)
+	assert.NoError(t, ServiceAccount.Validate(name))
+
+	rule := GCE.Reserve(len("-health-check"))
+	name, err = NameE("abc123", "TestCompute", strings.Repeat("app-", 20), rule)
+	require.NoError(t, err)
+	assert.Equal(t, 50, rule.MaxLen)
+	assert.LessOrEqual(t, len(name), 50)
+	assert.NotContains(t, name, "--")
+
+	_, err = NameE("abc123", "TestCompute", "app", GCE.Reserve(60))
+	assert.Error(t, err)
+}
+
+// TestNameESanitizes tests invalid characters and leading digits are fixed up
+func TestNameESanitizes(t *testing.T) {
+	t.Parallel()
+
+	name, err := NameE("abc123", "TestCloudSQL", "1st_DB.test", CloudSQLInstance("test-project"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(name, "t1st-db-test-"), name)
+}
+
+// TestRuleValidate tests the RFC 1035 and length checks
+func TestRuleValidate(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, GCE.Validate("test-vpc"))
+	assert.Error(t, GCE.Validate("Test-vpc"))
+	assert.Error(t, GCE.Validate("test-vpc-"))
+	assert.Error(t, GCE.Validate(strings.Repeat("a", 64)))
+	assert.Error(t, ServiceAccount.Validate("sa"))
+	assert.Equal(t, 85, CloudSQLInstance("test-project").MaxLen)
+}
+
+// TestSanitizeRunID tests overrides keep their changing tail as a label value
+func TestSanitizeRunID(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "345678", sanitizeRunID("Pipeline-12345678"))
+	assert.Equal(t, "", sanitizeRunID("--"))
+	assert.Len(t, randomID(runIDLength), runIDLength)
+}
@@ -0,0 +1,369 @@
+// Package nat checks that VMs without external IPs can still reach the
+// internet through Cloud NAT. The network module only creates a router and
+// NAT in its nat_regions, while subnets and instances can be in any region,
+// so a subnet outside them silently has no egress.
+package nat
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/gruntwork-io/terratest/modules/testing"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// unknownSubnet names the subnet of instances whose subnetwork is unknown
+// until apply and cannot be told from their region
+const unknownSubnet = "(unknown until apply)"
+
+// allSubnetworks are the source_subnetwork_ip_ranges_to_nat modes that cover
+// the primary range, which VMs take their addresses from, of every subnet
+var allSubnetworks = map[string]bool{
+	"ALL_SUBNETWORKS_ALL_IP_RANGES":         true,
+	"ALL_SUBNETWORKS_ALL_PRIMARY_IP_RANGES": true,
+}
+
+// primaryRanges are the source_ip_ranges_to_nat of a listed subnetwork that
+// cover its primary range
+var primaryRanges = map[string]bool{
+	"ALL_IP_RANGES":    true,
+	"PRIMARY_IP_RANGE": true,
+}
+
+// Gateway is a planned google_compute_router_nat
+type Gateway struct {
+	Address string
+	Region  string
+	// Network is the VPC of the gateway's router, empty when unknown until
+	// apply
+	Network string
+	// All is set when the gateway covers every subnet in its region
+	All bool
+	// Subnetworks are the names listed with LIST_OF_SUBNETWORKS for their
+	// primary range
+	Subnetworks []string
+	// Secondary are the names listed for secondary ranges only
+	Secondary []string
+	// Unknown counts listed subnetworks whose names are unknown until apply
+	Unknown int
+}
+
+// Gap is a subnet whose private-only instances have no NAT egress
+type Gap struct {
+	Subnet string
+	Region string
+	// Instances are the addresses of the instances and templates in the
+	// subnet, empty when every subnet is checked
+	Instances []string
+	Reason    string
+}
+
+func (g Gap) String() string {
+	s := fmt.Sprintf("%s in %s has no NAT egress: %s", g.Subnet, g.Region, g.Reason)
+	if len(g.Instances) > 0 {
+		s += " (used by " + strings.Join(g.Instances, ", ") + ")"
+	}
+	return s
+}
+
+// Gateways returns the planned NAT gateways. A gateway's network is read from
+// the planned router it names in its region.
+func Gateways(plan *terraform.PlanStruct) []Gateway {
+	routers := map[[2]string]string{}
+	for _, resource := range planassert.ResourcesOfType(plan, "google_compute_router") {
+		values := resource.AttributeValues
+		name, _ := values["name"].(string)
+		region, _ := values["region"].(string)
+		network, _ := values["network"].(string)
+		routers[[2]string{name, region}] = planassert.LastSegment(network)
+	}
+
+	var gateways []Gateway
+	for _, resource := range planassert.ResourcesOfType(plan, "google_compute_router_nat") {
+		values := resource.AttributeValues
+		gateway := Gateway{Address: resource.Address}
+		gateway.Region, _ = values["region"].(string)
+		router, _ := values["router"].(string)
+		gateway.Network = routers[[2]string{planassert.LastSegment(router), gateway.Region}]
+		mode, _ := values["source_subnetwork_ip_ranges_to_nat"].(string)
+		gateway.All = allSubnetworks[mode]
+		subnetworks, _ := values["subnetwork"].([]interface{})
+		for _, s := range subnetworks {
+			block, _ := s.(map[string]interface{})
+			ranges, _ := block["source_ip_ranges_to_nat"].([]interface{})
+			primary := false
+			for _, r := range ranges {
+				primary = primary || primaryRanges[fmt.Sprint(r)]
+			}
+			name, _ := block["name"].(string)
+			switch {
+			case name != "" && primary:
+				gateway.Subnetworks = append(gateway.Subnetworks, planassert.LastSegment(name))
+			case name != "":
+				gateway.Secondary = append(gateway.Secondary, planassert.LastSegment(name))
+			case primary:
+				gateway.Unknown++
+			}
+		}
+		gateways = append(gateways, gateway)
+	}
+	sort.Slice(gateways, func(i, j int) bool { return gateways[i].Address < gateways[j].Address })
+	return gateways
+}
+
+// subnet is a planned subnet's name, region and network, the network empty
+// when unknown until apply
+type subnet struct {
+	name, region, network string
+}
+
+// Gaps returns the subnets hosting instances or instance templates without
+// external IPs that no NAT in their region covers. Instances whose
+// subnetwork is unknown until apply are placed in the only planned subnet of
+// their region; they are covered anyway when a NAT covers the whole region.
+// A network unknown until apply is taken to be the only planned VPC.
+func Gaps(plan *terraform.PlanStruct) []Gap {
+	gateways := Gateways(plan)
+	subnets := plannedSubnets(plan)
+	networks := plannedNetworks(plan)
+
+	gaps := map[subnet]*Gap{}
+	for _, resourceType := range []string{"google_compute_instance", "google_compute_instance_template"} {
+		for _, resource := range planassert.ResourcesOfType(plan, resourceType) {
+			values := resource.AttributeValues
+			if len(planassert.ResourceExternalIPs(resource)) > 0 {
+				continue
+			}
+			s := placement(values, subnets)
+			if s.name == unknownSubnet && coversRegion(gateways, s, networks) {
+				continue
+			}
+			reason := uncovered(gateways, s, networks)
+			if reason == "" {
+				continue
+			}
+			if s.name == unknownSubnet {
+				reason = "subnetwork unknown until apply and no NAT covers every subnet in the region"
+			}
+			if gaps[s] == nil {
+				gaps[s] = &Gap{Subnet: s.name, Region: s.region, Reason: reason}
+			}
+			gaps[s].Instances = append(gaps[s].Instances, resource.Address)
+		}
+	}
+
+	var result []Gap
+	for _, gap := range gaps {
+		sort.Strings(gap.Instances)
+		result = append(result, *gap)
+	}
+	sortGaps(result)
+	return result
+}
+
+// SubnetGaps returns every planned subnet no NAT covers, whether or not any
+// planned instance uses it
+func SubnetGaps(plan *terraform.PlanStruct) []Gap {
+	gateways := Gateways(plan)
+	networks := plannedNetworks(plan)
+	var gaps []Gap
+	for _, s := range plannedSubnets(plan) {
+		if reason := uncovered(gateways, s, networks); reason != "" {
+			gaps = append(gaps, Gap{Subnet: s.name, Region: s.region, Reason: reason})
+		}
+	}
+	sortGaps(gaps)
+	return gaps
+}
+
+// uncovered returns why no gateway covers the subnet, or "" when one does
+func uncovered(gateways []Gateway, s subnet, networks []string) string {
+	var listing, secondary, elsewhere []string
+	unknown := 0
+	for _, gateway := range gateways {
+		if gateway.Region != s.region {
+			continue
+		}
+		if !sameNetwork(gateway.Network, s.network, networks) {
+			elsewhere = append(elsewhere, gateway.Address+" on "+networkName(gateway.Network))
+			continue
+		}
+		if gateway.All {
+			return ""
+		}
+		for _, name := range gateway.Subnetworks {
+			if name == s.name {
+				return ""
+			}
+		}
+		for _, name := range gateway.Secondary {
+			if name == s.name {
+				secondary = append(secondary, gateway.Address)
+			}
+		}
+		listing = append(listing, gateway.Address)
+		unknown += gateway.Unknown
+	}
+	if len(listing) == 0 {
+		if len(elsewhere) > 0 {
+			return fmt.Sprintf("no Cloud NAT on %s in %s (%s)", networkName(s.network), s.region, strings.Join(elsewhere, ", "))
+		}
+		return "no Cloud NAT in " + s.region
+	}
+	if len(secondary) > 0 {
+		return "listed by " + strings.Join(secondary, ", ") + " for secondary ranges only"
+	}
+	reason := "not listed by " + strings.Join(listing, ", ")
+	if unknown > 0 {
+		reason += fmt.Sprintf(", which list %d subnetworks unknown until apply", unknown)
+	}
+	return reason
+}
+
+// coversRegion reports whether a gateway on the subnet's network covers every
+// subnet in its region
+func coversRegion(gateways []Gateway, s subnet, networks []string) bool {
+	for _, gateway := range gateways {
+		if gateway.Region == s.region && gateway.All && sameNetwork(gateway.Network, s.network, networks) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameNetwork reports whether two networks are the same VPC, taking one
+// unknown until apply to be the only planned VPC
+func sameNetwork(a, b string, networks []string) bool {
+	if a == "" || b == "" {
+		return len(networks) <= 1
+	}
+	return a == b
+}
+
+func networkName(network string) string {
+	if network == "" {
+		return "a VPC unknown until apply"
+	}
+	return network
+}
+
+func plannedNetworks(plan *terraform.PlanStruct) []string {
+	var networks []string
+	for _, resource := range planassert.ResourcesOfType(plan, "google_compute_network") {
+		name, _ := resource.AttributeValues["name"].(string)
+		networks = append(networks, name)
+	}
+	sort.Strings(networks)
+	return networks
+}
+
+func plannedSubnets(plan *terraform.PlanStruct) []subnet {
+	var subnets []subnet
+	for _, resource := range planassert.ResourcesOfType(plan, "google_compute_subnetwork") {
+		values := resource.AttributeValues
+		if purpose, _ := values["purpose"].(string); purpose != "" && purpose != "PRIVATE" {
+			continue
+		}
+		var s subnet
+		s.name, _ = values["name"].(string)
+		s.region, _ = values["region"].(string)
+		network, _ := values["network"].(string)
+		s.network = planassert.LastSegment(network)
+		subnets = append(subnets, s)
+	}
+	return subnets
+}
+
+// placement returns the subnet of an instance or template's first network
+// interface, which carries its default route
+func placement(values map[string]interface{}, subnets []subnet) subnet {
+	link := planassert.String(values, "network_interface.0.subnetwork")
+	network := planassert.String(values, "network_interface.0.network")
+	region := instanceRegion(values)
+	if link != "" {
+		s := subnet{name: planassert.LastSegment(link), region: region, network: planassert.LastSegment(network)}
+		parts := strings.Split(link, "/")
+		inLink := len(parts) >= 4 && parts[len(parts)-4] == "regions"
+		if inLink {
+			s.region = parts[len(parts)-3]
+		}
+		for _, planned := range subnets {
+			if planned.name == s.name && (!inLink || planned.region == s.region) {
+				s.region = planned.region
+				if planned.network != "" {
+					s.network = planned.network
+				}
+			}
+		}
+		return s
+	}
+
+	var inRegion []subnet
+	for _, planned := range subnets {
+		if planned.region == region {
+			inRegion = append(inRegion, planned)
+		}
+	}
+	if len(inRegion) == 1 {
+		return inRegion[0]
+	}
+	return subnet{name: unknownSubnet, region: region, network: planassert.LastSegment(network)}
+}
+
+// instanceRegion returns the region of a template, or of an instance's zone
+func instanceRegion(values map[string]interface{}) string {
+	if region, _ := values["region"].(string); region != "" {
+		return planassert.LastSegment(region)
+	}
+	zone, _ := values["zone"].(string)
+	zone = planassert.LastSegment(zone)
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		return zone[:i]
+	}
+	return zone
+}
+
+func sortGaps(gaps []Gap) {
+	sort.Slice(gaps, func(i, j int) bool {
+		if gaps[i].Region != gaps[j].Region {
+			return gaps[i].Region < gaps[j].Region
+		}
+		return gaps[i].Subnet < gaps[j].Subnet
+	})
+}
+
+func gapsError(gaps []Gap) error {
+	if len(gaps) == 0 {
+		return nil
+	}
+	lines := make([]string, len(gaps))
+	for i, gap := range gaps {
+		lines[i] = gap.String()
+	}
+	return fmt.Errorf("%d subnets without NAT egress:\n%s", len(gaps), strings.Join(lines, "\n"))
+}
+
+// CoveredE returns an error listing the subnets whose private-only instances
+// have no NAT egress
+func CoveredE(plan *terraform.PlanStruct) error {
+	return gapsError(Gaps(plan))
+}
+
+// Covered asserts every private-only instance has NAT egress
+func Covered(t testing.TestingT, plan *terraform.PlanStruct) bool {
+	return assert.NoError(t, CoveredE(plan))
+}
+
+// SubnetsCoveredE returns an error listing every planned subnet no NAT covers
+func SubnetsCoveredE(plan *terraform.PlanStruct) error {
+	return gapsError(SubnetGaps(plan))
+}
+
+// SubnetsCovered asserts every planned subnet is covered by a NAT
+func SubnetsCovered(t testing.TestingT, plan *terraform.PlanStruct) bool {
+	return assert.NoError(t, SubnetsCoveredE(plan))
+}
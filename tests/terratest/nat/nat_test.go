@@ -0,0 +1,92 @@
+package nat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// TestGateways tests NAT modes, subnetwork lists and router networks are read
+func TestGateways(t *testing.T) {
+	t.Parallel()
+
+	gateways := Gateways(planassert.LoadPlanFile(t, "testdata/plan.json"))
+	require.Len(t, gateways, 3)
+	assert.Equal(t, Gateway{
+		Address:     `module.network.google_compute_router_nat.nat["europe-west1"]`,
+		Region:      "europe-west1",
+		Network:     "vpc",
+		Subnetworks: []string{"dev-subnet-01"},
+		Secondary:   []string{"dev-subnet-03"},
+		Unknown:     1,
+	}, gateways[0])
+	assert.True(t, gateways[1].All)
+	assert.Equal(t, "partner-vpc", gateways[2].Network)
+}
+
+// TestGaps tests the subnets of private-only instances without NAT are reported
+func TestGaps(t *testing.T) {
+	t.Parallel()
+
+	gaps := Gaps(planassert.LoadPlanFile(t, "testdata/plan.json"))
+	require.Len(t, gaps, 4)
+	assert.Equal(t, Gap{
+		Subnet:    "dev-subnet-02",
+		Region:    "europe-west1",
+		Instances: []string{"module.app_b.google_compute_instance_template.template"},
+		Reason:    `not listed by module.network.google_compute_router_nat.nat["europe-west1"], which list 1 subnetworks unknown until apply`,
+	}, gaps[1])
+	// Two subnets in europe-west1 and the NAT lists only one
+	assert.Equal(t, "(unknown until apply)", gaps[0].Subnet)
+	assert.Equal(t, []string{"module.worker.google_compute_instance_template.template"}, gaps[0].Instances)
+	// Only secondary ranges of dev-subnet-03 are translated
+	assert.Equal(t, `dev-subnet-03 in europe-west1 has no NAT egress: listed by module.network.google_compute_router_nat.nat["europe-west1"] for secondary ranges only (used by module.app_c.google_compute_instance_template.template)`, gaps[2].String())
+	// The NAT in europe-west3 is on the partner VPC and the public template
+	// in batch-subnet does not need NAT
+	assert.Equal(t, "batch-subnet in europe-west3 has no NAT egress: no Cloud NAT on vpc in europe-west3 (module.partner.google_compute_router_nat.nat on partner-vpc) (used by google_compute_instance.batch_worker)", gaps[3].String())
+
+	err := CoveredE(planassert.LoadPlanFile(t, "testdata/plan.json"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "4 subnets without NAT egress")
+	assert.NotContains(t, err.Error(), "dev-subnet-01")
+	assert.NotContains(t, err.Error(), "dr")
+}
+
+// TestSubnetGaps tests every planned VM subnet is checked without instances
+func TestSubnetGaps(t *testing.T) {
+	t.Parallel()
+
+	var subnets []string
+	for _, gap := range SubnetGaps(planassert.LoadPlanFile(t, "testdata/plan.json")) {
+		subnets = append(subnets, gap.Subnet)
+	}
+	assert.Equal(t, []string{"dev-subnet-02", "dev-subnet-03", "batch-subnet"}, subnets)
+}
+
+// TestUncovered tests gateways only cover subnets on their router's network,
+// taking an unknown network to be the only planned VPC
+func TestUncovered(t *testing.T) {
+	t.Parallel()
+
+	all := Gateway{Address: "nat", Region: "europe-west1", Network: "vpc", All: true}
+	unknown := Gateway{Address: "nat", Region: "europe-west1", All: true}
+	testCases := []struct {
+		name     string
+		gateway  Gateway
+		subnet   subnet
+		networks []string
+		reason   string
+	}{
+		{"same_network", all, subnet{"a", "europe-west1", "vpc"}, []string{"other", "vpc"}, ""},
+		{"other_network", all, subnet{"a", "europe-west1", "other"}, []string{"other", "vpc"}, "no Cloud NAT on other in europe-west1 (nat on vpc)"},
+		{"only_vpc", unknown, subnet{"a", "europe-west1", "vpc"}, []string{"vpc"}, ""},
+		{"ambiguous", unknown, subnet{"a", "europe-west1", ""}, []string{"other", "vpc"}, "no Cloud NAT on a VPC unknown until apply in europe-west1 (nat on a VPC unknown until apply)"},
+		{"other_region", all, subnet{"a", "europe-west4", "vpc"}, []string{"vpc"}, "no Cloud NAT in europe-west4"},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.reason, uncovered([]Gateway{tc.gateway}, tc.subnet, tc.networks), tc.name)
+	}
+}
@@ -1,19 +1,34 @@
 package test
 
 import (
+	"fmt"
+	"net/netip"
 	"testing"
 
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/addrspace"
+	"github.com/unicredit/gcp-migration/tests/terratest/firewall"
+	"github.com/unicredit/gcp-migration/tests/terratest/nat"
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+	"github.com/unicredit/gcp-migration/tests/terratest/policy"
+	"github.com/unicredit/gcp-migration/tests/terratest/tier"
 )
 
+// subnetAddress returns the planned address of one of the fixture's subnets
+func subnetAddress(vpcName, suffix string) string {
+	return fmt.Sprintf(`module.network.google_compute_subnetwork.subnets["%s-%s"]`, vpcName, suffix)
+}
+
 // TestNetworkModuleValidation validates the network module configuration
 func TestNetworkModuleValidation(t *testing.T) {
+	tier.Require(t, tier.Validate)
 	t.Parallel()
 
 	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "../../terraform/modules/network",
+		TerraformDir: moduleDir(t, "network"),
 		Vars: map[string]interface{}{
 			"project_id":  "test-project",
 			"region":      "europe-west1",
@@ -23,74 +38,66 @@ func TestNetworkModuleValidation(t *testing.T) {
 	})
 
 	// Validate the Terraform configuration
-	terraform.Validate(t, terraformOptions)
+	validate(t, terraformOptions)
 }
 
 // TestNetworkModulePlan tests the network module plan output
 func TestNetworkModulePlan(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/network",
-		Vars: map[string]interface{}{
-			"project_id":  "test-project",
-			"region":      "europe-west1",
-			"environment": "test",
-		},
-		NoColor:    true,
-		PlanFilePath: "./network-plan.out",
+	terraformOptions := fixtureOptions(t, "network", map[string]interface{}{
+		"project_id": "test-project",
+		"region":     "europe-west1",
 	})
 
-	// Clean up at the end
-	defer terraform.Destroy(t, terraformOptions)
-
-	// Initialize and plan
-	terraform.Init(t, terraformOptions)
-	planOutput := terraform.Plan(t, terraformOptions)
+	plan := planWithStruct(t, terraformOptions)
+	vpcName := terraformOptions.Vars["vpc_name"].(string)
 
 	// Verify plan contains expected resources
-	assert.Contains(t, planOutput, "google_compute_network.vpc")
-	assert.Contains(t, planOutput, "google_compute_subnetwork.public")
-	assert.Contains(t, planOutput, "google_compute_subnetwork.private")
-	assert.Contains(t, planOutput, "google_compute_router.router")
-	assert.Contains(t, planOutput, "google_compute_router_nat.nat")
+	planassert.ResourceExists(t, plan, "module.network.google_compute_network.vpc")
+	planassert.ResourceExists(t, plan, subnetAddress(vpcName, "public"))
+	planassert.ResourceExists(t, plan, subnetAddress(vpcName, "private"))
+	planassert.ResourceExists(t, plan, `module.network.google_compute_router.router["europe-west1"]`)
+	planassert.ResourceExists(t, plan, `module.network.google_compute_router_nat.nat["europe-west1"]`)
+	planassert.ResourceExists(t, plan, "module.network.google_service_networking_connection.private_vpc_connection")
 }
 
 // TestNetworkModuleOutputs tests the network module outputs
 func TestNetworkModuleOutputs(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/network",
-		Vars: map[string]interface{}{
-			"project_id":        "test-project",
-			"region":            "europe-west1",
-			"environment":       "test",
-			"vpc_name":          "test-vpc",
-			"public_subnet_cidr": "10.0.1.0/24",
-			"private_subnet_cidr": "10.0.2.0/24",
-		},
-		NoColor: true,
+	terraformOptions := fixtureOptions(t, "network", map[string]interface{}{
+		"project_id":          "test-project",
+		"region":              "europe-west1",
+		"vpc_name":            "test-vpc",
+		"public_subnet_cidr":  "10.0.1.0/24",
+		"private_subnet_cidr": "10.0.2.0/24",
 	})
 
-	// Initialize
-	terraform.Init(t, terraformOptions)
+	plan := planWithStruct(t, terraformOptions)
+	vpcName := terraformOptions.Vars["vpc_name"].(string)
 
-	// Get expected outputs from plan
-	planStruct := terraform.InitAndPlanAndShowWithStruct(t, terraformOptions)
-
-	// Verify outputs are defined
-	require.NotNil(t, planStruct.ResourcePlannedValuesMap)
+	// Verify outputs and the subnets behind them
+	planassert.OutputEquals(t, plan, "vpc_name", vpcName)
+	planassert.AttributeEquals(t, plan, "module.network.google_compute_network.vpc", "auto_create_subnetworks", false)
+	planassert.AttributeEquals(t, plan, subnetAddress(vpcName, "public"), "ip_cidr_range", "10.0.1.0/24")
+	planassert.AttributeEquals(t, plan, subnetAddress(vpcName, "private"), "ip_cidr_range", "10.0.2.0/24")
 }
 
-// TestNetworkCIDRValidation validates CIDR configurations
+// TestNetworkCIDRValidation validates CIDR configurations: subnet primary and
+// secondary ranges must not overlap each other or on-prem, and the private
+// services /16 must still fit in 10.0.0.0/8
 func TestNetworkCIDRValidation(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
 	testCases := []struct {
 		name        string
 		publicCIDR  string
 		privateCIDR string
+		onPrem      []string
 		shouldFail  bool
 	}{
 		{
@@ -105,6 +112,26 @@ func TestNetworkCIDRValidation(t *testing.T) {
 			privateCIDR: "10.0.16.0/20",
 			shouldFail:  false,
 		},
+		{
+			name:        "overlapping_subnets",
+			publicCIDR:  "10.0.0.0/20",
+			privateCIDR: "10.0.8.0/24",
+			shouldFail:  true,
+		},
+		{
+			// The fixture's public pods range is 10.10.0.0/16
+			name:        "subnet_inside_pods_range",
+			publicCIDR:  "10.10.4.0/24",
+			privateCIDR: "10.0.2.0/24",
+			shouldFail:  true,
+		},
+		{
+			name:        "overlapping_on_prem",
+			publicCIDR:  "10.0.1.0/24",
+			privateCIDR: "10.0.2.0/24",
+			onPrem:      []string{"10.0.0.0/22"},
+			shouldFail:  true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -112,49 +139,179 @@ func TestNetworkCIDRValidation(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-				TerraformDir: "./fixtures/network",
-				Vars: map[string]interface{}{
-					"project_id":         "test-project",
-					"region":             "europe-west1",
-					"environment":        "test",
-					"public_subnet_cidr":  tc.publicCIDR,
-					"private_subnet_cidr": tc.privateCIDR,
-				},
-				NoColor: true,
+			terraformOptions := fixtureOptions(t, "network", map[string]interface{}{
+				"project_id":          "test-project",
+				"region":              "europe-west1",
+				"public_subnet_cidr":  tc.publicCIDR,
+				"private_subnet_cidr": tc.privateCIDR,
 			})
 
-			terraform.Init(t, terraformOptions)
+			plan := planWithStruct(t, terraformOptions)
+			space, err := addrspace.FromPlan(plan)
+			require.NoError(t, err)
+			for i, cidr := range tc.onPrem {
+				require.NoError(t, space.Add(fmt.Sprintf("on-prem[%d]", i), cidr))
+			}
 
 			if tc.shouldFail {
-				_, err := terraform.PlanE(t, terraformOptions)
-				assert.Error(t, err)
-			} else {
-				terraform.Plan(t, terraformOptions)
+				assert.Error(t, addrspace.NoConflictsE(space))
+				return
 			}
+
+			addrspace.NoConflicts(t, space)
+			_, err = space.Place(netip.MustParsePrefix("10.0.0.0/8"))
+			assert.NoError(t, err)
+
+			vpcName := terraformOptions.Vars["vpc_name"].(string)
+			planassert.AttributeEquals(t, plan, subnetAddress(vpcName, "public"), "ip_cidr_range", tc.publicCIDR)
+			planassert.AttributeEquals(t, plan, subnetAddress(vpcName, "private"), "ip_cidr_range", tc.privateCIDR)
+		})
+	}
+}
+
+// TestNetworkNATCoverage verifies the fixture's subnets have Cloud NAT egress
+// only when a NAT is created in their region
+func TestNetworkNATCoverage(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	testCases := []struct {
+		name       string
+		natRegions []string
+		covered    bool
+	}{
+		{"same_region", []string{"europe-west1"}, true},
+		{"other_region", []string{"europe-west4"}, false},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			terraformOptions := fixtureOptions(t, "network", map[string]interface{}{
+				"project_id":  "test-project",
+				"region":      "europe-west1",
+				"nat_regions": tc.natRegions,
+			})
+
+			plan := planWithStruct(t, terraformOptions)
+			if tc.covered {
+				nat.SubnetsCovered(t, plan)
+				return
+			}
+
+			vpcName := terraformOptions.Vars["vpc_name"].(string)
+			var uncovered []string
+			for _, gap := range nat.SubnetGaps(plan) {
+				uncovered = append(uncovered, gap.Subnet)
+				assert.Equal(t, "no Cloud NAT in europe-west1", gap.Reason)
+			}
+			assert.ElementsMatch(t, []string{vpcName + "-public", vpcName + "-private"}, uncovered)
 		})
 	}
 }
 
 // TestNetworkFirewallRules validates firewall rule configurations
 func TestNetworkFirewallRules(t *testing.T) {
+	tier.Require(t, tier.Plan)
 	t.Parallel()
 
-	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-		TerraformDir: "./fixtures/network",
-		Vars: map[string]interface{}{
-			"project_id":  "test-project",
-			"region":      "europe-west1",
-			"environment": "test",
-			"enable_ssh_firewall": true,
-			"enable_http_firewall": true,
-		},
-		NoColor: true,
+	terraformOptions := fixtureOptions(t, "network", map[string]interface{}{
+		"project_id": "test-project",
+		"region":     "europe-west1",
 	})
 
-	terraform.Init(t, terraformOptions)
-	planOutput := terraform.Plan(t, terraformOptions)
+	plan := planWithStruct(t, terraformOptions)
 
 	// Verify firewall rules are in the plan
-	assert.Contains(t, planOutput, "google_compute_firewall")
+	planassert.ResourceCount(t, plan, "google_compute_firewall", 6)
+	planassert.AttributeEquals(t, plan, "module.network.google_compute_firewall.allow_ssh", "source_ranges", []string{"35.235.240.0/20"})
+	planassert.AttributeContains(t, plan, "module.network.google_compute_firewall.allow_iap", "allow.0.ports", "3389")
+}
+
+// TestNetworkFirewallReachability tests the module's rules against VMs tagged
+// the way the compute module tags them. The fixture plans no instances, so the
+// VMs are added to the simulated network by hand.
+func TestNetworkFirewallReachability(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	terraformOptions := fixtureOptions(t, "network", map[string]interface{}{
+		"project_id": "test-project",
+		"region":     "europe-west1",
+	})
+
+	plan := planWithStruct(t, terraformOptions)
+	network, err := firewall.FromPlan(plan)
+	require.NoError(t, err)
+	network.Instances = append(network.Instances,
+		firewall.Instance{Name: "linux", Tags: []string{"allow-ssh", "allow-http-https", "allow-health-check"}},
+		firewall.Instance{Name: "windows", Tags: []string{"allow-rdp", "allow-health-check"}},
+	)
+
+	internet := firewall.MustFromRange("0.0.0.0/0")
+	iap := firewall.MustFromRange("35.235.240.0/20")
+
+	// Health check probes reach every TCP port, so the internet as a whole
+	// reaches both VMs; public ranges outside IAP and the probes must not
+	for _, cidr := range []string{"1.0.0.0/8", "35.234.0.0/16", "203.0.113.0/24"} {
+		public := firewall.MustFromRange(cidr)
+		firewall.Unreachable(t, network, public, "linux", "tcp", 22)
+		firewall.Unreachable(t, network, public, "windows", "tcp", 3389)
+		firewall.Unreachable(t, network, public, "windows", "tcp", 443)
+	}
+	firewall.Reachable(t, network, iap, "linux", "tcp", 22)
+	firewall.Reachable(t, network, iap, "windows", "tcp", 3389)
+	firewall.Reachable(t, network, internet, "linux", "tcp", 443)
+	firewall.Reachable(t, network, firewall.MustFromRange("10.20.0.0/16"), "windows", "tcp", 5432)
+}
+
+// TestNetworkFirewallExposure tests the firewall policy rules against the
+// module's rules, with the default and a world-open SSH source range
+func TestNetworkFirewallExposure(t *testing.T) {
+	tier.Require(t, tier.Plan)
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		sshSourceRanges []string
+		exposed         bool
+	}{
+		{"iap_only", []string{"35.235.240.0/20"}, false},
+		{"world_open_ssh", []string{"0.0.0.0/0"}, true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			terraformOptions := fixtureOptions(t, "network", map[string]interface{}{
+				"project_id":        "test-project",
+				"region":            "europe-west1",
+				"ssh_source_ranges": tc.sshSourceRanges,
+			})
+
+			plan := planWithStruct(t, terraformOptions)
+
+			err := policy.NoFindingsE(plan, "firewall-world-open-ingress", "firewall-admin-ports-iap-only")
+			if tc.exposed {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "module.network.google_compute_firewall.allow_ssh")
+			} else {
+				assert.NoError(t, err)
+			}
+
+			// allow_internal opens every port to all of RFC 1918 and has no target
+			var internal []string
+			for _, finding := range policy.Default.Evaluate(plan) {
+				if finding.Address == "module.network.google_compute_firewall.allow_internal" {
+					internal = append(internal, finding.Rule)
+				}
+			}
+			assert.Contains(t, internal, "firewall-broad-internal-range")
+			assert.Contains(t, internal, "firewall-no-target")
+		})
+	}
 }
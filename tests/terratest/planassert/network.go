@@ -0,0 +1,66 @@
+package planassert
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/gruntwork-io/terratest/modules/testing"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+)
+
+// vmResourceTypes are the resource types whose network interfaces can carry
+// an external address through access_config or ipv6_access_config
+var vmResourceTypes = []string{
+	"google_compute_instance_template",
+	"google_compute_instance",
+}
+
+// ExternalIPs returns one entry per network interface of a planned instance or
+// instance template that would get an external IP
+func ExternalIPs(plan *terraform.PlanStruct) []string {
+	var found []string
+	for _, resourceType := range vmResourceTypes {
+		for _, resource := range ResourcesOfType(plan, resourceType) {
+			for _, path := range ResourceExternalIPs(resource) {
+				found = append(found, fmt.Sprintf("%s: %s", resource.Address, path))
+			}
+		}
+	}
+	return found
+}
+
+// ResourceExternalIPs returns the access config paths of one instance or
+// instance template that would get an external IP, e.g.
+// network_interface.0.access_config
+func ResourceExternalIPs(resource *tfjson.StateResource) []string {
+	interfaces, ok := resource.AttributeValues["network_interface"].([]interface{})
+	if !ok {
+		return []string{"network_interface is unknown until apply"}
+	}
+	var found []string
+	for i, nic := range interfaces {
+		for _, block := range []string{"access_config", "ipv6_access_config"} {
+			configs, _ := Lookup(nic, block)
+			if list, ok := configs.([]interface{}); ok && len(list) > 0 {
+				found = append(found, fmt.Sprintf("network_interface.%d.%s", i, block))
+			}
+		}
+	}
+	return found
+}
+
+// NoExternalIPE returns an error if any planned instance or instance template
+// has a network interface with an external IP
+func NoExternalIPE(plan *terraform.PlanStruct) error {
+	if found := ExternalIPs(plan); len(found) > 0 {
+		return fmt.Errorf("external IPs planned on %s", strings.Join(found, ", "))
+	}
+	return nil
+}
+
+// NoExternalIP asserts no planned instance or instance template gets an external IP
+func NoExternalIP(t testing.TestingT, plan *terraform.PlanStruct) bool {
+	return assert.NoError(t, NoExternalIPE(plan))
+}
@@ -0,0 +1,27 @@
+package planassert
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestExternalIPs tests access_config detection on templates and instances
+func TestExternalIPs(t *testing.T) {
+	t.Parallel()
+
+	plan := LoadPlanFile(t, "testdata/instances.json")
+
+	assert.Equal(t, []string{
+		"module.compute_app_b.google_compute_instance_template.template: network_interface.0.access_config",
+		"google_compute_instance.bastion: network_interface.1.ipv6_access_config",
+	}, ExternalIPs(plan))
+	assert.Error(t, NoExternalIPE(plan))
+}
+
+// TestNoExternalIP tests a plan without external addresses passes
+func TestNoExternalIP(t *testing.T) {
+	t.Parallel()
+
+	NoExternalIP(t, LoadPlanFile(t, "testdata/plan.json"))
+}
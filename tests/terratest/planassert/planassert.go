@@ -0,0 +1,436 @@
+// Package planassert provides typed assertions over terratest's PlanStruct,
+// so tests check planned resources and attribute values instead of grepping
+// the human-readable plan output.
+package planassert
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/gruntwork-io/terratest/modules/testing"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// LoadPlanFileE reads a plan from `terraform show -json` output saved to path
+func LoadPlanFileE(path string) (*terraform.PlanStruct, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := terraform.ParsePlanJSON(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return plan, nil
+}
+
+// LoadPlanFile reads a saved plan, failing the test if it cannot
+func LoadPlanFile(t testing.TestingT, path string) *terraform.PlanStruct {
+	plan, err := LoadPlanFileE(path)
+	require.NoError(t, err)
+	return plan
+}
+
+// Resource returns the planned resource at the full address, e.g.
+// module.cloudsql.google_sql_database_instance.instance
+func Resource(plan *terraform.PlanStruct, address string) (*tfjson.StateResource, error) {
+	resource, ok := plan.ResourcePlannedValuesMap[address]
+	if !ok {
+		return nil, fmt.Errorf("resource %s is not in the plan (planned: %s)",
+			address, strings.Join(Addresses(plan), ", "))
+	}
+	return resource, nil
+}
+
+// Addresses returns every planned resource address in sorted order
+func Addresses(plan *terraform.PlanStruct) []string {
+	addresses := make([]string, 0, len(plan.ResourcePlannedValuesMap))
+	for address := range plan.ResourcePlannedValuesMap {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// ResourcesOfType returns every planned managed resource of the given type,
+// sorted by address
+func ResourcesOfType(plan *terraform.PlanStruct, resourceType string) []*tfjson.StateResource {
+	var resources []*tfjson.StateResource
+	for _, address := range Addresses(plan) {
+		resource := plan.ResourcePlannedValuesMap[address]
+		if resource.Type == resourceType && resource.Mode != tfjson.DataResourceMode {
+			resources = append(resources, resource)
+		}
+	}
+	return resources
+}
+
+// Attribute looks up a nested attribute of a planned resource. The path is a
+// dot separated list of attribute names and list indexes, e.g.
+// settings.0.ip_configuration.0.ipv4_enabled
+func Attribute(plan *terraform.PlanStruct, address string, path string) (interface{}, error) {
+	resource, err := Resource(plan, address)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := Lookup(resource.AttributeValues, path)
+	if !ok {
+		return nil, fmt.Errorf("%s has no planned value at %s (it may be unknown until apply)", address, path)
+	}
+	return value, nil
+}
+
+// Lookup walks path through a decoded JSON value. A "*" segment matches every
+// element of a list, in which case the result is a list of the matches.
+func Lookup(value interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return value, true
+	}
+	return lookup(value, strings.Split(path, "."))
+}
+
+func lookup(value interface{}, segments []string) (interface{}, bool) {
+	if len(segments) == 0 {
+		return value, true
+	}
+	segment, rest := segments[0], segments[1:]
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		next, ok := v[segment]
+		if !ok {
+			return nil, false
+		}
+		return lookup(next, rest)
+	case []interface{}:
+		if segment == "*" {
+			matches := []interface{}{}
+			for _, element := range v {
+				if match, ok := lookup(element, rest); ok {
+					matches = append(matches, match)
+				}
+			}
+			return matches, true
+		}
+		index, err := strconv.Atoi(segment)
+		if err != nil || index < 0 || index >= len(v) {
+			return nil, false
+		}
+		return lookup(v[index], rest)
+	}
+	return nil, false
+}
+
+// String returns the string at path in values, empty when it is missing,
+// unknown until apply or not a string
+func String(values map[string]interface{}, path string) string {
+	value, _ := Lookup(values, path)
+	s, _ := value.(string)
+	return s
+}
+
+// LastSegment returns the name at the end of a self link, ID or URL
+func LastSegment(link string) string {
+	return link[strings.LastIndex(link, "/")+1:]
+}
+
+// ModulePath returns the module part of a planned resource's address, empty
+// for the root module
+func ModulePath(resource *tfjson.StateResource) string {
+	if i := strings.LastIndex(resource.Address, "."+resource.Type+"."); i >= 0 {
+		return resource.Address[:i]
+	}
+	return ""
+}
+
+// Qualify prefixes a name such as var.x or a resource address with a module
+// path, leaving it as is in the root module
+func Qualify(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+var indexes = regexp.MustCompile(`\[[^\]]*\]`)
+
+// ConfigResource returns the configuration of the resource at a planned
+// address, ignoring count and for_each keys, or nil when the plan carries no
+// configuration for it
+func ConfigResource(plan *terraform.PlanStruct, address string) *tfjson.ConfigResource {
+	if plan.RawPlan.Config == nil {
+		return nil
+	}
+	module := plan.RawPlan.Config.RootModule
+	parts := strings.Split(indexes.ReplaceAllString(address, ""), ".")
+	for len(parts) > 2 && parts[0] == "module" {
+		if module == nil || module.ModuleCalls[parts[1]] == nil {
+			return nil
+		}
+		module = module.ModuleCalls[parts[1]].Module
+		parts = parts[2:]
+	}
+	if module == nil {
+		return nil
+	}
+	local := strings.Join(parts, ".")
+	for _, resource := range module.Resources {
+		if resource.Address == local {
+			return resource
+		}
+	}
+	return nil
+}
+
+// References returns what an argument of the resource at a planned address
+// refers to in the configuration, with module paths. References to module
+// variables are followed to what the calling modules pass in, so an argument
+// set from var.health_check names what the caller passed as health_check.
+func References(plan *terraform.PlanStruct, address, name string) ([]string, error) {
+	resource := ConfigResource(plan, address)
+	if resource == nil {
+		return nil, fmt.Errorf("resource %s is not in the plan configuration", address)
+	}
+	expression := resource.Expressions[name]
+	if expression == nil || expression.ExpressionData == nil {
+		return nil, fmt.Errorf("%s does not set %s", address, name)
+	}
+
+	// The module calls from the root down to the resource, with their paths
+	var calls []*tfjson.ModuleCall
+	paths := []string{""}
+	module := plan.RawPlan.Config.RootModule
+	parts := strings.Split(indexes.ReplaceAllString(address, ""), ".")
+	for len(parts) > 2 && parts[0] == "module" {
+		call := module.ModuleCalls[parts[1]]
+		calls = append(calls, call)
+		paths = append(paths, Qualify(paths[len(paths)-1], "module."+parts[1]))
+		module = call.Module
+		parts = parts[2:]
+	}
+
+	var references []string
+	var follow func(depth int, refs []string)
+	follow = func(depth int, refs []string) {
+		for _, ref := range refs {
+			variable, isVar := strings.CutPrefix(ref, "var.")
+			if !isVar || depth == 0 {
+				references = append(references, Qualify(paths[depth], ref))
+				continue
+			}
+			input := calls[depth-1].Expressions[strings.Split(variable, ".")[0]]
+			if input != nil && input.ExpressionData != nil {
+				follow(depth-1, input.References)
+			}
+		}
+	}
+	follow(len(calls), expression.References)
+	return references, nil
+}
+
+// AttributeReferencesE returns an error unless an argument of the resource at a
+// planned address refers to reference, directly or through module variables
+func AttributeReferencesE(plan *terraform.PlanStruct, address, name, reference string) error {
+	references, err := References(plan, address, name)
+	if err != nil {
+		return err
+	}
+	for _, r := range references {
+		if r == reference {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s %s: refers to %s, not %s", address, name, strings.Join(references, ", "), reference)
+}
+
+// AttributeReferences asserts an argument of the resource at a planned address
+// refers to reference, directly or through module variables
+func AttributeReferences(t testing.TestingT, plan *terraform.PlanStruct, address, name, reference string) bool {
+	return assert.NoError(t, AttributeReferencesE(plan, address, name, reference))
+}
+
+// Output returns the planned value of a root module output
+func Output(plan *terraform.PlanStruct, name string) (interface{}, error) {
+	if plan.RawPlan.PlannedValues == nil {
+		return nil, fmt.Errorf("plan has no planned values")
+	}
+	output, ok := plan.RawPlan.PlannedValues.Outputs[name]
+	if !ok {
+		return nil, fmt.Errorf("output %s is not in the plan", name)
+	}
+	return output.Value, nil
+}
+
+// ResourceExistsE returns an error if the address is not planned
+func ResourceExistsE(plan *terraform.PlanStruct, address string) error {
+	_, err := Resource(plan, address)
+	return err
+}
+
+// ResourceExists asserts the address is planned
+func ResourceExists(t testing.TestingT, plan *terraform.PlanStruct, address string) bool {
+	return assert.NoError(t, ResourceExistsE(plan, address))
+}
+
+// ResourceAbsentE returns an error if the address is planned
+func ResourceAbsentE(plan *terraform.PlanStruct, address string) error {
+	if _, ok := plan.ResourcePlannedValuesMap[address]; ok {
+		return fmt.Errorf("resource %s is in the plan but should not be", address)
+	}
+	return nil
+}
+
+// ResourceAbsent asserts the address is not planned
+func ResourceAbsent(t testing.TestingT, plan *terraform.PlanStruct, address string) bool {
+	return assert.NoError(t, ResourceAbsentE(plan, address))
+}
+
+// ResourceCountE returns an error unless exactly count resources of the type are planned
+func ResourceCountE(plan *terraform.PlanStruct, resourceType string, count int) error {
+	resources := ResourcesOfType(plan, resourceType)
+	if len(resources) != count {
+		addresses := make([]string, 0, len(resources))
+		for _, resource := range resources {
+			addresses = append(addresses, resource.Address)
+		}
+		return fmt.Errorf("expected %d %s resources, planned %d: %s",
+			count, resourceType, len(resources), strings.Join(addresses, ", "))
+	}
+	return nil
+}
+
+// ResourceCount asserts exactly count resources of the type are planned
+func ResourceCount(t testing.TestingT, plan *terraform.PlanStruct, resourceType string, count int) bool {
+	return assert.NoError(t, ResourceCountE(plan, resourceType, count))
+}
+
+// AttributeEqualsE returns an error unless the attribute at path equals expected
+func AttributeEqualsE(plan *terraform.PlanStruct, address string, path string, expected interface{}) error {
+	actual, err := Attribute(plan, address, path)
+	if err != nil {
+		return err
+	}
+	if !Equal(expected, actual) {
+		return fmt.Errorf("%s %s: expected %#v, planned %#v", address, path, expected, actual)
+	}
+	return nil
+}
+
+// AttributeEquals asserts the attribute at path equals expected
+func AttributeEquals(t testing.TestingT, plan *terraform.PlanStruct, address string, path string, expected interface{}) bool {
+	return assert.NoError(t, AttributeEqualsE(plan, address, path, expected))
+}
+
+// AttributeContainsE returns an error unless the list or string attribute at
+// path contains element
+func AttributeContainsE(plan *terraform.PlanStruct, address string, path string, element interface{}) error {
+	actual, err := Attribute(plan, address, path)
+	if err != nil {
+		return err
+	}
+	if !contains(actual, element) {
+		return fmt.Errorf("%s %s: %#v does not contain %#v", address, path, actual, element)
+	}
+	return nil
+}
+
+// AttributeContains asserts the list or string attribute at path contains element
+func AttributeContains(t testing.TestingT, plan *terraform.PlanStruct, address string, path string, element interface{}) bool {
+	return assert.NoError(t, AttributeContainsE(plan, address, path, element))
+}
+
+// OutputEqualsE returns an error unless the root output equals expected
+func OutputEqualsE(plan *terraform.PlanStruct, name string, expected interface{}) error {
+	actual, err := Output(plan, name)
+	if err != nil {
+		return err
+	}
+	if !Equal(expected, actual) {
+		return fmt.Errorf("output %s: expected %#v, planned %#v", name, expected, actual)
+	}
+	return nil
+}
+
+// OutputEquals asserts the root output equals expected
+func OutputEquals(t testing.TestingT, plan *terraform.PlanStruct, name string, expected interface{}) bool {
+	return assert.NoError(t, OutputEqualsE(plan, name, expected))
+}
+
+// NoAttributeValueE returns an error if any planned resource of the type holds
+// value at path, or inside a list at path
+func NoAttributeValueE(plan *terraform.PlanStruct, resourceType string, path string, value interface{}) error {
+	var offenders []string
+	for _, resource := range ResourcesOfType(plan, resourceType) {
+		actual, ok := Lookup(resource.AttributeValues, path)
+		if !ok {
+			continue
+		}
+		if Equal(value, actual) || contains(actual, value) {
+			offenders = append(offenders, resource.Address)
+		}
+	}
+	if len(offenders) > 0 {
+		return fmt.Errorf("%s %s must not be %#v: %s", resourceType, path, value, strings.Join(offenders, ", "))
+	}
+	return nil
+}
+
+// NoAttributeValue asserts no planned resource of the type holds value at path
+func NoAttributeValue(t testing.TestingT, plan *terraform.PlanStruct, resourceType string, path string, value interface{}) bool {
+	return assert.NoError(t, NoAttributeValueE(plan, resourceType, path, value))
+}
+
+// Equal compares an expected Go value with a decoded JSON value, treating all
+// numeric types as float64 the way encoding/json decodes them
+func Equal(expected, actual interface{}) bool {
+	return reflect.DeepEqual(normalize(expected), normalize(actual))
+}
+
+func contains(haystack, needle interface{}) bool {
+	switch h := haystack.(type) {
+	case []interface{}:
+		for _, element := range h {
+			if Equal(needle, element) {
+				return true
+			}
+		}
+	case string:
+		if s, ok := needle.(string); ok {
+			return strings.Contains(h, s)
+		}
+	}
+	return false
+}
+
+func normalize(value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Slice, reflect.Array:
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = normalize(v.Index(i).Interface())
+		}
+		return out
+	case reflect.Map:
+		out := make(map[string]interface{}, v.Len())
+		for _, key := range v.MapKeys() {
+			out[fmt.Sprint(key.Interface())] = normalize(v.MapIndex(key).Interface())
+		}
+		return out
+	}
+	return value
+}
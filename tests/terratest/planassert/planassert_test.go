@@ -0,0 +1,160 @@
+package planassert
+
+import (
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	instanceAddress = "module.cloudsql.google_sql_database_instance.instance"
+	subnetAddress   = `module.network.google_compute_subnetwork.subnets["test-vpc-public"]`
+)
+
+// TestResourceExists tests address lookups across child modules
+func TestResourceExists(t *testing.T) {
+	t.Parallel()
+	plan := LoadPlanFile(t, "testdata/plan.json")
+
+	ResourceExists(t, plan, instanceAddress)
+	ResourceExists(t, plan, subnetAddress)
+	assert.Error(t, ResourceExistsE(plan, "module.cloudsql.google_sql_database_instance.read_replica[0]"))
+	ResourceAbsent(t, plan, "module.cloudsql.google_sql_database_instance.read_replica[0]")
+	assert.Error(t, ResourceAbsentE(plan, instanceAddress))
+}
+
+// TestResourceCount tests counting resources by type
+func TestResourceCount(t *testing.T) {
+	t.Parallel()
+	plan := LoadPlanFile(t, "testdata/plan.json")
+
+	ResourceCount(t, plan, "google_compute_firewall", 2)
+	ResourceCount(t, plan, "google_compute_router", 0)
+	assert.Error(t, ResourceCountE(plan, "google_compute_firewall", 6))
+}
+
+// TestAttributeEquals tests nested attribute lookups and number handling
+func TestAttributeEquals(t *testing.T) {
+	t.Parallel()
+	plan := LoadPlanFile(t, "testdata/plan.json")
+
+	AttributeEquals(t, plan, instanceAddress, "settings.0.availability_type", "REGIONAL")
+	AttributeEquals(t, plan, instanceAddress, "settings.0.disk_size", 100)
+	AttributeEquals(t, plan, instanceAddress, "settings.0.ip_configuration.0.ipv4_enabled", false)
+	AttributeEquals(t, plan, instanceAddress, "deletion_protection", true)
+	AttributeEquals(t, plan, subnetAddress, "ip_cidr_range", "10.0.1.0/24")
+
+	assert.Error(t, AttributeEqualsE(plan, instanceAddress, "settings.0.availability_type", "ZONAL"))
+	assert.Error(t, AttributeEqualsE(plan, instanceAddress, "settings.1.tier", "db-custom-2-4096"))
+	assert.Error(t, AttributeEqualsE(plan, instanceAddress, "settings.0.missing", nil))
+}
+
+// TestAttributeContains tests list and substring membership
+func TestAttributeContains(t *testing.T) {
+	t.Parallel()
+	plan := LoadPlanFile(t, "testdata/plan.json")
+
+	address := "module.network.google_compute_firewall.allow_iap"
+	AttributeContains(t, plan, address, "allow.0.ports", "3389")
+	AttributeContains(t, plan, instanceAddress, "settings.0.tier", "custom-2")
+	assert.Error(t, AttributeContainsE(plan, address, "allow.0.ports", "80"))
+}
+
+// TestNoAttributeValue tests type-wide negative checks
+func TestNoAttributeValue(t *testing.T) {
+	t.Parallel()
+	plan := LoadPlanFile(t, "testdata/plan.json")
+
+	NoAttributeValue(t, plan, "google_compute_firewall", "source_ranges", "0.0.0.0/0")
+	assert.Error(t, NoAttributeValueE(plan, "google_compute_firewall", "source_ranges", "35.235.240.0/20"))
+}
+
+// TestLookupWildcard tests "*" segments over lists
+func TestLookupWildcard(t *testing.T) {
+	t.Parallel()
+	plan := LoadPlanFile(t, "testdata/plan.json")
+
+	resource, err := Resource(plan, instanceAddress)
+	require.NoError(t, err)
+
+	value, ok := Lookup(resource.AttributeValues, "settings.*.ip_configuration.*.ipv4_enabled")
+	require.True(t, ok)
+	assert.True(t, Equal([]interface{}{[]interface{}{false}}, value))
+
+	_, ok = Lookup(resource.AttributeValues, "settings.x")
+	assert.False(t, ok)
+}
+
+// TestConfigResource tests planned addresses are resolved to their module's
+// configuration without count and for_each keys
+func TestConfigResource(t *testing.T) {
+	t.Parallel()
+	plan := LoadPlanFile(t, "testdata/plan.json")
+
+	resource := ConfigResource(plan, "module.cloudsql.google_sql_database_instance.read_replica[0]")
+	require.NotNil(t, resource)
+	assert.Contains(t, resource.Expressions, "master_instance_name")
+	assert.NotNil(t, ConfigResource(plan, instanceAddress))
+	assert.Nil(t, ConfigResource(plan, subnetAddress))
+	assert.Nil(t, ConfigResource(plan, "google_sql_database_instance.instance"))
+	assert.Nil(t, ConfigResource(&terraform.PlanStruct{}, instanceAddress))
+}
+
+// TestReferences tests references are qualified with the module path and
+// module variables are followed to what the caller passes in
+func TestReferences(t *testing.T) {
+	t.Parallel()
+	plan := LoadPlanFile(t, "testdata/plan.json")
+
+	references, err := References(plan, "module.cloudsql.google_sql_database_instance.read_replica[0]", "master_instance_name")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"module.cloudsql.google_sql_database_instance.instance.name",
+		"module.cloudsql.google_sql_database_instance.instance",
+	}, references)
+
+	// name = var.instance_name, set from random_id.suffix.hex in the root
+	AttributeReferences(t, plan, instanceAddress, "name", "random_id.suffix")
+	assert.EqualError(t, AttributeReferencesE(plan, instanceAddress, "name", "var.instance_name"),
+		instanceAddress+" name: refers to random_id.suffix.hex, random_id.suffix, not var.instance_name")
+	assert.Error(t, AttributeReferencesE(plan, instanceAddress, "region", "var.region"))
+	assert.Error(t, AttributeReferencesE(plan, subnetAddress, "network", "google_compute_network.vpc"))
+}
+
+// TestLoadPlanFile tests missing and malformed plans are errors naming the file
+func TestLoadPlanFile(t *testing.T) {
+	t.Parallel()
+
+	_, err := LoadPlanFileE("testdata/missing.json")
+	assert.Error(t, err)
+	_, err = LoadPlanFileE("planassert.go")
+	assert.ErrorContains(t, err, "planassert.go: ")
+}
+
+// TestValueHelpers tests string lookups, link names and module paths
+func TestValueHelpers(t *testing.T) {
+	t.Parallel()
+	plan := LoadPlanFile(t, "testdata/plan.json")
+
+	resource, err := Resource(plan, subnetAddress)
+	require.NoError(t, err)
+	assert.Equal(t, "module.network", ModulePath(resource))
+	assert.Equal(t, "test-vpc-public", String(resource.AttributeValues, "name"))
+	assert.Empty(t, String(resource.AttributeValues, "missing"))
+
+	assert.Equal(t, "test-vpc", LastSegment("projects/p/global/networks/test-vpc"))
+	assert.Equal(t, "test-vpc", LastSegment("test-vpc"))
+	assert.Equal(t, "module.a.var.x", Qualify("module.a", "var.x"))
+	assert.Equal(t, "var.x", Qualify("", "var.x"))
+}
+
+// TestOutputEquals tests root output lookups
+func TestOutputEquals(t *testing.T) {
+	t.Parallel()
+	plan := LoadPlanFile(t, "testdata/plan.json")
+
+	OutputEquals(t, plan, "vpc_name", "test-vpc")
+	assert.Error(t, OutputEqualsE(plan, "vpc_id", "anything"))
+}
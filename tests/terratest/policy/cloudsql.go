@@ -0,0 +1,237 @@
+package policy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	tfjson "github.com/hashicorp/terraform-json"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+	"github.com/unicredit/gcp-migration/tests/terratest/sqlflags"
+	"github.com/unicredit/gcp-migration/tests/terratest/sqltier"
+)
+
+// CloudSQLConfig tunes the Cloud SQL rules
+type CloudSQLConfig struct {
+	// ProductionEnvironments are the values of the environment user label
+	// the rules apply to; instances without the label are not checked
+	ProductionEnvironments []string
+}
+
+// DefaultCloudSQLConfig checks instances labelled environment=prod or production
+var DefaultCloudSQLConfig = CloudSQLConfig{
+	ProductionEnvironments: []string{"prod", "production"},
+}
+
+// cloudSQLType is the only resource type the Cloud SQL rules check
+const cloudSQLType = "google_sql_database_instance"
+
+// sslModes are the ssl_mode values that refuse unencrypted connections
+var sslModes = map[string]bool{
+	"ENCRYPTED_ONLY":                      true,
+	"TRUSTED_CLIENT_CERTIFICATE_REQUIRED": true,
+}
+
+// publicPrefix is the longest prefix of a public authorized network that
+// spans so much of the internet it is effectively open to it
+const publicPrefix = 8
+
+func init() {
+	mustRegister(CloudSQLRules(DefaultCloudSQLConfig)...)
+	mustRegister(Rule{
+		ID:          "cloudsql-valid-tier",
+		Severity:    High,
+		Description: "Cloud SQL tiers must exist and meet GCP's sizing constraints for the engine",
+		Types:       []string{cloudSQLType},
+		Check: func(resource *tfjson.StateResource) []string {
+			name := setting(resource.AttributeValues, "tier")
+			if name == "" {
+				return nil
+			}
+			tier, err := sqltier.Parse(name)
+			if err != nil {
+				return []string{err.Error()}
+			}
+			version, _ := resource.AttributeValues["database_version"].(string)
+			var messages []string
+			for _, problem := range tier.Validate(version) {
+				messages = append(messages, name+": "+problem)
+			}
+			return messages
+		},
+	})
+}
+
+// CloudSQLRules returns the Cloud SQL rules for production instances.
+// Backup, availability and maintenance settings are not checked on read
+// replicas, which take them from their primary.
+func CloudSQLRules(config CloudSQLConfig) []Rule {
+	production := map[string]bool{}
+	for _, environment := range config.ProductionEnvironments {
+		production[environment] = true
+	}
+	check := func(primaryOnly bool, check func(values map[string]interface{}) []string) func(*tfjson.StateResource) []string {
+		return func(resource *tfjson.StateResource) []string {
+			values := resource.AttributeValues
+			environment, _ := planassert.Lookup(values, "settings.0.user_labels.environment")
+			if name, _ := environment.(string); !production[name] {
+				return nil
+			}
+			if replica, _ := values["master_instance_name"].(string); primaryOnly && replica != "" {
+				return nil
+			}
+			return check(values)
+		}
+	}
+
+	return []Rule{
+		{
+			ID:          "cloudsql-deletion-protection",
+			Severity:    High,
+			Description: "Production Cloud SQL instances must set deletion_protection",
+			Types:       []string{cloudSQLType},
+			Check: check(false, func(values map[string]interface{}) []string {
+				if enabled, _ := values["deletion_protection"].(bool); !enabled {
+					return []string{"deletion_protection is off"}
+				}
+				return nil
+			}),
+		},
+		{
+			ID:          "cloudsql-regional-availability",
+			Severity:    High,
+			Description: "Production Cloud SQL primaries must be REGIONAL",
+			Types:       []string{cloudSQLType},
+			Check: check(true, func(values map[string]interface{}) []string {
+				if availability := setting(values, "availability_type"); availability != "REGIONAL" {
+					return []string{fmt.Sprintf("availability_type is %q", availability)}
+				}
+				return nil
+			}),
+		},
+		{
+			ID:          "cloudsql-backups",
+			Severity:    High,
+			Description: "Production Cloud SQL primaries must enable backups, and point-in-time recovery for PostgreSQL",
+			Types:       []string{cloudSQLType},
+			Check: check(true, func(values map[string]interface{}) []string {
+				if enabled, _ := lookupBool(values, "settings.0.backup_configuration.0.enabled"); !enabled {
+					return []string{"backups are disabled"}
+				}
+				version, _ := values["database_version"].(string)
+				if pitr, _ := lookupBool(values, "settings.0.backup_configuration.0.point_in_time_recovery_enabled"); strings.HasPrefix(version, "POSTGRES") && !pitr {
+					return []string{"point-in-time recovery is disabled"}
+				}
+				return nil
+			}),
+		},
+		{
+			ID:          "cloudsql-no-public-ip",
+			Severity:    High,
+			Description: "Production Cloud SQL instances must only have a private IP",
+			Types:       []string{cloudSQLType},
+			Check: check(false, func(values map[string]interface{}) []string {
+				if enabled, _ := lookupBool(values, "settings.0.ip_configuration.0.ipv4_enabled"); enabled {
+					return []string{"ipv4_enabled gives the instance a public IP"}
+				}
+				return nil
+			}),
+		},
+		{
+			ID:          "cloudsql-require-ssl",
+			Severity:    High,
+			Description: "Production Cloud SQL instances must refuse unencrypted connections",
+			Types:       []string{cloudSQLType},
+			Check: check(false, func(values map[string]interface{}) []string {
+				required, _ := lookupBool(values, "settings.0.ip_configuration.0.require_ssl")
+				mode, _ := planassert.Lookup(values, "settings.0.ip_configuration.0.ssl_mode")
+				if name, _ := mode.(string); !required && !sslModes[name] {
+					return []string{"neither require_ssl nor an encrypted ssl_mode is set"}
+				}
+				return nil
+			}),
+		},
+		{
+			ID:          "cloudsql-no-open-authorized-networks",
+			Severity:    Critical,
+			Description: fmt.Sprintf("Production Cloud SQL instances must not authorize the internet or public ranges of /%d or wider", publicPrefix),
+			Types:       []string{cloudSQLType},
+			Check: check(false, func(values map[string]interface{}) []string {
+				networks, _ := planassert.Lookup(values, "settings.0.ip_configuration.0.authorized_networks")
+				list, _ := networks.([]interface{})
+				return openAuthorizedNetworks(list)
+			}),
+		},
+		{
+			ID:          "cloudsql-database-flags",
+			Severity:    High,
+			Description: "Production Cloud SQL database flags must be in the catalog for the engine and version, valid, and not restart the instance",
+			Types:       []string{cloudSQLType},
+			Check: check(false, func(values map[string]interface{}) []string {
+				version, _ := values["database_version"].(string)
+				problems, err := sqlflags.Validate(version, sqlflags.FromValues(values), true)
+				if err != nil {
+					return []string{err.Error()}
+				}
+				return problems
+			}),
+		},
+		{
+			ID:          "cloudsql-maintenance-window",
+			Severity:    Medium,
+			Description: "Production Cloud SQL primaries must set a maintenance window",
+			Types:       []string{cloudSQLType},
+			Check: check(true, func(values map[string]interface{}) []string {
+				if day, _ := planassert.Lookup(values, "settings.0.maintenance_window.0.day"); day == nil {
+					return []string{"no maintenance window, so updates can happen at any time"}
+				}
+				return nil
+			}),
+		},
+	}
+}
+
+// openAuthorizedNetworks returns a message for every authorized network that
+// is public and /publicPrefix or wider, and one more when the networks only
+// cover the whole internet together. Values that are not CIDRs are left to
+// the API to reject.
+func openAuthorizedNetworks(list []interface{}) []string {
+	var messages []string
+	var ranges []*net.IPNet
+	whole := false
+	for _, n := range list {
+		network, _ := n.(map[string]interface{})
+		name, _ := network["name"].(string)
+		cidr, _ := network["value"].(string)
+		_, parsed, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		ranges = append(ranges, parsed)
+		switch ones, _ := parsed.Mask.Size(); {
+		case ones == 0:
+			whole = true
+			messages = append(messages, fmt.Sprintf("authorized network %q is %s", name, cidr))
+		case ones <= publicPrefix && !isPrivate(parsed):
+			messages = append(messages, fmt.Sprintf("authorized network %q is %s, which is effectively public", name, cidr))
+		}
+	}
+	if !whole && coversInternet(ranges) {
+		messages = append(messages, "authorized networks together cover the whole internet")
+	}
+	return messages
+}
+
+// setting returns a string attribute of the instance's settings block
+func setting(values map[string]interface{}, name string) string {
+	value, _ := planassert.Lookup(values, "settings.0."+name)
+	s, _ := value.(string)
+	return s
+}
+
+func lookupBool(values map[string]interface{}, path string) (bool, bool) {
+	value, found := planassert.Lookup(values, path)
+	b, ok := value.(bool)
+	return b, found && ok
+}
@@ -0,0 +1,101 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// TestCloudSQLRules tests only production instances are checked and replicas
+// skip the backup, availability and maintenance rules
+func TestCloudSQLRules(t *testing.T) {
+	t.Parallel()
+
+	registry, err := NewRegistry(CloudSQLRules(DefaultCloudSQLConfig)...)
+	require.NoError(t, err)
+
+	var got []string
+	for _, finding := range registry.Evaluate(planassert.LoadPlanFile(t, "testdata/cloudsql.json")) {
+		got = append(got, finding.Rule+" "+finding.Address+": "+finding.Message)
+	}
+	assert.ElementsMatch(t, []string{
+		`cloudsql-no-open-authorized-networks google_sql_database_instance.prod_bad: authorized network "anywhere" is 0.0.0.0/0`,
+		"cloudsql-deletion-protection google_sql_database_instance.prod_bad: deletion_protection is off",
+		`cloudsql-regional-availability google_sql_database_instance.prod_bad: availability_type is "ZONAL"`,
+		"cloudsql-backups google_sql_database_instance.prod_bad: point-in-time recovery is disabled",
+		"cloudsql-no-public-ip google_sql_database_instance.prod_bad: ipv4_enabled gives the instance a public IP",
+		"cloudsql-require-ssl google_sql_database_instance.prod_bad: neither require_ssl nor an encrypted ssl_mode is set",
+		"cloudsql-maintenance-window google_sql_database_instance.prod_bad: no maintenance window, so updates can happen at any time",
+		`cloudsql-database-flags google_sql_database_instance.prod_bad: flag "max_connections" restarts the instance when changed`,
+		`cloudsql-database-flags google_sql_database_instance.prod_bad: unknown flag "log_statment" for POSTGRES_15`,
+		`cloudsql-database-flags google_sql_database_instance.prod_sqlserver: flag "log_connections" is PostgreSQL-only`,
+		// SQL Server has no point-in-time recovery to check, and ssl_mode suffices
+		"cloudsql-backups google_sql_database_instance.prod_sqlserver: backups are disabled",
+		// 10.0.0.0/8 is as wide but private
+		`cloudsql-no-open-authorized-networks google_sql_database_instance.prod_sqlserver: authorized network "partners" is 64.0.0.0/6, which is effectively public`,
+	}, got)
+}
+
+// TestOpenAuthorizedNetworks tests wide public networks and sets covering the
+// internet are flagged
+func TestOpenAuthorizedNetworks(t *testing.T) {
+	t.Parallel()
+
+	network := func(name, cidr string) interface{} {
+		return map[string]interface{}{"name": name, "value": cidr}
+	}
+	testCases := []struct {
+		name     string
+		networks []interface{}
+		messages []string
+	}{
+		{"office", []interface{}{network("office", "203.0.113.0/24")}, nil},
+		{"private", []interface{}{network("corp", "10.0.0.0/8"), network("ula", "fd00::/8")}, nil},
+		{"narrow_public", []interface{}{network("partners", "64.0.0.0/9")}, nil},
+		{"ipv6_anywhere", []interface{}{network("anywhere", "::/0")}, []string{`authorized network "anywhere" is ::/0`}},
+		{"wide_public", []interface{}{network("partners", "64.0.0.0/8")}, []string{`authorized network "partners" is 64.0.0.0/8, which is effectively public`}},
+		{"split", []interface{}{network("low", "0.0.0.0/1"), network("high", "128.0.0.0/1")}, []string{
+			`authorized network "low" is 0.0.0.0/1, which is effectively public`,
+			`authorized network "high" is 128.0.0.0/1, which is effectively public`,
+			"authorized networks together cover the whole internet",
+		}},
+		{"invalid", []interface{}{network("typo", "0.0.0.0")}, nil},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.messages, openAuthorizedNetworks(tc.networks), tc.name)
+	}
+}
+
+// TestCloudSQLConfig tests which environments count as production
+func TestCloudSQLConfig(t *testing.T) {
+	t.Parallel()
+
+	registry, err := NewRegistry(CloudSQLRules(CloudSQLConfig{ProductionEnvironments: []string{"dev"}})...)
+	require.NoError(t, err)
+
+	addresses := map[string]bool{}
+	for _, finding := range registry.Evaluate(planassert.LoadPlanFile(t, "testdata/cloudsql.json")) {
+		addresses[finding.Address] = true
+	}
+	assert.Equal(t, map[string]bool{"google_sql_database_instance.dev_bad": true}, addresses)
+}
+
+// TestCloudSQLTierRule tests tiers are validated in every environment
+func TestCloudSQLTierRule(t *testing.T) {
+	t.Parallel()
+
+	selected, err := Default.Select("cloudsql-valid-tier")
+	require.NoError(t, err)
+
+	var got []string
+	for _, finding := range selected.Evaluate(planassert.LoadPlanFile(t, "testdata/cloudsql.json")) {
+		got = append(got, finding.Address+": "+finding.Message)
+	}
+	assert.Equal(t, []string{
+		"google_sql_database_instance.dev_bad: db-custom-3-8000: 3 vCPUs: want 1 or an even number up to 96",
+		"google_sql_database_instance.dev_bad: db-custom-3-8000: 8000 MB is not a multiple of 256 MB",
+	}, got)
+}
@@ -0,0 +1,283 @@
+package policy
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+
+	tfjson "github.com/hashicorp/terraform-json"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/firewall"
+)
+
+// FirewallConfig tunes the firewall rules
+type FirewallConfig struct {
+	// WorldOpenPorts may be reachable from 0.0.0.0/0, as "protocol:port"
+	WorldOpenPorts []string
+	// AdminPorts are the TCP ports only AdminSourceRanges may reach
+	AdminPorts []int
+	// AdminSourceRanges are the ranges allowed to reach AdminPorts
+	AdminSourceRanges []string
+	// ProbeSourceRanges are Google health check ranges. Probes never open
+	// interactive sessions, so they are exempt from the admin port rules.
+	ProbeSourceRanges []string
+	// MinInternalPrefix is the shortest prefix a private source range may
+	// have, e.g. 16 flags 10.0.0.0/8 but not 10.20.0.0/16
+	MinInternalPrefix int
+}
+
+// DefaultFirewallConfig allows HTTP and HTTPS from anywhere and SSH and RDP
+// through IAP TCP forwarding only
+var DefaultFirewallConfig = FirewallConfig{
+	WorldOpenPorts:    []string{"tcp:80", "tcp:443"},
+	AdminPorts:        []int{22, 3389},
+	AdminSourceRanges: []string{"35.235.240.0/20"},
+	ProbeSourceRanges: []string{"130.211.0.0/22", "35.191.0.0/16"},
+	MinInternalPrefix: 16,
+}
+
+// firewallType is the only resource type the firewall rules check
+const firewallType = "google_compute_firewall"
+
+func init() {
+	rules, err := FirewallRules(DefaultFirewallConfig)
+	if err != nil {
+		panic(err)
+	}
+	mustRegister(rules...)
+}
+
+// FirewallRules returns the firewall exposure rules for config. Egress and
+// disabled firewall rules are not checked.
+func FirewallRules(config FirewallConfig) ([]Rule, error) {
+	exemptRanges, err := parseCIDRs(append(append([]string{}, config.AdminSourceRanges...), config.ProbeSourceRanges...))
+	if err != nil {
+		return nil, err
+	}
+	worldOpenPorts := map[string]bool{}
+	for _, port := range config.WorldOpenPorts {
+		worldOpenPorts[strings.ToLower(port)] = true
+	}
+
+	return []Rule{
+		{
+			ID:          "firewall-world-open-ingress",
+			Severity:    Critical,
+			Description: fmt.Sprintf("Ingress from the whole internet may only allow %s", strings.Join(config.WorldOpenPorts, ", ")),
+			Types:       []string{firewallType},
+			Check: ingressCheck(func(rule firewall.Rule) []string {
+				if !coversInternet(rule.SourceRanges) {
+					return nil
+				}
+				var messages []string
+				for _, protocol := range rule.Protocols {
+					if open, ok := disallowed(protocol, worldOpenPorts); ok {
+						messages = append(messages, fmt.Sprintf("%s open to the internet", open))
+					}
+				}
+				return messages
+			}),
+		},
+		{
+			ID:          "firewall-admin-ports-iap-only",
+			Severity:    High,
+			Description: fmt.Sprintf("SSH and RDP ingress from public ranges may only come from %s", strings.Join(config.AdminSourceRanges, ", ")),
+			Types:       []string{firewallType},
+			Check: ingressCheck(func(rule firewall.Rule) []string {
+				ports := openTCP(rule, config.AdminPorts)
+				if ports == "" {
+					return nil
+				}
+				var messages []string
+				for _, source := range rule.SourceRanges {
+					if !withinAny(source, exemptRanges) && !isPrivate(source) {
+						messages = append(messages, fmt.Sprintf("tcp:%s reachable from %s", ports, source))
+					}
+				}
+				return messages
+			}),
+		},
+		{
+			ID:          "firewall-admin-ports-internal",
+			Severity:    Medium,
+			Description: "SSH and RDP ingress should come through IAP rather than internal ranges",
+			Types:       []string{firewallType},
+			Check: ingressCheck(func(rule firewall.Rule) []string {
+				ports := openTCP(rule, config.AdminPorts)
+				if ports == "" {
+					return nil
+				}
+				var messages []string
+				for _, source := range rule.SourceRanges {
+					if !withinAny(source, exemptRanges) && isPrivate(source) {
+						messages = append(messages, fmt.Sprintf("tcp:%s reachable from %s", ports, source))
+					}
+				}
+				return messages
+			}),
+		},
+		{
+			ID:          "firewall-broad-internal-range",
+			Severity:    Medium,
+			Description: fmt.Sprintf("Private source ranges should be /%d or narrower", config.MinInternalPrefix),
+			Types:       []string{firewallType},
+			Check: ingressCheck(func(rule firewall.Rule) []string {
+				var messages []string
+				for _, source := range rule.SourceRanges {
+					if ones, _ := source.Mask.Size(); isPrivate(source) && ones < config.MinInternalPrefix {
+						messages = append(messages, fmt.Sprintf("source range %s is broader than /%d", source, config.MinInternalPrefix))
+					}
+				}
+				return messages
+			}),
+		},
+		{
+			ID:          "firewall-unparsable",
+			Severity:    High,
+			Description: "Source ranges and ports must parse, so no exposure goes unchecked",
+			Types:       []string{firewallType},
+			Check: func(resource *tfjson.StateResource) []string {
+				_, problems := firewall.Parse(resource)
+				return problems
+			},
+		},
+		{
+			ID:          "firewall-no-target",
+			Severity:    Medium,
+			Description: "Ingress rules should be scoped with target_tags or target_service_accounts",
+			Types:       []string{firewallType},
+			Check: ingressCheck(func(rule firewall.Rule) []string {
+				if rule.Targeted() {
+					return nil
+				}
+				return []string{"applies to every instance in the network"}
+			}),
+		},
+	}, nil
+}
+
+// ingressCheck adapts a check of one parsed firewall rule to a Rule Check,
+// skipping egress, deny and disabled rules, which cannot expose anything
+func ingressCheck(check func(firewall.Rule) []string) func(*tfjson.StateResource) []string {
+	return func(resource *tfjson.StateResource) []string {
+		rule, _ := firewall.Parse(resource)
+		if !rule.Ingress || rule.Deny || rule.Disabled {
+			return nil
+		}
+		return check(rule)
+	}
+}
+
+// coversInternet reports whether the ranges together cover all of IPv4 or
+// all of IPv6, as 0.0.0.0/0 does alone or 0.0.0.0/1 and 128.0.0.0/1 do
+// together
+func coversInternet(ranges []*net.IPNet) bool {
+	for _, family := range []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")} {
+		var prefixes []netip.Prefix
+		for _, r := range ranges {
+			ip, ok := netip.AddrFromSlice(r.IP)
+			if !ok {
+				continue
+			}
+			ones, _ := r.Mask.Size()
+			if ip = ip.Unmap(); ip.Is4() == family.Addr().Is4() {
+				prefixes = append(prefixes, netip.PrefixFrom(ip, ones).Masked())
+			}
+		}
+		sort.Slice(prefixes, func(i, j int) bool { return prefixes[i].Addr().Less(prefixes[j].Addr()) })
+
+		next := family.Addr()
+		for _, prefix := range prefixes {
+			if next.Less(prefix.Addr()) {
+				break
+			}
+			end := lastAddr(prefix)
+			if !end.Less(next) {
+				if !end.Next().IsValid() {
+					return true
+				}
+				next = end.Next()
+			}
+		}
+	}
+	return false
+}
+
+// lastAddr returns the highest address in prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(bytes)*8; bit++ {
+		bytes[bit/8] |= 0x80 >> (bit % 8)
+	}
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+// disallowed returns the part of protocol outside the allowed "protocol:port"
+// set, with every port when the block does not list any
+func disallowed(protocol firewall.Protocol, allowed map[string]bool) (firewall.Protocol, bool) {
+	if len(protocol.Ports) == 0 {
+		return protocol, true
+	}
+	open := firewall.Protocol{Name: protocol.Name}
+	for _, r := range protocol.Ports {
+		for port := r.From; port <= r.To; port++ {
+			if allowed[protocol.Name+":"+strconv.Itoa(port)] {
+				continue
+			}
+			if n := len(open.Ports); n > 0 && open.Ports[n-1].To == port-1 {
+				open.Ports[n-1].To = port
+			} else {
+				open.Ports = append(open.Ports, firewall.PortRange{From: port, To: port})
+			}
+		}
+	}
+	return open, len(open.Ports) > 0
+}
+
+// openTCP returns which of ports the rule opens, comma separated
+func openTCP(rule firewall.Rule, ports []int) string {
+	var open []string
+	for _, port := range ports {
+		if rule.Covers("tcp", port) {
+			open = append(open, strconv.Itoa(port))
+		}
+	}
+	return strings.Join(open, ",")
+}
+
+func parseCIDRs(ranges []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, r := range ranges {
+		_, network, err := net.ParseCIDR(r)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// withinAny reports whether network lies entirely inside one of ranges
+func withinAny(network *net.IPNet, ranges []*net.IPNet) bool {
+	ones, _ := network.Mask.Size()
+	for _, r := range ranges {
+		rangeOnes, _ := r.Mask.Size()
+		if r.Contains(network.IP) && rangeOnes <= ones {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrivate reports whether network lies in RFC 1918 or RFC 4193 space
+func isPrivate(network *net.IPNet) bool {
+	last := make(net.IP, len(network.IP))
+	for i := range network.IP {
+		last[i] = network.IP[i] | ^network.Mask[i]
+	}
+	return network.IP.IsPrivate() && last.IsPrivate()
+}
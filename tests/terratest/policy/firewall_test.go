@@ -0,0 +1,118 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// firewallFindings evaluates the firewall rules of registry against
+// testdata/firewall.json and returns "rule address: message" per finding
+func firewallFindings(t *testing.T, registry *Registry) []string {
+	var got []string
+	for _, finding := range registry.Evaluate(planassert.LoadPlanFile(t, "testdata/firewall.json")) {
+		got = append(got, finding.Rule+" "+finding.Address+": "+finding.Message)
+	}
+	return got
+}
+
+// TestFirewallRules tests exposures are found on the right addresses and
+// egress, disabled, IAP and health check rules pass
+func TestFirewallRules(t *testing.T) {
+	t.Parallel()
+
+	rules, err := FirewallRules(DefaultFirewallConfig)
+	require.NoError(t, err)
+	registry, err := NewRegistry(rules...)
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{
+		"firewall-world-open-ingress google_compute_firewall.open_ssh: tcp:22 open to the internet",
+		// Only the ports outside the allow-list are reported
+		"firewall-world-open-ingress google_compute_firewall.postgres_world: tcp:5432 open to the internet",
+		"firewall-world-open-ingress google_compute_firewall.split_world: tcp:8000-8080 open to the internet",
+		"firewall-admin-ports-iap-only google_compute_firewall.office_rdp: tcp:3389 reachable from 203.0.113.0/24",
+		"firewall-admin-ports-iap-only google_compute_firewall.open_ssh: tcp:22 reachable from 0.0.0.0/0",
+		`firewall-unparsable google_compute_firewall.typo: unparsable source range "10.0.0.0/33"`,
+		`firewall-unparsable google_compute_firewall.typo: unparsable port "eighty"`,
+		// A block whose ports do not parse is treated as opening every port
+		"firewall-admin-ports-internal google_compute_firewall.typo: tcp:22,3389 reachable from 10.1.2.3/32",
+		"firewall-admin-ports-internal module.network.google_compute_firewall.allow_internal: tcp:22,3389 reachable from 10.0.0.0/8",
+		"firewall-admin-ports-internal module.network.google_compute_firewall.allow_internal: tcp:22,3389 reachable from 172.16.0.0/12",
+		"firewall-admin-ports-internal module.network.google_compute_firewall.allow_internal: tcp:22,3389 reachable from 192.168.0.0/16",
+		"firewall-broad-internal-range module.network.google_compute_firewall.allow_internal: source range 10.0.0.0/8 is broader than /16",
+		"firewall-broad-internal-range module.network.google_compute_firewall.allow_internal: source range 172.16.0.0/12 is broader than /16",
+		"firewall-no-target module.network.google_compute_firewall.allow_internal: applies to every instance in the network",
+	}, firewallFindings(t, registry))
+}
+
+// TestFirewallConfig tests the allow-list and prefix limit are configurable
+func TestFirewallConfig(t *testing.T) {
+	t.Parallel()
+
+	config := DefaultFirewallConfig
+	config.WorldOpenPorts = []string{"tcp:80", "tcp:443", "tcp:5432"}
+	config.MinInternalPrefix = 8
+	rules, err := FirewallRules(config)
+	require.NoError(t, err)
+	registry, err := NewRegistry(rules...)
+	require.NoError(t, err)
+	selected, err := registry.Select("firewall-world-open-ingress", "firewall-broad-internal-range")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"firewall-world-open-ingress google_compute_firewall.open_ssh: tcp:22 open to the internet",
+		"firewall-world-open-ingress google_compute_firewall.split_world: tcp:8000-8080 open to the internet",
+	}, firewallFindings(t, selected))
+
+	config.AdminSourceRanges = []string{"not-a-range"}
+	_, err = FirewallRules(config)
+	assert.Error(t, err)
+}
+
+// TestIsPrivate tests ranges must lie entirely in private space
+func TestIsPrivate(t *testing.T) {
+	t.Parallel()
+
+	for cidr, private := range map[string]bool{
+		"10.0.0.0/8":      true,
+		"10.0.0.0/7":      false,
+		"192.168.1.0/24":  true,
+		"35.235.240.0/20": false,
+		"fd00::/8":        true,
+		"0.0.0.0/0":       false,
+	} {
+		networks, err := parseCIDRs([]string{cidr})
+		require.NoError(t, err)
+		assert.Equal(t, private, isPrivate(networks[0]), cidr)
+	}
+}
+
+// TestCoversInternet tests sets of ranges that together span an address
+// family count as world-open
+func TestCoversInternet(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		ranges []string
+		world  bool
+	}{
+		{[]string{"0.0.0.0/0"}, true},
+		{[]string{"::/0"}, true},
+		{[]string{"0.0.0.0/1", "128.0.0.0/1"}, true},
+		{[]string{"128.0.0.0/2", "0.0.0.0/1", "192.0.0.0/2"}, true},
+		{[]string{"0.0.0.0/1", "10.0.0.0/8", "128.0.0.0/1"}, true},
+		{[]string{"0.0.0.0/1"}, false},
+		{[]string{"0.0.0.0/1", "192.0.0.0/2"}, false},
+		{[]string{"0.0.0.0/1", "8000::/1"}, false},
+		{[]string{"10.0.0.0/8"}, false},
+	}
+	for _, tc := range testCases {
+		networks, err := parseCIDRs(tc.ranges)
+		require.NoError(t, err)
+		assert.Equal(t, tc.world, coversInternet(networks), "%v", tc.ranges)
+	}
+}
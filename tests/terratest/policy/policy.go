@@ -0,0 +1,233 @@
+// Package policy evaluates security guardrails against a terraform plan. Rules
+// are registered by ID with a severity and report findings per resource
+// address, so the same checks run from Go tests and, through
+// cmd/policycheck, against any `terraform show -json` output.
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/gruntwork-io/terratest/modules/testing"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// Severity ranks findings. A check fails on findings at or above a threshold.
+type Severity int
+
+const (
+	Low Severity = iota
+	Medium
+	High
+	Critical
+)
+
+var severityNames = map[Severity]string{
+	Low:      "low",
+	Medium:   "medium",
+	High:     "high",
+	Critical: "critical",
+}
+
+func (s Severity) String() string {
+	if name, ok := severityNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// MarshalText writes the severity name, e.g. in JSON findings
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ParseSeverity reads a severity name
+func ParseSeverity(name string) (Severity, error) {
+	for severity, n := range severityNames {
+		if n == strings.ToLower(strings.TrimSpace(name)) {
+			return severity, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown severity %q (want low, medium, high or critical)", name)
+}
+
+// Rule is a named guardrail checked against each planned resource it applies to
+type Rule struct {
+	ID          string
+	Severity    Severity
+	Description string
+	// Types are the resource types the rule checks. A leading "*" matches
+	// by suffix, e.g. "*_iam_member"; no types means every resource.
+	Types []string
+	// Check returns one message per violation in the resource
+	Check func(resource *tfjson.StateResource) []string
+}
+
+// applies reports whether the rule checks resources of resourceType
+func (r Rule) applies(resourceType string) bool {
+	if len(r.Types) == 0 {
+		return true
+	}
+	for _, t := range r.Types {
+		if t == resourceType || strings.HasPrefix(t, "*") && strings.HasSuffix(resourceType, t[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Finding is one violation of a rule by one resource
+type Finding struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Address  string   `json:"address"`
+	Message  string   `json:"message"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("[%s] %s: %s: %s", f.Severity, f.Rule, f.Address, f.Message)
+}
+
+// Registry holds rules by ID
+type Registry struct {
+	rules map[string]Rule
+}
+
+// NewRegistry returns a registry with the given rules
+func NewRegistry(rules ...Rule) (*Registry, error) {
+	r := &Registry{rules: map[string]Rule{}}
+	for _, rule := range rules {
+		if err := r.Register(rule); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Register adds a rule. IDs must be unique.
+func (r *Registry) Register(rule Rule) error {
+	if rule.ID == "" || rule.Check == nil {
+		return fmt.Errorf("rule %q needs an ID and a Check", rule.ID)
+	}
+	if _, exists := r.rules[rule.ID]; exists {
+		return fmt.Errorf("rule %s is already registered", rule.ID)
+	}
+	r.rules[rule.ID] = rule
+	return nil
+}
+
+// Rules returns the registered rules sorted by ID
+func (r *Registry) Rules() []Rule {
+	rules := make([]Rule, 0, len(r.rules))
+	for _, rule := range r.rules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules
+}
+
+// Select returns a registry with only the given rules
+func (r *Registry) Select(ids ...string) (*Registry, error) {
+	selected := &Registry{rules: map[string]Rule{}}
+	for _, id := range ids {
+		rule, ok := r.rules[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown rule %s", id)
+		}
+		selected.rules[id] = rule
+	}
+	return selected, nil
+}
+
+// Evaluate checks every planned managed resource against every rule and
+// returns the findings sorted by severity, highest first, then address
+func (r *Registry) Evaluate(plan *terraform.PlanStruct) []Finding {
+	var findings []Finding
+	rules := r.Rules()
+	for _, address := range planassert.Addresses(plan) {
+		resource := plan.ResourcePlannedValuesMap[address]
+		if resource.Mode == tfjson.DataResourceMode {
+			continue
+		}
+		for _, rule := range rules {
+			if !rule.applies(resource.Type) {
+				continue
+			}
+			for _, message := range rule.Check(resource) {
+				findings = append(findings, Finding{Rule: rule.ID, Severity: rule.Severity, Address: address, Message: message})
+			}
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Severity != findings[j].Severity {
+			return findings[i].Severity > findings[j].Severity
+		}
+		return findings[i].Address < findings[j].Address
+	})
+	return findings
+}
+
+// Default holds the built-in rules
+var Default = &Registry{rules: map[string]Rule{}}
+
+// mustRegister adds a built-in rule to Default
+func mustRegister(rules ...Rule) {
+	for _, rule := range rules {
+		if err := Default.Register(rule); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// AtLeast returns the findings of severity min or higher
+func AtLeast(findings []Finding, min Severity) []Finding {
+	var selected []Finding
+	for _, finding := range findings {
+		if finding.Severity >= min {
+			selected = append(selected, finding)
+		}
+	}
+	return selected
+}
+
+// findingsError lists findings in an error, or returns nil when there are none
+func findingsError(findings []Finding) error {
+	if len(findings) == 0 {
+		return nil
+	}
+	lines := make([]string, len(findings))
+	for i, finding := range findings {
+		lines[i] = finding.String()
+	}
+	return fmt.Errorf("%d policy findings:\n%s", len(findings), strings.Join(lines, "\n"))
+}
+
+// PassesE returns an error listing findings of the built-in rules at or above min
+func PassesE(plan *terraform.PlanStruct, min Severity) error {
+	return findingsError(AtLeast(Default.Evaluate(plan), min))
+}
+
+// Passes asserts the plan has no built-in rule findings at or above min
+func Passes(t testing.TestingT, plan *terraform.PlanStruct, min Severity) bool {
+	return assert.NoError(t, PassesE(plan, min))
+}
+
+// NoFindingsE returns an error listing findings of the given built-in rules,
+// whatever their severity
+func NoFindingsE(plan *terraform.PlanStruct, ids ...string) error {
+	selected, err := Default.Select(ids...)
+	if err != nil {
+		return err
+	}
+	return findingsError(selected.Evaluate(plan))
+}
+
+// NoFindings asserts the given built-in rules find nothing in the plan
+func NoFindings(t testing.TestingT, plan *terraform.PlanStruct, ids ...string) bool {
+	return assert.NoError(t, NoFindingsE(plan, ids...))
+}
@@ -0,0 +1,80 @@
+package policy
+
+import (
+	"testing"
+
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// TestEvaluate tests the built-in rules across modules, resource types and
+// IAM member, binding and policy forms
+func TestEvaluate(t *testing.T) {
+	t.Parallel()
+
+	var got []string
+	for _, finding := range Default.Evaluate(planassert.LoadPlanFile(t, "testdata/plan.json")) {
+		got = append(got, finding.String())
+	}
+	assert.Equal(t, []string{
+		"[critical] iam-no-public-principals: module.iam.google_project_iam_binding.owners: roles/owner granted to allAuthenticatedUsers",
+		"[critical] iam-no-public-principals: module.iam.google_project_iam_member.public: roles/viewer granted to allUsers",
+		"[high] iam-no-basic-roles: google_storage_bucket_iam_policy.artifacts: basic role roles/editor granted to group:ops@unicredit.example.com",
+		"[high] compute-no-external-ip: module.compute.google_compute_instance_template.template: network_interface.0.access_config",
+		"[high] iam-no-basic-roles: module.iam.google_project_iam_binding.owners: basic role roles/owner granted to user:admin@unicredit.example.com",
+		"[high] iam-no-basic-roles: module.iam.google_project_iam_binding.owners: basic role roles/owner granted to allAuthenticatedUsers",
+	}, got)
+}
+
+// TestPassesE tests the severity threshold and rule selection
+func TestPassesE(t *testing.T) {
+	t.Parallel()
+	plan := planassert.LoadPlanFile(t, "testdata/plan.json")
+
+	err := PassesE(plan, Critical)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2 policy findings")
+
+	assert.NoError(t, PassesE(planassert.LoadPlanFile(t, "testdata/clean.json"), Low))
+	Passes(t, planassert.LoadPlanFile(t, "testdata/clean.json"), Low)
+
+	assert.Error(t, NoFindingsE(plan, "compute-no-external-ip"))
+	assert.ErrorContains(t, NoFindingsE(plan, "no-such-rule"), "unknown rule")
+}
+
+// TestRegistry tests registration, duplicate IDs and type patterns
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	check := func(*tfjson.StateResource) []string { return []string{"found"} }
+	registry, err := NewRegistry(
+		Rule{ID: "any", Severity: Low, Check: check},
+		Rule{ID: "buckets", Severity: Medium, Types: []string{"google_storage_bucket"}, Check: check},
+	)
+	require.NoError(t, err)
+	assert.Error(t, registry.Register(Rule{ID: "any", Check: check}))
+	assert.Error(t, registry.Register(Rule{ID: "no-check"}))
+
+	findings := registry.Evaluate(planassert.LoadPlanFile(t, "testdata/clean.json"))
+	assert.Len(t, findings, 2)
+	assert.Empty(t, AtLeast(findings, Medium))
+
+	assert.True(t, Rule{Types: []string{"*_iam_member"}}.applies("google_storage_bucket_iam_member"))
+	assert.False(t, Rule{Types: []string{"*_iam_member"}}.applies("google_project_iam_binding"))
+}
+
+// TestParseSeverity tests severity names round-trip
+func TestParseSeverity(t *testing.T) {
+	t.Parallel()
+
+	for _, severity := range []Severity{Low, Medium, High, Critical} {
+		parsed, err := ParseSeverity(severity.String())
+		require.NoError(t, err)
+		assert.Equal(t, severity, parsed)
+	}
+	_, err := ParseSeverity("urgent")
+	assert.Error(t, err)
+}
@@ -0,0 +1,115 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+
+	tfjson "github.com/hashicorp/terraform-json"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// iamTypes covers member, binding and policy resources of every IAM-enabled
+// resource type, e.g. google_project_iam_member or google_storage_bucket_iam_binding
+var iamTypes = []string{"*_iam_member", "*_iam_binding", "*_iam_policy"}
+
+// publicPrincipals grant access to anyone, or anyone with a Google account
+var publicPrincipals = map[string]bool{
+	"allUsers":              true,
+	"allAuthenticatedUsers": true,
+}
+
+// basicRoles are the legacy project-wide roles that grant write access to
+// nearly everything
+var basicRoles = map[string]bool{
+	"roles/owner":  true,
+	"roles/editor": true,
+}
+
+func init() {
+	mustRegister(
+		Rule{
+			ID:          "iam-no-public-principals",
+			Severity:    Critical,
+			Description: "IAM grants must not include allUsers or allAuthenticatedUsers",
+			Types:       iamTypes,
+			Check: func(resource *tfjson.StateResource) []string {
+				var messages []string
+				for _, g := range grants(resource) {
+					if publicPrincipals[g.member] {
+						messages = append(messages, fmt.Sprintf("%s granted to %s", g.role, g.member))
+					}
+				}
+				return messages
+			},
+		},
+		Rule{
+			ID:          "iam-no-basic-roles",
+			Severity:    High,
+			Description: "IAM grants must use predefined or custom roles instead of roles/owner or roles/editor",
+			Types:       iamTypes,
+			Check: func(resource *tfjson.StateResource) []string {
+				var messages []string
+				for _, g := range grants(resource) {
+					if basicRoles[g.role] {
+						messages = append(messages, fmt.Sprintf("basic role %s granted to %s", g.role, g.member))
+					}
+				}
+				return messages
+			},
+		},
+		Rule{
+			ID:          "compute-no-external-ip",
+			Severity:    High,
+			Description: "Instances and instance templates must not have external IPs; use Cloud NAT and IAP",
+			Types:       []string{"google_compute_instance", "google_compute_instance_template"},
+			Check:       planassert.ResourceExternalIPs,
+		},
+	)
+}
+
+// grant is one role given to one member
+type grant struct {
+	role   string
+	member string
+}
+
+// grants returns the role and member pairs an IAM member, binding or policy
+// resource grants. Members unknown until apply are skipped.
+func grants(resource *tfjson.StateResource) []grant {
+	values := resource.AttributeValues
+	role, _ := values["role"].(string)
+	if member, ok := values["member"].(string); ok {
+		return []grant{{role, member}}
+	}
+	if members, ok := values["members"].([]interface{}); ok {
+		var result []grant
+		for _, member := range members {
+			if m, ok := member.(string); ok {
+				result = append(result, grant{role, m})
+			}
+		}
+		return result
+	}
+
+	policyData, ok := values["policy_data"].(string)
+	if !ok {
+		return nil
+	}
+	var policy struct {
+		Bindings []struct {
+			Role    string   `json:"role"`
+			Members []string `json:"members"`
+		} `json:"bindings"`
+	}
+	if err := json.Unmarshal([]byte(policyData), &policy); err != nil {
+		return nil
+	}
+	var result []grant
+	for _, binding := range policy.Bindings {
+		for _, member := range binding.Members {
+			result = append(result, grant{binding.Role, member})
+		}
+	}
+	return result
+}
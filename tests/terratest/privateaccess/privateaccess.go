@@ -0,0 +1,542 @@
+// Package privateaccess follows planned private IP Cloud SQL instances
+// through the configuration to the VPC and private services access they
+// need. The cloudsql module only orders itself after the peering through
+// depends_on = [var.private_vpc_connection], so an environment that passes a
+// private_network but forgets the connection, or reserves too small a
+// peering range, plans cleanly and fails on apply.
+package privateaccess
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/gruntwork-io/terratest/modules/testing"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+const (
+	instanceType   = "google_sql_database_instance"
+	networkType    = "google_compute_network"
+	connectionType = "google_service_networking_connection"
+	rangeType      = "google_compute_global_address"
+)
+
+// Sizing estimates how much of a reserved peering range Cloud SQL uses
+type Sizing struct {
+	// BlockPrefix is the prefix of the subnet Cloud SQL carves out of the
+	// range for each region it has instances in
+	BlockPrefix int
+	// AddressesPerBlock is how many instance addresses a block holds
+	AddressesPerBlock int
+}
+
+// DefaultSizing takes a /24 per region, less the four addresses Google Cloud
+// reserves in every subnet
+var DefaultSizing = Sizing{BlockPrefix: 24, AddressesPerBlock: 252}
+
+// Instance is a planned private IP Cloud SQL instance and what its private
+// network leads to in the configuration
+type Instance struct {
+	Address      string
+	Region       string
+	Availability string
+	// Network is the configuration address of the planned VPC, or the input
+	// or self link the private network comes from
+	Network string
+	// Connection is the configuration address of the planned service
+	// networking connection for Network, empty when none is planned
+	Connection string
+	// Inputs are the root variables passed to the instance through depends_on
+	Inputs []string
+	// Awaits is set when the instance depends on Connection
+	Awaits bool
+}
+
+// FromPlan returns every planned instance with a private network, leaving out
+// those whose private network is known to be empty
+func FromPlan(plan *terraform.PlanStruct) ([]Instance, error) {
+	raw := plan.RawPlan
+	if raw.Config == nil || raw.Config.RootModule == nil {
+		return nil, fmt.Errorf("plan has no configuration to follow")
+	}
+	g := graph{root: raw.Config.RootModule}
+
+	networkNames := map[string]string{}
+	for _, network := range planassert.ResourcesOfType(plan, networkType) {
+		if name, _ := network.AttributeValues["name"].(string); name != "" {
+			networkNames[name] = configAddress(network.Address)
+		}
+	}
+	connections := map[string]string{}
+	for _, connection := range planassert.ResourcesOfType(plan, connectionType) {
+		address := configAddress(connection.Address)
+		for _, network := range g.attributeOrigins(address, "network") {
+			connections[network] = address
+		}
+		if name := planassert.LastSegment(planassert.String(connection.AttributeValues, "network")); networkNames[name] != "" {
+			connections[networkNames[name]] = address
+		}
+	}
+
+	var instances []Instance
+	for _, resource := range planassert.ResourcesOfType(plan, instanceType) {
+		values := resource.AttributeValues
+		link, known := planassert.Lookup(values, "settings.0.ip_configuration.0.private_network")
+		if known && (link == nil || link == "") {
+			continue
+		}
+		instance := Instance{
+			Address:      resource.Address,
+			Region:       planassert.String(values, "region"),
+			Availability: planassert.String(values, "settings.0.availability_type"),
+		}
+
+		address := configAddress(resource.Address)
+		if s, _ := link.(string); s != "" {
+			instance.Network = s
+			if planned := networkNames[planassert.LastSegment(s)]; planned != "" {
+				instance.Network = planned
+			}
+		} else {
+			for _, origin := range g.attributeOrigins(address, "settings", "ip_configuration", "private_network") {
+				if resourceType(origin) == networkType || strings.HasPrefix(origin, "var.") {
+					instance.Network = origin
+					break
+				}
+			}
+		}
+
+		instance.Connection = connections[instance.Network]
+		waits, inputs := g.dependencies(address)
+		for _, input := range inputs {
+			if value := raw.Variables[strings.TrimPrefix(input, "var.")]; value != nil && value.Value != nil && value.Value != "" {
+				instance.Inputs = append(instance.Inputs, input)
+			}
+		}
+		if instance.Connection != "" {
+			instance.Awaits = awaits(waits, instance.Connection)
+		}
+		instances = append(instances, instance)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Address < instances[j].Address })
+	return instances, nil
+}
+
+// Problems returns why the instance can be created before, or without,
+// private services access to its VPC
+func (i Instance) Problems() []string {
+	switch {
+	case i.Network == "":
+		return []string{"private network cannot be traced to a planned VPC or an input"}
+	case i.Connection == "" && len(i.Inputs) == 0:
+		return []string{fmt.Sprintf("no service networking connection for %s in the plan and none passed as an input", i.Network)}
+	case i.Connection != "" && !i.Awaits && len(i.Inputs) == 0:
+		return []string{fmt.Sprintf("does not depend on %s, so Terraform can create it before private services access is connected", i.Connection)}
+	}
+	return nil
+}
+
+// addresses returns how many range addresses the instance takes, counting
+// the standby of a regional instance
+func (i Instance) addresses() int {
+	if i.Availability == "REGIONAL" {
+		return 2
+	}
+	return 1
+}
+
+// ConnectedE returns an error listing the planned private IP instances that
+// can be created before, or without, private services access to their VPC
+func ConnectedE(plan *terraform.PlanStruct) error {
+	instances, err := FromPlan(plan)
+	if err != nil {
+		return err
+	}
+	var lines []string
+	for _, instance := range instances {
+		for _, problem := range instance.Problems() {
+			lines = append(lines, instance.Address+": "+problem)
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d private connectivity problems:\n%s", len(lines), strings.Join(lines, "\n"))
+}
+
+// Connected asserts every planned private IP instance waits for private
+// services access to its VPC
+func Connected(t testing.TestingT, plan *terraform.PlanStruct) bool {
+	return assert.NoError(t, ConnectedE(plan))
+}
+
+// RangeUse is how much of a connection's reserved peering ranges the planned
+// instances need
+type RangeUse struct {
+	Connection string
+	// Ranges are the planned reserved peering ranges by configuration address
+	Ranges map[string]int
+	// Blocks is how many blocks the ranges hold
+	Blocks int
+	// Needed is how many blocks the instances on the connection take
+	Needed int
+}
+
+// RangeUses returns the use of the reserved peering ranges of every planned
+// connection with private IP instances on it
+func RangeUses(plan *terraform.PlanStruct, sizing Sizing) ([]RangeUse, error) {
+	instances, err := FromPlan(plan)
+	if err != nil {
+		return nil, err
+	}
+	g := graph{root: plan.RawPlan.Config.RootModule}
+
+	regions := map[string]map[string]int{}
+	for _, instance := range instances {
+		if instance.Connection == "" {
+			continue
+		}
+		if regions[instance.Connection] == nil {
+			regions[instance.Connection] = map[string]int{}
+		}
+		regions[instance.Connection][instance.Region] += instance.addresses()
+	}
+
+	var uses []RangeUse
+	for _, connection := range planassert.ResourcesOfType(plan, connectionType) {
+		address := configAddress(connection.Address)
+		if regions[address] == nil {
+			continue
+		}
+		use := RangeUse{Connection: address, Ranges: reservedRanges(plan, g, connection)}
+		for _, prefix := range use.Ranges {
+			if prefix <= sizing.BlockPrefix {
+				use.Blocks += 1 << (sizing.BlockPrefix - prefix)
+			}
+		}
+		for _, count := range regions[address] {
+			use.Needed += int(math.Ceil(float64(count) / float64(sizing.AddressesPerBlock)))
+		}
+		uses = append(uses, use)
+	}
+	return uses, nil
+}
+
+// reservedRanges returns the prefix length of each planned range the
+// connection reserves, found through the configuration or by name
+func reservedRanges(plan *terraform.PlanStruct, g graph, connection *tfjson.StateResource) map[string]int {
+	origins := map[string]bool{}
+	for _, origin := range g.attributeOrigins(configAddress(connection.Address), "reserved_peering_ranges") {
+		origins[origin] = true
+	}
+	names := map[string]bool{}
+	if planned, ok := connection.AttributeValues["reserved_peering_ranges"].([]interface{}); ok {
+		for _, name := range planned {
+			if s, ok := name.(string); ok {
+				names[s] = true
+			}
+		}
+	}
+
+	ranges := map[string]int{}
+	for _, resource := range planassert.ResourcesOfType(plan, rangeType) {
+		name, _ := resource.AttributeValues["name"].(string)
+		if !origins[configAddress(resource.Address)] && !names[name] {
+			continue
+		}
+		if prefix, ok := resource.AttributeValues["prefix_length"].(float64); ok {
+			ranges[configAddress(resource.Address)] = int(prefix)
+		}
+	}
+	return ranges
+}
+
+// PeeringRangesE returns an error listing the connections whose reserved
+// peering ranges are too small for the instances planned on them
+func PeeringRangesE(plan *terraform.PlanStruct, sizing Sizing) error {
+	uses, err := RangeUses(plan, sizing)
+	if err != nil {
+		return err
+	}
+	var lines []string
+	for _, use := range uses {
+		var ranges []string
+		for address, prefix := range use.Ranges {
+			ranges = append(ranges, fmt.Sprintf("%s (/%d)", address, prefix))
+			if prefix > sizing.BlockPrefix {
+				lines = append(lines, fmt.Sprintf("%s: %s is smaller than the /%d Cloud SQL takes per region", use.Connection, address, sizing.BlockPrefix))
+			}
+		}
+		sort.Strings(ranges)
+		switch {
+		case len(ranges) == 0:
+			lines = append(lines, fmt.Sprintf("%s: no planned reserved peering range", use.Connection))
+		case use.Needed > use.Blocks:
+			lines = append(lines, fmt.Sprintf("%s: %s hold %d /%d blocks but the planned instances need %d",
+				use.Connection, strings.Join(ranges, ", "), use.Blocks, sizing.BlockPrefix, use.Needed))
+		}
+	}
+	sort.Strings(lines)
+	if len(lines) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d peering range problems:\n%s", len(lines), strings.Join(lines, "\n"))
+}
+
+// PeeringRanges asserts the reserved peering ranges fit the planned instances
+func PeeringRanges(t testing.TestingT, plan *terraform.PlanStruct, sizing Sizing) bool {
+	return assert.NoError(t, PeeringRangesE(plan, sizing))
+}
+
+// graph follows references through the configuration. Resources are named by
+// their configuration address, e.g. module.network.google_compute_network.vpc,
+// and root variables as var.name.
+type graph struct {
+	root *tfjson.ConfigModule
+}
+
+// module returns the module at a path such as module.a.module.b
+func (g graph) module(path string) *tfjson.ConfigModule {
+	module := g.root
+	for _, name := range callNames(path) {
+		if module == nil || module.ModuleCalls[name] == nil {
+			return nil
+		}
+		module = module.ModuleCalls[name].Module
+	}
+	return module
+}
+
+// resource returns the configuration of a resource and its module path
+func (g graph) resource(address string) (*tfjson.ConfigResource, string) {
+	path, local := splitAddress(address)
+	module := g.module(path)
+	if module == nil {
+		return nil, path
+	}
+	for _, resource := range module.Resources {
+		if resource.Address == local {
+			return resource, path
+		}
+	}
+	return nil, path
+}
+
+// attributeOrigins returns where a resource argument, or an argument in its
+// nested blocks, gets its value from
+func (g graph) attributeOrigins(address string, names ...string) []string {
+	resource, path := g.resource(address)
+	if resource == nil {
+		return nil
+	}
+	expressions := []map[string]*tfjson.Expression{resource.Expressions}
+	for i, name := range names {
+		var next []map[string]*tfjson.Expression
+		for _, block := range expressions {
+			expression := block[name]
+			if expression == nil || expression.ExpressionData == nil {
+				continue
+			}
+			if i == len(names)-1 {
+				return g.origins(path, expression.References)
+			}
+			next = append(next, expression.NestedBlocks...)
+		}
+		expressions = next
+	}
+	return nil
+}
+
+// origins follows references made in the module at path through variables
+// and module outputs to the resources and root variables they come from
+func (g graph) origins(path string, references []string) []string {
+	seen := map[string]bool{}
+	var found []string
+	add := func(origins ...string) {
+		for _, origin := range origins {
+			if !seen[origin] {
+				seen[origin] = true
+				found = append(found, origin)
+			}
+		}
+	}
+
+	for _, reference := range references {
+		parts := strings.Split(reference, ".")
+		switch {
+		case parts[0] == "var" && len(parts) > 1:
+			name := trimIndex(parts[1])
+			if path == "" {
+				add("var." + name)
+				continue
+			}
+			parent, call := splitCall(path)
+			module := g.module(parent)
+			if module == nil || module.ModuleCalls[call] == nil {
+				continue
+			}
+			if expression := module.ModuleCalls[call].Expressions[name]; expression != nil && expression.ExpressionData != nil {
+				add(g.origins(parent, expression.References)...)
+			}
+		case parts[0] == "module" && len(parts) > 2:
+			child := planassert.Qualify(path, "module."+trimIndex(parts[1]))
+			module := g.module(child)
+			if module == nil {
+				continue
+			}
+			if output := module.Outputs[trimIndex(parts[2])]; output != nil && output.Expression != nil && output.Expression.ExpressionData != nil {
+				add(g.origins(child, output.Expression.References)...)
+			}
+		case parts[0] == "data" && len(parts) > 2:
+			add(planassert.Qualify(path, "data."+parts[1]+"."+trimIndex(parts[2])))
+		case len(parts) > 1 && !pseudo[parts[0]]:
+			add(planassert.Qualify(path, parts[0]+"."+trimIndex(parts[1])))
+		}
+	}
+	return found
+}
+
+// pseudo are reference prefixes that do not name a resource
+var pseudo = map[string]bool{"module": true, "each": true, "count": true, "path": true, "terraform": true, "local": true, "self": true}
+
+// dependencies returns everything a resource waits for before it is
+// created: resources, and whole modules by their path, reached through its
+// references and depends_on, transitively. It also returns the root
+// variables passed to it through depends_on, as the cloudsql module takes
+// private_vpc_connection.
+func (g graph) dependencies(address string) (map[string]bool, []string) {
+	waits := map[string]bool{}
+	var inputs []string
+	queue := []string{address}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		resource, path := g.resource(current)
+		if resource == nil {
+			continue
+		}
+
+		var references []string
+		for _, expression := range allExpressions(resource.Expressions) {
+			references = append(references, expression.References...)
+		}
+		for _, expression := range []*tfjson.Expression{resource.CountExpression, resource.ForEachExpression} {
+			if expression != nil && expression.ExpressionData != nil {
+				references = append(references, expression.References...)
+			}
+		}
+		next := g.origins(path, references)
+
+		// depends_on of the resource and every module call it sits in
+		explicit := map[string][]string{path: resource.DependsOn}
+		for p := path; p != ""; {
+			parent, call := splitCall(p)
+			if module := g.module(parent); module != nil && module.ModuleCalls[call] != nil {
+				explicit[parent] = append(explicit[parent], module.ModuleCalls[call].DependsOn...)
+			}
+			p = parent
+		}
+		for at, dependsOn := range explicit {
+			for _, reference := range dependsOn {
+				if parts := strings.Split(reference, "."); len(parts) == 2 && parts[0] == "module" {
+					next = append(next, planassert.Qualify(at, "module."+trimIndex(parts[1])))
+				}
+			}
+			for _, origin := range g.origins(at, dependsOn) {
+				if strings.HasPrefix(origin, "var.") {
+					inputs = append(inputs, origin)
+				}
+				next = append(next, origin)
+			}
+		}
+
+		for _, dependency := range next {
+			if !waits[dependency] {
+				waits[dependency] = true
+				queue = append(queue, dependency)
+			}
+		}
+	}
+	sort.Strings(inputs)
+	return waits, inputs
+}
+
+// awaits reports whether a dependency set holds the resource or a module it
+// belongs to
+func awaits(waits map[string]bool, address string) bool {
+	if waits[address] {
+		return true
+	}
+	for dependency := range waits {
+		if strings.HasPrefix(dependency, "module.") && strings.HasPrefix(address, dependency+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// allExpressions returns the expressions of a block and its nested blocks
+func allExpressions(expressions map[string]*tfjson.Expression) []*tfjson.Expression {
+	var all []*tfjson.Expression
+	for _, expression := range expressions {
+		if expression == nil || expression.ExpressionData == nil {
+			continue
+		}
+		all = append(all, expression)
+		for _, block := range expression.NestedBlocks {
+			all = append(all, allExpressions(block)...)
+		}
+	}
+	return all
+}
+
+var indexes = regexp.MustCompile(`\[[^\]]*\]`)
+
+// configAddress drops the count and for_each keys from a planned address
+func configAddress(address string) string {
+	return indexes.ReplaceAllString(address, "")
+}
+
+func trimIndex(name string) string {
+	return strings.Split(name, "[")[0]
+}
+
+// splitAddress splits a configuration address into its module path and the
+// address within the module
+func splitAddress(address string) (string, string) {
+	parts := strings.Split(address, ".")
+	i := 0
+	for i+1 < len(parts) && parts[i] == "module" {
+		i += 2
+	}
+	return strings.Join(parts[:i], "."), strings.Join(parts[i:], ".")
+}
+
+// splitCall splits a module path into its parent path and the call name
+func splitCall(path string) (string, string) {
+	i := strings.LastIndex(path, "module.")
+	return strings.TrimSuffix(path[:i], "."), path[i+len("module."):]
+}
+
+// callNames returns the module call names along a module path
+func callNames(path string) []string {
+	var names []string
+	parts := strings.Split(path, ".")
+	for i := 0; i+1 < len(parts); i += 2 {
+		names = append(names, parts[i+1])
+	}
+	return names
+}
+
+// resourceType returns the type in a configuration address
+func resourceType(address string) string {
+	_, local := splitAddress(address)
+	return strings.Split(local, ".")[0]
+}
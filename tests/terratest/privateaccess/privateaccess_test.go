@@ -0,0 +1,121 @@
+package privateaccess
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// TestFromPlan tests private networks are followed through module inputs and
+// outputs to the planned VPC and its connection, and instances with an empty
+// private network are left out
+func TestFromPlan(t *testing.T) {
+	t.Parallel()
+
+	instances, err := FromPlan(planassert.LoadPlanFile(t, "testdata/plan.json"))
+	require.NoError(t, err)
+
+	const (
+		vpc        = "module.network.google_compute_network.vpc"
+		connection = "module.network.google_service_networking_connection.private_vpc_connection"
+	)
+	testCases := []struct {
+		address    string
+		network    string
+		connection string
+		awaits     bool
+	}{
+		// depends_on = [var.private_vpc_connection]
+		{"module.app_a.google_sql_database_instance.instance", vpc, connection, true},
+		// The replica waits for its primary through master_instance_name
+		{"module.app_a.google_sql_database_instance.read_replica[0]", vpc, connection, true},
+		{"module.app_b.google_sql_database_instance.instance", vpc, connection, false},
+		// depends_on = [module.network] on the module call
+		{"module.app_c.google_sql_database_instance.instance", vpc, connection, true},
+		{"module.dr.google_sql_database_instance.instance", "module.dr_network.google_compute_network.vpc", "module.dr_network.google_service_networking_connection.private_vpc_connection", true},
+		{"module.dr.google_sql_database_instance.read_replica[0]", "module.dr_network.google_compute_network.vpc", "module.dr_network.google_service_networking_connection.private_vpc_connection", true},
+		{"module.legacy.google_sql_database_instance.instance", "projects/unicredit-dev/global/networks/shared-vpc", "", false},
+		{"module.orphan.google_sql_database_instance.instance", "projects/unicredit-dev/global/networks/other-vpc", "", false},
+	}
+	require.Len(t, instances, len(testCases))
+	for i, tc := range testCases {
+		assert.Equal(t, tc.address, instances[i].Address)
+		assert.Equal(t, tc.network, instances[i].Network, tc.address)
+		assert.Equal(t, tc.connection, instances[i].Connection, tc.address)
+		assert.Equal(t, tc.awaits, instances[i].Awaits, tc.address)
+	}
+	assert.Equal(t, []string{"var.shared_vpc_connection"}, instances[6].Inputs)
+}
+
+// TestProblems tests instances need a connection they wait for, or one
+// passed in as an input
+func TestProblems(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		instance Instance
+		problems []string
+	}{
+		{"awaits", Instance{Network: "vpc", Connection: "connection", Awaits: true}, nil},
+		{"input", Instance{Network: "var.private_network", Inputs: []string{"var.private_vpc_connection"}}, nil},
+		{"untraced", Instance{}, []string{"private network cannot be traced to a planned VPC or an input"}},
+		{"no_connection", Instance{Network: "vpc"}, []string{"no service networking connection for vpc in the plan and none passed as an input"}},
+		{"not_awaited", Instance{Network: "vpc", Connection: "connection"}, []string{
+			"does not depend on connection, so Terraform can create it before private services access is connected",
+		}},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.problems, tc.instance.Problems(), tc.name)
+	}
+}
+
+// TestConnected tests every problem in the plan is reported
+func TestConnected(t *testing.T) {
+	t.Parallel()
+
+	assert.EqualError(t, ConnectedE(planassert.LoadPlanFile(t, "testdata/plan.json")), "2 private connectivity problems:\n"+
+		"module.app_b.google_sql_database_instance.instance: does not depend on module.network.google_service_networking_connection.private_vpc_connection, so Terraform can create it before private services access is connected\n"+
+		"module.orphan.google_sql_database_instance.instance: no service networking connection for projects/unicredit-dev/global/networks/other-vpc in the plan and none passed as an input")
+}
+
+// TestRangeUses tests blocks are counted per region, with regional
+// instances taking an address for their standby
+func TestRangeUses(t *testing.T) {
+	t.Parallel()
+
+	uses, err := RangeUses(planassert.LoadPlanFile(t, "testdata/plan.json"), Sizing{BlockPrefix: 24, AddressesPerBlock: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []RangeUse{
+		{
+			Connection: "module.dr_network.google_service_networking_connection.private_vpc_connection",
+			Ranges:     map[string]int{"module.dr_network.google_compute_global_address.private_ip_range": 24},
+			Blocks:     1,
+			Needed:     2,
+		},
+		{
+			Connection: "module.network.google_service_networking_connection.private_vpc_connection",
+			Ranges:     map[string]int{"module.network.google_compute_global_address.private_ip_range": 16},
+			Blocks:     256,
+			// app_a and its standby, its replica, app_b and app_c in europe-west1
+			Needed: 3,
+		},
+	}, uses)
+}
+
+// TestPeeringRanges tests ranges are checked against the instances planned on
+// their connection
+func TestPeeringRanges(t *testing.T) {
+	t.Parallel()
+
+	plan := planassert.LoadPlanFile(t, "testdata/plan.json")
+	assert.EqualError(t, PeeringRangesE(plan, DefaultSizing), "1 peering range problems:\n"+
+		"module.dr_network.google_service_networking_connection.private_vpc_connection: module.dr_network.google_compute_global_address.private_ip_range (/24) hold 1 /24 blocks but the planned instances need 2")
+
+	err := PeeringRangesE(plan, Sizing{BlockPrefix: 20, AddressesPerBlock: 4092})
+	assert.ErrorContains(t, err, "module.dr_network.google_compute_global_address.private_ip_range is smaller than the /20 Cloud SQL takes per region")
+	assert.NotContains(t, err.Error(), "module.network.")
+}
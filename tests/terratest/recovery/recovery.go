@@ -0,0 +1,239 @@
+// Package recovery derives what a planned Cloud SQL instance can recover
+// from its backup and availability settings: the recovery point objective,
+// how many days back it can be restored, and whether a zone outage fails over
+// on its own. The cloudsql module silently turns point-in-time recovery off
+// for SQL Server, so the plan rather than the module inputs decides.
+package recovery
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/gruntwork-io/terratest/modules/testing"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// Unbounded is the RPO or RTO of an instance that cannot be recovered
+const Unbounded time.Duration = math.MaxInt64
+
+// backupInterval is how often Cloud SQL takes automated backups, and so the
+// most data lost restoring the last one
+const backupInterval = 24 * time.Hour
+
+// Failover is what happens when the instance's zone goes down
+type Failover string
+
+const (
+	// Automatic failover promotes the standby in another zone
+	Automatic Failover = "automatic"
+	// None leaves a zonal instance down until it is restored from a backup
+	None Failover = "none"
+)
+
+// Estimates are the recovery times the RTO is derived from
+type Estimates struct {
+	// LogArchive is the most transaction log not yet archived, and so the
+	// RPO with point-in-time recovery
+	LogArchive time.Duration
+	// Failover is how long a regional instance takes to fail over
+	Failover time.Duration
+	// Restore is how long restoring a backup into a new instance takes
+	Restore time.Duration
+}
+
+// DefaultEstimates are conservative figures for the instance sizes in this
+// migration
+var DefaultEstimates = Estimates{
+	LogArchive: 5 * time.Minute,
+	Failover:   time.Minute,
+	Restore:    time.Hour,
+}
+
+// Instance is the recovery configuration of a planned primary instance
+type Instance struct {
+	Address         string
+	Name            string
+	Environment     string
+	DatabaseVersion string
+	Backups         bool
+	BackupStart     string
+	RetainedBackups int
+	// LogRetentionDays is transaction_log_retention_days
+	LogRetentionDays int
+	// PITR is the planned point_in_time_recovery_enabled
+	PITR         bool
+	Availability string
+}
+
+// RPO returns the most data lost restoring the instance
+func (i Instance) RPO(estimates Estimates) time.Duration {
+	switch {
+	case !i.Backups:
+		return Unbounded
+	case i.PITR:
+		return estimates.LogArchive
+	default:
+		return backupInterval
+	}
+}
+
+// RestoreDays returns how many days back the instance can be restored.
+// Point-in-time recovery needs a backup to replay the logs from, so it reaches
+// no further back than the oldest retained backup.
+func (i Instance) RestoreDays() int {
+	switch {
+	case !i.Backups:
+		return 0
+	case i.PITR:
+		return min(i.LogRetentionDays, i.RetainedBackups)
+	default:
+		return i.RetainedBackups
+	}
+}
+
+// Failover returns what happens when the instance's zone goes down
+func (i Instance) Failover() Failover {
+	if i.Availability == "REGIONAL" {
+		return Automatic
+	}
+	return None
+}
+
+// RTO returns how long the instance is down when its zone goes down
+func (i Instance) RTO(estimates Estimates) time.Duration {
+	switch {
+	case i.Failover() == Automatic:
+		return estimates.Failover
+	case i.Backups:
+		return estimates.Restore
+	default:
+		return Unbounded
+	}
+}
+
+func (i Instance) String() string {
+	return fmt.Sprintf("%s: RPO %s, restore window %d days, %s failover (RTO %s)",
+		i.Name, format(i.RPO(DefaultEstimates)), i.RestoreDays(), i.Failover(), format(i.RTO(DefaultEstimates)))
+}
+
+func format(d time.Duration) string {
+	if d == Unbounded {
+		return "unbounded"
+	}
+	return d.String()
+}
+
+// FromPlan returns the planned primary instances. Read replicas are left out
+// since they have no backups of their own.
+func FromPlan(plan *terraform.PlanStruct) []Instance {
+	var instances []Instance
+	for _, resource := range planassert.ResourcesOfType(plan, "google_sql_database_instance") {
+		values := resource.AttributeValues
+		if replica, _ := values["master_instance_name"].(string); replica != "" {
+			continue
+		}
+		backup := "settings.0.backup_configuration.0."
+		instance := Instance{
+			Address:          resource.Address,
+			Name:             planassert.String(values, "name"),
+			Environment:      planassert.String(values, "settings.0.user_labels.environment"),
+			DatabaseVersion:  planassert.String(values, "database_version"),
+			Backups:          boolValue(values, backup+"enabled"),
+			BackupStart:      planassert.String(values, backup+"start_time"),
+			RetainedBackups:  intValue(values, backup+"backup_retention_settings.0.retained_backups"),
+			LogRetentionDays: intValue(values, backup+"transaction_log_retention_days"),
+			PITR:             boolValue(values, backup+"point_in_time_recovery_enabled"),
+			Availability:     planassert.String(values, "settings.0.availability_type"),
+		}
+		instances = append(instances, instance)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Address < instances[j].Address })
+	return instances
+}
+
+// Target is the recovery an environment needs
+type Target struct {
+	RPO         time.Duration
+	RTO         time.Duration
+	RestoreDays int
+}
+
+// DefaultTargets are the recovery targets by environment label. Production
+// must lose at most 15 minutes of data and survive a zone outage within 5;
+// test only needs last night's backup.
+var DefaultTargets = map[string]Target{
+	"prod":       {RPO: 15 * time.Minute, RTO: 5 * time.Minute, RestoreDays: 7},
+	"production": {RPO: 15 * time.Minute, RTO: 5 * time.Minute, RestoreDays: 7},
+	"test":       {RPO: 24 * time.Hour, RTO: 24 * time.Hour, RestoreDays: 1},
+}
+
+// Misses returns how the instance falls short of the target
+func (i Instance) Misses(target Target, estimates Estimates) []string {
+	var misses []string
+	if rpo := i.RPO(estimates); rpo > target.RPO {
+		misses = append(misses, fmt.Sprintf("RPO %s exceeds %s%s", format(rpo), target.RPO, i.rpoReason()))
+	}
+	if rto := i.RTO(estimates); rto > target.RTO {
+		misses = append(misses, fmt.Sprintf("RTO %s exceeds %s with %s failover", format(rto), target.RTO, i.Failover()))
+	}
+	if days := i.RestoreDays(); days < target.RestoreDays {
+		misses = append(misses, fmt.Sprintf("restore window of %d days is shorter than %d", days, target.RestoreDays))
+	}
+	return misses
+}
+
+// rpoReason explains an RPO that a target might not expect
+func (i Instance) rpoReason() string {
+	switch {
+	case !i.Backups:
+		return " (backups are disabled)"
+	case !i.PITR && strings.HasPrefix(i.DatabaseVersion, "SQLSERVER"):
+		return " (the cloudsql module turns point-in-time recovery off for SQL Server)"
+	case !i.PITR:
+		return " (point-in-time recovery is off)"
+	}
+	return ""
+}
+
+// MeetsE returns an error listing the planned instances that miss the target
+// for their environment label. Instances in environments without a target
+// are not checked.
+func MeetsE(plan *terraform.PlanStruct, targets map[string]Target) error {
+	var misses []string
+	for _, instance := range FromPlan(plan) {
+		target, ok := targets[instance.Environment]
+		if !ok {
+			continue
+		}
+		for _, miss := range instance.Misses(target, DefaultEstimates) {
+			misses = append(misses, fmt.Sprintf("%s (%s): %s", instance.Address, instance.Environment, miss))
+		}
+	}
+	if len(misses) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d recovery targets missed:\n%s", len(misses), strings.Join(misses, "\n"))
+}
+
+// Meets asserts the planned instances meet the target for their environment
+func Meets(t testing.TestingT, plan *terraform.PlanStruct, targets map[string]Target) bool {
+	return assert.NoError(t, MeetsE(plan, targets))
+}
+
+func boolValue(values map[string]interface{}, path string) bool {
+	value, _ := planassert.Lookup(values, path)
+	b, _ := value.(bool)
+	return b
+}
+
+func intValue(values map[string]interface{}, path string) int {
+	value, _ := planassert.Lookup(values, path)
+	n, _ := value.(float64)
+	return int(n)
+}
@@ -0,0 +1,74 @@
+package recovery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// TestFromPlan tests RPO, restore window and failover are derived per instance
+func TestFromPlan(t *testing.T) {
+	t.Parallel()
+
+	var got []string
+	for _, instance := range FromPlan(planassert.LoadPlanFile(t, "testdata/plan.json")) {
+		got = append(got, instance.String())
+	}
+	assert.Equal(t, []string{
+		"dev-app-a: RPO unbounded, restore window 0 days, none failover (RTO unbounded)",
+		"prod-app-a: RPO 5m0s, restore window 7 days, automatic failover (RTO 1m0s)",
+		"prod-app-b: RPO 24h0m0s, restore window 7 days, automatic failover (RTO 1m0s)",
+		"prod-legacy: RPO unbounded, restore window 0 days, none failover (RTO unbounded)",
+		"test-app-a: RPO 5m0s, restore window 3 days, none failover (RTO 1h0m0s)",
+	}, got)
+}
+
+// TestMisses tests instances are compared against a target
+func TestMisses(t *testing.T) {
+	t.Parallel()
+
+	target := Target{RPO: 15 * time.Minute, RTO: 5 * time.Minute, RestoreDays: 7}
+	testCases := []struct {
+		name     string
+		instance Instance
+		misses   []string
+	}{
+		{"regional_pitr", Instance{Backups: true, PITR: true, RetainedBackups: 7, LogRetentionDays: 7, Availability: "REGIONAL"}, nil},
+		{"short_log_retention", Instance{Backups: true, PITR: true, RetainedBackups: 14, LogRetentionDays: 3, Availability: "REGIONAL"}, []string{
+			"restore window of 3 days is shorter than 7",
+		}},
+		{"sqlserver", Instance{DatabaseVersion: "SQLSERVER_2019_STANDARD", Backups: true, RetainedBackups: 7, LogRetentionDays: 7, Availability: "REGIONAL"}, []string{
+			"RPO 24h0m0s exceeds 15m0s (the cloudsql module turns point-in-time recovery off for SQL Server)",
+		}},
+		{"zonal", Instance{Backups: true, PITR: true, RetainedBackups: 7, LogRetentionDays: 7, Availability: "ZONAL"}, []string{
+			"RTO 1h0m0s exceeds 5m0s with none failover",
+		}},
+		{"no_backups", Instance{Availability: "ZONAL"}, []string{
+			"RPO unbounded exceeds 15m0s (backups are disabled)",
+			"RTO unbounded exceeds 5m0s with none failover",
+			"restore window of 0 days is shorter than 7",
+		}},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.misses, tc.instance.Misses(target, DefaultEstimates), tc.name)
+	}
+}
+
+// TestMeets tests only instances in environments with a target are checked
+func TestMeets(t *testing.T) {
+	t.Parallel()
+
+	plan := planassert.LoadPlanFile(t, "testdata/plan.json")
+	assert.EqualError(t, MeetsE(plan, DefaultTargets), "4 recovery targets missed:\n"+
+		"google_sql_database_instance.prod_app_b (prod): RPO 24h0m0s exceeds 15m0s (the cloudsql module turns point-in-time recovery off for SQL Server)\n"+
+		"google_sql_database_instance.prod_legacy (prod): RPO unbounded exceeds 15m0s (backups are disabled)\n"+
+		"google_sql_database_instance.prod_legacy (prod): RTO unbounded exceeds 5m0s with none failover\n"+
+		"google_sql_database_instance.prod_legacy (prod): restore window of 0 days is shorter than 7")
+
+	assert.NoError(t, MeetsE(plan, map[string]Target{"test": DefaultTargets["test"]}))
+	assert.ErrorContains(t, MeetsE(plan, map[string]Target{"test": {RPO: time.Hour, RTO: time.Hour, RestoreDays: 7}}),
+		"test_app_a (test): restore window of 3 days is shorter than 7")
+}
@@ -0,0 +1,299 @@
+// Package replica compares planned Cloud SQL read replicas with their
+// primaries. The cloudsql module's read_replica copies the tier and disk but
+// not backups, maintenance, insights or database flags, and replica_region
+// can put it in a region the private network does not reach, so a replica
+// can plan cleanly and still be unusable as a read or failover target.
+package replica
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/gruntwork-io/terratest/modules/testing"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+const instanceType = "google_sql_database_instance"
+
+// setting is a planned attribute replicas should match their primary on
+type setting struct {
+	path string
+	// reason explains what the divergence means for the replica
+	reason string
+	// inherent is set for settings replicas cannot match
+	inherent bool
+}
+
+var settings = []setting{
+	{"database_version", "a replica must run the primary's database version", false},
+	{"settings.0.tier", "replicas should match their primary's size, since a smaller one can fall behind the primary's writes", false},
+	{"settings.0.disk_size", "Cloud SQL needs a replica's storage to be at least the primary's", false},
+	{"settings.0.disk_type", "a slower disk can make the replica lag", false},
+	{"settings.0.disk_autoresize", "without autoresize the replica runs out of space as the primary grows", false},
+	{"settings.0.database_flags", "replicas do not inherit flags, and flags such as max_connections must be at least the primary's for replication to run", false},
+	{"settings.0.maintenance_window", "without its own window Cloud SQL can update the replica at any time", false},
+	{"settings.0.insights_config", "queries served by the replica are missing from Query Insights", false},
+	{"settings.0.ip_configuration.0.ipv4_enabled", "the replica is exposed differently from the primary", false},
+	{"settings.0.ip_configuration.0.private_network", "the replica is on a different VPC from the primary", false},
+	{"settings.0.ip_configuration.0.require_ssl", "clients connecting to the replica are held to different SSL rules", false},
+	{"settings.0.ip_configuration.0.enable_private_path_for_google_cloud_services", "Google Cloud services reach the primary privately but not the replica", false},
+	{"settings.0.ip_configuration.0.authorized_networks", "different networks are authorized on the replica", false},
+	{"settings.0.user_labels", "the replica is labelled differently, so cost and policy reports treat it apart from its primary", false},
+	{"deletion_protection", "the replica can be deleted when the primary cannot", false},
+	{"settings.0.backup_configuration", "replicas cannot take backups, restores come from the primary", true},
+	{"settings.0.availability_type", "a replica's availability is independent of its primary", true},
+}
+
+// Pair is a planned replica and its primary
+type Pair struct {
+	Primary *tfjson.StateResource
+	Replica *tfjson.StateResource
+}
+
+// Pairs matches every planned replica with its primary by
+// master_instance_name, or with the only primary in its module when the name
+// is unknown until apply
+func Pairs(plan *terraform.PlanStruct) ([]Pair, error) {
+	primaries := map[string]*tfjson.StateResource{}
+	byModule := map[string][]*tfjson.StateResource{}
+	var replicas []*tfjson.StateResource
+	for _, resource := range planassert.ResourcesOfType(plan, instanceType) {
+		if isReplica(plan, resource) {
+			replicas = append(replicas, resource)
+			continue
+		}
+		if name, _ := resource.AttributeValues["name"].(string); name != "" {
+			primaries[name] = resource
+		}
+		module := planassert.ModulePath(resource)
+		byModule[module] = append(byModule[module], resource)
+	}
+
+	var pairs []Pair
+	for _, replica := range replicas {
+		name, _ := replica.AttributeValues["master_instance_name"].(string)
+		primary, ok := primaries[name]
+		if !ok && name == "" && len(byModule[planassert.ModulePath(replica)]) == 1 {
+			primary, ok = byModule[planassert.ModulePath(replica)][0], true
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s: primary %q is not planned", replica.Address, name)
+		}
+		pairs = append(pairs, Pair{Primary: primary, Replica: replica})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Replica.Address < pairs[j].Replica.Address })
+	return pairs, nil
+}
+
+// isReplica reports whether an instance is a read replica: it names its
+// primary, plans a replica_configuration block or the READ_REPLICA_INSTANCE
+// type, or sets master_instance_name in its configuration. The attribute is
+// optional and computed, so primaries usually leave it out of the plan, as
+// do replicas whose primary's name is unknown until apply.
+func isReplica(plan *terraform.PlanStruct, resource *tfjson.StateResource) bool {
+	values := resource.AttributeValues
+	if planassert.String(values, "master_instance_name") != "" || planassert.String(values, "instance_type") == "READ_REPLICA_INSTANCE" {
+		return true
+	}
+	if blocks, _ := values["replica_configuration"].([]interface{}); len(blocks) > 0 {
+		return true
+	}
+	if config := planassert.ConfigResource(plan, resource.Address); config != nil {
+		return config.Expressions["master_instance_name"] != nil
+	}
+	return false
+}
+
+// Divergence is a setting a replica plans differently from its primary
+type Divergence struct {
+	Replica string
+	Setting string
+	Primary interface{}
+	Planned interface{}
+	Reason  string
+	// Inherent is set for divergences every replica has
+	Inherent bool
+}
+
+func (d Divergence) String() string {
+	return fmt.Sprintf("%s: %s is %s on the primary but %s on the replica: %s", d.Replica, d.Setting, show(d.Primary), show(d.Planned), d.Reason)
+}
+
+// show formats a planned value, with empty blocks and lists as unset
+func show(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "unset"
+	case []interface{}:
+		if len(v) == 0 {
+			return "unset"
+		}
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return "unset"
+		}
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// Compare returns every setting the replica plans differently from its primary
+func Compare(pair Pair) []Divergence {
+	var divergences []Divergence
+	for _, s := range settings {
+		primary, _ := planassert.Lookup(pair.Primary.AttributeValues, s.path)
+		replica, _ := planassert.Lookup(pair.Replica.AttributeValues, s.path)
+		if show(primary) == "unset" && show(replica) == "unset" || planassert.Equal(primary, replica) {
+			continue
+		}
+		divergences = append(divergences, Divergence{
+			Replica:  pair.Replica.Address,
+			Setting:  s.path,
+			Primary:  primary,
+			Planned:  replica,
+			Reason:   s.reason,
+			Inherent: s.inherent,
+		})
+	}
+	return divergences
+}
+
+// Reachability returns why the replica cannot be reached privately from its
+// own region: no private network and no public IP, a different VPC from the
+// primary, no planned subnet of the VPC in the replica's region, or no
+// planned private services access connection for the VPC. A network unknown
+// until apply is taken to be the only planned VPC.
+func Reachability(plan *terraform.PlanStruct, pair Pair) []string {
+	replica := pair.Replica.AttributeValues
+	region, _ := replica["region"].(string)
+	network := planassert.LastSegment(planassert.String(replica, "settings.0.ip_configuration.0.private_network"))
+	primaryNetwork := planassert.LastSegment(planassert.String(pair.Primary.AttributeValues, "settings.0.ip_configuration.0.private_network"))
+	public, _ := planassert.Lookup(replica, "settings.0.ip_configuration.0.ipv4_enabled")
+
+	var problems []string
+	if network == "" && known(pair.Replica, "settings.0.ip_configuration.0.private_network") {
+		if public != true {
+			problems = append(problems, "no private network and no public IP, so nothing can connect to it")
+		}
+		return problems
+	}
+	if network != "" && primaryNetwork != "" && network != primaryNetwork {
+		problems = append(problems, fmt.Sprintf("on VPC %s but its primary is on %s", network, primaryNetwork))
+	}
+
+	networks := plannedNetworks(plan)
+	if network == "" {
+		if len(networks) != 1 {
+			return append(problems, fmt.Sprintf("private network unknown until apply and %d VPCs planned", len(networks)))
+		}
+		network = networks[0]
+	}
+
+	// Resources whose network is unknown until apply are on the only VPC
+	assumed := len(networks) == 1 && networks[0] == network
+	if !hasSubnet(plan, network, region, assumed) {
+		problems = append(problems, fmt.Sprintf("no subnet of %s in %s, so nothing in the replica's region reaches it privately", network, region))
+	}
+	if !hasPrivateServiceAccess(plan, network, assumed) {
+		problems = append(problems, fmt.Sprintf("no private services access connection for %s in the plan", network))
+	}
+	return problems
+}
+
+// known reports whether the attribute is known at plan time, even if null
+func known(resource *tfjson.StateResource, path string) bool {
+	_, found := planassert.Lookup(resource.AttributeValues, path)
+	return found
+}
+
+func plannedNetworks(plan *terraform.PlanStruct) []string {
+	var networks []string
+	for _, resource := range planassert.ResourcesOfType(plan, "google_compute_network") {
+		networks = append(networks, planassert.String(resource.AttributeValues, "name"))
+	}
+	sort.Strings(networks)
+	return networks
+}
+
+// hasSubnet reports whether a subnet of network is planned in region,
+// counting subnets whose network is unknown until apply when assumed is set
+func hasSubnet(plan *terraform.PlanStruct, network, region string, assumed bool) bool {
+	for _, resource := range planassert.ResourcesOfType(plan, "google_compute_subnetwork") {
+		values := resource.AttributeValues
+		if subnetRegion, _ := values["region"].(string); subnetRegion != region {
+			continue
+		}
+		if on := planassert.LastSegment(planassert.String(values, "network")); on == network || on == "" && assumed {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrivateServiceAccess(plan *terraform.PlanStruct, network string, assumed bool) bool {
+	for _, resource := range planassert.ResourcesOfType(plan, "google_service_networking_connection") {
+		if on := planassert.LastSegment(planassert.String(resource.AttributeValues, "network")); on == network || on == "" && assumed {
+			return true
+		}
+	}
+	return false
+}
+
+// ConsistentE returns an error listing the divergences of every planned
+// replica from its primary, leaving out the ones every replica has
+func ConsistentE(plan *terraform.PlanStruct) error {
+	pairs, err := Pairs(plan)
+	if err != nil {
+		return err
+	}
+	var lines []string
+	for _, pair := range pairs {
+		for _, divergence := range Compare(pair) {
+			if !divergence.Inherent {
+				lines = append(lines, divergence.String())
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d replica settings diverge from their primary:\n%s", len(lines), strings.Join(lines, "\n"))
+}
+
+// Consistent asserts every planned replica matches its primary
+func Consistent(t testing.TestingT, plan *terraform.PlanStruct) bool {
+	return assert.NoError(t, ConsistentE(plan))
+}
+
+// ReachableE returns an error listing the planned replicas that cannot be
+// reached privately from their region
+func ReachableE(plan *terraform.PlanStruct) error {
+	pairs, err := Pairs(plan)
+	if err != nil {
+		return err
+	}
+	var lines []string
+	for _, pair := range pairs {
+		for _, problem := range Reachability(plan, pair) {
+			lines = append(lines, pair.Replica.Address+": "+problem)
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d replica reachability problems:\n%s", len(lines), strings.Join(lines, "\n"))
+}
+
+// Reachable asserts every planned replica can be reached privately from its region
+func Reachable(t testing.TestingT, plan *terraform.PlanStruct) bool {
+	return assert.NoError(t, ReachableE(plan))
+}
@@ -0,0 +1,109 @@
+package replica
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// TestPairs tests replicas are matched with their primary by name, or by
+// module when the name is unknown until apply
+func TestPairs(t *testing.T) {
+	t.Parallel()
+
+	pairs, err := Pairs(planassert.LoadPlanFile(t, "testdata/plan.json"))
+	require.NoError(t, err)
+	got := map[string]string{}
+	for _, pair := range pairs {
+		got[pair.Replica.Address] = pair.Primary.Address
+	}
+	assert.Equal(t, map[string]string{
+		"module.app_a.google_sql_database_instance.read_replica[0]": "module.app_a.google_sql_database_instance.instance",
+		"module.app_b.google_sql_database_instance.read_replica[0]": "module.app_b.google_sql_database_instance.instance",
+		"module.app_c.google_sql_database_instance.read_replica[0]": "module.app_c.google_sql_database_instance.instance",
+		"module.app_d.google_sql_database_instance.read_replica[0]": "module.app_d.google_sql_database_instance.instance",
+	}, got)
+}
+
+// TestPairsComputedMaster tests primaries that leave the computed
+// master_instance_name out of the plan are not taken for replicas, and
+// replicas are told apart by their replica_configuration or configuration
+func TestPairsComputedMaster(t *testing.T) {
+	t.Parallel()
+
+	plan := planassert.LoadPlanFile(t, "testdata/computed_master.json")
+	pairs, err := Pairs(plan)
+	require.NoError(t, err)
+	got := map[string]string{}
+	for _, pair := range pairs {
+		got[pair.Replica.Address] = pair.Primary.Address
+	}
+	assert.Equal(t, map[string]string{
+		"module.analytics.google_sql_database_instance.read_replica[0]": "module.analytics.google_sql_database_instance.instance",
+		"module.app.google_sql_database_instance.read_replica[0]":       "module.app.google_sql_database_instance.instance",
+	}, got)
+	assert.NoError(t, ConsistentE(plan))
+	assert.NoError(t, ReachableE(plan))
+}
+
+// TestCompare tests every divergence is reported with its reason, and the
+// ones every replica has are marked inherent
+func TestCompare(t *testing.T) {
+	t.Parallel()
+
+	pairs, err := Pairs(planassert.LoadPlanFile(t, "testdata/plan.json"))
+	require.NoError(t, err)
+
+	settings := map[string]bool{}
+	for _, divergence := range Compare(pairs[0]) {
+		settings[divergence.Setting] = divergence.Inherent
+	}
+	assert.Equal(t, map[string]bool{
+		"settings.0.database_flags":     false,
+		"settings.0.maintenance_window": false,
+		"settings.0.insights_config":    false,
+		"settings.0.ip_configuration.0.enable_private_path_for_google_cloud_services": false,
+		"settings.0.backup_configuration":                                             true,
+		"settings.0.availability_type":                                                true,
+	}, settings)
+
+	divergences := Compare(pairs[1])
+	require.Len(t, divergences, 2)
+	assert.Equal(t, `module.app_b.google_sql_database_instance.read_replica[0]: settings.0.tier is "db-custom-2-8192" on the primary but "db-custom-1-3840" on the replica: `+
+		`replicas should match their primary's size, since a smaller one can fall behind the primary's writes`, divergences[0].String())
+	assert.Equal(t, "settings.0.ip_configuration.0.private_network", divergences[1].Setting)
+
+	assert.Empty(t, Compare(pairs[2]))
+}
+
+// TestReachability tests cross-region replicas need a subnet of their VPC in
+// their region and private services access
+func TestReachability(t *testing.T) {
+	t.Parallel()
+
+	plan := planassert.LoadPlanFile(t, "testdata/plan.json")
+	pairs, err := Pairs(plan)
+	require.NoError(t, err)
+
+	assert.Empty(t, Reachability(plan, pairs[0]))
+	assert.Equal(t, []string{
+		"on VPC other-vpc but its primary is on prod-vpc",
+		"no subnet of other-vpc in europe-west4, so nothing in the replica's region reaches it privately",
+		"no private services access connection for other-vpc in the plan",
+	}, Reachability(plan, pairs[1]))
+	// Unknown network taken to be prod-vpc, which has a subnet in europe-west3
+	assert.Empty(t, Reachability(plan, pairs[2]))
+	assert.Equal(t, []string{"no private network and no public IP, so nothing can connect to it"}, Reachability(plan, pairs[3]))
+}
+
+// TestConsistentAndReachable tests the errors count the problems over the plan
+func TestConsistentAndReachable(t *testing.T) {
+	t.Parallel()
+
+	plan := planassert.LoadPlanFile(t, "testdata/plan.json")
+	assert.ErrorContains(t, ConsistentE(plan), "6 replica settings diverge from their primary:\n")
+	assert.ErrorContains(t, ReachableE(plan), "4 replica reachability problems:\n")
+}
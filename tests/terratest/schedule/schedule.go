@@ -0,0 +1,376 @@
+// Package schedule checks when planned Cloud SQL instances run backups and
+// maintenance. The cloudsql module sets the maintenance window and backup
+// start time independently, and read replicas inherit neither, so nothing
+// stops a backup running into maintenance, either landing in business hours,
+// or every instance in an environment restarting in the same hour.
+package schedule
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/gruntwork-io/terratest/modules/testing"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+const (
+	day  = 24 * time.Hour
+	week = 7 * day
+	// backupLength is how long after start_time an automated backup may start
+	backupLength = 4 * time.Hour
+	// maintenanceLength is how long a maintenance window lasts
+	maintenanceLength = time.Hour
+)
+
+// Window is a weekly UTC time span, starting at an offset from Monday 00:00
+type Window struct {
+	Start  time.Duration
+	Length time.Duration
+}
+
+// Overlaps reports whether the windows share any time, including across the
+// end of the week
+func (w Window) Overlaps(other Window) bool {
+	for _, shift := range []time.Duration{-week, 0, week} {
+		start := other.Start + shift
+		if w.Start < start+other.Length && start < w.Start+w.Length {
+			return true
+		}
+	}
+	return false
+}
+
+func (w Window) String() string {
+	end := (w.Start + w.Length) % week
+	return fmt.Sprintf("%s %s-%s UTC", weekdays[w.Start/day], clock(w.Start), clock(end))
+}
+
+// weekdays are the days of a week starting on Monday, as Cloud SQL numbers them
+var weekdays = []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+
+func clock(offset time.Duration) string {
+	offset %= day
+	return fmt.Sprintf("%02d:%02d", int(offset/time.Hour), int(offset%time.Hour/time.Minute))
+}
+
+// Instance is the schedule of a planned Cloud SQL instance
+type Instance struct {
+	Address     string
+	Name        string
+	Environment string
+	Application string
+	Replica     bool
+	// BackupStart is the daily backup start time, zero without backups
+	BackupStart time.Duration
+	Backups     bool
+	// Maintenance is nil when the instance has no maintenance window
+	Maintenance *Window
+}
+
+// BackupWindows returns the backup window of each day of the week
+func (i Instance) BackupWindows() []Window {
+	if !i.Backups {
+		return nil
+	}
+	windows := make([]Window, 7)
+	for d := range windows {
+		windows[d] = Window{Start: time.Duration(d)*day + i.BackupStart, Length: backupLength}
+	}
+	return windows
+}
+
+// FromPlan returns the schedule of every planned Cloud SQL instance
+func FromPlan(plan *terraform.PlanStruct) ([]Instance, error) {
+	var instances []Instance
+	for _, resource := range planassert.ResourcesOfType(plan, "google_sql_database_instance") {
+		values := resource.AttributeValues
+		instance := Instance{
+			Address:     resource.Address,
+			Name:        planassert.String(values, "name"),
+			Environment: planassert.String(values, "settings.0.user_labels.environment"),
+			Application: planassert.String(values, "settings.0.user_labels.application"),
+			Replica:     planassert.String(values, "master_instance_name") != "",
+		}
+
+		if enabled, _ := planassert.Lookup(values, "settings.0.backup_configuration.0.enabled"); enabled == true {
+			start, err := parseClock(planassert.String(values, "settings.0.backup_configuration.0.start_time"))
+			if err != nil {
+				return nil, fmt.Errorf("%s: backup start_time: %w", resource.Address, err)
+			}
+			instance.Backups = true
+			instance.BackupStart = start
+		}
+
+		// day 0 lets Cloud SQL pick any day, which is no window at all
+		weekday, _ := planassert.Lookup(values, "settings.0.maintenance_window.0.day")
+		hour, _ := planassert.Lookup(values, "settings.0.maintenance_window.0.hour")
+		if d, ok := weekday.(float64); ok && d >= 1 && d <= 7 {
+			h, _ := hour.(float64)
+			instance.Maintenance = &Window{
+				Start:  time.Duration(d-1)*day + time.Duration(h)*time.Hour,
+				Length: maintenanceLength,
+			}
+		}
+		instances = append(instances, instance)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Address < instances[j].Address })
+	return instances, nil
+}
+
+// parseClock reads an HH:MM UTC time of day
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// BusinessHours are the hours an application is in use, in its local time
+type BusinessHours struct {
+	// Location is an IANA time zone such as Europe/Rome
+	Location string
+	Days     []time.Weekday
+	// Start and End are HH:MM local time
+	Start string
+	End   string
+}
+
+// DefaultBusinessHours are the declared business hours by application label
+var DefaultBusinessHours = map[string]BusinessHours{
+	"app-a": {Location: "Europe/Rome", Days: workdays, Start: "08:00", End: "18:00"},
+	"app-b": {Location: "Europe/Rome", Days: workdays, Start: "08:00", End: "18:00"},
+}
+
+var workdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+
+// referenceWeeks start on a Monday in winter and in summer, so business
+// hours are converted at both daylight saving offsets
+var referenceWeeks = []time.Time{
+	time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC),
+	time.Date(2024, time.July, 15, 0, 0, 0, 0, time.UTC),
+}
+
+// Windows returns the business hours as UTC windows, with one window per day
+// for each daylight saving offset of the location
+func (b BusinessHours) Windows() ([]Window, error) {
+	location, err := time.LoadLocation(b.Location)
+	if err != nil {
+		return nil, err
+	}
+	start, err := parseClock(b.Start)
+	if err != nil {
+		return nil, fmt.Errorf("business hours start: %w", err)
+	}
+	end, err := parseClock(b.End)
+	if err != nil {
+		return nil, fmt.Errorf("business hours end: %w", err)
+	}
+	if end <= start {
+		return nil, fmt.Errorf("business hours end %s is not after start %s", b.End, b.Start)
+	}
+
+	seen := map[Window]bool{}
+	var windows []Window
+	for _, monday := range referenceWeeks {
+		for _, weekday := range b.Days {
+			offset := (int(weekday) + 6) % 7
+			date := monday.AddDate(0, 0, offset)
+			local := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, location).Add(start)
+			window := Window{Start: (local.UTC().Sub(monday) + week) % week, Length: end - start}
+			if !seen[window] {
+				seen[window] = true
+				windows = append(windows, window)
+			}
+		}
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Start < windows[j].Start })
+	return windows, nil
+}
+
+// Kind is a type of scheduling conflict
+type Kind string
+
+const (
+	// Unscheduled is an instance without a maintenance window although its
+	// application declares business hours
+	Unscheduled Kind = "unscheduled-maintenance"
+	// BackupInMaintenance is a backup that can run into maintenance
+	BackupInMaintenance Kind = "backup-in-maintenance"
+	// MaintenanceInBusinessHours is maintenance during business hours
+	MaintenanceInBusinessHours Kind = "maintenance-in-business-hours"
+	// BackupInBusinessHours is a backup that can run in business hours
+	BackupInBusinessHours Kind = "backup-in-business-hours"
+	// SharedSlot is instances in one environment sharing a maintenance slot
+	SharedSlot Kind = "shared-maintenance-slot"
+)
+
+// Conflict is one scheduling problem
+type Conflict struct {
+	Kind Kind
+	// Addresses are the instances involved, several for a shared slot
+	Addresses []string
+	Message   string
+}
+
+func (c Conflict) String() string {
+	if len(c.Addresses) == 1 {
+		return c.Addresses[0] + ": " + c.Message
+	}
+	return strings.Join(c.Addresses, ", ") + " " + c.Message
+}
+
+// Conflicts returns the scheduling problems of the instances: backups that
+// can run into maintenance, backups or maintenance in their application's
+// business hours, instances whose maintenance is unscheduled while their
+// application declares business hours, and instances in one environment
+// sharing a maintenance slot
+func Conflicts(instances []Instance, hours map[string]BusinessHours) ([]Conflict, error) {
+	business := map[string][]Window{}
+	for application, h := range hours {
+		windows, err := h.Windows()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", application, err)
+		}
+		business[application] = windows
+	}
+
+	var conflicts []Conflict
+	for _, instance := range instances {
+		report := func(kind Kind, format string, args ...interface{}) {
+			conflicts = append(conflicts, Conflict{Kind: kind, Addresses: []string{instance.Address}, Message: fmt.Sprintf(format, args...)})
+		}
+		open, declared := business[instance.Application]
+
+		if instance.Maintenance == nil {
+			if declared {
+				what := "no maintenance window"
+				if instance.Replica {
+					what = "read replica does not inherit its primary's maintenance window"
+				}
+				report(Unscheduled, "%s, so Cloud SQL can update it during %s business hours", what, instance.Application)
+			}
+		} else {
+			if backup, ok := overlap(instance.BackupWindows(), []Window{*instance.Maintenance}); ok {
+				report(BackupInMaintenance, "backup window %s overlaps maintenance window %s", daily(backup), instance.Maintenance)
+			}
+			if hours, ok := overlap(open, []Window{*instance.Maintenance}); ok {
+				report(MaintenanceInBusinessHours, "maintenance window %s overlaps %s business hours %s", instance.Maintenance, instance.Application, hours)
+			}
+		}
+		if backup, ok := overlap(instance.BackupWindows(), open); ok {
+			hours, _ := overlap(open, []Window{backup})
+			report(BackupInBusinessHours, "backup window %s overlaps %s business hours %s", daily(backup), instance.Application, hours)
+		}
+	}
+
+	slots := map[string][]string{}
+	for _, instance := range instances {
+		if instance.Maintenance != nil && !instance.Replica {
+			key := instance.Environment + "\x00" + instance.Maintenance.String()
+			slots[key] = append(slots[key], instance.Address)
+		}
+	}
+	for key, addresses := range slots {
+		if len(addresses) > 1 {
+			environment, slot, _ := strings.Cut(key, "\x00")
+			conflicts = append(conflicts, Conflict{Kind: SharedSlot, Addresses: addresses, Message: fmt.Sprintf("share the %s maintenance slot %s", environment, slot)})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].String() < conflicts[j].String() })
+	return conflicts, nil
+}
+
+// Known is a conflict accepted until its fix lands
+type Known struct {
+	Kind Kind
+	// Address is one of the instances involved
+	Address string
+	// Tracking names where the fix is tracked and is required
+	Tracking string
+}
+
+// Unexpected returns the conflicts no known conflict covers. A known
+// conflict covers the conflicts of its kind that involve its address.
+func Unexpected(conflicts []Conflict, known []Known) ([]Conflict, error) {
+	for _, k := range known {
+		if k.Tracking == "" {
+			return nil, fmt.Errorf("known %s conflict of %s has no tracking reference", k.Kind, k.Address)
+		}
+	}
+	var unexpected []Conflict
+	for _, conflict := range conflicts {
+		if !covered(conflict, known) {
+			unexpected = append(unexpected, conflict)
+		}
+	}
+	return unexpected, nil
+}
+
+func covered(conflict Conflict, known []Known) bool {
+	for _, k := range known {
+		if k.Kind != conflict.Kind {
+			continue
+		}
+		for _, address := range conflict.Addresses {
+			if address == k.Address {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// overlap returns the first window of a that overlaps one of b
+func overlap(a, b []Window) (Window, bool) {
+	for _, w := range a {
+		for _, other := range b {
+			if w.Overlaps(other) {
+				return w, true
+			}
+		}
+	}
+	return Window{}, false
+}
+
+// daily formats a backup window without its day, since it repeats daily
+func daily(w Window) string {
+	return fmt.Sprintf("daily %s-%s UTC", clock(w.Start), clock(w.Start+w.Length))
+}
+
+// NoConflictsE returns an error listing the scheduling conflicts in the
+// plan, leaving out the known ones
+func NoConflictsE(plan *terraform.PlanStruct, hours map[string]BusinessHours, known ...Known) error {
+	instances, err := FromPlan(plan)
+	if err != nil {
+		return err
+	}
+	conflicts, err := Conflicts(instances, hours)
+	if err != nil {
+		return err
+	}
+	conflicts, err = Unexpected(conflicts, known)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+	lines := make([]string, len(conflicts))
+	for i, conflict := range conflicts {
+		lines[i] = conflict.String()
+	}
+	return fmt.Errorf("%d scheduling conflicts:\n%s", len(conflicts), strings.Join(lines, "\n"))
+}
+
+// NoConflicts asserts the plan has no scheduling conflicts other than the
+// known ones
+func NoConflicts(t testing.TestingT, plan *terraform.PlanStruct, hours map[string]BusinessHours, known ...Known) bool {
+	return assert.NoError(t, NoConflictsE(plan, hours, known...))
+}
@@ -0,0 +1,124 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// TestOverlaps tests windows overlap across the end of the week but not when
+// one ends as the other starts
+func TestOverlaps(t *testing.T) {
+	t.Parallel()
+
+	sunday := Window{Start: 6*day + 22*time.Hour, Length: 4 * time.Hour}
+	assert.True(t, sunday.Overlaps(Window{Start: time.Hour, Length: time.Hour}))
+	assert.True(t, Window{Start: time.Hour, Length: time.Hour}.Overlaps(sunday))
+	assert.False(t, sunday.Overlaps(Window{Start: 2 * time.Hour, Length: time.Hour}))
+	assert.False(t, Window{Start: 3 * time.Hour, Length: time.Hour}.Overlaps(Window{Start: 4 * time.Hour, Length: time.Hour}))
+	assert.Equal(t, "Sun 22:00-02:00 UTC", sunday.String())
+}
+
+// TestBusinessHoursWindows tests local business hours are converted to UTC
+// at both daylight saving offsets
+func TestBusinessHoursWindows(t *testing.T) {
+	t.Parallel()
+
+	windows, err := BusinessHours{Location: "Europe/Rome", Days: []time.Weekday{time.Monday, time.Sunday}, Start: "08:00", End: "18:00"}.Windows()
+	require.NoError(t, err)
+	var got []string
+	for _, window := range windows {
+		got = append(got, window.String())
+	}
+	assert.Equal(t, []string{
+		"Mon 06:00-16:00 UTC",
+		"Mon 07:00-17:00 UTC",
+		"Sun 06:00-16:00 UTC",
+		"Sun 07:00-17:00 UTC",
+	}, got)
+
+	// Tokyo's Monday morning is still Sunday in UTC
+	windows, err = BusinessHours{Location: "Asia/Tokyo", Days: []time.Weekday{time.Monday}, Start: "08:00", End: "18:00"}.Windows()
+	require.NoError(t, err)
+	assert.Equal(t, []Window{{Start: 6*day + 23*time.Hour, Length: 10 * time.Hour}}, windows)
+
+	_, err = BusinessHours{Location: "Mars/Olympus_Mons", Start: "08:00", End: "18:00"}.Windows()
+	assert.Error(t, err)
+	_, err = BusinessHours{Location: "UTC", Start: "18:00", End: "08:00"}.Windows()
+	assert.EqualError(t, err, "business hours end 08:00 is not after start 18:00")
+}
+
+func kinds(conflicts []Conflict) []Kind {
+	var got []Kind
+	for _, conflict := range conflicts {
+		got = append(got, conflict.Kind)
+	}
+	return got
+}
+
+func lines(conflicts []Conflict) []string {
+	var got []string
+	for _, conflict := range conflicts {
+		got = append(got, conflict.String())
+	}
+	return got
+}
+
+// TestConflicts tests every kind of conflict over a whole plan
+func TestConflicts(t *testing.T) {
+	t.Parallel()
+
+	instances, err := FromPlan(planassert.LoadPlanFile(t, "testdata/plan.json"))
+	require.NoError(t, err)
+	require.Len(t, instances, 5)
+	assert.True(t, instances[1].Replica)
+	assert.Nil(t, instances[1].Maintenance)
+
+	conflicts, err := Conflicts(instances, DefaultBusinessHours)
+	require.NoError(t, err)
+	assert.Equal(t, []Kind{SharedSlot, Unscheduled, BackupInBusinessHours, BackupInMaintenance, MaintenanceInBusinessHours}, kinds(conflicts))
+	assert.Equal(t, []string{
+		"google_sql_database_instance.prod_app_a, google_sql_database_instance.prod_app_b share the prod maintenance slot Sun 03:00-04:00 UTC",
+		"google_sql_database_instance.prod_app_a_replica: read replica does not inherit its primary's maintenance window, so Cloud SQL can update it during app-a business hours",
+		"google_sql_database_instance.prod_app_b: backup window daily 03:00-07:00 UTC overlaps app-b business hours Mon 06:00-16:00 UTC",
+		"google_sql_database_instance.prod_app_b: backup window daily 03:00-07:00 UTC overlaps maintenance window Sun 03:00-04:00 UTC",
+		"google_sql_database_instance.test_app_a: maintenance window Wed 12:00-13:00 UTC overlaps app-a business hours Wed 06:00-16:00 UTC",
+	}, lines(conflicts))
+
+	// Without business hours only the instances' own windows are compared
+	conflicts, err = Conflicts(instances, nil)
+	require.NoError(t, err)
+	assert.Len(t, conflicts, 2)
+}
+
+// TestNoConflicts tests the error counts the conflicts
+func TestNoConflicts(t *testing.T) {
+	t.Parallel()
+
+	assert.ErrorContains(t, NoConflictsE(planassert.LoadPlanFile(t, "testdata/plan.json"), DefaultBusinessHours), "5 scheduling conflicts:\n")
+}
+
+// TestKnownConflicts tests known conflicts are left out by kind and address
+// and must carry a tracking reference
+func TestKnownConflicts(t *testing.T) {
+	t.Parallel()
+	plan := planassert.LoadPlanFile(t, "testdata/plan.json")
+
+	const tracking = "reschedule prod databases"
+	err := NoConflictsE(plan, DefaultBusinessHours,
+		Known{Kind: SharedSlot, Address: "google_sql_database_instance.prod_app_b", Tracking: tracking},
+		Known{Kind: BackupInMaintenance, Address: "google_sql_database_instance.prod_app_b", Tracking: tracking},
+		// Another instance's conflict of this kind is not covered
+		Known{Kind: BackupInBusinessHours, Address: "google_sql_database_instance.prod_app_a", Tracking: tracking},
+	)
+	assert.ErrorContains(t, err, "3 scheduling conflicts:\n")
+	assert.ErrorContains(t, err, "google_sql_database_instance.prod_app_b: backup window daily 03:00-07:00 UTC overlaps app-b business hours")
+	assert.NotContains(t, err.Error(), "share the prod maintenance slot")
+
+	err = NoConflictsE(plan, DefaultBusinessHours, Known{Kind: SharedSlot, Address: "google_sql_database_instance.prod_app_a"})
+	assert.EqualError(t, err, "known shared-maintenance-slot conflict of google_sql_database_instance.prod_app_a has no tracking reference")
+}
@@ -0,0 +1,500 @@
+// Package secrets audits plans and state for credentials handled in
+// cleartext. The cloudsql module takes user passwords as plain variables and
+// the environments feed them from their own variables, so one missing
+// sensitive flag, or a password copied into metadata or an output, puts it
+// in CLI output, plan files and state readers without anything failing.
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/gruntwork-io/terratest/modules/testing"
+	tfjson "github.com/hashicorp/terraform-json"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// secretName matches attribute, variable and output names that hold
+// credentials, but not settings about them such as enable_password_policy or
+// the secret ID of a google_secret_manager_secret_version
+var secretName = regexp.MustCompile(`(?i)(^|_)(password|passwd|secret_data|token|private_key|credentials|api_key)$|_secret$`)
+
+// minSecretLength is the shortest secret searched for elsewhere in a plan,
+// so short values such as "on" or "1" are not mistaken for leaks
+const minSecretLength = 6
+
+// Finding is one way a secret is handled in cleartext
+type Finding struct {
+	// Location is a resource attribute, output or variable address
+	Location string
+	Problem  string
+}
+
+func (f Finding) String() string {
+	return f.Location + ": " + f.Problem
+}
+
+// AuditPlan returns the findings in a plan: secret-looking attributes and
+// outputs not marked sensitive, secret values copied into unmarked
+// attributes or outputs, variables feeding secrets that are not marked
+// sensitive, and PostgreSQL users without a password policy when the cloudsql
+// module's enable_password_policy is set
+func AuditPlan(plan *terraform.PlanStruct) []Finding {
+	raw := plan.RawPlan
+	var root *tfjson.ConfigModule
+	if raw.Config != nil {
+		root = raw.Config.RootModule
+	}
+
+	a := auditor{secrets: map[string]string{}}
+	if root != nil {
+		for name, variable := range raw.Variables {
+			if declared, ok := root.Variables[name]; ok && declared.Sensitive {
+				a.addSecret(variable.Value, "var."+name)
+			}
+		}
+	}
+	if root != nil {
+		a.config(root, "")
+		a.passwordPolicies(plan, root)
+	}
+	if raw.PlannedValues != nil {
+		a.values(raw.PlannedValues)
+	}
+	return a.sorted()
+}
+
+// AuditState returns the findings in state read with terraform show -json:
+// secret-looking attributes and outputs not marked sensitive, and secret
+// values copied into unmarked attributes or outputs
+func AuditState(state *tfjson.State) []Finding {
+	a := auditor{secrets: map[string]string{}}
+	if state.Values != nil {
+		a.values(state.Values)
+	}
+	return a.sorted()
+}
+
+// LoadState reads the output of terraform show -json for a state file
+func LoadState(path string) (*tfjson.State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state tfjson.State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &state, nil
+}
+
+type auditor struct {
+	// secrets maps each sensitive value to where it came from
+	secrets  map[string]string
+	findings []Finding
+}
+
+// report records a finding, keeping only the first one for each location
+func (a *auditor) report(location, format string, args ...interface{}) {
+	for _, finding := range a.findings {
+		if finding.Location == location {
+			return
+		}
+	}
+	a.findings = append(a.findings, Finding{Location: location, Problem: fmt.Sprintf(format, args...)})
+}
+
+func (a *auditor) sorted() []Finding {
+	sort.Slice(a.findings, func(i, j int) bool {
+		if a.findings[i].Location != a.findings[j].Location {
+			return a.findings[i].Location < a.findings[j].Location
+		}
+		return a.findings[i].Problem < a.findings[j].Problem
+	})
+	return a.findings
+}
+
+// addSecret records every string in a sensitive value
+func (a *auditor) addSecret(value interface{}, source string) {
+	walk(value, source, func(path string, s string) {
+		if len(s) >= minSecretLength {
+			if _, seen := a.secrets[s]; !seen {
+				a.secrets[s] = path
+			}
+		}
+	})
+}
+
+// leak returns where a secret contained in s came from
+func (a *auditor) leak(s string) (string, bool) {
+	sources := make([]string, 0, len(a.secrets))
+	for secret, source := range a.secrets {
+		if strings.Contains(s, secret) {
+			sources = append(sources, source)
+		}
+	}
+	if len(sources) == 0 {
+		return "", false
+	}
+	sort.Strings(sources)
+	return sources[0], true
+}
+
+// leaf is an unmarked string attribute
+type leaf struct {
+	location, name, value string
+}
+
+// values checks planned or state values. Sensitive attributes are collected
+// first, so a secret copied anywhere in the plan is found.
+func (a *auditor) values(values *tfjson.StateValues) {
+	var leaves []leaf
+	for _, resource := range resources(values.RootModule) {
+		var mask interface{}
+		if len(resource.SensitiveValues) > 0 {
+			_ = json.Unmarshal(resource.SensitiveValues, &mask)
+		}
+		for name, value := range resource.AttributeValues {
+			a.attribute(resource.Address+"."+name, name, value, lookupMask(mask, name), &leaves)
+		}
+	}
+
+	for _, l := range leaves {
+		if source, ok := a.leak(l.value); ok {
+			a.report(l.location, "contains the sensitive value of %s but is not marked sensitive", source)
+		} else if secretName.MatchString(l.name) && l.value != "" {
+			a.report(l.location, "looks like a secret but is not marked sensitive")
+		}
+	}
+
+	names := make([]string, 0, len(values.Outputs))
+	for name := range values.Outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		output := values.Outputs[name]
+		if output.Sensitive {
+			continue
+		}
+		reported := false
+		walk(output.Value, "output."+name, func(path, s string) {
+			if source, ok := a.leak(s); ok && !reported {
+				a.report("output."+name, "contains the sensitive value of %s but is not marked sensitive", source)
+				reported = true
+			}
+		})
+		if !reported && secretName.MatchString(name) && output.Value != nil {
+			a.report("output."+name, "looks like a secret but is not marked sensitive")
+		}
+	}
+}
+
+// attribute walks a value alongside its sensitive_values mask, recording
+// sensitive strings as secrets and the rest as leaves to check
+func (a *auditor) attribute(location, name string, value, mask interface{}, leaves *[]leaf) {
+	if marked, _ := mask.(bool); marked {
+		a.addSecret(value, location)
+		return
+	}
+	switch v := value.(type) {
+	case string:
+		*leaves = append(*leaves, leaf{location, name, v})
+	case map[string]interface{}:
+		for key, child := range v {
+			a.attribute(location+"."+key, key, child, lookupMask(mask, key), leaves)
+		}
+	case []interface{}:
+		for i, child := range v {
+			a.attribute(location+"."+strconv.Itoa(i), name, child, lookupMask(mask, strconv.Itoa(i)), leaves)
+		}
+	}
+}
+
+// lookupMask returns the part of a sensitive_values mask for a key or index
+func lookupMask(mask interface{}, key string) interface{} {
+	switch m := mask.(type) {
+	case map[string]interface{}:
+		return m[key]
+	case []interface{}:
+		if i, err := strconv.Atoi(key); err == nil && i < len(m) {
+			return m[i]
+		}
+	}
+	return nil
+}
+
+// walk calls fn with the path of every string in a value
+func walk(value interface{}, path string, fn func(path, s string)) {
+	switch v := value.(type) {
+	case string:
+		fn(path, v)
+	case map[string]interface{}:
+		for key, child := range v {
+			walk(child, path+"."+key, fn)
+		}
+	case []interface{}:
+		for i, child := range v {
+			walk(child, path+"."+strconv.Itoa(i), fn)
+		}
+	}
+}
+
+func resources(module *tfjson.StateModule) []*tfjson.StateResource {
+	if module == nil {
+		return nil
+	}
+	all := append([]*tfjson.StateResource{}, module.Resources...)
+	for _, child := range module.ChildModules {
+		all = append(all, resources(child)...)
+	}
+	return all
+}
+
+// config checks the sensitive flags of a module's variables and outputs,
+// returning the variables that must be sensitive with why
+func (a *auditor) config(module *tfjson.ConfigModule, path string) map[string]string {
+	required := map[string]string{}
+	for _, resource := range module.Resources {
+		for attribute, references := range secretExpressions(resource.Expressions, "") {
+			for _, variable := range variables(references, resource.ForEachExpression) {
+				if _, ok := required[variable]; !ok {
+					required[variable] = fmt.Sprintf("feeds %s.%s", planassert.Qualify(path, resource.Address), attribute)
+				}
+			}
+		}
+	}
+
+	callNames := make([]string, 0, len(module.ModuleCalls))
+	for name := range module.ModuleCalls {
+		callNames = append(callNames, name)
+	}
+	sort.Strings(callNames)
+	for _, name := range callNames {
+		call := module.ModuleCalls[name]
+		if call.Module == nil {
+			continue
+		}
+		childPath := planassert.Qualify(path, "module."+name)
+		for variable := range a.config(call.Module, childPath) {
+			expression := call.Expressions[variable]
+			if expression == nil || expression.ExpressionData == nil {
+				continue
+			}
+			for _, parent := range variables(expression.References, nil) {
+				if _, ok := required[parent]; !ok {
+					required[parent] = fmt.Sprintf("is passed to %s.var.%s", childPath, variable)
+				}
+			}
+		}
+	}
+
+	for name, reason := range required {
+		if variable, ok := module.Variables[name]; ok && !variable.Sensitive {
+			a.report(planassert.Qualify(path, "var."+name), "%s but is not marked sensitive", reason)
+		}
+	}
+	for name, variable := range module.Variables {
+		if _, ok := required[name]; !ok && secretName.MatchString(name) && !variable.Sensitive {
+			a.report(planassert.Qualify(path, "var."+name), "looks like a secret but is not marked sensitive")
+		}
+	}
+
+	outputNames := make([]string, 0, len(module.Outputs))
+	for name := range module.Outputs {
+		outputNames = append(outputNames, name)
+	}
+	sort.Strings(outputNames)
+	for _, name := range outputNames {
+		output := module.Outputs[name]
+		if output.Sensitive || output.Expression == nil || output.Expression.ExpressionData == nil {
+			continue
+		}
+		for _, reference := range output.Expression.References {
+			if secretReference(reference, required) {
+				a.report(planassert.Qualify(path, "output."+name), "exposes %s but is not marked sensitive", reference)
+				break
+			}
+		}
+	}
+	return required
+}
+
+// secretExpressions returns the references of each secret-named argument,
+// including those in nested blocks
+func secretExpressions(expressions map[string]*tfjson.Expression, prefix string) map[string][]string {
+	found := map[string][]string{}
+	for name, expression := range expressions {
+		if expression == nil || expression.ExpressionData == nil {
+			continue
+		}
+		if secretName.MatchString(name) && len(expression.References) > 0 {
+			found[prefix+name] = expression.References
+		}
+		for i, block := range expression.NestedBlocks {
+			for nested, references := range secretExpressions(block, fmt.Sprintf("%s%s.%d.", prefix, name, i)) {
+				found[nested] = references
+			}
+		}
+	}
+	return found
+}
+
+// variables returns the variables an expression references, following
+// each.value to the resource's for_each expression
+func variables(references []string, forEach *tfjson.Expression) []string {
+	var names []string
+	for _, reference := range references {
+		if strings.HasPrefix(reference, "each.value") && forEach != nil && forEach.ExpressionData != nil {
+			names = append(names, variables(forEach.References, nil)...)
+		}
+		if rest, ok := strings.CutPrefix(reference, "var."); ok {
+			name, _, _ := strings.Cut(rest, ".")
+			names = append(names, strings.Split(name, "[")[0])
+		}
+	}
+	return names
+}
+
+// secretReference reports whether an output reference reaches a secret: a
+// variable that must be sensitive or a secret-named attribute. Whole
+// resources are left out, since outputs such as user_names only read
+// their names.
+func secretReference(reference string, required map[string]string) bool {
+	if rest, ok := strings.CutPrefix(reference, "var."); ok {
+		_, found := required[strings.Split(rest, ".")[0]]
+		return found
+	}
+	parts := strings.Split(reference, ".")
+	return len(parts) > 2 && secretName.MatchString(parts[len(parts)-1])
+}
+
+// passwordPolicies checks planned google_sql_user resources of PostgreSQL
+// instances have a password_policy block when their cloudsql module call
+// sets enable_password_policy, or leaves it at a true default, and reports
+// the users it cannot check because the input is not known from the plan
+func (a *auditor) passwordPolicies(plan *terraform.PlanStruct, root *tfjson.ConfigModule) {
+	engines := map[string]string{}
+	for _, resource := range planassert.ResourcesOfType(plan, "google_sql_database_instance") {
+		if version, _ := resource.AttributeValues["database_version"].(string); version != "" {
+			if master, _ := resource.AttributeValues["master_instance_name"].(string); master == "" {
+				engines[planassert.ModulePath(resource)] = version
+			}
+		}
+	}
+	for _, user := range planassert.ResourcesOfType(plan, "google_sql_user") {
+		module := planassert.ModulePath(user)
+		if !strings.HasPrefix(engines[module], "POSTGRES") {
+			continue
+		}
+		enabled, known := passwordPolicyEnabled(plan, root, module)
+		if !known {
+			a.report(user.Address, "password_policy not checked: the enable_password_policy input of %s is not known from the plan", module)
+			continue
+		}
+		if !enabled {
+			continue
+		}
+		if policy, _ := user.AttributeValues["password_policy"].([]interface{}); len(policy) == 0 {
+			a.report(user.Address, "PostgreSQL user has no password_policy although enable_password_policy is set")
+		}
+	}
+}
+
+// passwordPolicyEnabled returns the enable_password_policy input of the
+// module at path, and whether it is known from the plan. A module without
+// the input has no policy to enable.
+func passwordPolicyEnabled(plan *terraform.PlanStruct, root *tfjson.ConfigModule, path string) (bool, bool) {
+	module := root
+	var calls []*tfjson.ModuleCall
+	for _, segment := range strings.Split(path, ".") {
+		if segment == "module" || segment == "" {
+			continue
+		}
+		if module == nil {
+			return false, false
+		}
+		call := module.ModuleCalls[strings.Split(segment, "[")[0]]
+		if call == nil || call.Module == nil {
+			return false, false
+		}
+		calls = append(calls, call)
+		module = call.Module
+	}
+	if len(calls) == 0 {
+		return false, true
+	}
+	if _, declared := module.Variables["enable_password_policy"]; !declared {
+		return false, true
+	}
+	value, known := input(plan, calls, "enable_password_policy")
+	enabled, ok := value.(bool)
+	return enabled, known && ok
+}
+
+// input returns the value the innermost of a chain of module calls gives its
+// variable: a constant, the variable's default, or a variable of the caller
+// resolved the same way up to the planned root variables. An expression
+// referencing a single variable is taken to be that variable; any other is
+// not known.
+func input(plan *terraform.PlanStruct, calls []*tfjson.ModuleCall, name string) (interface{}, bool) {
+	if len(calls) == 0 {
+		variable := plan.RawPlan.Variables[name]
+		if variable == nil {
+			return nil, false
+		}
+		return variable.Value, true
+	}
+	call := calls[len(calls)-1]
+	expression := call.Expressions[name]
+	if expression == nil || expression.ExpressionData == nil {
+		variable := call.Module.Variables[name]
+		if variable == nil {
+			return nil, false
+		}
+		return variable.Default, true
+	}
+	if expression.ConstantValue != tfjson.UnknownConstantValue {
+		return expression.ConstantValue, true
+	}
+	if len(expression.References) == 1 {
+		if parent, ok := strings.CutPrefix(expression.References[0], "var."); ok && !strings.ContainsAny(parent, ".[") {
+			return input(plan, calls[:len(calls)-1], parent)
+		}
+	}
+	return nil, false
+}
+
+func findingsError(findings []Finding) error {
+	if len(findings) == 0 {
+		return nil
+	}
+	lines := make([]string, len(findings))
+	for i, finding := range findings {
+		lines[i] = finding.String()
+	}
+	return fmt.Errorf("%d secrets handled in cleartext:\n%s", len(findings), strings.Join(lines, "\n"))
+}
+
+// NoFindingsE returns an error listing the secret handling findings in the plan
+func NoFindingsE(plan *terraform.PlanStruct) error {
+	return findingsError(AuditPlan(plan))
+}
+
+// NoFindings asserts the plan handles every secret as sensitive
+func NoFindings(t testing.TestingT, plan *terraform.PlanStruct) bool {
+	return assert.NoError(t, NoFindingsE(plan))
+}
+
+// StateNoFindingsE returns an error listing the secret handling findings in state
+func StateNoFindingsE(state *tfjson.State) error {
+	return findingsError(AuditState(state))
+}
@@ -0,0 +1,118 @@
+package secrets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+func lines(findings []Finding) []string {
+	var got []string
+	for _, finding := range findings {
+		got = append(got, finding.String())
+	}
+	return got
+}
+
+// TestSecretName tests which names are treated as credentials
+func TestSecretName(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		secret bool
+	}{
+		{"password", true},
+		{"postgres_password", true},
+		{"root_password", true},
+		{"secret_data", true},
+		{"client_secret", true},
+		{"access_token", true},
+		{"private_key", true},
+		{"enable_password_policy", false},
+		{"password_policy", false},
+		{"password_expiration_duration", false},
+		{"secret", false},
+		{"secret_id", false},
+		{"name", false},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.secret, secretName.MatchString(tc.name), tc.name)
+	}
+}
+
+// TestAuditPlan tests cleartext secrets, missing sensitive flags and missing
+// password policies are found in a plan
+func TestAuditPlan(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{
+		"google_compute_instance.bastion.metadata.startup-script: contains the sensitive value of var.postgres_password but is not marked sensitive",
+		`module.reporting.google_sql_user.users["reporting_user"]: PostgreSQL user has no password_policy although enable_password_policy is set`,
+		`module.reporting.google_sql_user.users["reporting_user"].password: looks like a secret but is not marked sensitive`,
+		"module.reporting.var.users: feeds module.reporting.google_sql_user.users.password but is not marked sensitive",
+		"output.admin_password: exposes var.reporting_password but is not marked sensitive",
+		"output.app_a_connection: exposes var.postgres_password but is not marked sensitive",
+		"var.reporting_password: is passed to module.reporting.var.users but is not marked sensitive",
+	}, lines(AuditPlan(planassert.LoadPlanFile(t, "testdata/plan.json"))))
+}
+
+// TestPasswordPolicyEnabled tests enable_password_policy is read from the
+// module call, resolving variables up to the root and falling back to the
+// variable default
+func TestPasswordPolicyEnabled(t *testing.T) {
+	t.Parallel()
+
+	plan := planassert.LoadPlanFile(t, "testdata/plan.json")
+	root := plan.RawPlan.Config.RootModule
+	testCases := []struct {
+		module  string
+		enabled bool
+		known   bool
+	}{
+		{"module.app_a", true, true},
+		{"module.analytics", false, true},
+		// enable_password_policy = var.enforce_password_policy
+		{"module.reporting", true, true},
+		{"module.missing", false, false},
+		// The root module has no input to enable
+		{"", false, true},
+	}
+	for _, tc := range testCases {
+		enabled, known := passwordPolicyEnabled(plan, root, tc.module)
+		assert.Equal(t, tc.enabled, enabled, tc.module)
+		assert.Equal(t, tc.known, known, tc.module)
+	}
+}
+
+// TestPasswordPolicyUnknown tests users are reported rather than skipped when
+// enable_password_policy cannot be resolved
+func TestPasswordPolicyUnknown(t *testing.T) {
+	t.Parallel()
+
+	plan := planassert.LoadPlanFile(t, "testdata/plan.json")
+	call := plan.RawPlan.Config.RootModule.ModuleCalls["reporting"]
+	call.Expressions["enable_password_policy"].References = []string{"local.environment", "var.enforce_password_policy"}
+
+	assert.Contains(t, lines(AuditPlan(plan)), `module.reporting.google_sql_user.users["reporting_user"]: password_policy not checked: the enable_password_policy input of module.reporting is not known from the plan`)
+}
+
+// TestAuditState tests cleartext secrets are found in state
+func TestAuditState(t *testing.T) {
+	t.Parallel()
+
+	state, err := LoadState("testdata/state.json")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"module.app_a.google_cloud_run_v2_service.api.template.0.containers.0.env.0.value: contains the sensitive value of module.app_a.google_secret_manager_secret_version.dsn.secret_data but is not marked sensitive",
+		"module.app_a.google_sql_database_instance.instance.root_password: looks like a secret but is not marked sensitive",
+		"output.root_password: looks like a secret but is not marked sensitive",
+	}, lines(AuditState(state)))
+	assert.ErrorContains(t, StateNoFindingsE(state), "3 secrets handled in cleartext:\n")
+
+	_, err = LoadState("testdata/missing.json")
+	assert.Error(t, err)
+}
@@ -0,0 +1,252 @@
+// Package sqlflags checks Cloud SQL database_flags against an embedded
+// catalog of the flags each engine and major version supports. The cloudsql
+// module passes var.database_flags straight through, so without it a typo or
+// an out-of-range value only fails at apply, and a flag that restarts the
+// instance is only noticed when the database goes down.
+package sqlflags
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+//go:embed catalog.json
+var catalogJSON []byte
+
+// Type is the kind of value a flag takes
+type Type string
+
+const (
+	Boolean Type = "boolean"
+	Integer Type = "integer"
+	Float   Type = "float"
+	Enum    Type = "enum"
+	String  Type = "string"
+)
+
+// Definition is one catalog entry
+type Definition struct {
+	Name string `json:"name"`
+	Type Type   `json:"type"`
+	// Min and Max bound integer and float values
+	Min *float64 `json:"min"`
+	Max *float64 `json:"max"`
+	// Values are the allowed values of an enum
+	Values []string `json:"values"`
+	// Restart is set on flags that restart the instance when changed
+	Restart bool `json:"restart"`
+	// Since is the first major version with the flag, empty for all
+	Since string `json:"since"`
+	// Until is the last major version with the flag, empty for all
+	Until string `json:"until"`
+	// ReplacedBy is the flag that takes over once this one is removed
+	ReplacedBy string `json:"replaced_by"`
+	// Changed describes how the flag differs from its definition before Since
+	Changed string `json:"changed"`
+}
+
+// covers reports whether the definition applies to a major version
+func (d Definition) covers(engine, major string) bool {
+	i := index(engine, major)
+	return (d.Since == "" || i >= index(engine, d.Since)) && (d.Until == "" || i <= index(engine, d.Until))
+}
+
+type catalog struct {
+	Versions map[string][]string     `json:"versions"`
+	Flags    map[string][]Definition `json:"flags"`
+}
+
+var embedded = func() catalog {
+	var c catalog
+	if err := json.Unmarshal(catalogJSON, &c); err != nil {
+		panic(fmt.Sprintf("sqlflags: catalog.json: %v", err))
+	}
+	return c
+}()
+
+// Flag is a planned database flag
+type Flag struct {
+	Name  string
+	Value string
+}
+
+// FromValues returns the database_flags of a planned Cloud SQL instance's
+// attribute values
+func FromValues(values map[string]interface{}) []Flag {
+	list, _ := planassert.Lookup(values, "settings.0.database_flags")
+	blocks, _ := list.([]interface{})
+	var flags []Flag
+	for _, b := range blocks {
+		block, _ := b.(map[string]interface{})
+		name, _ := block["name"].(string)
+		value, _ := block["value"].(string)
+		flags = append(flags, Flag{Name: name, Value: value})
+	}
+	return flags
+}
+
+// engineNames are the engines as the API spells them in messages
+var engineNames = map[string]string{
+	"POSTGRES":  "PostgreSQL",
+	"SQLSERVER": "SQL Server",
+}
+
+// ParseVersion splits a database_version such as POSTGRES_9_6 or
+// SQLSERVER_2019_STANDARD into its engine and catalog major version
+func ParseVersion(databaseVersion string) (engine, major string, err error) {
+	engine, rest, _ := strings.Cut(databaseVersion, "_")
+	versions, ok := embedded.Versions[engine]
+	if !ok {
+		return "", "", fmt.Errorf("unsupported engine in database_version %q", databaseVersion)
+	}
+	for _, version := range versions {
+		if rest == version || strings.HasPrefix(rest, version+"_") {
+			return engine, version, nil
+		}
+	}
+	return "", "", fmt.Errorf("unsupported database_version %q (catalog covers %s %s)", databaseVersion, engine, strings.Join(versions, ", "))
+}
+
+// Lookup returns the definition of a flag for databaseVersion
+func Lookup(databaseVersion, name string) (Definition, error) {
+	engine, major, err := ParseVersion(databaseVersion)
+	if err != nil {
+		return Definition{}, err
+	}
+	var outside error
+	for _, definition := range embedded.Flags[engine] {
+		if definition.Name != name {
+			continue
+		}
+		if definition.covers(engine, major) {
+			return definition, nil
+		}
+		if outside != nil {
+			continue
+		}
+		if definition.Until != "" && index(engine, major) > index(engine, definition.Until) {
+			outside = fmt.Errorf("flag %q was removed after %s_%s", name, engine, definition.Until)
+			if definition.ReplacedBy != "" {
+				outside = fmt.Errorf("%w, use %q", outside, definition.ReplacedBy)
+			}
+		} else {
+			outside = fmt.Errorf("flag %q needs %s_%s or later", name, engine, definition.Since)
+		}
+	}
+	if outside != nil {
+		return Definition{}, outside
+	}
+	for other := range embedded.Flags {
+		if other == engine {
+			continue
+		}
+		for _, definition := range embedded.Flags[other] {
+			if definition.Name == name {
+				return Definition{}, fmt.Errorf("flag %q is %s-only", name, engineNames[other])
+			}
+		}
+	}
+	return Definition{}, fmt.Errorf("unknown flag %q for %s", name, databaseVersion)
+}
+
+// Versions returns the major versions of an engine the catalog covers,
+// oldest first
+func Versions(engine string) []string {
+	return embedded.Versions[engine]
+}
+
+// index returns the position of a major version in the engine's versions
+func index(engine, major string) int {
+	for i, version := range embedded.Versions[engine] {
+		if version == major {
+			return i
+		}
+	}
+	return -1
+}
+
+// Check returns why value is not valid for the flag, or nil
+func (d Definition) Check(value string) error {
+	switch d.Type {
+	case Boolean:
+		if value != "on" && value != "off" {
+			return fmt.Errorf("%q is not on or off", value)
+		}
+	case Integer, Float:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || d.Type == Integer && strings.ContainsAny(value, ".eE") {
+			return fmt.Errorf("%q is not %s", value, map[Type]string{Integer: "an integer", Float: "a number"}[d.Type])
+		}
+		if d.Min != nil && number < *d.Min {
+			return fmt.Errorf("%s is below the minimum %s", value, format(*d.Min))
+		}
+		if d.Max != nil && number > *d.Max {
+			return fmt.Errorf("%s is above the maximum %s", value, format(*d.Max))
+		}
+	case Enum:
+		for _, allowed := range d.Values {
+			if value == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of %s", value, strings.Join(d.Values, ", "))
+	}
+	return nil
+}
+
+func format(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// Validate checks the flags of an instance running databaseVersion and
+// returns one message per problem. With production set, flags that restart
+// the instance are rejected too, since changing them causes downtime.
+func Validate(databaseVersion string, flags []Flag, production bool) ([]string, error) {
+	if _, _, err := ParseVersion(databaseVersion); err != nil {
+		return nil, err
+	}
+	var problems []string
+	seen := map[string]bool{}
+	for _, flag := range flags {
+		if seen[flag.Name] {
+			problems = append(problems, fmt.Sprintf("flag %q is set more than once", flag.Name))
+			continue
+		}
+		seen[flag.Name] = true
+
+		definition, err := Lookup(databaseVersion, flag.Name)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if err := definition.Check(flag.Value); err != nil {
+			problems = append(problems, fmt.Sprintf("flag %q: %v", flag.Name, err))
+		}
+		if production && definition.Restart {
+			problems = append(problems, fmt.Sprintf("flag %q restarts the instance when changed", flag.Name))
+		}
+	}
+	return problems, nil
+}
+
+// Names returns the catalog's flags for databaseVersion, sorted
+func Names(databaseVersion string) ([]string, error) {
+	engine, major, err := ParseVersion(databaseVersion)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, definition := range embedded.Flags[engine] {
+		if definition.covers(engine, major) {
+			names = append(names, definition.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,132 @@
+package sqlflags
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseVersion tests engines and major versions are read from database_version
+func TestParseVersion(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		version string
+		engine  string
+		major   string
+	}{
+		{"POSTGRES_9_6", "POSTGRES", "9_6"},
+		{"POSTGRES_16", "POSTGRES", "16"},
+		{"SQLSERVER_2017_EXPRESS", "SQLSERVER", "2017"},
+		{"SQLSERVER_2022_ENTERPRISE", "SQLSERVER", "2022"},
+	}
+	for _, tc := range testCases {
+		engine, major, err := ParseVersion(tc.version)
+		require.NoError(t, err, tc.version)
+		assert.Equal(t, tc.engine, engine)
+		assert.Equal(t, tc.major, major)
+	}
+
+	_, _, err := ParseVersion("POSTGRES_17")
+	assert.ErrorContains(t, err, `unsupported database_version "POSTGRES_17"`)
+	_, _, err = ParseVersion("MYSQL_8_0")
+	assert.ErrorContains(t, err, "unsupported engine")
+}
+
+// TestValidate tests unknown flags, bad values, version and engine
+// mismatches and restarting flags in production
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	flags := []Flag{
+		{"log_connections", "on"},
+		{"work_mem", "4096"},
+		{"log_statement", "ddl"},
+		{"random_page_cost", "1.1"},
+		{"max_connections", "500"},
+	}
+	problems, err := Validate("POSTGRES_15", flags, false)
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+
+	problems, err = Validate("POSTGRES_15", flags, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{`flag "max_connections" restarts the instance when changed`}, problems)
+
+	problems, err = Validate("POSTGRES_12", []Flag{
+		{"log_conections", "on"},
+		{"log_connections", "true"},
+		{"work_mem", "4.5"},
+		{"work_mem", "8192"},
+		{"max_connections", "10"},
+		{"log_statement", "everything"},
+		{"idle_session_timeout", "60000"},
+	}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`unknown flag "log_conections" for POSTGRES_12`,
+		`flag "log_connections": "true" is not on or off`,
+		`flag "work_mem": "4.5" is not an integer`,
+		`flag "work_mem" is set more than once`,
+		`flag "max_connections": 10 is below the minimum 14`,
+		`flag "log_statement": "everything" is not one of none, ddl, mod, all`,
+		`flag "idle_session_timeout" needs POSTGRES_14 or later`,
+	}, problems)
+
+	problems, err = Validate("SQLSERVER_2019_STANDARD", []Flag{
+		{"max degree of parallelism", "40000"},
+		{"log_min_duration_statement", "1000"},
+		{"3226", "on"},
+	}, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`flag "max degree of parallelism": 40000 is above the maximum 32767`,
+		`flag "log_min_duration_statement" is PostgreSQL-only`,
+		`flag "3226" restarts the instance when changed`,
+	}, problems)
+}
+
+// TestLookup tests flags are looked up by the version range they exist in
+func TestLookup(t *testing.T) {
+	t.Parallel()
+
+	old, err := Lookup("POSTGRES_13", "password_encryption")
+	require.NoError(t, err)
+	assert.NoError(t, old.Check("on"))
+	assert.Empty(t, old.Changed)
+
+	current, err := Lookup("POSTGRES_14", "password_encryption")
+	require.NoError(t, err)
+	assert.Error(t, current.Check("on"))
+	assert.NotEmpty(t, current.Changed)
+
+	_, err = Lookup("POSTGRES_15", "wal_keep_segments")
+	assert.EqualError(t, err, `flag "wal_keep_segments" was removed after POSTGRES_12, use "wal_keep_size"`)
+	_, err = Lookup("POSTGRES_16", "vacuum_cleanup_index_scale_factor")
+	assert.EqualError(t, err, `flag "vacuum_cleanup_index_scale_factor" was removed after POSTGRES_13`)
+	_, err = Lookup("POSTGRES_10", "vacuum_cleanup_index_scale_factor")
+	assert.EqualError(t, err, `flag "vacuum_cleanup_index_scale_factor" needs POSTGRES_11 or later`)
+}
+
+// TestNames tests flags are listed per engine and version
+func TestNames(t *testing.T) {
+	t.Parallel()
+
+	old, err := Names("POSTGRES_9_6")
+	require.NoError(t, err)
+	current, err := Names("POSTGRES_16")
+	require.NoError(t, err)
+	assert.NotContains(t, old, "idle_session_timeout")
+	assert.NotContains(t, old, "max_parallel_workers")
+	assert.Contains(t, current, "idle_session_timeout")
+	assert.Contains(t, old, "wal_keep_segments")
+	assert.NotContains(t, current, "wal_keep_segments")
+	assert.Contains(t, current, "wal_keep_size")
+	assert.Equal(t, len(old)+1, len(current))
+
+	sqlserver, err := Names("SQLSERVER_2022_STANDARD")
+	require.NoError(t, err)
+	assert.Contains(t, sqlserver, "user connections")
+	assert.NotContains(t, sqlserver, "work_mem")
+}
@@ -0,0 +1,187 @@
+// Package sqltier parses Cloud SQL machine tiers such as db-custom-2-8192,
+// db-g1-small or db-n1-standard-4 into vCPUs and memory and checks them
+// against GCP's sizing constraints, so tests can assert how big an instance
+// is rather than comparing tier strings.
+package sqltier
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/gruntwork-io/terratest/modules/testing"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// Family is the kind of tier
+type Family string
+
+const (
+	// Custom tiers are db-custom-<vCPUs>-<memory MB>
+	Custom Family = "custom"
+	// SharedCore tiers share a vCPU and are not covered by the SLA
+	SharedCore Family = "shared-core"
+	// Predefined tiers are the legacy db-n1-standard-N and db-n1-highmem-N
+	Predefined Family = "predefined"
+)
+
+// Custom tier constraints
+const (
+	maxVCPUs = 96
+	// memoryStepMB is the granularity of custom memory sizes
+	memoryStepMB = 256
+	// minMemoryMB is the smallest custom memory size, 3.75 GB
+	minMemoryMB = 3840
+	// Memory per vCPU must be between 0.9 and 6.5 GB
+	minMemoryPerVCPUMB = 0.9 * 1024
+	maxMemoryPerVCPUMB = 6.5 * 1024
+)
+
+// sqlServerMinVCPUs are the fewest vCPUs each SQL Server edition runs on
+var sqlServerMinVCPUs = map[string]float64{
+	"EXPRESS":    1,
+	"WEB":        1,
+	"STANDARD":   1,
+	"ENTERPRISE": 2,
+}
+
+// Tier is a parsed machine tier
+type Tier struct {
+	Name   string
+	Family Family
+	// VCPUs is a fraction of one vCPU for shared-core tiers
+	VCPUs    float64
+	MemoryMB int
+}
+
+// MemoryGB returns the memory in GB
+func (t Tier) MemoryGB() float64 {
+	return float64(t.MemoryMB) / 1024
+}
+
+func (t Tier) String() string {
+	return fmt.Sprintf("%s (%s vCPU, %s GB)", t.Name, strconv.FormatFloat(t.VCPUs, 'f', -1, 64), strconv.FormatFloat(t.MemoryGB(), 'f', -1, 64))
+}
+
+var sharedCore = map[string]Tier{
+	"db-f1-micro": {Name: "db-f1-micro", Family: SharedCore, VCPUs: 0.2, MemoryMB: 614},
+	"db-g1-small": {Name: "db-g1-small", Family: SharedCore, VCPUs: 0.5, MemoryMB: 1740},
+}
+
+// predefinedMemoryMB is the memory per vCPU of the predefined series
+var predefinedMemoryMB = map[string]int{
+	"standard": 3840,
+	"highmem":  6656,
+}
+
+// Parse reads a tier name
+func Parse(name string) (Tier, error) {
+	if tier, ok := sharedCore[name]; ok {
+		return tier, nil
+	}
+
+	if rest, ok := strings.CutPrefix(name, "db-custom-"); ok {
+		cpus, memory, ok := strings.Cut(rest, "-")
+		vcpus, err := strconv.Atoi(cpus)
+		if !ok || err != nil {
+			return Tier{}, fmt.Errorf("invalid custom tier %q, want db-custom-<vCPUs>-<memory MB>", name)
+		}
+		memoryMB, err := strconv.Atoi(memory)
+		if err != nil {
+			return Tier{}, fmt.Errorf("invalid custom tier %q, want db-custom-<vCPUs>-<memory MB>", name)
+		}
+		return Tier{Name: name, Family: Custom, VCPUs: float64(vcpus), MemoryMB: memoryMB}, nil
+	}
+
+	if rest, ok := strings.CutPrefix(name, "db-n1-"); ok {
+		series, cpus, _ := strings.Cut(rest, "-")
+		perVCPU, known := predefinedMemoryMB[series]
+		vcpus, err := strconv.Atoi(cpus)
+		if !known || err != nil || vcpus < 1 || vcpus&(vcpus-1) != 0 || vcpus > 64 || series == "highmem" && vcpus < 2 {
+			return Tier{}, fmt.Errorf("unknown predefined tier %q", name)
+		}
+		return Tier{Name: name, Family: Predefined, VCPUs: float64(vcpus), MemoryMB: vcpus * perVCPU}, nil
+	}
+	return Tier{}, fmt.Errorf("unknown tier %q", name)
+}
+
+// Validate returns the reasons the tier cannot run databaseVersion, such as
+// POSTGRES_15 or SQLSERVER_2019_STANDARD
+func (t Tier) Validate(databaseVersion string) []string {
+	var problems []string
+	sqlServer := strings.HasPrefix(databaseVersion, "SQLSERVER")
+	if sqlServer && t.Family != Custom {
+		problems = append(problems, fmt.Sprintf("SQL Server needs a custom tier, not %s", t.Family))
+	}
+	if t.Family == Custom {
+		if t.VCPUs != 1 && (int(t.VCPUs)%2 != 0 || t.VCPUs < 1 || t.VCPUs > maxVCPUs) {
+			problems = append(problems, fmt.Sprintf("%v vCPUs: want 1 or an even number up to %d", t.VCPUs, maxVCPUs))
+		}
+		if t.MemoryMB%memoryStepMB != 0 {
+			problems = append(problems, fmt.Sprintf("%d MB is not a multiple of %d MB", t.MemoryMB, memoryStepMB))
+		}
+		if t.MemoryMB < minMemoryMB {
+			problems = append(problems, fmt.Sprintf("%d MB is below the minimum %d MB", t.MemoryMB, minMemoryMB))
+		}
+		if perVCPU := float64(t.MemoryMB) / t.VCPUs; t.VCPUs > 0 && (perVCPU < minMemoryPerVCPUMB || perVCPU > maxMemoryPerVCPUMB) {
+			problems = append(problems, fmt.Sprintf("%.0f MB per vCPU is outside 0.9 to 6.5 GB", perVCPU))
+		}
+	}
+	if sqlServer && t.Family == Custom {
+		parts := strings.Split(databaseVersion, "_")
+		edition := parts[len(parts)-1]
+		if min, ok := sqlServerMinVCPUs[edition]; ok && t.VCPUs < min {
+			problems = append(problems, fmt.Sprintf("SQL Server %s needs at least %v vCPUs", strings.ToLower(edition), min))
+		}
+	}
+	return problems
+}
+
+// Planned returns the parsed tier of a planned Cloud SQL instance
+func Planned(plan *terraform.PlanStruct, address string) (Tier, error) {
+	value, err := planassert.Attribute(plan, address, "settings.0.tier")
+	if err != nil {
+		return Tier{}, err
+	}
+	name, _ := value.(string)
+	return Parse(name)
+}
+
+// AtLeastE returns an error unless the planned instance has at least vcpus
+// vCPUs and memoryGB of memory
+func AtLeastE(plan *terraform.PlanStruct, address string, vcpus, memoryGB float64) error {
+	tier, err := Planned(plan, address)
+	if err != nil {
+		return err
+	}
+	if tier.VCPUs < vcpus || tier.MemoryGB() < memoryGB {
+		return fmt.Errorf("%s: tier %s is smaller than %v vCPU and %v GB", address, tier, vcpus, memoryGB)
+	}
+	return nil
+}
+
+// AtLeast asserts the planned instance has at least vcpus vCPUs and memoryGB of memory
+func AtLeast(t testing.TestingT, plan *terraform.PlanStruct, address string, vcpus, memoryGB float64) bool {
+	return assert.NoError(t, AtLeastE(plan, address, vcpus, memoryGB))
+}
+
+// SizeE returns an error unless the planned instance has exactly vcpus vCPUs
+// and memoryGB of memory
+func SizeE(plan *terraform.PlanStruct, address string, vcpus, memoryGB float64) error {
+	tier, err := Planned(plan, address)
+	if err != nil {
+		return err
+	}
+	if tier.VCPUs != vcpus || tier.MemoryGB() != memoryGB {
+		return fmt.Errorf("%s: tier %s, want %v vCPU and %v GB", address, tier, vcpus, memoryGB)
+	}
+	return nil
+}
+
+// Size asserts the planned instance has exactly vcpus vCPUs and memoryGB of memory
+func Size(t testing.TestingT, plan *terraform.PlanStruct, address string, vcpus, memoryGB float64) bool {
+	return assert.NoError(t, SizeE(plan, address, vcpus, memoryGB))
+}
@@ -0,0 +1,83 @@
+package sqltier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// TestParse tests custom, shared-core and predefined tiers
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		family   Family
+		vcpus    float64
+		memoryMB int
+	}{
+		{"db-custom-2-8192", Custom, 2, 8192},
+		{"db-custom-1-3840", Custom, 1, 3840},
+		{"db-f1-micro", SharedCore, 0.2, 614},
+		{"db-g1-small", SharedCore, 0.5, 1740},
+		{"db-n1-standard-4", Predefined, 4, 15360},
+		{"db-n1-highmem-2", Predefined, 2, 13312},
+	}
+	for _, tc := range testCases {
+		tier, err := Parse(tc.name)
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, Tier{Name: tc.name, Family: tc.family, VCPUs: tc.vcpus, MemoryMB: tc.memoryMB}, tier)
+	}
+
+	for _, name := range []string{"db-custom-2", "db-custom-two-8192", "db-n1-standard-3", "db-n1-highmem-1", "db-n1-standard-0", "n2-standard-2"} {
+		_, err := Parse(name)
+		assert.Error(t, err, name)
+	}
+	tier, _ := Parse("db-custom-2-8192")
+	assert.Equal(t, "db-custom-2-8192 (2 vCPU, 8 GB)", tier.String())
+}
+
+// TestValidate tests GCP's custom tier and SQL Server constraints
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		version  string
+		problems []string
+	}{
+		{"db-custom-2-8192", "POSTGRES_15", nil},
+		{"db-custom-96-393216", "POSTGRES_15", nil},
+		{"db-g1-small", "POSTGRES_15", nil},
+		{"db-custom-3-7680", "POSTGRES_15", []string{"3 vCPUs: want 1 or an even number up to 96"}},
+		{"db-custom-2-8000", "POSTGRES_15", []string{"8000 MB is not a multiple of 256 MB"}},
+		{"db-custom-1-3584", "POSTGRES_15", []string{"3584 MB is below the minimum 3840 MB"}},
+		{"db-custom-2-14336", "POSTGRES_15", []string{"7168 MB per vCPU is outside 0.9 to 6.5 GB"}},
+		{"db-custom-8-3840", "POSTGRES_15", []string{"480 MB per vCPU is outside 0.9 to 6.5 GB"}},
+		{"db-custom-2-8192", "SQLSERVER_2019_ENTERPRISE", nil},
+		{"db-custom-1-3840", "SQLSERVER_2019_ENTERPRISE", []string{"SQL Server enterprise needs at least 2 vCPUs"}},
+		{"db-custom-1-3840", "SQLSERVER_2019_STANDARD", nil},
+		{"db-g1-small", "SQLSERVER_2019_EXPRESS", []string{"SQL Server needs a custom tier, not shared-core"}},
+	}
+	for _, tc := range testCases {
+		tier, err := Parse(tc.name)
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, tc.problems, tier.Validate(tc.version), "%s on %s", tc.name, tc.version)
+	}
+}
+
+// TestAtLeast tests sizing assertions against a planned instance
+func TestAtLeast(t *testing.T) {
+	t.Parallel()
+
+	plan := planassert.LoadPlanFile(t, "testdata/plan.json")
+	address := "module.cloudsql.google_sql_database_instance.instance"
+
+	assert.NoError(t, AtLeastE(plan, address, 2, 4))
+	assert.NoError(t, SizeE(plan, address, 2, 4))
+	assert.EqualError(t, AtLeastE(plan, address, 4, 4), address+": tier db-custom-2-4096 (2 vCPU, 4 GB) is smaller than 4 vCPU and 4 GB")
+	assert.EqualError(t, SizeE(plan, address, 2, 8), address+": tier db-custom-2-4096 (2 vCPU, 4 GB), want 2 vCPU and 8 GB")
+}
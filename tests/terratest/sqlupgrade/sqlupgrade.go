@@ -0,0 +1,306 @@
+// Package sqlupgrade checks the path from a legacy Cloud SQL database_version
+// to its migration target: whether Cloud SQL can upgrade in place, which hops
+// that takes, and which database_flags disappear or change meaning on the way.
+package sqlupgrade
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/gruntwork-io/terratest/modules/testing"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+	"github.com/unicredit/gcp-migration/tests/terratest/sqlflags"
+)
+
+// Kind is how a hop is carried out
+type Kind string
+
+const (
+	// MajorVersion is an in-place major version upgrade
+	MajorVersion Kind = "major version upgrade"
+	// Edition is an in-place SQL Server edition upgrade, which Cloud SQL runs
+	// separately from major version upgrades
+	Edition Kind = "edition upgrade"
+	// Import restores a backup of a version Cloud SQL does not run into a new
+	// instance, since there is nothing to upgrade in place
+	Import Kind = "import"
+)
+
+// legacyVersions are SQL Server versions Cloud SQL does not run, oldest first.
+// Their backups restore onto any version Cloud SQL does run.
+var legacyVersions = map[string][]string{
+	"SQLSERVER": {"2008_R2", "2012", "2014", "2016"},
+}
+
+// inPlace lists the major versions Cloud SQL upgrades each version to
+// directly. PostgreSQL upgrades to any later version.
+var inPlace = map[string]map[string][]string{
+	"SQLSERVER": {
+		"2017": {"2019", "2022"},
+		"2019": {"2022"},
+	},
+}
+
+// editions are the SQL Server editions from least to most capable
+var editions = []string{"EXPRESS", "WEB", "STANDARD", "ENTERPRISE"}
+
+// Version is a parsed database_version
+type Version struct {
+	Engine string
+	Major  string
+	// Edition is empty for PostgreSQL, and for legacy SQL Server versions
+	// given without one
+	Edition string
+	// Legacy is set for versions Cloud SQL does not run
+	Legacy bool
+}
+
+func (v Version) String() string {
+	s := v.Engine + "_" + v.Major
+	if v.Edition != "" {
+		s += "_" + v.Edition
+	}
+	return s
+}
+
+// ParseVersion reads a database_version such as POSTGRES_9_6,
+// SQLSERVER_2019_STANDARD or the legacy SQLSERVER_2014
+func ParseVersion(databaseVersion string) (Version, error) {
+	engine, rest, _ := strings.Cut(databaseVersion, "_")
+	for _, major := range legacyVersions[engine] {
+		if rest == major || strings.HasPrefix(rest, major+"_") {
+			version := Version{Engine: engine, Major: major, Legacy: true}
+			version.Edition = strings.TrimPrefix(strings.TrimPrefix(rest, major), "_")
+			return version, checkEdition(version, databaseVersion)
+		}
+	}
+
+	engine, major, err := sqlflags.ParseVersion(databaseVersion)
+	if err != nil {
+		return Version{}, err
+	}
+	version := Version{Engine: engine, Major: major}
+	version.Edition = strings.TrimPrefix(strings.TrimPrefix(rest, major), "_")
+	if engine == "SQLSERVER" && version.Edition == "" {
+		return Version{}, fmt.Errorf("database_version %q has no edition", databaseVersion)
+	}
+	return version, checkEdition(version, databaseVersion)
+}
+
+func checkEdition(version Version, databaseVersion string) error {
+	if version.Edition != "" && rank(version.Edition) < 0 {
+		return fmt.Errorf("unknown edition in database_version %q", databaseVersion)
+	}
+	return nil
+}
+
+func rank(edition string) int {
+	for i, e := range editions {
+		if e == edition {
+			return i
+		}
+	}
+	return -1
+}
+
+// order returns the position of a major version among all the engine's
+// versions, legacy ones first
+func order(engine, major string) int {
+	for i, version := range append(append([]string{}, legacyVersions[engine]...), sqlflags.Versions(engine)...) {
+		if version == major {
+			return i
+		}
+	}
+	return -1
+}
+
+// Hop is one step of an upgrade
+type Hop struct {
+	From Version
+	To   Version
+	Kind Kind
+}
+
+func (h Hop) String() string {
+	return fmt.Sprintf("%s -> %s (%s)", h.From, h.To, h.Kind)
+}
+
+// Path returns the hops from one database_version to another. Major version
+// upgrades keep the edition, so a SQL Server edition change is a hop of its
+// own after the last one. Legacy SQL Server versions are imported straight
+// into the target.
+func Path(from, to string) ([]Hop, error) {
+	source, err := ParseVersion(from)
+	if err != nil {
+		return nil, err
+	}
+	target, err := ParseVersion(to)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case target.Legacy:
+		return nil, fmt.Errorf("%s does not run on Cloud SQL", to)
+	case source.Engine != target.Engine:
+		return nil, fmt.Errorf("cannot upgrade %s to %s: engines differ, migrate with an export and import", from, to)
+	case order(source.Engine, source.Major) > order(target.Engine, target.Major):
+		return nil, fmt.Errorf("cannot upgrade %s to %s: major version downgrades are not supported", from, to)
+	case source.Legacy:
+		return []Hop{{From: source, To: target, Kind: Import}}, nil
+	case rank(source.Edition) > rank(target.Edition):
+		return nil, fmt.Errorf("cannot upgrade %s to %s: edition downgrades are not supported, migrate with an export and import", from, to)
+	}
+
+	var hops []Hop
+	if source.Major != target.Major {
+		majors, err := majorPath(source.Engine, source.Major, target.Major)
+		if err != nil {
+			return nil, fmt.Errorf("cannot upgrade %s to %s: %w", from, to, err)
+		}
+		current := source
+		for _, major := range majors {
+			next := current
+			next.Major = major
+			hops = append(hops, Hop{From: current, To: next, Kind: MajorVersion})
+			current = next
+		}
+	}
+	if source.Edition != target.Edition {
+		last := source
+		if len(hops) > 0 {
+			last = hops[len(hops)-1].To
+		}
+		hops = append(hops, Hop{From: last, To: target, Kind: Edition})
+	}
+	return hops, nil
+}
+
+// majorPath returns the fewest major versions to step through, ending with to
+func majorPath(engine, from, to string) ([]string, error) {
+	previous := map[string]string{from: ""}
+	queue := []string{from}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if current == to {
+			var majors []string
+			for major := to; major != from; major = previous[major] {
+				majors = append([]string{major}, majors...)
+			}
+			return majors, nil
+		}
+		for _, next := range targets(engine, current) {
+			if _, seen := previous[next]; !seen {
+				previous[next] = current
+				queue = append(queue, next)
+			}
+		}
+	}
+	return nil, fmt.Errorf("no in-place upgrade path from %s_%s to %s_%s", engine, from, engine, to)
+}
+
+// targets returns the major versions Cloud SQL upgrades major to directly
+func targets(engine, major string) []string {
+	if table, ok := inPlace[engine]; ok {
+		return table[major]
+	}
+	versions := sqlflags.Versions(engine)
+	for i, version := range versions {
+		if version == major {
+			return versions[i+1:]
+		}
+	}
+	return nil
+}
+
+// FlagChanges returns, for each hop, the flags that are removed, change
+// meaning or take a value the new version rejects. A removed flag is reported
+// once. Flags of legacy versions are not catalogued, so imported flags are
+// only checked against the target.
+func FlagChanges(hops []Hop, flags []sqlflags.Flag) []string {
+	var messages []string
+	removed := map[string]bool{}
+	for _, hop := range hops {
+		if hop.Kind == Edition {
+			continue
+		}
+		for _, flag := range flags {
+			if removed[flag.Name] {
+				continue
+			}
+			prefix := fmt.Sprintf("%s: flag %q", hop, flag.Name)
+			definition, err := sqlflags.Lookup(hop.To.String(), flag.Name)
+			if err != nil {
+				removed[flag.Name] = true
+				messages = append(messages, fmt.Sprintf("%s: %v", hop, err))
+				continue
+			}
+			if hop.Kind == MajorVersion && definition.Changed != "" {
+				if before, err := sqlflags.Lookup(hop.From.String(), flag.Name); err == nil && before.Since != definition.Since {
+					messages = append(messages, fmt.Sprintf("%s changes meaning: %s", prefix, definition.Changed))
+				}
+			}
+			if err := definition.Check(flag.Value); err != nil {
+				messages = append(messages, fmt.Sprintf("%s: %v", prefix, err))
+			}
+		}
+	}
+	return messages
+}
+
+// Upgrade is the checked path from a legacy version to its target
+type Upgrade struct {
+	Hops []Hop
+	// Flags are the problems FlagChanges finds along the hops
+	Flags []string
+}
+
+// Check returns the path between two database_versions and the flag problems
+// along it
+func Check(from, to string, flags []sqlflags.Flag) (Upgrade, error) {
+	hops, err := Path(from, to)
+	if err != nil {
+		return Upgrade{}, err
+	}
+	return Upgrade{Hops: hops, Flags: FlagChanges(hops, flags)}, nil
+}
+
+// SupportedE returns an error unless Cloud SQL can take from to to with the
+// flags carrying over unchanged
+func SupportedE(from, to string, flags []sqlflags.Flag) error {
+	upgrade, err := Check(from, to, flags)
+	if err != nil {
+		return err
+	}
+	if len(upgrade.Flags) > 0 {
+		return fmt.Errorf("%d database flag problems upgrading %s to %s:\n%s", len(upgrade.Flags), from, to, strings.Join(upgrade.Flags, "\n"))
+	}
+	return nil
+}
+
+// Supported asserts Cloud SQL can take from to to with the flags carrying
+// over unchanged
+func Supported(t testing.TestingT, from, to string, flags []sqlflags.Flag) bool {
+	return assert.NoError(t, SupportedE(from, to, flags))
+}
+
+// FromLegacyE returns an error unless Cloud SQL can take the legacy version
+// to the planned instance's database_version with its planned flags
+func FromLegacyE(plan *terraform.PlanStruct, address, legacy string) error {
+	resource, err := planassert.Resource(plan, address)
+	if err != nil {
+		return err
+	}
+	values := resource.AttributeValues
+	target, _ := values["database_version"].(string)
+	return SupportedE(legacy, target, sqlflags.FromValues(values))
+}
+
+// FromLegacy asserts Cloud SQL can take the legacy version to the planned
+// instance's database_version with its planned flags
+func FromLegacy(t testing.TestingT, plan *terraform.PlanStruct, address, legacy string) bool {
+	return assert.NoError(t, FromLegacyE(plan, address, legacy))
+}
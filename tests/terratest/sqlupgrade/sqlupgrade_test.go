@@ -0,0 +1,167 @@
+package sqlupgrade
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+	"github.com/unicredit/gcp-migration/tests/terratest/sqlflags"
+)
+
+// TestPath tests the hops between legacy and target versions
+func TestPath(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		from string
+		to   string
+		hops []string
+		err  string
+	}{
+		{"app_a_9_6", "POSTGRES_9_6", "POSTGRES_15", []string{
+			"POSTGRES_9_6 -> POSTGRES_15 (major version upgrade)",
+		}, ""},
+		{"app_a_10", "POSTGRES_10", "POSTGRES_16", []string{
+			"POSTGRES_10 -> POSTGRES_16 (major version upgrade)",
+		}, ""},
+		{"app_b_2014", "SQLSERVER_2014_STANDARD", "SQLSERVER_2019_STANDARD", []string{
+			"SQLSERVER_2014_STANDARD -> SQLSERVER_2019_STANDARD (import)",
+		}, ""},
+		{"app_b_2016", "SQLSERVER_2016", "SQLSERVER_2022_ENTERPRISE", []string{
+			"SQLSERVER_2016 -> SQLSERVER_2022_ENTERPRISE (import)",
+		}, ""},
+		{"sqlserver_in_place", "SQLSERVER_2017_STANDARD", "SQLSERVER_2022_STANDARD", []string{
+			"SQLSERVER_2017_STANDARD -> SQLSERVER_2022_STANDARD (major version upgrade)",
+		}, ""},
+		{"sqlserver_edition", "SQLSERVER_2017_STANDARD", "SQLSERVER_2019_ENTERPRISE", []string{
+			"SQLSERVER_2017_STANDARD -> SQLSERVER_2019_STANDARD (major version upgrade)",
+			"SQLSERVER_2019_STANDARD -> SQLSERVER_2019_ENTERPRISE (edition upgrade)",
+		}, ""},
+		{"same_version", "POSTGRES_15", "POSTGRES_15", nil, ""},
+		{"downgrade", "POSTGRES_15", "POSTGRES_13", nil, "major version downgrades are not supported"},
+		{"edition_downgrade", "SQLSERVER_2019_ENTERPRISE", "SQLSERVER_2022_STANDARD", nil, "edition downgrades are not supported"},
+		{"engine_change", "SQLSERVER_2016", "POSTGRES_15", nil, "engines differ"},
+		{"legacy_target", "SQLSERVER_2014", "SQLSERVER_2016", nil, "SQLSERVER_2016 does not run on Cloud SQL"},
+		{"unknown", "POSTGRES_9_4", "POSTGRES_15", nil, `unsupported database_version "POSTGRES_9_4"`},
+		{"no_edition", "SQLSERVER_2016", "SQLSERVER_2019", nil, `database_version "SQLSERVER_2019" has no edition`},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			hops, err := Path(tc.from, tc.to)
+			if tc.err != "" {
+				assert.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			var got []string
+			for _, hop := range hops {
+				got = append(got, hop.String())
+			}
+			assert.Equal(t, tc.hops, got)
+		})
+	}
+}
+
+// TestMajorPath tests the shortest path is taken through the in-place table
+func TestMajorPath(t *testing.T) {
+	t.Parallel()
+
+	majors, err := majorPath("SQLSERVER", "2017", "2022")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2022"}, majors)
+
+	_, err = majorPath("SQLSERVER", "2022", "2017")
+	assert.EqualError(t, err, "no in-place upgrade path from SQLSERVER_2022 to SQLSERVER_2017")
+}
+
+// TestFlagChanges tests flags that disappear, change meaning or become
+// invalid are reported along the path
+func TestFlagChanges(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		from     string
+		to       string
+		flags    []sqlflags.Flag
+		messages []string
+	}{
+		{"unchanged", "POSTGRES_9_6", "POSTGRES_15", []sqlflags.Flag{
+			{Name: "log_connections", Value: "on"},
+			{Name: "work_mem", Value: "4096"},
+		}, nil},
+		{"removed", "POSTGRES_10", "POSTGRES_16", []sqlflags.Flag{
+			{Name: "wal_keep_segments", Value: "64"},
+			{Name: "vacuum_defer_cleanup_age", Value: "0"},
+			{Name: "force_parallel_mode", Value: "off"},
+		}, []string{
+			`POSTGRES_10 -> POSTGRES_16 (major version upgrade): flag "wal_keep_segments" was removed after POSTGRES_12, use "wal_keep_size"`,
+			`POSTGRES_10 -> POSTGRES_16 (major version upgrade): flag "vacuum_defer_cleanup_age" was removed after POSTGRES_15`,
+			`POSTGRES_10 -> POSTGRES_16 (major version upgrade): flag "force_parallel_mode" was removed after POSTGRES_15, use "debug_parallel_query"`,
+		}},
+		{"changed_meaning", "POSTGRES_9_6", "POSTGRES_15", []sqlflags.Flag{
+			{Name: "password_encryption", Value: "on"},
+		}, []string{
+			`POSTGRES_9_6 -> POSTGRES_15 (major version upgrade): flag "password_encryption" changes meaning: on and off are no longer accepted, and the default changes from md5 to scram-sha-256`,
+			`POSTGRES_9_6 -> POSTGRES_15 (major version upgrade): flag "password_encryption": "on" is not one of md5, scram-sha-256`,
+		}},
+		{"already_changed", "POSTGRES_14", "POSTGRES_16", []sqlflags.Flag{
+			{Name: "password_encryption", Value: "scram-sha-256"},
+		}, nil},
+		{"imported", "SQLSERVER_2016", "SQLSERVER_2019_STANDARD", []sqlflags.Flag{
+			{Name: "max degree of parallelism", Value: "8"},
+			{Name: "1118", Value: "on"},
+		}, []string{
+			`SQLSERVER_2016 -> SQLSERVER_2019_STANDARD (import): unknown flag "1118" for SQLSERVER_2019_STANDARD`,
+		}},
+		{"edition", "SQLSERVER_2017_STANDARD", "SQLSERVER_2019_ENTERPRISE", []sqlflags.Flag{
+			{Name: "user connections", Value: "0"},
+		}, nil},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			upgrade, err := Check(tc.from, tc.to, tc.flags)
+			require.NoError(t, err)
+			assert.Equal(t, tc.messages, upgrade.Flags)
+		})
+	}
+}
+
+// TestSupported tests the error lists every flag problem
+func TestSupported(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, SupportedE("POSTGRES_9_6", "POSTGRES_15", []sqlflags.Flag{{Name: "autovacuum", Value: "on"}}))
+
+	err := SupportedE("POSTGRES_9_6", "POSTGRES_15", []sqlflags.Flag{{Name: "operator_precedence_warning", Value: "on"}})
+	assert.EqualError(t, err, "1 database flag problems upgrading POSTGRES_9_6 to POSTGRES_15:\n"+
+		`POSTGRES_9_6 -> POSTGRES_15 (major version upgrade): flag "operator_precedence_warning" was removed after POSTGRES_13`)
+
+	assert.ErrorContains(t, SupportedE("POSTGRES_15", "POSTGRES_9_6", nil), "downgrades")
+}
+
+// TestFromLegacy tests the planned version and flags are checked against the
+// legacy version
+func TestFromLegacy(t *testing.T) {
+	t.Parallel()
+
+	plan := planassert.LoadPlanFile(t, "testdata/plan.json")
+
+	assert.NoError(t, FromLegacyE(plan, "module.app_a.google_sql_database_instance.instance", "POSTGRES_14"))
+	assert.ErrorContains(t, FromLegacyE(plan, "module.app_a.google_sql_database_instance.instance", "POSTGRES_9_6"),
+		`flag "password_encryption" changes meaning`)
+	assert.NoError(t, FromLegacyE(plan, "module.app_b.google_sql_database_instance.instance", "SQLSERVER_2016"))
+	assert.ErrorContains(t, FromLegacyE(plan, "module.app_b.google_sql_database_instance.instance", "SQLSERVER_2022_STANDARD"), "downgrades")
+	assert.Error(t, FromLegacyE(plan, "module.missing.google_sql_database_instance.instance", "POSTGRES_9_6"))
+}
@@ -0,0 +1,316 @@
+package sweep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/iam/v1"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sqladmin/v1beta4"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/planassert"
+)
+
+// pollInterval is how often unfinished operations are checked
+var pollInterval = 5 * time.Second
+
+// Endpoints overrides the API endpoints and credentials, e.g. to target the
+// gcpstub stand-in. Empty fields use the defaults.
+type Endpoints struct {
+	// Compute includes the version, e.g. https://host/compute/v1/
+	Compute string
+	// SQL and IAM exclude the version, as the Go clients add it themselves
+	SQL string
+	IAM string
+	// Token is an OAuth access token used instead of application default
+	// credentials
+	Token string
+}
+
+// EndpointsFromEnv reads the google provider's custom endpoint and access
+// token variables, so the sweeper follows the same settings as terraform
+func EndpointsFromEnv(getenv func(string) string) Endpoints {
+	return Endpoints{
+		Compute: getenv("GOOGLE_COMPUTE_CUSTOM_ENDPOINT"),
+		SQL:     strings.TrimSuffix(getenv("GOOGLE_SQL_CUSTOM_ENDPOINT"), "sql/v1beta4/"),
+		IAM:     strings.TrimSuffix(getenv("GOOGLE_IAM_CUSTOM_ENDPOINT"), "v1/"),
+		Token:   getenv("GOOGLE_OAUTH_ACCESS_TOKEN"),
+	}
+}
+
+// Sweeper lists and deletes suite resources in one project
+type Sweeper struct {
+	Project string
+
+	compute *compute.Service
+	sql     *sqladmin.Service
+	iam     *iam.Service
+	now     func() time.Time
+}
+
+// New connects to the Compute Engine, Cloud SQL Admin and IAM APIs
+func New(ctx context.Context, project string, endpoints Endpoints) (*Sweeper, error) {
+	options := func(endpoint string) []option.ClientOption {
+		var opts []option.ClientOption
+		if endpoint != "" {
+			opts = append(opts, option.WithEndpoint(endpoint))
+		}
+		if endpoints.Token != "" {
+			opts = append(opts, option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: endpoints.Token})))
+		}
+		return opts
+	}
+
+	computeService, err := compute.NewService(ctx, options(endpoints.Compute)...)
+	if err != nil {
+		return nil, fmt.Errorf("compute client: %w", err)
+	}
+	sqlService, err := sqladmin.NewService(ctx, options(endpoints.SQL)...)
+	if err != nil {
+		return nil, fmt.Errorf("sqladmin client: %w", err)
+	}
+	iamService, err := iam.NewService(ctx, options(endpoints.IAM)...)
+	if err != nil {
+		return nil, fmt.Errorf("iam client: %w", err)
+	}
+	return &Sweeper{Project: project, compute: computeService, sql: sqlService, iam: iamService, now: time.Now}, nil
+}
+
+// Now returns the time ages are measured against
+func (s *Sweeper) Now() time.Time {
+	return s.now()
+}
+
+// Find lists the project's suite resources in deletion order, each with the
+// filter's verdict. Unlabelled resources are only listed when the filter
+// names a run.
+func (s *Sweeper) Find(ctx context.Context, filter Filter) ([]Candidate, error) {
+	var resources []Resource
+	for _, kind := range Kinds {
+		found, err := s.list(ctx, kind)
+		if err != nil {
+			return nil, fmt.Errorf("listing %ss: %w", kind, err)
+		}
+		resources = append(resources, found...)
+	}
+
+	now := s.now()
+	var candidates []Candidate
+	for _, resource := range resources {
+		if resource, ok := filter.claim(resource); ok {
+			candidates = append(candidates, Candidate{Resource: resource, Keep: filter.Check(resource, now)})
+		}
+	}
+	sortCandidates(candidates)
+	return candidates, nil
+}
+
+// Delete deletes the selected candidates in order, reporting progress to w.
+// It carries on past failures and returns them joined.
+func (s *Sweeper) Delete(ctx context.Context, w io.Writer, candidates []Candidate) error {
+	var errs []error
+	for _, candidate := range Selected(candidates) {
+		if err := s.delete(ctx, candidate.Resource); err != nil {
+			errs = append(errs, fmt.Errorf("deleting %s %s: %w", candidate.Kind, candidate.Name, err))
+			fmt.Fprintf(w, "failed  %s %s: %v\n", candidate.Kind, candidate.Name, err)
+			continue
+		}
+		fmt.Fprintf(w, "deleted %s %s\n", candidate.Kind, candidate.Name)
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Sweeper) list(ctx context.Context, kind Kind) ([]Resource, error) {
+	var resources []Resource
+	switch kind {
+	case Networks:
+		err := s.compute.Networks.List(s.Project).Pages(ctx, func(page *compute.NetworkList) error {
+			for _, item := range page.Items {
+				resources = append(resources, newResource(kind, item.Name, "global", nil, item.CreationTimestamp))
+			}
+			return nil
+		})
+		return resources, err
+	case Firewalls:
+		err := s.compute.Firewalls.List(s.Project).Pages(ctx, func(page *compute.FirewallList) error {
+			for _, item := range page.Items {
+				resources = append(resources, newResource(kind, item.Name, "global", nil, item.CreationTimestamp))
+			}
+			return nil
+		})
+		return resources, err
+	case InstanceTemplates:
+		err := s.compute.InstanceTemplates.List(s.Project).Pages(ctx, func(page *compute.InstanceTemplateList) error {
+			for _, item := range page.Items {
+				var labels map[string]string
+				if item.Properties != nil {
+					labels = item.Properties.Labels
+				}
+				resources = append(resources, newResource(kind, item.Name, "global", labels, item.CreationTimestamp))
+			}
+			return nil
+		})
+		return resources, err
+	case GlobalAddresses:
+		err := s.compute.GlobalAddresses.List(s.Project).Pages(ctx, func(page *compute.AddressList) error {
+			for _, item := range page.Items {
+				resources = append(resources, newResource(kind, item.Name, "global", nil, item.CreationTimestamp))
+			}
+			return nil
+		})
+		return resources, err
+	case Subnetworks:
+		err := s.compute.Subnetworks.AggregatedList(s.Project).Pages(ctx, func(page *compute.SubnetworkAggregatedList) error {
+			for location, scoped := range page.Items {
+				for _, item := range scoped.Subnetworks {
+					resources = append(resources, newResource(kind, item.Name, location, nil, item.CreationTimestamp))
+				}
+			}
+			return nil
+		})
+		return resources, err
+	case Routers:
+		err := s.compute.Routers.AggregatedList(s.Project).Pages(ctx, func(page *compute.RouterAggregatedList) error {
+			for location, scoped := range page.Items {
+				for _, item := range scoped.Routers {
+					resources = append(resources, newResource(kind, item.Name, location, nil, item.CreationTimestamp))
+				}
+			}
+			return nil
+		})
+		return resources, err
+	case InstanceGroupManagers:
+		// The aggregated list covers both regional and zonal managers
+		err := s.compute.InstanceGroupManagers.AggregatedList(s.Project).Pages(ctx, func(page *compute.InstanceGroupManagerAggregatedList) error {
+			for location, scoped := range page.Items {
+				for _, item := range scoped.InstanceGroupManagers {
+					resources = append(resources, newResource(kind, item.Name, location, nil, item.CreationTimestamp))
+				}
+			}
+			return nil
+		})
+		return resources, err
+	case SQLInstances:
+		err := s.sql.Instances.List(s.Project).Pages(ctx, func(page *sqladmin.InstancesListResponse) error {
+			for _, item := range page.Items {
+				var labels map[string]string
+				if item.Settings != nil {
+					labels = item.Settings.UserLabels
+				}
+				resource := newResource(kind, item.Name, "regions/"+item.Region, labels, item.CreateTime)
+				resource.replica = item.MasterInstanceName != ""
+				resources = append(resources, resource)
+			}
+			return nil
+		})
+		return resources, err
+	case ServiceAccounts:
+		err := s.iam.Projects.ServiceAccounts.List("projects/"+s.Project).Pages(ctx, func(page *iam.ListServiceAccountsResponse) error {
+			for _, item := range page.Accounts {
+				id, _, _ := strings.Cut(item.Email, "@")
+				resources = append(resources, Resource{Kind: kind, Name: id, id: item.Name})
+			}
+			return nil
+		})
+		return resources, err
+	}
+	return nil, fmt.Errorf("unknown kind %q", kind)
+}
+
+// newResource builds a Resource from the fields every API reports. An
+// unparsable timestamp leaves the age unknown.
+func newResource(kind Kind, name, location string, labels map[string]string, created string) Resource {
+	resource := Resource{Kind: kind, Name: name, Location: location, Labels: labels}
+	if t, err := time.Parse(time.RFC3339, created); err == nil {
+		resource.Created = t
+	}
+	return resource
+}
+
+func (s *Sweeper) delete(ctx context.Context, r Resource) error {
+	scope, location := scopeName(r.Location)
+	var (
+		op  *compute.Operation
+		err error
+	)
+	switch r.Kind {
+	case Networks:
+		op, err = s.compute.Networks.Delete(s.Project, r.Name).Context(ctx).Do()
+	case Firewalls:
+		op, err = s.compute.Firewalls.Delete(s.Project, r.Name).Context(ctx).Do()
+	case InstanceTemplates:
+		op, err = s.compute.InstanceTemplates.Delete(s.Project, r.Name).Context(ctx).Do()
+	case GlobalAddresses:
+		op, err = s.compute.GlobalAddresses.Delete(s.Project, r.Name).Context(ctx).Do()
+	case Subnetworks:
+		op, err = s.compute.Subnetworks.Delete(s.Project, location, r.Name).Context(ctx).Do()
+	case Routers:
+		op, err = s.compute.Routers.Delete(s.Project, location, r.Name).Context(ctx).Do()
+	case InstanceGroupManagers:
+		if scope == "zones" {
+			op, err = s.compute.InstanceGroupManagers.Delete(s.Project, location, r.Name).Context(ctx).Do()
+		} else {
+			op, err = s.compute.RegionInstanceGroupManagers.Delete(s.Project, location, r.Name).Context(ctx).Do()
+		}
+	case SQLInstances:
+		return s.deleteSQLInstance(ctx, r.Name)
+	case ServiceAccounts:
+		_, err = s.iam.Projects.ServiceAccounts.Delete(r.id).Context(ctx).Do()
+		return err
+	default:
+		return fmt.Errorf("unknown kind %q", r.Kind)
+	}
+	if err != nil {
+		return err
+	}
+	return s.waitCompute(ctx, op)
+}
+
+// waitCompute waits for a compute operation and returns its error, if any
+func (s *Sweeper) waitCompute(ctx context.Context, op *compute.Operation) error {
+	for op.Status != "DONE" {
+		var err error
+		switch {
+		case op.Zone != "":
+			op, err = s.compute.ZoneOperations.Wait(s.Project, planassert.LastSegment(op.Zone), op.Name).Context(ctx).Do()
+		case op.Region != "":
+			op, err = s.compute.RegionOperations.Wait(s.Project, planassert.LastSegment(op.Region), op.Name).Context(ctx).Do()
+		default:
+			op, err = s.compute.GlobalOperations.Wait(s.Project, op.Name).Context(ctx).Do()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if op.Error != nil && len(op.Error.Errors) > 0 {
+		return errors.New(op.Error.Errors[0].Message)
+	}
+	return nil
+}
+
+func (s *Sweeper) deleteSQLInstance(ctx context.Context, name string) error {
+	op, err := s.sql.Instances.Delete(s.Project, name).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	for op.Status != "DONE" {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+		if op, err = s.sql.Operations.Get(s.Project, op.Name).Context(ctx).Do(); err != nil {
+			return err
+		}
+	}
+	if op.Error != nil && len(op.Error.Errors) > 0 {
+		return errors.New(op.Error.Errors[0].Message)
+	}
+	return nil
+}
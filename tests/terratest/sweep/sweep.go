@@ -0,0 +1,207 @@
+// Package sweep finds and deletes resources that crashed or interrupted test
+// runs left behind. A resource belongs to the suite when it carries the
+// test_run_id label. Unlabelled resources, such as networks and service
+// accounts, only belong to a run given explicitly by ID, when their name
+// follows the naming package's "<base>-<run ID>-<test hash>" scheme for that
+// ID. Filters on run ID, age and labels narrow that down to what is safe to
+// delete.
+package sweep
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/naming"
+)
+
+// Kind is a type of resource the sweeper handles
+type Kind string
+
+const (
+	InstanceGroupManagers Kind = "instance-group-manager"
+	InstanceTemplates     Kind = "instance-template"
+	SQLInstances          Kind = "sql-instance"
+	ServiceAccounts       Kind = "service-account"
+	Firewalls             Kind = "firewall"
+	Routers               Kind = "router"
+	Subnetworks           Kind = "subnetwork"
+	GlobalAddresses       Kind = "global-address"
+	Networks              Kind = "network"
+)
+
+// Kinds lists every kind in deletion order: dependents before the resources
+// they use, networks last. Subnetworks and routers are swept because a
+// network cannot be deleted while they exist.
+var Kinds = []Kind{
+	InstanceGroupManagers,
+	InstanceTemplates,
+	SQLInstances,
+	ServiceAccounts,
+	Firewalls,
+	Routers,
+	Subnetworks,
+	GlobalAddresses,
+	Networks,
+}
+
+// runName matches the "-<run ID>-<test hash>" part of names generated in run
+// runID, optionally followed by a suffix the module appended
+func runName(runID string) *regexp.Regexp {
+	return regexp.MustCompile(`-` + regexp.QuoteMeta(runID) + `-[a-z0-9]{4}(-|$)`)
+}
+
+// Resource is a swept resource
+type Resource struct {
+	Kind Kind
+	Name string
+	// Location is "global", "regions/<region>" or "zones/<zone>", and empty
+	// for service accounts
+	Location string
+	Labels   map[string]string
+	// Created is zero when the API does not report it, as for service accounts
+	Created time.Time
+
+	// id is what the API deletes by when it differs from Name
+	id string
+	// replica marks Cloud SQL read replicas, which are deleted first
+	replica bool
+	// run is the run an unlabelled resource was claimed for by its name
+	run string
+}
+
+// RunID returns the run that created r, from its label or else the run it
+// was claimed for by name
+func (r Resource) RunID() string {
+	if id := r.Labels[naming.LabelKey]; id != "" {
+		return id
+	}
+	return r.run
+}
+
+// Filter selects which suite resources to delete. The zero Filter selects all.
+type Filter struct {
+	// RunID keeps resources of other runs. It is also the only way
+	// unlabelled resources are claimed: their name must carry this ID.
+	RunID string
+	// OlderThan keeps resources created more recently, which may belong to
+	// a run still in progress. Resources of unknown age only pass when
+	// RunID is set.
+	OlderThan time.Duration
+	// Labels keeps resources that lack any of these labels
+	Labels map[string]string
+}
+
+// claim reports whether r belongs to the suite. Labelled resources always
+// do. Unlabelled ones only do when f names a run and r's name carries exactly
+// that run ID, since ordinary names like vpc-shared-prod have the same shape
+// as generated ones.
+func (f Filter) claim(r Resource) (Resource, bool) {
+	if r.Labels[naming.LabelKey] != "" {
+		return r, true
+	}
+	if f.RunID == "" || !runName(f.RunID).MatchString(r.Name) {
+		return r, false
+	}
+	r.run = f.RunID
+	return r, true
+}
+
+// Check returns why r must be kept, or "" when it may be deleted
+func (f Filter) Check(r Resource, now time.Time) string {
+	if f.RunID != "" && r.RunID() != f.RunID {
+		return fmt.Sprintf("run %s", r.RunID())
+	}
+	keys := make([]string, 0, len(f.Labels))
+	for key := range f.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if r.Labels[key] != f.Labels[key] {
+			return fmt.Sprintf("label %s is not %q", key, f.Labels[key])
+		}
+	}
+	if f.OlderThan > 0 {
+		if r.Created.IsZero() {
+			if f.RunID == "" {
+				return "age unknown"
+			}
+		} else if age := now.Sub(r.Created); age < f.OlderThan {
+			return fmt.Sprintf("younger than %s", f.OlderThan)
+		}
+	}
+	return ""
+}
+
+// Candidate is a suite resource with the filter's verdict
+type Candidate struct {
+	Resource
+	// Keep is why the resource is kept, empty when it is to be deleted
+	Keep string
+}
+
+// Selected returns the candidates to delete, in order
+func Selected(candidates []Candidate) []Candidate {
+	var selected []Candidate
+	for _, candidate := range candidates {
+		if candidate.Keep == "" {
+			selected = append(selected, candidate)
+		}
+	}
+	return selected
+}
+
+// sortCandidates puts candidates in deletion order, then by location and name
+func sortCandidates(candidates []Candidate) {
+	order := map[Kind]int{}
+	for i, kind := range Kinds {
+		order[kind] = i
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.Kind != b.Kind {
+			return order[a.Kind] < order[b.Kind]
+		}
+		if a.replica != b.replica {
+			return a.replica
+		}
+		if a.Location != b.Location {
+			return a.Location < b.Location
+		}
+		return a.Name < b.Name
+	})
+}
+
+// WriteReport prints candidates as a table with the action taken for each
+func WriteReport(w io.Writer, candidates []Candidate, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tLOCATION\tNAME\tRUN ID\tAGE\tACTION")
+	for _, candidate := range candidates {
+		age := "-"
+		if !candidate.Created.IsZero() {
+			age = now.Sub(candidate.Created).Truncate(time.Minute).String()
+		}
+		action := "delete"
+		if candidate.Keep != "" {
+			action = "keep: " + candidate.Keep
+		}
+		location := candidate.Location
+		if location == "" {
+			location = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", candidate.Kind, location, candidate.Name, candidate.RunID(), age, action)
+	}
+	fmt.Fprintf(tw, "\n%d of %d suite resources selected\n", len(Selected(candidates)), len(candidates))
+	return tw.Flush()
+}
+
+// scopeName splits "regions/<region>" or "zones/<zone>" into its parts
+func scopeName(location string) (scope, name string) {
+	scope, name, _ = strings.Cut(location, "/")
+	return scope, name
+}
@@ -0,0 +1,251 @@
+package sweep
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/iam/v1"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sqladmin/v1beta4"
+
+	"github.com/unicredit/gcp-migration/tests/terratest/gcpstub"
+)
+
+const project = "test-project"
+
+// seed creates suite resources of runs abc123 and zzz999 plus unrelated ones,
+// some named like suite resources, in the stand-in
+func seed(t *testing.T, s *gcpstub.Server) {
+	ctx := context.Background()
+	computeService, err := compute.NewService(ctx, option.WithEndpoint(s.URL+gcpstub.ComputePrefix), option.WithoutAuthentication())
+	require.NoError(t, err)
+	sqlService, err := sqladmin.NewService(ctx, option.WithEndpoint(s.URL+"/"), option.WithoutAuthentication())
+	require.NoError(t, err)
+	iamService, err := iam.NewService(ctx, option.WithEndpoint(s.URL+"/iam/"), option.WithoutAuthentication())
+	require.NoError(t, err)
+
+	for _, name := range []string{"test-vpc-abc123-k3m9", "prod-vpc", "vpc-shared-prod"} {
+		_, err = computeService.Networks.Insert(project, &compute.Network{Name: name}).Do()
+		require.NoError(t, err)
+	}
+	_, err = computeService.Subnetworks.Insert(project, "europe-west1", &compute.Subnetwork{Name: "test-vpc-abc123-k3m9-private", IpCidrRange: "10.0.2.0/24"}).Do()
+	require.NoError(t, err)
+	for _, name := range []string{"test-vpc-abc123-k3m9-allow-ssh", "allow-server-prod"} {
+		_, err = computeService.Firewalls.Insert(project, &compute.Firewall{Name: name}).Do()
+		require.NoError(t, err)
+	}
+	_, err = computeService.GlobalAddresses.Insert(project, &compute.Address{Name: "test-lb-abc123-p0q1-ip"}).Do()
+	require.NoError(t, err)
+	_, err = computeService.InstanceTemplates.Insert(project, &compute.InstanceTemplate{
+		Name:       "web-zzz999-a1b2-20240101000000000000000001",
+		Properties: &compute.InstanceProperties{Labels: map[string]string{"test_run_id": "zzz999", "env": "ci"}},
+	}).Do()
+	require.NoError(t, err)
+	_, err = computeService.RegionInstanceGroupManagers.Insert(project, "europe-west1", &compute.InstanceGroupManager{Name: "web-zzz999-a1b2-mig"}).Do()
+	require.NoError(t, err)
+
+	for _, instance := range []*sqladmin.DatabaseInstance{
+		{Name: "test-db-abc123-x1y2", Region: "europe-west1"},
+		{Name: "test-db-abc123-x1y2-replica", Region: "europe-west1", MasterInstanceName: "test-db-abc123-x1y2"},
+		{Name: "labelled-db", Region: "europe-west1", Settings: &sqladmin.Settings{UserLabels: map[string]string{"test_run_id": "abc123"}}},
+	} {
+		_, err = sqlService.Instances.Insert(project, instance).Do()
+		require.NoError(t, err)
+	}
+
+	for _, id := range []string{"app-a-sa-abc123-r2d2", "terraform"} {
+		_, err = iamService.Projects.ServiceAccounts.Create("projects/"+project, &iam.CreateServiceAccountRequest{AccountId: id}).Do()
+		require.NoError(t, err)
+	}
+}
+
+func newSweeper(t *testing.T, s *gcpstub.Server, now time.Time) *Sweeper {
+	env := s.EnvVars()
+	sweeper, err := New(context.Background(), project, EndpointsFromEnv(func(name string) string { return env[name] }))
+	require.NoError(t, err)
+	sweeper.now = func() time.Time { return now }
+	return sweeper
+}
+
+func names(candidates []Candidate) []string {
+	var result []string
+	for _, candidate := range candidates {
+		result = append(result, candidate.Name)
+	}
+	return result
+}
+
+// TestFind tests suite resources are found in deletion order and unrelated
+// ones are ignored, and unlabelled resources are only claimed for a given run
+func TestFind(t *testing.T) {
+	t.Parallel()
+	s := gcpstub.Start(t)
+	seed(t, s)
+	sweeper := newSweeper(t, s, time.Now())
+
+	candidates, err := sweeper.Find(context.Background(), Filter{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"web-zzz999-a1b2-20240101000000000000000001",
+		"labelled-db",
+	}, names(candidates))
+	assert.Len(t, Selected(candidates), len(candidates))
+
+	candidates, err = sweeper.Find(context.Background(), Filter{RunID: "abc123"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"web-zzz999-a1b2-20240101000000000000000001",
+		"test-db-abc123-x1y2-replica",
+		"labelled-db",
+		"test-db-abc123-x1y2",
+		"app-a-sa-abc123-r2d2",
+		"test-vpc-abc123-k3m9-allow-ssh",
+		"test-vpc-abc123-k3m9-private",
+		"test-lb-abc123-p0q1-ip",
+		"test-vpc-abc123-k3m9",
+	}, names(candidates))
+	assert.Equal(t, "run zzz999", candidates[0].Keep)
+	assert.Equal(t, "abc123", candidates[1].RunID())
+	assert.Equal(t, "regions/europe-west1", candidates[1].Location)
+	assert.Len(t, Selected(candidates), len(candidates)-1)
+
+	candidates, err = sweeper.Find(context.Background(), Filter{RunID: "zzz999"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"web-zzz999-a1b2-mig",
+		"web-zzz999-a1b2-20240101000000000000000001",
+	}, names(Selected(candidates)))
+	assert.Equal(t, "labelled-db", candidates[2].Name)
+}
+
+// TestFindFilters tests the run ID, label and age filters
+func TestFindFilters(t *testing.T) {
+	t.Parallel()
+	s := gcpstub.Start(t)
+	seed(t, s)
+	ctx := context.Background()
+
+	young := newSweeper(t, s, time.Now())
+	candidates, err := young.Find(ctx, Filter{RunID: "abc123", OlderThan: time.Hour})
+	require.NoError(t, err)
+	// Only the service account has no age, and the run ID lets it through
+	assert.Equal(t, []string{"app-a-sa-abc123-r2d2"}, names(Selected(candidates)))
+
+	old := newSweeper(t, s, time.Now().Add(3*time.Hour))
+	candidates, err = old.Find(ctx, Filter{RunID: "abc123", OlderThan: time.Hour})
+	require.NoError(t, err)
+	assert.Len(t, Selected(candidates), 8)
+
+	candidates, err = old.Find(ctx, Filter{OlderThan: time.Hour, Labels: map[string]string{"env": "ci"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web-zzz999-a1b2-20240101000000000000000001"}, names(Selected(candidates)))
+
+	var report bytes.Buffer
+	require.NoError(t, WriteReport(&report, candidates, old.Now()))
+	assert.Contains(t, report.String(), "keep: label env is not \"ci\"")
+	assert.Contains(t, report.String(), "1 of 2 suite resources selected")
+}
+
+// TestDelete tests selected resources are deleted and the rest remain
+func TestDelete(t *testing.T) {
+	t.Parallel()
+	s := gcpstub.Start(t)
+	seed(t, s)
+	ctx := context.Background()
+	sweeper := newSweeper(t, s, time.Now())
+
+	var progress bytes.Buffer
+	for _, runID := range []string{"abc123", "zzz999"} {
+		candidates, err := sweeper.Find(ctx, Filter{RunID: runID})
+		require.NoError(t, err)
+		require.NoError(t, sweeper.Delete(ctx, &progress, candidates))
+	}
+	assert.Contains(t, progress.String(), "deleted network test-vpc-abc123-k3m9")
+
+	// Look-alike names of unrelated resources survive
+	assert.ElementsMatch(t, []string{
+		"compute/v1/projects/test-project/global/firewalls/allow-server-prod",
+		"compute/v1/projects/test-project/global/networks/prod-vpc",
+		"compute/v1/projects/test-project/global/networks/vpc-shared-prod",
+		"iam/v1/serviceAccounts/terraform@test-project.iam.gserviceaccount.com",
+	}, s.Resources())
+
+	// A second pass finds nothing and deleting nothing succeeds
+	candidates, err := sweeper.Find(ctx, Filter{RunID: "abc123"})
+	require.NoError(t, err)
+	assert.Empty(t, candidates)
+	require.NoError(t, sweeper.Delete(ctx, &progress, candidates))
+}
+
+// TestDeleteReportsFailures tests a failed delete does not stop the others
+func TestDeleteReportsFailures(t *testing.T) {
+	t.Parallel()
+	s := gcpstub.Start(t)
+	seed(t, s)
+	ctx := context.Background()
+	sweeper := newSweeper(t, s, time.Now())
+
+	missing := Candidate{Resource: Resource{Kind: Networks, Name: "gone-abc123-k3m9", Location: "global"}}
+	candidates, err := sweeper.Find(ctx, Filter{RunID: "zzz999"})
+	require.NoError(t, err)
+	err = sweeper.Delete(ctx, &bytes.Buffer{}, append([]Candidate{missing}, candidates...))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "deleting network gone-abc123-k3m9")
+
+	_, ok := s.Get("compute/v1/projects/test-project/global/instanceTemplates/web-zzz999-a1b2-20240101000000000000000001")
+	assert.False(t, ok)
+}
+
+// TestClaim tests labelled resources are always claimed and unlabelled ones
+// only when their name carries the filter's run ID
+func TestClaim(t *testing.T) {
+	t.Parallel()
+
+	labelled := map[string]string{"test_run_id": "zzz999"}
+	testCases := []struct {
+		name    string
+		labels  map[string]string
+		runID   string
+		claimed string
+	}{
+		{"test-vpc-abc123-k3m9", labelled, "", "zzz999"},
+		{"test-vpc-abc123-k3m9", nil, "abc123", "abc123"},
+		{"test-vpc-abc123-k3m9-router-europe-west1", nil, "abc123", "abc123"},
+		// The name shape alone is not enough
+		{"test-vpc-abc123-k3m9", nil, "", ""},
+		{"vpc-shared-prod", nil, "", ""},
+		{"allow-server-prod", nil, "abc123", ""},
+		{"test-vpc-abc123-k3m9", nil, "zzz999", ""},
+		{"default", nil, "abc123", ""},
+	}
+	for _, tc := range testCases {
+		resource, ok := Filter{RunID: tc.runID}.claim(Resource{Name: tc.name, Labels: tc.labels})
+		assert.Equal(t, tc.claimed != "", ok, "%s with run %q", tc.name, tc.runID)
+		assert.Equal(t, tc.claimed, resource.RunID(), "%s with run %q", tc.name, tc.runID)
+	}
+}
+
+// TestEndpointsFromEnv tests the provider's versioned endpoints are adapted to
+// the Go clients
+func TestEndpointsFromEnv(t *testing.T) {
+	t.Parallel()
+
+	env := map[string]string{
+		"GOOGLE_COMPUTE_CUSTOM_ENDPOINT": "http://127.0.0.1:1/compute/v1/",
+		"GOOGLE_SQL_CUSTOM_ENDPOINT":     "http://127.0.0.1:1/sql/v1beta4/",
+		"GOOGLE_IAM_CUSTOM_ENDPOINT":     "http://127.0.0.1:1/iam/v1/",
+		"GOOGLE_OAUTH_ACCESS_TOKEN":      "token",
+	}
+	assert.Equal(t, Endpoints{
+		Compute: "http://127.0.0.1:1/compute/v1/",
+		SQL:     "http://127.0.0.1:1/",
+		IAM:     "http://127.0.0.1:1/iam/",
+		Token:   "token",
+	}, EndpointsFromEnv(func(name string) string { return env[name] }))
+	assert.Equal(t, Endpoints{}, EndpointsFromEnv(func(string) string { return "" }))
+}
@@ -0,0 +1,170 @@
+// Package tier gates tests by what they need to run. Each test declares its
+// tier with Require; TERRATEST_TIER selects which tiers run and tests whose
+// prerequisites are missing skip with the reason instead of failing.
+package tier
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Tier is a class of test, ordered by cost
+type Tier int
+
+const (
+	// Static tests read HCL or JSON only and need no terraform binary
+	Static Tier = iota
+	// Validate tests run terraform init and validate
+	Validate
+	// Plan tests run terraform plan against GCP or the local stand-in
+	Plan
+	// Apply tests create and destroy resources
+	Apply
+)
+
+// Env selects the tiers to run as a comma separated list, e.g.
+// "static,validate", or "all". Apply is opt-in.
+const Env = "TERRATEST_TIER"
+
+// OfflineEnv switches plan and apply tests to the local GCP API stand-in
+const OfflineEnv = "TERRATEST_OFFLINE"
+
+// DefaultTiers run when Env is unset
+var DefaultTiers = []Tier{Static, Validate, Plan}
+
+var names = map[Tier]string{
+	Static:   "static",
+	Validate: "validate",
+	Plan:     "plan",
+	Apply:    "apply",
+}
+
+func (t Tier) String() string {
+	if name, ok := names[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("tier(%d)", int(t))
+}
+
+// Parse reads a tier name
+func Parse(name string) (Tier, error) {
+	for tier, n := range names {
+		if n == strings.ToLower(strings.TrimSpace(name)) {
+			return tier, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown tier %q (want static, validate, plan or apply)", name)
+}
+
+// ParseList reads a comma separated tier list as used in Env
+func ParseList(value string) (map[Tier]bool, error) {
+	selected := map[Tier]bool{}
+	if strings.TrimSpace(value) == "" {
+		for _, tier := range DefaultTiers {
+			selected[tier] = true
+		}
+		return selected, nil
+	}
+	for _, name := range strings.Split(value, ",") {
+		if strings.TrimSpace(name) == "all" {
+			for tier := range names {
+				selected[tier] = true
+			}
+			continue
+		}
+		tier, err := Parse(name)
+		if err != nil {
+			return nil, err
+		}
+		selected[tier] = true
+	}
+	return selected, nil
+}
+
+// Offline reports whether the stand-in should replace the real APIs
+func Offline() bool {
+	return os.Getenv(OfflineEnv) != ""
+}
+
+// environment is what the prerequisite checks look at
+type environment struct {
+	getenv   func(string) string
+	lookPath func(string) (string, error)
+	exists   func(string) bool
+}
+
+var hostEnvironment = environment{
+	getenv:   os.Getenv,
+	lookPath: exec.LookPath,
+	exists: func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	},
+}
+
+// Missing lists the prerequisites of tier that are absent on this machine
+func Missing(tier Tier) []string {
+	return hostEnvironment.missing(tier)
+}
+
+func (e environment) missing(tier Tier) []string {
+	var missing []string
+	if tier >= Validate && !e.hasBinary() {
+		missing = append(missing, "no terraform or tofu binary on PATH")
+	}
+	if tier >= Plan && e.getenv(OfflineEnv) == "" && !e.hasCredentials() {
+		missing = append(missing, fmt.Sprintf("no GCP credentials and %s is not set", OfflineEnv))
+	}
+	return missing
+}
+
+func (e environment) hasBinary() bool {
+	for _, binary := range []string{"terraform", "tofu"} {
+		if _, err := e.lookPath(binary); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCredentials mirrors the google provider's credential lookup: explicit
+// variables first, then gcloud application default credentials
+func (e environment) hasCredentials() bool {
+	for _, name := range []string{
+		"GOOGLE_CREDENTIALS",
+		"GOOGLE_CLOUD_KEYFILE_JSON",
+		"GCLOUD_KEYFILE_JSON",
+		"GOOGLE_APPLICATION_CREDENTIALS",
+		"GOOGLE_OAUTH_ACCESS_TOKEN",
+	} {
+		if e.getenv(name) != "" {
+			return true
+		}
+	}
+	config := e.getenv("CLOUDSDK_CONFIG")
+	if config == "" {
+		config = filepath.Join(e.getenv("HOME"), ".config", "gcloud")
+	}
+	return e.exists(filepath.Join(config, "application_default_credentials.json"))
+}
+
+// Require skips the test unless tier is selected through TERRATEST_TIER and
+// its prerequisites are present, and logs the tier otherwise
+func Require(t testing.TB, tier Tier) {
+	t.Helper()
+	selected, err := ParseList(os.Getenv(Env))
+	if err != nil {
+		t.Fatalf("%s: %v", Env, err)
+	}
+	if !selected[tier] {
+		t.Skipf("tier %s not selected (set %s=%s to run it)", tier, Env, tier)
+	}
+	if missing := Missing(tier); len(missing) > 0 {
+		t.Skipf("tier %s prerequisites missing: %s", tier, strings.Join(missing, "; "))
+	}
+	t.Logf("tier: %s", tier)
+}
@@ -0,0 +1,81 @@
+package tier
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeEnvironment(vars map[string]string, binaries ...string) environment {
+	return environment{
+		getenv: func(name string) string { return vars[name] },
+		lookPath: func(name string) (string, error) {
+			for _, binary := range binaries {
+				if binary == name {
+					return "/usr/bin/" + name, nil
+				}
+			}
+			return "", errors.New("not found")
+		},
+		exists: func(path string) bool { return path == vars["adc"] },
+	}
+}
+
+// TestParseList tests the default, explicit and "all" selections
+func TestParseList(t *testing.T) {
+	t.Parallel()
+
+	selected, err := ParseList("")
+	require.NoError(t, err)
+	assert.Equal(t, map[Tier]bool{Static: true, Validate: true, Plan: true}, selected)
+
+	selected, err = ParseList("static, Validate")
+	require.NoError(t, err)
+	assert.Equal(t, map[Tier]bool{Static: true, Validate: true}, selected)
+
+	selected, err = ParseList("all")
+	require.NoError(t, err)
+	assert.True(t, selected[Apply])
+
+	_, err = ParseList("plan,integration")
+	assert.Error(t, err)
+}
+
+// TestMissing tests the prerequisites checked for each tier
+func TestMissing(t *testing.T) {
+	t.Parallel()
+
+	bare := fakeEnvironment(nil)
+	assert.Empty(t, bare.missing(Static))
+	assert.Len(t, bare.missing(Validate), 1)
+	assert.Len(t, bare.missing(Plan), 2)
+
+	tofuOnly := fakeEnvironment(nil, "tofu")
+	assert.Empty(t, tofuOnly.missing(Validate))
+	assert.Len(t, tofuOnly.missing(Plan), 1)
+
+	offline := fakeEnvironment(map[string]string{OfflineEnv: "1"}, "terraform")
+	assert.Empty(t, offline.missing(Apply))
+
+	keyFile := fakeEnvironment(map[string]string{"GOOGLE_APPLICATION_CREDENTIALS": "/tmp/key.json"}, "terraform")
+	assert.Empty(t, keyFile.missing(Plan))
+
+	adc := fakeEnvironment(map[string]string{
+		"HOME": "/home/ci",
+		"adc":  "/home/ci/.config/gcloud/application_default_credentials.json",
+	}, "terraform")
+	assert.Empty(t, adc.missing(Plan))
+}
+
+// TestString tests tier names round-trip through Parse
+func TestString(t *testing.T) {
+	t.Parallel()
+
+	for _, tier := range []Tier{Static, Validate, Plan, Apply} {
+		parsed, err := Parse(tier.String())
+		require.NoError(t, err)
+		assert.Equal(t, tier, parsed)
+	}
+}